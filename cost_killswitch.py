@@ -0,0 +1,29 @@
+import json
+import logging
+import os
+import boto3
+from typing import Dict, Any
+
+# Setup logging from environment variable
+logger = logging.getLogger()
+logger.setLevel(os.environ.get('LOG_LEVEL', 'INFO'))
+
+lambda_client = boto3.client('lambda')
+
+# Function paused when the killswitch alarm fires
+TARGET_FUNCTION_NAME = os.environ['TARGET_FUNCTION_NAME']
+
+def handler(event: Dict[str, Any], context: Any) -> Dict[str, Any]:
+    """CloudWatch alarm action - pause the API by setting reserved concurrency to 0"""
+    state = event.get('alarmData', {}).get('state', {}).get('value')
+    if state != 'ALARM':
+        logger.info(f"Ignoring alarm state {state}")
+        return {'paused': False}
+
+    lambda_client.put_function_concurrency(
+        FunctionName=TARGET_FUNCTION_NAME,
+        ReservedConcurrentExecutions=0
+    )
+    logger.warning(f"Paused {TARGET_FUNCTION_NAME}: {json.dumps(event.get('alarmData', {}).get('state', {}))}")
+
+    return {'paused': True}
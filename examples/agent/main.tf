@@ -0,0 +1,223 @@
+# Bedrock agent example for Amazon Bedrock + Lambda + API Gateway module
+# Creates an agent with a Lambda action group and exposes it on /agent
+
+terraform {
+  required_version = "~> 1.13.0"
+
+  required_providers {
+    aws = {
+      source  = "hashicorp/aws"
+      version = "~> 6.2.0"
+    }
+    archive = {
+      source  = "hashicorp/archive"
+      version = "~> 2.0"
+    }
+  }
+}
+
+variable "name_prefix" {
+  description = "Prefix for resource names"
+  type        = string
+  default     = "agent-example"
+}
+
+variable "region" {
+  description = "AWS region with Bedrock agents and the model below"
+  type        = string
+  default     = "us-east-1"
+}
+
+variable "tags" {
+  description = "Tags applied to the module's resources"
+  type        = map(string)
+  default = {
+    Environment = "dev"
+    Project     = "example"
+    ManagedBy   = "terraform"
+  }
+}
+
+variable "agent_model_id" {
+  description = "Foundation model the agent orchestrates with"
+  type        = string
+  default     = "anthropic.claude-3-haiku-20240307-v1:0"
+}
+
+provider "aws" {
+  region = var.region
+}
+
+data "aws_caller_identity" "current" {}
+data "aws_region" "current" {}
+
+locals {
+  action_group_name = "orders"
+  agent_model_arn   = "arn:aws:bedrock:${data.aws_region.current.name}::foundation-model/${var.agent_model_id}"
+}
+
+# Action group Lambda
+data "archive_file" "order_status" {
+  type        = "zip"
+  source_file = "${path.module}/order_status.py"
+  output_path = "${path.module}/order_status.zip"
+}
+
+resource "aws_iam_role" "order_status" {
+  name = "${var.name_prefix}-order-status-role"
+
+  assume_role_policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Effect    = "Allow"
+        Principal = { Service = "lambda.amazonaws.com" }
+        Action    = "sts:AssumeRole"
+      }
+    ]
+  })
+}
+
+resource "aws_iam_role_policy_attachment" "order_status_logs" {
+  role       = aws_iam_role.order_status.name
+  policy_arn = "arn:aws:iam::aws:policy/service-role/AWSLambdaBasicExecutionRole"
+}
+
+resource "aws_lambda_function" "order_status" {
+  function_name    = "${var.name_prefix}-order-status"
+  role             = aws_iam_role.order_status.arn
+  handler          = "order_status.lambda_handler"
+  runtime          = "python3.11"
+  filename         = data.archive_file.order_status.output_path
+  source_code_hash = data.archive_file.order_status.output_base64sha256
+  timeout          = 10
+}
+
+resource "aws_lambda_permission" "agent" {
+  statement_id  = "AllowBedrockAgent"
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.order_status.function_name
+  principal     = "bedrock.amazonaws.com"
+  source_arn    = aws_bedrockagent_agent.orders.agent_arn
+}
+
+# Agent
+resource "aws_iam_role" "agent" {
+  name = "${var.name_prefix}-agent-role"
+
+  assume_role_policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Effect    = "Allow"
+        Principal = { Service = "bedrock.amazonaws.com" }
+        Action    = "sts:AssumeRole"
+        Condition = {
+          StringEquals = { "aws:SourceAccount" = data.aws_caller_identity.current.account_id }
+          ArnLike      = { "aws:SourceArn" = "arn:aws:bedrock:${data.aws_region.current.name}:${data.aws_caller_identity.current.account_id}:agent/*" }
+        }
+      }
+    ]
+  })
+}
+
+resource "aws_iam_role_policy" "agent" {
+  name = "${var.name_prefix}-agent-policy"
+  role = aws_iam_role.agent.id
+
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Effect   = "Allow"
+        Action   = ["bedrock:InvokeModel"]
+        Resource = local.agent_model_arn
+      }
+    ]
+  })
+}
+
+resource "aws_bedrockagent_agent" "orders" {
+  agent_name              = "${var.name_prefix}-orders"
+  agent_resource_role_arn = aws_iam_role.agent.arn
+  foundation_model        = var.agent_model_id
+
+  idle_session_ttl_in_seconds = 600
+  instruction                 = "You are an order support assistant. Always look up an order's status with the get_order_status function before answering, and report the carrier and tracking code it returns."
+
+  depends_on = [aws_iam_role_policy.agent]
+}
+
+resource "aws_bedrockagent_agent_action_group" "orders" {
+  action_group_name = local.action_group_name
+  agent_id          = aws_bedrockagent_agent.orders.agent_id
+  agent_version     = "DRAFT"
+  description       = "Looks up order status"
+
+  # Prepares the draft so the alias below snapshots a version with this group
+  prepare_agent              = true
+  skip_resource_in_use_check = true
+
+  action_group_executor {
+    lambda = aws_lambda_function.order_status.arn
+  }
+
+  function_schema {
+    member_functions {
+      functions {
+        name        = "get_order_status"
+        description = "Returns the shipping status, carrier and tracking code of an order"
+        parameters {
+          map_block_key = "order_id"
+          type          = "string"
+          description   = "The order number"
+          required      = true
+        }
+      }
+    }
+  }
+}
+
+resource "aws_bedrockagent_agent_alias" "live" {
+  agent_alias_name = "live"
+  agent_id         = aws_bedrockagent_agent.orders.agent_id
+  description      = "Alias invoked through the API"
+
+  depends_on = [aws_bedrockagent_agent_action_group.orders]
+}
+
+module "bedrock_api" {
+  source = "../../"
+
+  name_prefix = var.name_prefix
+
+  bedrock_model_id       = var.agent_model_id
+  bedrock_agent_id       = aws_bedrockagent_agent.orders.agent_id
+  bedrock_agent_alias_id = aws_bedrockagent_agent_alias.live.agent_alias_id
+  enable_agent_trace     = true
+
+  api_stage_name     = "dev"
+  log_retention_days = 7
+
+  tags = var.tags
+}
+
+output "agent_api_url" {
+  description = "Bedrock agent endpoint URL"
+  value       = module.bedrock_api.agent_api_url
+}
+
+output "agent_id" {
+  description = "ID of the agent"
+  value       = aws_bedrockagent_agent.orders.agent_id
+}
+
+output "agent_alias_id" {
+  description = "ID of the alias the API invokes"
+  value       = aws_bedrockagent_agent_alias.live.agent_alias_id
+}
+
+output "action_group_name" {
+  description = "Name of the agent's Lambda action group"
+  value       = local.action_group_name
+}
@@ -0,0 +1,27 @@
+"""Action group for the agent example: looks up a made-up order's status"""
+import json
+
+# Fixed answers, so callers can tell the agent used the action group rather than guessing
+CARRIER = 'Kestrel Freight'
+
+
+def lambda_handler(event, context):
+    """Answer a function-schema action group call in the format Bedrock agents expect"""
+    parameters = {p['name']: p['value'] for p in event.get('parameters', [])}
+    order_id = parameters.get('order_id', '')
+    body = {
+        'order_id': order_id,
+        'status': 'shipped',
+        'carrier': CARRIER,
+        'tracking_code': f"KF-{order_id}"
+    }
+    return {
+        'messageVersion': '1.0',
+        'response': {
+            'actionGroup': event['actionGroup'],
+            'function': event['function'],
+            'functionResponse': {
+                'responseBody': {'TEXT': {'body': json.dumps(body)}}
+            }
+        }
+    }
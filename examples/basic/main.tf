@@ -11,6 +11,22 @@ terraform {
   }
 }
 
+variable "name_prefix" {
+  description = "Prefix for resource names"
+  type        = string
+  default     = "basic-example"
+}
+
+variable "tags" {
+  description = "Tags applied to the module's resources"
+  type        = map(string)
+  default = {
+    Environment = "dev"
+    Project     = "example"
+    ManagedBy   = "terraform"
+  }
+}
+
 provider "aws" {
   region = "us-east-1"  # Region where Bedrock is available
 }
@@ -18,7 +34,7 @@ provider "aws" {
 module "bedrock_api" {
   source = "../../"
 
-  name_prefix = "basic-example"
+  name_prefix = var.name_prefix
   
   # Basic configuration with defaults
   bedrock_model_id = "anthropic.claude-3-sonnet-20240229-v1:0"
@@ -34,11 +50,7 @@ module "bedrock_api" {
   enable_monitoring = true
   log_retention_days = 7
   
-  tags = {
-    Environment = "dev"
-    Project     = "example"
-    ManagedBy   = "terraform"
-  }
+  tags = var.tags
 }
 
 # Outputs
@@ -56,11 +68,3 @@ output "cloudwatch_log_group" {
   description = "Name of the CloudWatch log group"
   value       = module.bedrock_api.cloudwatch_log_group_name
 }
-  description = "Lambda function name"
-  value       = module.bedrock_api.lambda_function_name
-}
-
-output "cloudwatch_log_group" {
-  description = "CloudWatch log group name"
-  value       = module.bedrock_api.cloudwatch_log_group_name
-} 
\ No newline at end of file
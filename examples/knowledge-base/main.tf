@@ -0,0 +1,96 @@
+# Knowledge base example for Amazon Bedrock + Lambda + API Gateway module
+# Answers questions from documents in S3 through a /retrieve route
+
+terraform {
+  required_version = "~> 1.13.0"
+
+  required_providers {
+    aws = {
+      source  = "hashicorp/aws"
+      version = "~> 6.2.0"
+    }
+    opensearch = {
+      source  = "opensearch-project/opensearch"
+      version = "~> 2.3"
+    }
+  }
+}
+
+variable "name_prefix" {
+  description = "Prefix for resource names (lowercase, for OpenSearch Serverless)"
+  type        = string
+  default     = "kb-example"
+}
+
+variable "region" {
+  description = "AWS region with Bedrock knowledge bases and the models below"
+  type        = string
+  default     = "us-east-1"
+}
+
+variable "tags" {
+  description = "Tags applied to the knowledge base and API resources"
+  type        = map(string)
+  default = {
+    Environment = "dev"
+    Project     = "example"
+    ManagedBy   = "terraform"
+  }
+}
+
+provider "aws" {
+  region = var.region
+}
+
+# The index is created through the collection's own endpoint with SigV4
+provider "opensearch" {
+  url               = module.knowledge_base.collection_endpoint
+  aws_region        = var.region
+  healthcheck       = false
+  sign_aws_requests = true
+}
+
+module "knowledge_base" {
+  source = "../../modules/knowledge-base"
+
+  name_prefix = var.name_prefix
+
+  tags = var.tags
+}
+
+module "bedrock_api" {
+  source = "../../"
+
+  name_prefix = var.name_prefix
+
+  # Answers are generated by this model from the retrieved chunks
+  bedrock_model_id          = "anthropic.claude-3-haiku-20240307-v1:0"
+  bedrock_knowledge_base_id = module.knowledge_base.knowledge_base_id
+
+  knowledge_base_number_of_results = 5
+
+  api_stage_name     = "dev"
+  log_retention_days = 7
+
+  tags = var.tags
+}
+
+output "retrieve_api_url" {
+  description = "Retrieve-and-generate endpoint URL"
+  value       = module.bedrock_api.retrieve_api_url
+}
+
+output "knowledge_base_id" {
+  description = "ID of the knowledge base"
+  value       = module.knowledge_base.knowledge_base_id
+}
+
+output "data_source_id" {
+  description = "ID of the S3 data source to start ingestion jobs for"
+  value       = module.knowledge_base.data_source_id
+}
+
+output "data_source_bucket" {
+  description = "Bucket to upload documents to before starting an ingestion job"
+  value       = module.knowledge_base.documents_bucket_name
+}
@@ -0,0 +1,181 @@
+# Private networking example for Amazon Bedrock + Lambda + API Gateway module
+# Runs the Lambda in private subnets with no internet route, reaching Bedrock
+# through a bedrock-runtime interface endpoint (PrivateLink)
+
+terraform {
+  required_version = "~> 1.13.0"
+
+  required_providers {
+    aws = {
+      source  = "hashicorp/aws"
+      version = "~> 6.2.0"
+    }
+  }
+}
+
+variable "name_prefix" {
+  description = "Prefix for resource names"
+  type        = string
+  default     = "private-example"
+}
+
+variable "region" {
+  description = "AWS region with Bedrock and a bedrock-runtime endpoint service"
+  type        = string
+  default     = "us-east-1"
+}
+
+variable "restrict_egress" {
+  description = "Allow the Lambda security group HTTPS egress to the endpoint only, instead of all egress"
+  type        = bool
+  default     = true
+}
+
+variable "vpc_cidr" {
+  description = "CIDR block of the VPC"
+  type        = string
+  default     = "10.20.0.0/16"
+}
+
+variable "tags" {
+  description = "Tags applied to the network and API resources"
+  type        = map(string)
+  default = {
+    Environment = "dev"
+    Project     = "example"
+    ManagedBy   = "terraform"
+  }
+}
+
+provider "aws" {
+  region = var.region
+}
+
+data "aws_region" "current" {}
+
+data "aws_availability_zones" "available" {
+  state = "available"
+}
+
+locals {
+  azs = slice(data.aws_availability_zones.available.names, 0, 2)
+}
+
+# VPC with private subnets only: no internet gateway and no NAT gateway
+resource "aws_vpc" "private" {
+  cidr_block = var.vpc_cidr
+
+  # Private DNS on the endpoint resolves the public Bedrock hostname to it
+  enable_dns_support   = true
+  enable_dns_hostnames = true
+
+  tags = merge(var.tags, { Name = "${var.name_prefix}-vpc" })
+}
+
+resource "aws_subnet" "private" {
+  count             = length(local.azs)
+  vpc_id            = aws_vpc.private.id
+  cidr_block        = cidrsubnet(var.vpc_cidr, 8, count.index)
+  availability_zone = local.azs[count.index]
+
+  tags = merge(var.tags, { Name = "${var.name_prefix}-private-${local.azs[count.index]}" })
+}
+
+# Security groups. Rules are separate resources since each group refers to the other.
+resource "aws_security_group" "lambda" {
+  name        = "${var.name_prefix}-lambda"
+  description = "Bedrock API Lambda"
+  vpc_id      = aws_vpc.private.id
+  tags        = var.tags
+}
+
+resource "aws_security_group" "endpoints" {
+  name        = "${var.name_prefix}-endpoints"
+  description = "Interface endpoints reachable from the Bedrock API Lambda"
+  vpc_id      = aws_vpc.private.id
+  tags        = var.tags
+}
+
+resource "aws_vpc_security_group_egress_rule" "lambda_to_endpoints" {
+  count                        = var.restrict_egress ? 1 : 0
+  security_group_id            = aws_security_group.lambda.id
+  description                  = "HTTPS to the interface endpoints"
+  referenced_security_group_id = aws_security_group.endpoints.id
+  ip_protocol                  = "tcp"
+  from_port                    = 443
+  to_port                      = 443
+}
+
+resource "aws_vpc_security_group_egress_rule" "lambda_all" {
+  count             = var.restrict_egress ? 0 : 1
+  security_group_id = aws_security_group.lambda.id
+  description       = "All egress; the subnets still have no internet route"
+  cidr_ipv4         = "0.0.0.0/0"
+  ip_protocol       = "-1"
+}
+
+resource "aws_vpc_security_group_ingress_rule" "endpoints_from_lambda" {
+  security_group_id            = aws_security_group.endpoints.id
+  description                  = "HTTPS from the Bedrock API Lambda"
+  referenced_security_group_id = aws_security_group.lambda.id
+  ip_protocol                  = "tcp"
+  from_port                    = 443
+  to_port                      = 443
+}
+
+resource "aws_vpc_endpoint" "bedrock_runtime" {
+  vpc_id              = aws_vpc.private.id
+  service_name        = "com.amazonaws.${data.aws_region.current.name}.bedrock-runtime"
+  vpc_endpoint_type   = "Interface"
+  subnet_ids          = aws_subnet.private[*].id
+  security_group_ids  = [aws_security_group.endpoints.id]
+  private_dns_enabled = true
+
+  tags = merge(var.tags, { Name = "${var.name_prefix}-bedrock-runtime" })
+}
+
+module "bedrock_api" {
+  source = "../../"
+
+  name_prefix = var.name_prefix
+
+  bedrock_model_id = "anthropic.claude-3-haiku-20240307-v1:0"
+
+  vpc_subnet_ids         = aws_subnet.private[*].id
+  vpc_security_group_ids = [aws_security_group.lambda.id]
+
+  api_stage_name     = "dev"
+  log_retention_days = 7
+
+  tags = var.tags
+}
+
+output "api_url" {
+  description = "API Gateway endpoint URL"
+  value       = module.bedrock_api.api_gateway_url
+}
+
+output "lambda_function_name" {
+  description = "Name of the Lambda function"
+  value       = module.bedrock_api.lambda_function_name
+}
+
+output "vpc_id" {
+  description = "ID of the private VPC"
+  value       = aws_vpc.private.id
+}
+
+output "subnet_ids" {
+  description = "Private subnets the Lambda is attached to"
+  value       = aws_subnet.private[*].id
+}
+
+output "lambda_security_group_id" {
+  description = "Security group of the Lambda"
+  value       = aws_security_group.lambda.id
+}
+
+output "bedrock_runtime_endpoint_id" {
+  description = "ID of the bedrock-runtime interface endpoint"
+  value       = aws_vpc_endpoint.bedrock_runtime.id
+}
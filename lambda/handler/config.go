@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// maxTokensLimit caps the max_tokens a request can ask for, as the
+// max_tokens variable does
+const maxTokensLimit = 4096
+
+// Config holds the settings the module passes as environment variables.
+type Config struct {
+	ModelID        string
+	MaxTokens      int32
+	Temperature    float32
+	TopP           float32
+	HandlerVersion string
+	CORSOrigin     string
+	// CORSAllowCredentials sends Access-Control-Allow-Credentials, as
+	// cors_allow_credentials does for the Python handler
+	CORSAllowCredentials bool
+	// APIStyle is "invoke" for each family's native InvokeModel body or
+	// "converse" for the Converse API, as the api_style variable sets
+	APIStyle string
+	// Tracing records a subsegment with token counts for each Bedrock call
+	Tracing bool
+}
+
+// loadConfig reads Config through getenv, normally os.Getenv. MAX_TOKENS,
+// TEMPERATURE, TOP_P and API_STYLE default to the module variables' defaults.
+func loadConfig(getenv func(string) string) (Config, error) {
+	config := Config{
+		ModelID:              getenv("BEDROCK_MODEL_ID"),
+		MaxTokens:            1000,
+		Temperature:          0.7,
+		TopP:                 0.9,
+		HandlerVersion:       getenv("HANDLER_VERSION"),
+		CORSOrigin:           "*",
+		APIStyle:             "invoke",
+		Tracing:              getenv("TRACING_ENABLED") == "true",
+		CORSAllowCredentials: getenv("CORS_ALLOW_CREDENTIALS") == "true",
+	}
+	if config.ModelID == "" {
+		return Config{}, errors.New("BEDROCK_MODEL_ID is required")
+	}
+	if origin := getenv("CORS_ALLOWED_ORIGIN"); origin != "" {
+		config.CORSOrigin = origin
+	}
+	if style := getenv("API_STYLE"); style != "" {
+		config.APIStyle = style
+	}
+	switch {
+	case config.APIStyle != "invoke" && config.APIStyle != "converse":
+		return Config{}, fmt.Errorf("API_STYLE must be invoke or converse, got %q", config.APIStyle)
+	case config.APIStyle == "invoke" && modelFamily(config.ModelID) == "":
+		return Config{}, fmt.Errorf("model %s has no InvokeModel body format; set API_STYLE to converse", config.ModelID)
+	}
+
+	if value := getenv("MAX_TOKENS"); value != "" {
+		maxTokens, err := strconv.ParseInt(value, 10, 32)
+		if err != nil || maxTokens < 1 || maxTokens > maxTokensLimit {
+			return Config{}, fmt.Errorf("MAX_TOKENS must be an integer between 1 and %d, got %q", maxTokensLimit, value)
+		}
+		config.MaxTokens = int32(maxTokens)
+	}
+
+	for name, field := range map[string]*float32{"TEMPERATURE": &config.Temperature, "TOP_P": &config.TopP} {
+		value := getenv(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.ParseFloat(value, 32)
+		if err != nil || parsed < 0 || parsed > 1 {
+			return Config{}, fmt.Errorf("%s must be a number between 0 and 1, got %q", name, value)
+		}
+		*field = float32(parsed)
+	}
+	return config, nil
+}
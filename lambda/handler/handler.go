@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+	"github.com/aws/aws-xray-sdk-go/xray"
+)
+
+// BedrockInvoker is the part of the Bedrock runtime client the handler uses.
+// *bedrockruntime.Client satisfies it; tests substitute a mock.
+type BedrockInvoker interface {
+	InvokeModel(ctx context.Context, params *bedrockruntime.InvokeModelInput, optFns ...func(*bedrockruntime.Options)) (*bedrockruntime.InvokeModelOutput, error)
+	Converse(ctx context.Context, params *bedrockruntime.ConverseInput, optFns ...func(*bedrockruntime.Options)) (*bedrockruntime.ConverseOutput, error)
+}
+
+// Handler serves the module's API Gateway routes.
+type Handler struct {
+	config Config
+	client BedrockInvoker
+	logger *slog.Logger
+}
+
+// NewHandler returns a Handler calling Bedrock through client.
+func NewHandler(config Config, client BedrockInvoker, logger *slog.Logger) *Handler {
+	return &Handler{config: config, client: client, logger: logger}
+}
+
+// completionRequest is a validated POST /bedrock body.
+type completionRequest struct {
+	Prompt      string
+	System      string
+	MaxTokens   int32
+	Temperature float32
+	TopP        float32
+}
+
+// usage reports tokens the way the Python handler does.
+type usage struct {
+	InputTokens  int32 `json:"input_tokens"`
+	OutputTokens int32 `json:"output_tokens"`
+}
+
+// Handle routes an API Gateway proxy event. Errors are returned as responses;
+// the returned error is always nil so Lambda never retries a request.
+func (h *Handler) Handle(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	start := time.Now()
+	requestID := ""
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		requestID = lc.AwsRequestID
+	}
+
+	// Health checks bypass request validation and never call Bedrock
+	if event.Resource == "/health" {
+		return h.jsonResponse(http.StatusOK, map[string]interface{}{
+			"status":    "ok",
+			"version":   h.config.HandlerVersion,
+			"model_id":  h.config.ModelID,
+			"timestamp": time.Now().Unix(),
+		}), nil
+	}
+
+	// Every other route needs a feature only the Python handler implements
+	if event.Resource != "/bedrock" {
+		return h.jsonResponse(http.StatusNotFound, map[string]interface{}{
+			"error":     true,
+			"code":      "FeatureDisabled",
+			"message":   fmt.Sprintf("The Go handler does not serve %s", event.Resource),
+			"timestamp": time.Now().Unix(),
+		}), nil
+	}
+
+	request, message := h.parseRequest(event)
+	if message != "" {
+		h.logger.Warn("Invalid request", "request_id", requestID, "message", message)
+		return h.jsonResponse(http.StatusBadRequest, map[string]interface{}{
+			"error":     true,
+			"code":      "InvalidRequest",
+			"message":   message,
+			"timestamp": time.Now().Unix(),
+		}), nil
+	}
+
+	result, err := h.complete(ctx, request)
+	latency := time.Since(start)
+	metadata := map[string]interface{}{
+		"execution_time_ms": math.Round(float64(latency.Microseconds())/10) / 100,
+		"timestamp":         time.Now().Unix(),
+		"request_id":        requestID,
+	}
+
+	statusCode, errorCode, errorMessage := http.StatusOK, "", ""
+	if err != nil {
+		statusCode, errorCode, errorMessage = modelError(err)
+	}
+	h.logger.Info("Request summary",
+		"request_id", requestID,
+		"model_id", h.config.ModelID,
+		"success", err == nil,
+		"input_tokens", result.Usage.InputTokens,
+		"output_tokens", result.Usage.OutputTokens,
+		"latency_ms", metadata["execution_time_ms"],
+		"error_code", errorCode,
+	)
+
+	if err != nil {
+		h.logger.Error("Request failed", "request_id", requestID, "error", err.Error())
+		response := h.jsonResponse(statusCode, map[string]interface{}{
+			"success": false,
+			"error": map[string]interface{}{
+				"code":       errorCode,
+				"message":    errorMessage,
+				"request_id": requestID,
+			},
+			"metadata": metadata,
+		})
+		if statusCode == http.StatusTooManyRequests {
+			response.Headers["Retry-After"] = "1"
+		}
+		return response, nil
+	}
+
+	body := map[string]interface{}{
+		"success":  true,
+		"content":  result.Content,
+		"model_id": h.config.ModelID,
+		"usage":    result.Usage,
+		"metadata": metadata,
+	}
+	if result.Truncated {
+		body["truncated"] = true
+	}
+	return h.jsonResponse(http.StatusOK, body), nil
+}
+
+// parseRequest validates a POST /bedrock event against the same rules as the
+// Python handler. message is empty when the request is valid.
+func (h *Handler) parseRequest(event events.APIGatewayProxyRequest) (completionRequest, string) {
+	if event.HTTPMethod != http.MethodPost {
+		return completionRequest{}, "Only POST method supported"
+	}
+	if event.Body == "" {
+		return completionRequest{}, "Request body required"
+	}
+
+	raw := []byte(event.Body)
+	if event.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(event.Body)
+		if err != nil {
+			return completionRequest{}, "Invalid JSON format"
+		}
+		raw = decoded
+	}
+	if !json.Valid(raw) {
+		return completionRequest{}, "Invalid JSON format"
+	}
+	var fields map[string]json.RawMessage
+	if !bytes.HasPrefix(bytes.TrimSpace(raw), []byte("{")) || json.Unmarshal(raw, &fields) != nil {
+		return completionRequest{}, "Request body must be a JSON object"
+	}
+
+	request := completionRequest{
+		MaxTokens:   h.config.MaxTokens,
+		Temperature: h.config.Temperature,
+		TopP:        h.config.TopP,
+	}
+	prompt, ok := fields["prompt"]
+	if !ok || string(prompt) == "null" {
+		return completionRequest{}, "Prompt field required"
+	}
+	if json.Unmarshal(prompt, &request.Prompt) != nil {
+		return completionRequest{}, "prompt must be a string"
+	}
+	if request.Prompt == "" {
+		return completionRequest{}, "Prompt field required"
+	}
+	if value, ok := fields["system"]; ok {
+		if json.Unmarshal(value, &request.System) != nil || request.System == "" {
+			return completionRequest{}, "system must be a non-empty string"
+		}
+	}
+
+	if value, ok := fields["max_tokens"]; ok {
+		maxTokens, err := strconv.ParseInt(string(value), 10, 32)
+		if err != nil || maxTokens < 1 || maxTokens > maxTokensLimit {
+			return completionRequest{}, fmt.Sprintf("max_tokens must be an integer between 1 and %d", maxTokensLimit)
+		}
+		request.MaxTokens = int32(maxTokens)
+	}
+	for name, field := range map[string]*float32{"temperature": &request.Temperature, "top_p": &request.TopP} {
+		value, ok := fields[name]
+		if !ok {
+			continue
+		}
+		if json.Unmarshal(value, field) != nil || *field < 0 || *field > 1 {
+			return completionRequest{}, name + " must be between 0 and 1"
+		}
+	}
+	return request, ""
+}
+
+// modelError maps a failed Bedrock call to a status, code and client message.
+// Throttling and rejected parameters are the caller's to act on; anything
+// else is hidden behind a generic 500.
+func modelError(err error) (int, string, string) {
+	var throttling *types.ThrottlingException
+	var validation *types.ValidationException
+	switch {
+	case errors.As(err, &throttling):
+		return http.StatusTooManyRequests, "ModelThrottled", "The model is throttling requests, retry later"
+	case errors.As(err, &validation):
+		return http.StatusBadRequest, "ModelValidationError", "The model rejected the request parameters"
+	}
+	return http.StatusInternalServerError, "ModelError", "The model request failed"
+}
+
+// complete clamps the request to the model's limits and sends it with the
+// configured API style. With tracing on, the call is wrapped in a subsegment
+// annotated with the model and token counts.
+func (h *Handler) complete(ctx context.Context, request completionRequest) (completion, error) {
+	request = clampParameters(h.config.ModelID, request)
+
+	var segment *xray.Segment
+	if h.config.Tracing {
+		name := "Bedrock InvokeModel"
+		if h.config.APIStyle == "converse" {
+			name = "Bedrock Converse"
+		}
+		ctx, segment = xray.BeginSubsegment(ctx, name)
+	}
+
+	var result completion
+	var err error
+	if h.config.APIStyle == "converse" {
+		result, err = h.converse(ctx, request)
+	} else {
+		result, err = h.invokeModel(ctx, request)
+	}
+
+	if segment != nil {
+		segment.AddAnnotation("model_id", h.config.ModelID)
+		segment.AddAnnotation("input_tokens", int(result.Usage.InputTokens))
+		segment.AddAnnotation("output_tokens", int(result.Usage.OutputTokens))
+		segment.Close(err)
+	}
+	if err != nil {
+		return completion{}, err
+	}
+	return result, nil
+}
+
+// invokeModel sends the model family's native body through InvokeModel.
+func (h *Handler) invokeModel(ctx context.Context, request completionRequest) (completion, error) {
+	body, err := invokeBody(h.config.ModelID, request)
+	if err != nil {
+		return completion{}, err
+	}
+	output, err := h.client.InvokeModel(ctx, &bedrockruntime.InvokeModelInput{
+		ModelId:     aws.String(h.config.ModelID),
+		Body:        body,
+		ContentType: aws.String("application/json"),
+		Accept:      aws.String("application/json"),
+	})
+	if err != nil {
+		return completion{}, err
+	}
+	return parseInvokeBody(h.config.ModelID, output.Body)
+}
+
+// converse sends the request through the Converse API.
+func (h *Handler) converse(ctx context.Context, request completionRequest) (completion, error) {
+	input := &bedrockruntime.ConverseInput{
+		ModelId: aws.String(h.config.ModelID),
+		Messages: []types.Message{{
+			Role:    types.ConversationRoleUser,
+			Content: []types.ContentBlock{&types.ContentBlockMemberText{Value: request.Prompt}},
+		}},
+		InferenceConfig: &types.InferenceConfiguration{
+			MaxTokens:   aws.Int32(request.MaxTokens),
+			Temperature: aws.Float32(request.Temperature),
+			TopP:        aws.Float32(request.TopP),
+		},
+	}
+	if request.System != "" {
+		input.System = []types.SystemContentBlock{&types.SystemContentBlockMemberText{Value: request.System}}
+	}
+
+	output, err := h.client.Converse(ctx, input)
+	if err != nil {
+		return completion{}, err
+	}
+	var result completion
+	if message, ok := output.Output.(*types.ConverseOutputMemberMessage); ok {
+		for _, block := range message.Value.Content {
+			if text, ok := block.(*types.ContentBlockMemberText); ok {
+				result.Content += text.Value
+			}
+		}
+	}
+	if output.Usage != nil {
+		result.Usage = usage{aws.ToInt32(output.Usage.InputTokens), aws.ToInt32(output.Usage.OutputTokens)}
+	}
+	result.Truncated = output.StopReason == types.StopReasonMaxTokens
+	return result, nil
+}
+
+// jsonResponse is an API Gateway proxy response with the Python handler's
+// default headers.
+func (h *Handler) jsonResponse(statusCode int, body interface{}) events.APIGatewayProxyResponse {
+	encoded, _ := json.Marshal(body)
+	headers := map[string]string{
+		"Content-Type":                 "application/json",
+		"Access-Control-Allow-Origin":  h.config.CORSOrigin,
+		"Access-Control-Allow-Headers": "Content-Type,X-Amz-Date,Authorization,X-Api-Key,X-Amz-Security-Token",
+		"Access-Control-Allow-Methods": "GET,POST,OPTIONS",
+	}
+	if h.config.CORSAllowCredentials {
+		headers["Access-Control-Allow-Credentials"] = "true"
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: statusCode,
+		Headers:    headers,
+		Body:       string(encoded),
+	}
+}
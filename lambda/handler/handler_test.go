@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockInvoker records each Bedrock call and answers InvokeModel with body,
+// Converse with output, or either with err.
+type mockInvoker struct {
+	invokeInputs   []*bedrockruntime.InvokeModelInput
+	converseInputs []*bedrockruntime.ConverseInput
+	body           string
+	output         *bedrockruntime.ConverseOutput
+	err            error
+}
+
+func (m *mockInvoker) InvokeModel(ctx context.Context, params *bedrockruntime.InvokeModelInput, optFns ...func(*bedrockruntime.Options)) (*bedrockruntime.InvokeModelOutput, error) {
+	m.invokeInputs = append(m.invokeInputs, params)
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &bedrockruntime.InvokeModelOutput{Body: []byte(m.body), ContentType: aws.String("application/json")}, nil
+}
+
+func (m *mockInvoker) Converse(ctx context.Context, params *bedrockruntime.ConverseInput, optFns ...func(*bedrockruntime.Options)) (*bedrockruntime.ConverseOutput, error) {
+	m.converseInputs = append(m.converseInputs, params)
+	return m.output, m.err
+}
+
+// calls is how many requests reached Bedrock through either API.
+func (m *mockInvoker) calls() int {
+	return len(m.invokeInputs) + len(m.converseInputs)
+}
+
+func textOutput(text string, stopReason types.StopReason) *bedrockruntime.ConverseOutput {
+	return &bedrockruntime.ConverseOutput{
+		Output: &types.ConverseOutputMemberMessage{Value: types.Message{
+			Role:    types.ConversationRoleAssistant,
+			Content: []types.ContentBlock{&types.ContentBlockMemberText{Value: text}},
+		}},
+		StopReason: stopReason,
+		Usage:      &types.TokenUsage{InputTokens: aws.Int32(7), OutputTokens: aws.Int32(3)},
+	}
+}
+
+func testConfig() Config {
+	return Config{ModelID: "anthropic.claude-3-haiku-20240307-v1:0", MaxTokens: 1000, Temperature: 0.7, TopP: 0.9, CORSOrigin: "*", APIStyle: "invoke"}
+}
+
+func converseConfig() Config {
+	config := testConfig()
+	config.APIStyle = "converse"
+	return config
+}
+
+func newTestHandler(config Config, client BedrockInvoker) *Handler {
+	return NewHandler(config, client, slog.New(slog.NewJSONHandler(&bytes.Buffer{}, nil)))
+}
+
+// invoke runs the handler on a POST /bedrock event and decodes the body.
+func invoke(t *testing.T, handler *Handler, method string, body string) (int, map[string]interface{}) {
+	t.Helper()
+	response := invokeResponse(t, handler, method, body)
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(response.Body), &decoded))
+	return response.StatusCode, decoded
+}
+
+func invokeResponse(t *testing.T, handler *Handler, method string, body string) events.APIGatewayProxyResponse {
+	t.Helper()
+	ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: "req-123"})
+	response, err := handler.Handle(ctx, events.APIGatewayProxyRequest{
+		Resource:   "/bedrock",
+		HTTPMethod: method,
+		Body:       body,
+	})
+	require.NoError(t, err)
+	return response
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Parallel()
+
+	env := map[string]string{"BEDROCK_MODEL_ID": "amazon.titan-text-express-v1"}
+	config, err := loadConfig(func(key string) string { return env[key] })
+	require.NoError(t, err)
+	assert.Equal(t, Config{ModelID: "amazon.titan-text-express-v1", MaxTokens: 1000, Temperature: 0.7, TopP: 0.9, CORSOrigin: "*", APIStyle: "invoke"}, config)
+
+	env = map[string]string{
+		"BEDROCK_MODEL_ID":       "amazon.titan-text-express-v1",
+		"MAX_TOKENS":             "256",
+		"TEMPERATURE":            "0.2",
+		"TOP_P":                  "1",
+		"CORS_ALLOWED_ORIGIN":    "https://app.example.com",
+		"TRACING_ENABLED":        "true",
+		"API_STYLE":              "converse",
+		"CORS_ALLOW_CREDENTIALS": "true",
+	}
+	config, err = loadConfig(func(key string) string { return env[key] })
+	require.NoError(t, err)
+	assert.EqualValues(t, 256, config.MaxTokens)
+	assert.InDelta(t, 0.2, config.Temperature, 1e-6)
+	assert.InDelta(t, 1.0, config.TopP, 1e-6)
+	assert.Equal(t, "https://app.example.com", config.CORSOrigin)
+	assert.True(t, config.CORSAllowCredentials)
+	assert.True(t, config.Tracing)
+	assert.Equal(t, "converse", config.APIStyle)
+
+	for _, invalid := range []map[string]string{
+		{},
+		{"BEDROCK_MODEL_ID": "m", "MAX_TOKENS": "5000"},
+		{"BEDROCK_MODEL_ID": "m", "TEMPERATURE": "1.5"},
+		{"BEDROCK_MODEL_ID": "m", "TOP_P": "high"},
+		{"BEDROCK_MODEL_ID": "amazon.titan-text-express-v1", "API_STYLE": "stream"},
+		// Only the converse style reaches families without a native body
+		{"BEDROCK_MODEL_ID": "amazon.nova-lite-v1:0"},
+	} {
+		invalid := invalid
+		_, err := loadConfig(func(key string) string { return invalid[key] })
+		assert.Error(t, err, "%v", invalid)
+	}
+}
+
+func TestHandleSendsConverseRequest(t *testing.T) {
+	t.Parallel()
+
+	client := &mockInvoker{output: textOutput("Hello there", types.StopReasonEndTurn)}
+	handler := newTestHandler(converseConfig(), client)
+
+	statusCode, body := invoke(t, handler, "POST", `{"prompt": "Hello", "system": "Be brief", "max_tokens": 64, "temperature": 0.1}`)
+	require.Equal(t, 200, statusCode, "unexpected response: %v", body)
+	assert.Equal(t, true, body["success"])
+	assert.Equal(t, "Hello there", body["content"])
+	assert.Equal(t, "anthropic.claude-3-haiku-20240307-v1:0", body["model_id"])
+	assert.Equal(t, map[string]interface{}{"input_tokens": 7.0, "output_tokens": 3.0}, body["usage"])
+	assert.Equal(t, "req-123", body["metadata"].(map[string]interface{})["request_id"])
+	assert.NotContains(t, body, "truncated")
+
+	require.Len(t, client.converseInputs, 1)
+	assert.Empty(t, client.invokeInputs)
+	input := client.converseInputs[0]
+	assert.Equal(t, "anthropic.claude-3-haiku-20240307-v1:0", aws.ToString(input.ModelId))
+	require.Len(t, input.Messages, 1)
+	assert.Equal(t, types.ConversationRoleUser, input.Messages[0].Role)
+	assert.Equal(t, &types.ContentBlockMemberText{Value: "Hello"}, input.Messages[0].Content[0])
+	assert.Equal(t, []types.SystemContentBlock{&types.SystemContentBlockMemberText{Value: "Be brief"}}, input.System)
+	assert.EqualValues(t, 64, aws.ToInt32(input.InferenceConfig.MaxTokens))
+	assert.InDelta(t, 0.1, aws.ToFloat32(input.InferenceConfig.Temperature), 1e-6)
+	// Parameters the request leaves out come from the environment
+	assert.InDelta(t, 0.9, aws.ToFloat32(input.InferenceConfig.TopP), 1e-6)
+}
+
+func TestHandleSendsInvokeModelRequest(t *testing.T) {
+	t.Parallel()
+
+	client := &mockInvoker{body: `{"content": [{"type": "text", "text": "Hi!"}], "stop_reason": "end_turn", "usage": {"input_tokens": 12, "output_tokens": 2}}`}
+	handler := newTestHandler(testConfig(), client)
+
+	statusCode, body := invoke(t, handler, "POST", `{"prompt": "Hello", "system": "Be brief"}`)
+	require.Equal(t, 200, statusCode, "unexpected response: %v", body)
+	assert.Equal(t, "Hi!", body["content"])
+	assert.Equal(t, map[string]interface{}{"input_tokens": 12.0, "output_tokens": 2.0}, body["usage"])
+
+	require.Len(t, client.invokeInputs, 1)
+	assert.Empty(t, client.converseInputs)
+	input := client.invokeInputs[0]
+	assert.Equal(t, "anthropic.claude-3-haiku-20240307-v1:0", aws.ToString(input.ModelId))
+	assert.Equal(t, "application/json", aws.ToString(input.ContentType))
+	assert.JSONEq(t, `{
+		"anthropic_version": "bedrock-2023-05-31",
+		"max_tokens": 1000,
+		"temperature": 0.7,
+		"top_p": 0.9,
+		"system": "Be brief",
+		"messages": [{"role": "user", "content": "Hello"}]
+	}`, string(input.Body))
+}
+
+func TestHandleMarksTruncatedCompletions(t *testing.T) {
+	t.Parallel()
+
+	client := &mockInvoker{output: textOutput("Once upon", types.StopReasonMaxTokens)}
+	handler := newTestHandler(converseConfig(), client)
+
+	statusCode, body := invoke(t, handler, "POST", `{"prompt": "Tell a story", "max_tokens": 2}`)
+	require.Equal(t, 200, statusCode)
+	assert.Equal(t, true, body["truncated"])
+}
+
+func TestHandleRejectsInvalidRequests(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name    string
+		method  string
+		body    string
+		message string
+	}{
+		{"wrong_method", "GET", `{"prompt": "hi"}`, "Only POST method supported"},
+		{"empty_body", "POST", "", "Request body required"},
+		{"non_json_body", "POST", "prompt=hello", "Invalid JSON format"},
+		{"non_object_body", "POST", `["hello"]`, "Request body must be a JSON object"},
+		{"missing_prompt", "POST", `{"max_tokens": 10}`, "Prompt field required"},
+		{"non_string_prompt", "POST", `{"prompt": 42}`, "prompt must be a string"},
+		{"empty_system", "POST", `{"prompt": "hi", "system": ""}`, "system must be a non-empty string"},
+		{"oversized_max_tokens", "POST", `{"prompt": "hi", "max_tokens": 100000}`, "max_tokens must be an integer between 1 and 4096"},
+		{"fractional_max_tokens", "POST", `{"prompt": "hi", "max_tokens": 10.5}`, "max_tokens must be an integer between 1 and 4096"},
+		{"temperature_out_of_range", "POST", `{"prompt": "hi", "temperature": 2}`, "temperature must be between 0 and 1"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			client := &mockInvoker{}
+			handler := newTestHandler(testConfig(), client)
+
+			statusCode, body := invoke(t, handler, tc.method, tc.body)
+			assert.Equal(t, 400, statusCode)
+			assert.Equal(t, true, body["error"])
+			assert.Equal(t, "InvalidRequest", body["code"])
+			assert.Equal(t, tc.message, body["message"])
+			assert.Zero(t, client.calls(), "invalid requests should never reach Bedrock")
+		})
+	}
+}
+
+func TestHandleHidesModelErrors(t *testing.T) {
+	t.Parallel()
+
+	client := &mockInvoker{err: errors.New("connection reset by peer")}
+	handler := newTestHandler(testConfig(), client)
+
+	statusCode, body := invoke(t, handler, "POST", `{"prompt": "Hello"}`)
+	assert.Equal(t, 500, statusCode)
+	assert.Equal(t, false, body["success"])
+	assert.Equal(t, map[string]interface{}{
+		"code":       "ModelError",
+		"message":    "The model request failed",
+		"request_id": "req-123",
+	}, body["error"])
+}
+
+func TestHandleMapsModelErrors(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name       string
+		err        error
+		statusCode int
+		code       string
+	}{
+		{"throttling", &types.ThrottlingException{Message: aws.String("Too many requests, please wait before trying again.")}, 429, "ModelThrottled"},
+		{"validation", &types.ValidationException{Message: aws.String("Malformed input request")}, 400, "ModelValidationError"},
+		// The SDK wraps service errors, so the mapping has to see through that
+		{"wrapped_throttling", fmt.Errorf("operation error Bedrock Runtime: InvokeModel: %w", &types.ThrottlingException{}), 429, "ModelThrottled"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			handler := newTestHandler(testConfig(), &mockInvoker{err: tc.err})
+			response := invokeResponse(t, handler, "POST", `{"prompt": "Hello"}`)
+			assert.Equal(t, tc.statusCode, response.StatusCode)
+
+			var body map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(response.Body), &body))
+			assert.Equal(t, false, body["success"])
+			assert.Equal(t, tc.code, body["error"].(map[string]interface{})["code"])
+			assert.NotContains(t, response.Body, "Malformed input request", "Bedrock's message should not reach the client")
+			if tc.statusCode == 429 {
+				assert.Equal(t, "1", response.Headers["Retry-After"])
+			} else {
+				assert.NotContains(t, response.Headers, "Retry-After")
+			}
+		})
+	}
+}
+
+func TestHandleHealth(t *testing.T) {
+	t.Parallel()
+
+	client := &mockInvoker{}
+	config := testConfig()
+	config.HandlerVersion = "1.4.0-abc123"
+	response, err := newTestHandler(config, client).Handle(context.Background(), events.APIGatewayProxyRequest{
+		Resource:   "/health",
+		HTTPMethod: "GET",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 200, response.StatusCode)
+	assert.Contains(t, response.Body, `"status":"ok"`)
+	assert.Contains(t, response.Body, `"version":"1.4.0-abc123"`)
+	assert.Zero(t, client.calls())
+}
+
+func TestHandleRejectsPythonOnlyRoutes(t *testing.T) {
+	t.Parallel()
+
+	client := &mockInvoker{output: textOutput("Hello there", types.StopReasonEndTurn)}
+	handler := newTestHandler(converseConfig(), client)
+	for _, resource := range []string{"/retrieve", "/agent", "/images", "/batch", "/result/{job_id}"} {
+		response, err := handler.Handle(context.Background(), events.APIGatewayProxyRequest{
+			Resource:   resource,
+			HTTPMethod: "POST",
+			Body:       `{"prompt": "Hello"}`,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 404, response.StatusCode, resource)
+		assert.Contains(t, response.Body, `"code":"FeatureDisabled"`, resource)
+	}
+	assert.Zero(t, client.calls(), "unserved routes should never reach Bedrock")
+}
+
+func TestHandleSendsCredentialsHeader(t *testing.T) {
+	t.Parallel()
+
+	client := &mockInvoker{output: textOutput("Hello there", types.StopReasonEndTurn)}
+	response := invokeResponse(t, newTestHandler(converseConfig(), client), "POST", `{"prompt": "Hello"}`)
+	assert.NotContains(t, response.Headers, "Access-Control-Allow-Credentials")
+
+	config := converseConfig()
+	config.CORSOrigin = "https://app.example.com"
+	config.CORSAllowCredentials = true
+	response = invokeResponse(t, newTestHandler(config, client), "POST", `{"prompt": "Hello"}`)
+	assert.Equal(t, "https://app.example.com", response.Headers["Access-Control-Allow-Origin"])
+	assert.Equal(t, "true", response.Headers["Access-Control-Allow-Credentials"])
+}
+
+func TestHandleLogsRequestSummary(t *testing.T) {
+	t.Parallel()
+
+	var logs bytes.Buffer
+	client := &mockInvoker{output: textOutput("Hello there", types.StopReasonEndTurn)}
+	handler := NewHandler(converseConfig(), client, slog.New(slog.NewJSONHandler(&logs, nil)))
+
+	statusCode, _ := invoke(t, handler, "POST", `{"prompt": "Hello"}`)
+	require.Equal(t, 200, statusCode)
+
+	var summary map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &entry), "log lines should be JSON: %s", line)
+		if entry["msg"] == "Request summary" {
+			summary = entry
+		}
+	}
+	require.NotNil(t, summary, "a request summary should be logged: %s", logs.String())
+	assert.Equal(t, "INFO", summary["level"])
+	assert.Equal(t, "req-123", summary["request_id"])
+	assert.Equal(t, true, summary["success"])
+	assert.EqualValues(t, 7, summary["input_tokens"])
+	assert.EqualValues(t, 3, summary["output_tokens"])
+	assert.Equal(t, "", summary["error_code"])
+}
@@ -0,0 +1,43 @@
+// Command handler is a Go implementation of the module's Lambda function for
+// the provided.al2023 runtime. It serves POST /bedrock through InvokeModel or
+// the Converse API, following api_style, and GET /health, with JSON logs and
+// optional X-Ray tracing.
+// The bundled Python handler remains the default and covers every feature.
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-xray-sdk-go/instrumentation/awsv2"
+)
+
+func main() {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+	handlerConfig, err := loadConfig(os.Getenv)
+	if err != nil {
+		logger.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+
+	awsConfig, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		logger.Error("loading AWS configuration", "error", err)
+		os.Exit(1)
+	}
+	if handlerConfig.Tracing {
+		awsv2.AWSV2Instrumentor(&awsConfig.APIOptions)
+	}
+
+	client := bedrockruntime.NewFromConfig(awsConfig, func(o *bedrockruntime.Options) {
+		if endpoint := os.Getenv("BEDROCK_ENDPOINT_URL"); endpoint != "" {
+			o.BaseEndpoint = &endpoint
+		}
+	})
+	lambda.Start(NewHandler(handlerConfig, client, logger).Handle)
+}
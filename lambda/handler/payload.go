@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// anthropicVersion is the Messages API version Bedrock expects in Anthropic
+// InvokeModel bodies
+const anthropicVersion = "bedrock-2023-05-31"
+
+// Model families with a native InvokeModel body. Other models need the
+// converse API style.
+const (
+	familyAnthropic = "anthropic"
+	familyTitan     = "titan"
+	familyMeta      = "meta"
+)
+
+// outputTokenCaps are the most tokens a model generates in one response,
+// below maxTokensLimit. Larger requests are clamped rather than sent on for
+// Bedrock to reject.
+var outputTokenCaps = map[string]int32{
+	"amazon.titan-text-premier": 3072,
+	"meta.llama":                2048,
+}
+
+// modelFamily matches the model ID the way the Python handler does, so
+// inference profile IDs such as us.anthropic.claude-... resolve too.
+func modelFamily(modelID string) string {
+	switch {
+	case strings.Contains(modelID, "anthropic"):
+		return familyAnthropic
+	case strings.Contains(modelID, "amazon.titan"):
+		return familyTitan
+	case strings.Contains(modelID, "meta"):
+		return familyMeta
+	}
+	return ""
+}
+
+// completion is a model response reduced to what the handler returns.
+type completion struct {
+	Content   string
+	Usage     usage
+	Truncated bool
+}
+
+// clampParameters fits a validated request to the model's own limits.
+func clampParameters(modelID string, request completionRequest) completionRequest {
+	for prefix, maxTokens := range outputTokenCaps {
+		if strings.Contains(modelID, prefix) && request.MaxTokens > maxTokens {
+			request.MaxTokens = maxTokens
+		}
+	}
+	// Validation already bounds these; the clamp keeps defaults from the
+	// environment in range as well
+	request.Temperature = min(max(request.Temperature, 0), 1)
+	request.TopP = min(max(request.TopP, 0), 1)
+	return request
+}
+
+// renderPrompt is the prompt text for families without a system field, where
+// the system prompt leads the text
+func renderPrompt(request completionRequest) string {
+	if request.System == "" {
+		return request.Prompt
+	}
+	return request.System + "\n\n" + request.Prompt
+}
+
+type anthropicMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type anthropicRequest struct {
+	AnthropicVersion string             `json:"anthropic_version"`
+	MaxTokens        int32              `json:"max_tokens"`
+	Temperature      float32            `json:"temperature"`
+	TopP             float32            `json:"top_p"`
+	System           string             `json:"system,omitempty"`
+	Messages         []anthropicMessage `json:"messages"`
+}
+
+type anthropicResponse struct {
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	StopReason string `json:"stop_reason"`
+	Usage      usage  `json:"usage"`
+}
+
+type titanGenerationConfig struct {
+	MaxTokenCount int32   `json:"maxTokenCount"`
+	Temperature   float32 `json:"temperature"`
+	TopP          float32 `json:"topP"`
+}
+
+type titanRequest struct {
+	InputText            string                `json:"inputText"`
+	TextGenerationConfig titanGenerationConfig `json:"textGenerationConfig"`
+}
+
+type titanResponse struct {
+	InputTextTokenCount int32 `json:"inputTextTokenCount"`
+	Results             []struct {
+		TokenCount       int32  `json:"tokenCount"`
+		OutputText       string `json:"outputText"`
+		CompletionReason string `json:"completionReason"`
+	} `json:"results"`
+}
+
+type metaRequest struct {
+	Prompt      string  `json:"prompt"`
+	MaxGenLen   int32   `json:"max_gen_len"`
+	Temperature float32 `json:"temperature"`
+	TopP        float32 `json:"top_p"`
+}
+
+type metaResponse struct {
+	Generation           string `json:"generation"`
+	PromptTokenCount     int32  `json:"prompt_token_count"`
+	GenerationTokenCount int32  `json:"generation_token_count"`
+	StopReason           string `json:"stop_reason"`
+}
+
+// invokeBody marshals request as the model family's InvokeModel body.
+func invokeBody(modelID string, request completionRequest) ([]byte, error) {
+	switch modelFamily(modelID) {
+	case familyAnthropic:
+		return json.Marshal(anthropicRequest{
+			AnthropicVersion: anthropicVersion,
+			MaxTokens:        request.MaxTokens,
+			Temperature:      request.Temperature,
+			TopP:             request.TopP,
+			System:           request.System,
+			Messages:         []anthropicMessage{{Role: "user", Content: request.Prompt}},
+		})
+	case familyTitan:
+		return json.Marshal(titanRequest{
+			InputText: renderPrompt(request),
+			TextGenerationConfig: titanGenerationConfig{
+				MaxTokenCount: request.MaxTokens,
+				Temperature:   request.Temperature,
+				TopP:          request.TopP,
+			},
+		})
+	case familyMeta:
+		return json.Marshal(metaRequest{
+			Prompt:      renderPrompt(request),
+			MaxGenLen:   request.MaxTokens,
+			Temperature: request.Temperature,
+			TopP:        request.TopP,
+		})
+	}
+	return nil, fmt.Errorf("model %s has no InvokeModel body format; use the converse API style", modelID)
+}
+
+// parseInvokeBody reads the model family's InvokeModel response.
+func parseInvokeBody(modelID string, body []byte) (completion, error) {
+	switch modelFamily(modelID) {
+	case familyAnthropic:
+		var response anthropicResponse
+		if err := json.Unmarshal(body, &response); err != nil {
+			return completion{}, fmt.Errorf("decoding Anthropic response: %w", err)
+		}
+		var content strings.Builder
+		for _, block := range response.Content {
+			if block.Type == "text" {
+				content.WriteString(block.Text)
+			}
+		}
+		return completion{content.String(), response.Usage, response.StopReason == "max_tokens"}, nil
+	case familyTitan:
+		var response titanResponse
+		if err := json.Unmarshal(body, &response); err != nil {
+			return completion{}, fmt.Errorf("decoding Titan response: %w", err)
+		}
+		if len(response.Results) == 0 {
+			return completion{}, errors.New("Titan response has no results")
+		}
+		result := response.Results[0]
+		return completion{result.OutputText, usage{response.InputTextTokenCount, result.TokenCount}, result.CompletionReason == "LENGTH"}, nil
+	case familyMeta:
+		var response metaResponse
+		if err := json.Unmarshal(body, &response); err != nil {
+			return completion{}, fmt.Errorf("decoding Llama response: %w", err)
+		}
+		return completion{response.Generation, usage{response.PromptTokenCount, response.GenerationTokenCount}, response.StopReason == "length"}, nil
+	}
+	return completion{}, fmt.Errorf("model %s has no InvokeModel body format", modelID)
+}
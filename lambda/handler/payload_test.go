@@ -0,0 +1,147 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModelFamily(t *testing.T) {
+	t.Parallel()
+
+	for modelID, family := range map[string]string{
+		"anthropic.claude-3-haiku-20240307-v1:0":       familyAnthropic,
+		"us.anthropic.claude-3-5-sonnet-20241022-v2:0": familyAnthropic,
+		"amazon.titan-text-express-v1":                 familyTitan,
+		"meta.llama3-8b-instruct-v1:0":                 familyMeta,
+		"amazon.nova-lite-v1:0":                        "",
+		"mistral.mistral-7b-instruct-v0:2":             "",
+	} {
+		assert.Equal(t, family, modelFamily(modelID), modelID)
+	}
+}
+
+func TestClampParameters(t *testing.T) {
+	t.Parallel()
+
+	request := completionRequest{Prompt: "hi", MaxTokens: 4096, Temperature: 0.5, TopP: 0.9}
+	assert.EqualValues(t, 3072, clampParameters("amazon.titan-text-premier-v1:0", request).MaxTokens)
+	assert.EqualValues(t, 2048, clampParameters("meta.llama3-8b-instruct-v1:0", request).MaxTokens)
+	// Models without a lower cap keep the validated value
+	assert.EqualValues(t, 4096, clampParameters("anthropic.claude-3-haiku-20240307-v1:0", request).MaxTokens)
+
+	request.MaxTokens = 100
+	assert.EqualValues(t, 100, clampParameters("amazon.titan-text-premier-v1:0", request).MaxTokens)
+
+	for _, tc := range []struct {
+		given, want float32
+	}{
+		{-0.5, 0},
+		{0, 0},
+		{1, 1},
+		{1.7, 1},
+	} {
+		request.Temperature, request.TopP = tc.given, tc.given
+		clamped := clampParameters("anthropic.claude-3-haiku-20240307-v1:0", request)
+		assert.Equal(t, tc.want, clamped.Temperature, "temperature %v", tc.given)
+		assert.Equal(t, tc.want, clamped.TopP, "top_p %v", tc.given)
+	}
+}
+
+func TestInvokeBodyTemplatesPrompt(t *testing.T) {
+	t.Parallel()
+
+	request := completionRequest{Prompt: "Summarize this", System: "You are terse", MaxTokens: 200, Temperature: 0.2, TopP: 0.8}
+
+	// Anthropic keeps the system prompt in its own field
+	body, err := invokeBody("anthropic.claude-3-haiku-20240307-v1:0", request)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"anthropic_version": "bedrock-2023-05-31",
+		"max_tokens": 200,
+		"temperature": 0.2,
+		"top_p": 0.8,
+		"system": "You are terse",
+		"messages": [{"role": "user", "content": "Summarize this"}]
+	}`, string(body))
+
+	// Titan and Llama have no system field, so it leads the prompt text
+	body, err = invokeBody("amazon.titan-text-express-v1", request)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"inputText": "You are terse\n\nSummarize this",
+		"textGenerationConfig": {"maxTokenCount": 200, "temperature": 0.2, "topP": 0.8}
+	}`, string(body))
+
+	body, err = invokeBody("meta.llama3-8b-instruct-v1:0", request)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"prompt": "You are terse\n\nSummarize this", "max_gen_len": 200, "temperature": 0.2, "top_p": 0.8}`, string(body))
+
+	request.System = ""
+	body, err = invokeBody("amazon.titan-text-express-v1", request)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `"inputText":"Summarize this"`)
+	body, err = invokeBody("anthropic.claude-3-haiku-20240307-v1:0", request)
+	require.NoError(t, err)
+	assert.NotContains(t, string(body), `"system"`)
+
+	_, err = invokeBody("amazon.nova-lite-v1:0", request)
+	assert.Error(t, err)
+}
+
+func TestParseInvokeBody(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name    string
+		modelID string
+		body    string
+		want    completion
+	}{
+		{
+			"anthropic",
+			"anthropic.claude-3-haiku-20240307-v1:0",
+			`{"content": [{"type": "text", "text": "Hello"}, {"type": "text", "text": " there"}], "stop_reason": "end_turn", "usage": {"input_tokens": 9, "output_tokens": 2}}`,
+			completion{Content: "Hello there", Usage: usage{9, 2}},
+		},
+		{
+			"anthropic_truncated",
+			"anthropic.claude-3-haiku-20240307-v1:0",
+			`{"content": [{"type": "text", "text": "Once"}], "stop_reason": "max_tokens", "usage": {"input_tokens": 5, "output_tokens": 1}}`,
+			completion{Content: "Once", Usage: usage{5, 1}, Truncated: true},
+		},
+		{
+			"titan",
+			"amazon.titan-text-express-v1",
+			`{"inputTextTokenCount": 6, "results": [{"tokenCount": 4, "outputText": "Hi from Titan", "completionReason": "FINISH"}]}`,
+			completion{Content: "Hi from Titan", Usage: usage{6, 4}},
+		},
+		{
+			"titan_truncated",
+			"amazon.titan-text-express-v1",
+			`{"inputTextTokenCount": 6, "results": [{"tokenCount": 2, "outputText": "Hi", "completionReason": "LENGTH"}]}`,
+			completion{Content: "Hi", Usage: usage{6, 2}, Truncated: true},
+		},
+		{
+			"llama",
+			"meta.llama3-8b-instruct-v1:0",
+			`{"generation": "Hi from Llama", "prompt_token_count": 8, "generation_token_count": 5, "stop_reason": "stop"}`,
+			completion{Content: "Hi from Llama", Usage: usage{8, 5}},
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := parseInvokeBody(tc.modelID, []byte(tc.body))
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	_, err := parseInvokeBody("amazon.titan-text-express-v1", []byte(`{"results": []}`))
+	assert.Error(t, err, "a Titan response without results should fail")
+	_, err = parseInvokeBody("anthropic.claude-3-haiku-20240307-v1:0", []byte(`not json`))
+	assert.Error(t, err)
+}
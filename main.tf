@@ -4,6 +4,799 @@
 data "aws_caller_identity" "current" {}
 data "aws_region" "current" {}
 
+locals {
+  image_model_arns = var.enable_image_generation ? [
+    "arn:aws:bedrock:${data.aws_region.current.name}::foundation-model/${var.image_model_id}"
+  ] : []
+
+  # Aliased models must be invokable even if not listed in bedrock_model_arns
+  alias_model_arns = [
+    for model_id in distinct(values(var.model_aliases)) :
+    "arn:aws:bedrock:${data.aws_region.current.name}::foundation-model/${model_id}"
+  ]
+
+  # Fallback entries may be aliases or concrete model IDs
+  fallback_model_arns = [
+    for model_id in distinct([for m in var.model_fallback_chain : lookup(var.model_aliases, m, m)]) :
+    "arn:aws:bedrock:${data.aws_region.current.name}::foundation-model/${model_id}"
+  ]
+
+  # A custom (fine-tuned) model replaces bedrock_model_id as the default. The
+  # handler invokes it through its provisioned throughput, so both need access
+  default_model_id = coalesce(var.custom_model_arn, var.bedrock_model_id)
+  custom_model_arns = var.custom_model_arn != null ? [
+    var.custom_model_arn,
+    var.custom_model_provisioned_throughput_arn
+  ] : []
+
+  # Settings that differ by environment unless overridden. Prod only serves the
+  # default model and its fallbacks; an empty allowlist allows any model
+  environment_defaults = {
+    dev = {
+      allowed_model_ids = []
+    }
+    staging = {
+      allowed_model_ids = []
+    }
+    prod = {
+      allowed_model_ids = distinct(concat([local.default_model_id], [for m in var.model_fallback_chain : lookup(var.model_aliases, m, m)]))
+    }
+  }
+  allowed_model_ids = lookup(var.allowed_model_ids, var.environment, local.environment_defaults[var.environment].allowed_model_ids)
+
+  # Replacements serve requests for retired models, which may not be listed anywhere else
+  replacement_model_arns = [
+    for model_id in distinct(values(var.deprecated_model_replacements)) :
+    "arn:aws:bedrock:${data.aws_region.current.name}::foundation-model/${model_id}"
+  ]
+
+  summarization_model_arns = var.enable_conversation_history && var.max_conversation_turns > 0 ? [
+    "arn:aws:bedrock:${data.aws_region.current.name}::foundation-model/${var.summarization_model_id}"
+  ] : []
+
+  ensemble_judge_model_arns = var.enable_ensemble && var.ensemble_strategy == "judge" ? [
+    "arn:aws:bedrock:${data.aws_region.current.name}::foundation-model/${var.ensemble_judge_model_id}"
+  ] : []
+
+  moderation_model_arns = var.enable_input_moderation ? [
+    "arn:aws:bedrock:${data.aws_region.current.name}::foundation-model/${var.moderation_model_id}"
+  ] : []
+
+  scheduled_prompts = var.enable_scheduled_prompts ? { for p in var.scheduled_prompts : p.name => p } : {}
+
+  scheduled_sns_topic_arns = distinct([
+    for p in values(local.scheduled_prompts) : p.destination if startswith(p.destination, "arn:")
+  ])
+  scheduled_s3_object_arns = distinct([
+    for p in values(local.scheduled_prompts) : "arn:aws:s3:::${trimsuffix(trimprefix(p.destination, "s3://"), "/")}/*"
+    if startswith(p.destination, "s3://")
+  ])
+
+  # Prompt templates are read from an SSM parameter path or an S3 prefix
+  template_source   = var.prompt_template_source == null ? "" : var.prompt_template_source
+  template_ssm_path = startswith(local.template_source, "/") ? trimsuffix(local.template_source, "/") : null
+  template_s3_path  = startswith(local.template_source, "s3://") ? trimsuffix(trimprefix(local.template_source, "s3://"), "/") : null
+
+  # Scheduled prompts may name a concrete model ID rather than an alias
+  scheduled_model_arns = [
+    for model_id in distinct([for p in values(local.scheduled_prompts) : p.model if p.model != null && !contains(keys(var.model_aliases), p.model)]) :
+    "arn:aws:bedrock:${data.aws_region.current.name}::foundation-model/${model_id}"
+  ]
+
+  # Cross-region inference profiles invoke the underlying model in whichever
+  # region the profile routes to, so the model is granted in every region and
+  # the profile in each region fallback may call it from
+  profile_model_ids = [
+    for model_id in distinct(concat([local.default_model_id], [for m in var.model_fallback_chain : lookup(var.model_aliases, m, m)], values(var.model_aliases))) :
+    model_id if can(regex("^(us|us-gov|eu|apac|jp|global)\\.", model_id))
+  ]
+  profile_regions = length(var.profile_fallback_regions) > 0 ? distinct(concat([data.aws_region.current.name], var.profile_fallback_regions)) : ["*"]
+  profile_fallback_arns = var.enable_profile_region_fallback ? flatten([
+    for model_id in local.profile_model_ids : concat(
+      [for region in local.profile_regions : "arn:aws:bedrock:${region}:${data.aws_caller_identity.current.account_id}:inference-profile/${model_id}"],
+      ["arn:aws:bedrock:*::foundation-model/${regex("^[a-z-]+\\.(.+)$", model_id)[0]}"]
+    )
+  ]) : []
+
+  # Application inference profiles copy a foundation model, or a system
+  # inference profile for IDs with a geography prefix, whose model may run in
+  # any region the profile routes to
+  cost_allocation_copies_profile = can(regex("^(us|us-gov|eu|apac|jp|global)\\.", var.bedrock_model_id))
+  cost_allocation_model_source   = local.cost_allocation_copies_profile ? "arn:aws:bedrock:${data.aws_region.current.name}:${data.aws_caller_identity.current.account_id}:inference-profile/${var.bedrock_model_id}" : "arn:aws:bedrock:${data.aws_region.current.name}::foundation-model/${var.bedrock_model_id}"
+  cost_allocation_profile_arns = var.enable_cost_allocation_tags ? concat(
+    [aws_bedrock_inference_profile.cost_allocation[0].arn, local.cost_allocation_model_source],
+    local.cost_allocation_copies_profile ? ["arn:aws:bedrock:*::foundation-model/${regex("^[a-z-]+\\.(.+)$", var.bedrock_model_id)[0]}"] : []
+  ) : []
+
+  # Provisioned throughput is bought for a model variant in this region, and
+  # the role invokes the default model through it
+  provisioned_base_model_arn = "arn:aws:bedrock:${data.aws_region.current.name}::foundation-model/${coalesce(var.provisioned_base_model_id, var.bedrock_model_id)}"
+  provisioned_throughput_arns = var.enable_provisioned_throughput ? [
+    aws_bedrock_provisioned_model_throughput.default_model[0].provisioned_model_arn,
+    local.provisioned_base_model_arn
+  ] : []
+
+  # The lowest maximum_concurrency an SQS event source mapping accepts
+  buffer_pollers = 2
+
+  # Model names as Bedrock service quota names spell them, for the quota check
+  model_quota_names = {
+    "anthropic.claude-3-sonnet-20240229-v1:0"   = "Anthropic Claude 3 Sonnet"
+    "anthropic.claude-3-haiku-20240307-v1:0"    = "Anthropic Claude 3 Haiku"
+    "anthropic.claude-3-opus-20240229-v1:0"     = "Anthropic Claude 3 Opus"
+    "anthropic.claude-3-5-sonnet-20240620-v1:0" = "Anthropic Claude 3.5 Sonnet"
+  }
+  quota_model_name = var.quota_model_name != null ? var.quota_model_name : lookup(local.model_quota_names, var.bedrock_model_id, null)
+
+  # Context window sizes in tokens, extended or overridden by var.model_context_windows
+  model_context_windows = merge({
+    "anthropic.claude-3-sonnet-20240229-v1:0"   = 200000
+    "anthropic.claude-3-haiku-20240307-v1:0"    = 200000
+    "anthropic.claude-3-opus-20240229-v1:0"     = 200000
+    "anthropic.claude-3-5-sonnet-20240620-v1:0" = 200000
+    "anthropic.claude-v2:1"                     = 200000
+    "anthropic.claude-v2"                       = 100000
+    "anthropic.claude-instant-v1"               = 100000
+    "amazon.titan-text-express-v1"              = 8192
+    "amazon.titan-text-lite-v1"                 = 4096
+    "ai21.j2-ultra-v1"                          = 8191
+    "ai21.j2-mid-v1"                            = 8191
+    "meta.llama2-13b-chat-v1"                   = 4096
+    "meta.llama2-70b-chat-v1"                   = 4096
+  }, var.model_context_windows)
+
+  # Routes attach to the module's own API or to an existing one
+  rest_api_id            = var.existing_rest_api_id != null ? var.existing_rest_api_id : aws_api_gateway_rest_api.bedrock_api[0].id
+  root_resource_id       = var.existing_rest_api_id != null ? var.existing_root_resource_id : aws_api_gateway_rest_api.bedrock_api[0].root_resource_id
+  rest_api_execution_arn = "arn:aws:execute-api:${data.aws_region.current.name}:${data.aws_caller_identity.current.account_id}:${local.rest_api_id}"
+
+  # Deny-by-default resource policy: with a policy attached, API Gateway refuses
+  # any request that no Allow statement matches
+  api_allowlist_enabled = length(var.api_allowed_ip_ranges) > 0 || length(var.api_allowed_account_ids) > 0
+  api_resource_policy = local.api_allowlist_enabled ? jsonencode({
+    Version = "2012-10-17"
+    Statement = concat(
+      length(var.api_allowed_ip_ranges) > 0 ? [
+        {
+          Sid       = "AllowSourceIpRanges"
+          Effect    = "Allow"
+          Principal = "*"
+          Action    = "execute-api:Invoke"
+          Resource  = "${local.rest_api_execution_arn}/*"
+          Condition = {
+            IpAddress = { "aws:SourceIp" = var.api_allowed_ip_ranges }
+          }
+        }
+      ] : [],
+      length(var.api_allowed_account_ids) > 0 ? [
+        {
+          Sid       = "AllowAccounts"
+          Effect    = "Allow"
+          Principal = { AWS = [for id in var.api_allowed_account_ids : "arn:aws:iam::${id}:root"] }
+          Action    = "execute-api:Invoke"
+          Resource  = "${local.rest_api_execution_arn}/*"
+        }
+      ] : []
+    )
+  }) : null
+
+  # API Gateway only has an API-wide compression size, so it is set to the
+  # smallest one configured and the handler opts larger-threshold routes out
+  route_compression_sizes = {
+    for route, settings in var.routes : route => settings.minimum_compression_size
+    if settings.minimum_compression_size != null
+  }
+  api_compression_sizes        = concat(values(local.route_compression_sizes), var.minimum_compression_size != null ? [var.minimum_compression_size] : [])
+  api_minimum_compression_size = length(local.api_compression_sizes) > 0 ? min(local.api_compression_sizes...) : null
+
+  # Account allowlisting only works for SigV4-signed requests
+  method_authorization = (
+    var.auth_type == "COGNITO" ? "COGNITO_USER_POOLS" :
+    var.auth_type == "AWS_IAM" || length(var.api_allowed_account_ids) > 0 ? "AWS_IAM" : "NONE"
+  )
+  method_authorizer_id = var.auth_type == "COGNITO" ? aws_api_gateway_authorizer.cognito[0].id : null
+
+  # Optional headers the OPTIONS mock returns on top of origin, methods and headers
+  cors_preflight_headers = merge(
+    var.cors_allow_credentials ? { "Access-Control-Allow-Credentials" = "true" } : {},
+    var.cors_allow_private_network ? { "Access-Control-Allow-Private-Network" = "true" } : {}
+  )
+
+  # Archive records go through Firehose when buffering is enabled, otherwise straight to S3
+  archive_firehose_enabled = var.enable_archival && var.archive_use_firehose
+
+  # Either the module's own log group or one managed elsewhere
+  lambda_log_group_name = coalesce(var.log_group_name, "${var.log_group_prefix}/${var.name_prefix}-bedrock-lambda")
+
+  # Kinesis and Firehose destinations need a role for CloudWatch Logs to assume, Lambda
+  # destinations a resource policy; cross-account logs destinations need neither
+  log_subscription_service = var.log_subscription_destination_arn != null ? split(":", var.log_subscription_destination_arn)[2] : ""
+
+  # Identifies the live handler build on /health and in the handler_version output
+  handler_version = coalesce(
+    var.handler_version,
+    substr(var.lambda_package_path != null ? filesha256(var.lambda_package_path) : data.archive_file.lambda_zip.output_sha256, 0, 12)
+  )
+
+  lambda_environment = merge(
+    {
+      BEDROCK_MODEL_ID        = local.default_model_id
+      HANDLER_VERSION         = local.handler_version
+      LOG_LEVEL               = var.log_level
+      LOG_CONTENT             = tostring(var.log_content)
+      LOG_SAMPLING_RATE       = tostring(var.log_sampling_rate)
+      LOG_REDACT_PII          = tostring(var.log_redact_pii)
+      ERROR_VERBOSITY         = var.error_verbosity
+      DEFAULT_RESPONSE_FORMAT = var.default_response_format
+      METRIC_NAMESPACE        = var.metric_namespace
+      MODEL_ALIASES           = jsonencode(var.model_aliases)
+      REQUEST_FIELD_MAP       = jsonencode(var.request_field_map)
+      POST_PROCESSORS         = jsonencode(var.post_processors)
+      MODEL_CONTEXT_WINDOWS   = jsonencode(local.model_context_windows)
+      TENANT_HEADER           = lower(var.tenant_header)
+      MAX_REQUEST_TIMEOUT_MS  = tostring(var.max_request_timeout_ms)
+      ENVIRONMENT             = var.environment
+    },
+    length(local.allowed_model_ids) > 0 ? { ALLOWED_MODEL_IDS = jsonencode(local.allowed_model_ids) } : {},
+    length(var.metric_dimensions) > 0 ? { METRIC_DIMENSIONS = jsonencode(var.metric_dimensions) } : {},
+    length(var.deprecated_model_replacements) > 0 ? { DEPRECATED_MODEL_REPLACEMENTS = jsonencode(var.deprecated_model_replacements) } : {},
+    var.bedrock_endpoint_url != null ? { BEDROCK_ENDPOINT_URL = var.bedrock_endpoint_url } : {},
+    # The Python handler has these rendered in; the Go handler reads them at startup
+    var.lambda_runtime == "provided.al2023" ? {
+      MAX_TOKENS  = tostring(var.max_tokens)
+      TEMPERATURE = tostring(var.temperature)
+      TOP_P       = tostring(var.top_p)
+    } : {},
+    var.enable_xray_tracing ? { TRACING_ENABLED = "true" } : {},
+    var.custom_model_arn != null ? {
+      PROVISIONED_MODEL_ARNS = jsonencode({ (var.custom_model_arn) = var.custom_model_provisioned_throughput_arn })
+    } : {},
+    # The default model is invoked through its tagged profile, as a custom model is through its throughput
+    var.enable_cost_allocation_tags ? {
+      PROVISIONED_MODEL_ARNS = jsonencode({ (var.bedrock_model_id) = aws_bedrock_inference_profile.cost_allocation[0].arn })
+    } : {},
+    var.enable_provisioned_throughput ? {
+      PROVISIONED_MODEL_ARNS = jsonencode({ (var.bedrock_model_id) = aws_bedrock_provisioned_model_throughput.default_model[0].provisioned_model_arn })
+    } : {},
+    var.api_style != "invoke" ? {
+      API_STYLE       = var.api_style
+      MAX_TOOL_ROUNDS = tostring(var.max_tool_rounds)
+    } : {},
+    var.unsupported_param_mode != "strip" ? { UNSUPPORTED_PARAM_MODE = var.unsupported_param_mode } : {},
+    var.lenient_json ? { LENIENT_JSON = "true" } : {},
+    var.response_json_schema != null ? { RESPONSE_JSON_SCHEMA = var.response_json_schema } : {},
+    var.prompt_template_source != null ? {
+      PROMPT_TEMPLATE_SOURCE   = var.prompt_template_source
+      TEMPLATE_REFRESH_SECONDS = tostring(var.template_refresh_seconds)
+    } : {},
+    local.api_minimum_compression_size != null ? {
+      API_MINIMUM_COMPRESSION_SIZE = tostring(local.api_minimum_compression_size)
+      MINIMUM_COMPRESSION_SIZE     = var.minimum_compression_size != null ? tostring(var.minimum_compression_size) : ""
+      ROUTE_COMPRESSION_SIZES      = jsonencode(local.route_compression_sizes)
+    } : {},
+    length(var.model_fallback_chain) > 0 ? {
+      MODEL_FALLBACK_CHAIN      = jsonencode(var.model_fallback_chain)
+      FALLBACK_TOTAL_TIMEOUT_MS = tostring(var.fallback_total_timeout_ms)
+    } : {},
+    var.enable_profile_region_fallback ? {
+      PROFILE_REGION_FALLBACK  = "true"
+      PROFILE_FALLBACK_REGIONS = jsonencode(var.profile_fallback_regions)
+    } : {},
+    var.enable_bedrock_prompt_cache ? { BEDROCK_PROMPT_CACHE = "true" } : {},
+    var.enable_cors && var.cors_allow_credentials ? {
+      CORS_ALLOWED_ORIGIN    = var.cors_allowed_origins[0]
+      CORS_ALLOW_CREDENTIALS = "true"
+    } : {},
+    var.strip_invalid_chars ? { STRIP_INVALID_CHARS = "true" } : {},
+    var.normalize_input ? { NORMALIZE_INPUT = "true" } : {},
+    var.trim_response ? {
+      TRIM_RESPONSE          = "true"
+      RESPONSE_TRIM_SUFFIXES = jsonencode(var.response_trim_suffixes)
+    } : {},
+    var.max_response_bytes > 0 ? { MAX_RESPONSE_BYTES = tostring(var.max_response_bytes) } : {},
+    var.enable_api_cache ? { CACHE_KEY_HEADER = local.cache_key_header } : {},
+    var.serve_stale_on_error ? {
+      STALE_RESPONSES_TABLE = aws_dynamodb_table.stale_responses[0].name
+      MAX_STALE_SECONDS     = tostring(var.max_stale_seconds)
+    } : {},
+    var.enable_ensemble ? {
+      ENSEMBLE_MAX_MODELS     = tostring(var.ensemble_max_models)
+      ENSEMBLE_STRATEGY       = var.ensemble_strategy
+      ENSEMBLE_JUDGE_MODEL_ID = var.ensemble_judge_model_id
+    } : {},
+    var.enable_input_moderation ? {
+      MODERATION_MODEL_ID  = var.moderation_model_id
+      MODERATION_THRESHOLD = tostring(var.moderation_threshold)
+    } : {},
+    length(var.per_model_concurrency) > 0 ? {
+      CONCURRENCY_TABLE     = aws_dynamodb_table.model_concurrency[0].name
+      PER_MODEL_CONCURRENCY = jsonencode(var.per_model_concurrency)
+    } : {},
+    var.enable_adaptive_throttling ? {
+      ADAPTIVE_THROTTLING = "true"
+      ADAPTIVE_MIN_RATE   = tostring(var.adaptive_throttling_min_rate)
+      ADAPTIVE_MAX_RATE   = tostring(var.adaptive_throttling_max_rate)
+    } : {},
+    var.enable_usage_accounting ? { USAGE_TABLE = aws_dynamodb_table.usage[0].name } : {},
+    var.enable_tenant_isolation ? {
+      TENANT_ISOLATION   = "true"
+      ALLOWED_TENANT_IDS = jsonencode(var.allowed_tenant_ids)
+    } : {},
+    var.enable_idempotency ? {
+      IDEMPOTENCY_TABLE       = aws_dynamodb_table.idempotency[0].name
+      IDEMPOTENCY_TTL_SECONDS = tostring(var.idempotency_ttl_seconds)
+    } : {},
+    var.enable_streaming ? {
+      ENABLE_STREAMING     = "true"
+      STREAM_ERROR_MODE    = var.stream_error_mode
+      CANCEL_ON_DISCONNECT = tostring(var.cancel_on_disconnect)
+    } : {},
+    var.enable_streaming && var.stream_json_mode != "raw" ? { STREAM_JSON_MODE = var.stream_json_mode } : {},
+    var.handler_fault_injection.stream_failure_after_chunks > 0 ? {
+      FAULT_STREAM_FAILURE_AFTER_CHUNKS = tostring(var.handler_fault_injection.stream_failure_after_chunks)
+    } : {},
+    var.handler_fault_injection.shutdown_after_ms > 0 ? {
+      FAULT_SHUTDOWN_AFTER_MS = tostring(var.handler_fault_injection.shutdown_after_ms)
+    } : {},
+    var.handler_fault_injection.client_disconnect_after_chunks > 0 ? {
+      FAULT_CLIENT_DISCONNECT_AFTER_CHUNKS = tostring(var.handler_fault_injection.client_disconnect_after_chunks)
+    } : {},
+    var.handler_fault_injection.throttle_percent > 0 ? {
+      FAULT_THROTTLE_PERCENT = tostring(var.handler_fault_injection.throttle_percent)
+    } : {},
+    var.drain_timeout_seconds > 0 ? { DRAIN_TIMEOUT_SECONDS = tostring(var.drain_timeout_seconds) } : {},
+    var.enable_image_generation ? { IMAGE_MODEL_ID = var.image_model_id } : {},
+    var.bedrock_agent_id != null ? {
+      AGENT_ID             = var.bedrock_agent_id
+      AGENT_ALIAS_ID       = var.bedrock_agent_alias_id
+      SESSION_POOL_SIZE    = tostring(var.session_pool_size)
+      SESSION_IDLE_SECONDS = tostring(var.session_idle_seconds)
+      AGENT_TRACE          = tostring(var.enable_agent_trace)
+    } : {},
+    var.enable_guardrails ? {
+      GUARDRAIL_ID      = aws_bedrock_guardrail.bedrock[0].guardrail_id
+      GUARDRAIL_VERSION = aws_bedrock_guardrail_version.bedrock[0].version
+    } : {},
+    var.bedrock_knowledge_base_id != null ? {
+      KNOWLEDGE_BASE_ID      = var.bedrock_knowledge_base_id
+      KNOWLEDGE_BASE_RESULTS = tostring(var.knowledge_base_number_of_results)
+    } : {},
+    var.enable_object_lambda ? { COMPLETIONS_BUCKET = aws_s3_bucket.completions[0].id } : {},
+    var.enable_archival ? {
+      ARCHIVE_BUCKET = aws_s3_bucket.archive[0].id
+      ARCHIVE_STREAM = local.archive_firehose_enabled ? aws_kinesis_firehose_delivery_stream.archive[0].name : ""
+    } : {},
+    var.enable_continuation ? {
+      CONTINUATION_TABLE       = aws_dynamodb_table.continuations[0].name
+      CONTINUATION_TTL_SECONDS = tostring(var.continuation_ttl_seconds)
+    } : {},
+    var.enable_async_invocation ? {
+      ASYNC_QUEUE_URL          = aws_sqs_queue.async_requests[0].url
+      ASYNC_QUEUE_ARN          = aws_sqs_queue.async_requests[0].arn
+      ASYNC_JOBS_TABLE         = aws_dynamodb_table.async_jobs[0].name
+      ASYNC_RESULT_TTL_SECONDS = tostring(var.async_result_ttl_seconds)
+    } : {},
+    var.enable_request_buffering ? {
+      BUFFER_QUEUE_URL              = aws_sqs_queue.buffered_requests[0].url
+      BUFFER_QUEUE_ARN              = aws_sqs_queue.buffered_requests[0].arn
+      BUFFER_DRAIN_INTERVAL_SECONDS = tostring(local.buffer_pollers / var.drain_rate_per_second)
+    } : {},
+    var.sync_max_tokens_threshold != null ? { SYNC_MAX_TOKENS_THRESHOLD = tostring(var.sync_max_tokens_threshold) } : {},
+    var.enable_presigned_uploads ? {
+      UPLOAD_BUCKET             = aws_s3_bucket.uploads[0].id
+      UPLOAD_URL_EXPIRY_SECONDS = tostring(var.upload_url_expiry_seconds)
+    } : {},
+    var.enable_batch_inference ? {
+      BATCH_BUCKET              = aws_s3_bucket.batch[0].id
+      BATCH_ROLE_ARN            = aws_iam_role.batch[0].arn
+      BATCH_QUEUE_URL           = aws_sqs_queue.batch_submissions[0].url
+      BATCH_JOB_PREFIX          = var.name_prefix
+      MAX_CONCURRENT_BATCH_JOBS = tostring(var.max_concurrent_batch_jobs)
+    } : {},
+    var.enable_conversation_history ? {
+      CONVERSATION_TABLE            = aws_dynamodb_table.conversations[0].name
+      CONVERSATION_TTL_DAYS         = tostring(var.conversation_ttl_days)
+      MAX_CONVERSATION_TURNS        = tostring(var.max_conversation_turns)
+      MAX_CONVERSATION_BYTES        = tostring(var.max_conversation_bytes)
+      SUMMARIZATION_MODEL_ID        = var.summarization_model_id
+      CONVERSATION_FIELD_ENCRYPTION = tostring(var.conversation_field_encryption)
+      CONVERSATION_KMS_KEY_ARN      = local.conversation_kms_key_arn
+      SESSION_LOCKING               = tostring(var.enable_session_locking)
+    } : {}
+  )
+
+  conversation_kms_key_arn = (
+    var.enable_conversation_history && var.conversation_field_encryption
+    ? coalesce(var.conversation_kms_key_arn, try(aws_kms_key.conversations[0].arn, null))
+    : ""
+  )
+
+  # Header carrying the request body hash used as the API cache key
+  cache_key_header = "X-Body-Hash"
+
+  # Gateway-generated errors customized to match the handler's error shape
+  gateway_response_types = var.enable_gateway_responses && var.existing_rest_api_id == null ? toset(["DEFAULT_4XX", "DEFAULT_5XX", "THROTTLED", "UNAUTHORIZED"]) : toset([])
+
+  # JSON-encoded message per type; $context.error.messageString is already a quoted string
+  gateway_response_messages = {
+    for type in local.gateway_response_types :
+    type => contains(keys(var.gateway_response_messages), type) ? jsonencode(var.gateway_response_messages[type]) : "$context.error.messageString"
+  }
+
+  # WAF rule priorities, shared by the Web ACL and the waf_rules output
+  waf_rule_priorities = {
+    blocked_ips     = 0
+    rate_limit      = 1
+    common_rule_set = 2
+  }
+
+  # WAF sees REST API paths with the stage prefix, e.g. /prod/health
+  waf_excluded_path_regex = length(var.waf_excluded_paths) > 0 ? "^/${var.api_stage_name}(${join("|", var.waf_excluded_paths)})(/|$)" : null
+
+  # Ordered by evaluation priority
+  waf_rules = var.enable_waf ? concat(
+    length(var.waf_blocked_ip_ranges) > 0 ? [
+      { name = "BlockedIPRule", priority = local.waf_rule_priorities.blocked_ips, action = "block", type = "ip_set" }
+    ] : [],
+    [
+      { name = "RateLimitRule", priority = local.waf_rule_priorities.rate_limit, action = "block", type = "rate_based" },
+      { name = "AWSManagedRulesCommonRuleSet", priority = local.waf_rule_priorities.common_rule_set, action = "managed", type = "managed_rule_group" }
+    ]
+  ) : []
+
+  # Policy statements are assembled per feature so optional permissions
+  # only appear when the feature that needs them is enabled
+  bedrock_policy_statements = concat(
+    [
+      {
+        Effect = "Allow"
+        Action = [
+          "bedrock:InvokeModel",
+          "bedrock:InvokeModelWithResponseStream"
+        ]
+        Resource = distinct(concat(var.bedrock_model_arns, local.alias_model_arns, local.fallback_model_arns, local.replacement_model_arns, local.scheduled_model_arns, local.image_model_arns, local.summarization_model_arns, local.moderation_model_arns, local.ensemble_judge_model_arns, local.custom_model_arns, local.profile_fallback_arns, local.cost_allocation_profile_arns, local.provisioned_throughput_arns))
+      },
+      {
+        Effect = "Allow"
+        Action = [
+          "logs:CreateLogGroup",
+          "logs:CreateLogStream",
+          "logs:PutLogEvents"
+        ]
+        Resource = "arn:aws:logs:${data.aws_region.current.name}:${data.aws_caller_identity.current.account_id}:*"
+      }
+    ],
+    length(var.per_model_concurrency) > 0 ? [
+      {
+        Effect = "Allow"
+        Action = [
+          "dynamodb:GetItem",
+          "dynamodb:PutItem",
+          "dynamodb:UpdateItem"
+        ]
+        Resource = aws_dynamodb_table.model_concurrency[0].arn
+      }
+    ] : [],
+    var.enable_usage_accounting ? [
+      {
+        Effect   = "Allow"
+        Action   = ["dynamodb:UpdateItem"]
+        Resource = aws_dynamodb_table.usage[0].arn
+      }
+    ] : [],
+    var.enable_idempotency ? [
+      {
+        Effect = "Allow"
+        Action = [
+          "dynamodb:GetItem",
+          "dynamodb:PutItem"
+        ]
+        Resource = aws_dynamodb_table.idempotency[0].arn
+      }
+    ] : [],
+    var.serve_stale_on_error ? [
+      {
+        Effect = "Allow"
+        Action = [
+          "dynamodb:GetItem",
+          "dynamodb:PutItem"
+        ]
+        Resource = aws_dynamodb_table.stale_responses[0].arn
+      }
+    ] : [],
+    var.bedrock_agent_id != null ? [
+      {
+        Effect   = "Allow"
+        Action   = ["bedrock:InvokeAgent"]
+        Resource = "arn:aws:bedrock:${data.aws_region.current.name}:${data.aws_caller_identity.current.account_id}:agent-alias/${var.bedrock_agent_id}/${var.bedrock_agent_alias_id}"
+      }
+    ] : [],
+    var.enable_guardrails ? [
+      {
+        Effect   = "Allow"
+        Action   = ["bedrock:ApplyGuardrail"]
+        Resource = aws_bedrock_guardrail.bedrock[0].guardrail_arn
+      }
+    ] : [],
+    var.bedrock_knowledge_base_id != null ? [
+      {
+        Effect   = "Allow"
+        Action   = ["bedrock:Retrieve"]
+        Resource = "arn:aws:bedrock:${data.aws_region.current.name}:${data.aws_caller_identity.current.account_id}:knowledge-base/${var.bedrock_knowledge_base_id}"
+      },
+      # RetrieveAndGenerate has no resource-level permissions; the knowledge
+      # base stays limited by bedrock:Retrieve and the model by the statements above
+      {
+        Effect   = "Allow"
+        Action   = ["bedrock:RetrieveAndGenerate"]
+        Resource = "*"
+      }
+    ] : [],
+    var.enable_continuation ? [
+      {
+        Effect = "Allow"
+        Action = [
+          "dynamodb:GetItem",
+          "dynamodb:PutItem"
+        ]
+        Resource = aws_dynamodb_table.continuations[0].arn
+      }
+    ] : [],
+    var.enable_async_invocation ? [
+      {
+        Effect = "Allow"
+        Action = [
+          "dynamodb:GetItem",
+          "dynamodb:PutItem",
+          "dynamodb:UpdateItem"
+        ]
+        Resource = aws_dynamodb_table.async_jobs[0].arn
+      },
+      {
+        Effect = "Allow"
+        Action = [
+          "sqs:SendMessage",
+          "sqs:ReceiveMessage",
+          "sqs:DeleteMessage",
+          "sqs:GetQueueAttributes"
+        ]
+        Resource = aws_sqs_queue.async_requests[0].arn
+      }
+    ] : [],
+    var.enable_request_buffering ? [
+      {
+        Effect = "Allow"
+        Action = [
+          "sqs:SendMessage",
+          "sqs:ReceiveMessage",
+          "sqs:DeleteMessage",
+          "sqs:GetQueueAttributes"
+        ]
+        Resource = aws_sqs_queue.buffered_requests[0].arn
+      }
+    ] : [],
+    # ENI management required for Lambda functions attached to a VPC
+    var.vpc_subnet_ids != null ? [
+      {
+        Effect = "Allow"
+        Action = [
+          "ec2:CreateNetworkInterface",
+          "ec2:DescribeNetworkInterfaces",
+          "ec2:DeleteNetworkInterface",
+          "ec2:AssignPrivateIpAddresses",
+          "ec2:UnassignPrivateIpAddresses"
+        ]
+        Resource = "*"
+      }
+    ] : [],
+    var.enable_conversation_history ? [
+      {
+        Effect = "Allow"
+        Action = [
+          "dynamodb:GetItem",
+          "dynamodb:PutItem"
+        ]
+        Resource = aws_dynamodb_table.conversations[0].arn
+      }
+    ] : [],
+    length(local.scheduled_sns_topic_arns) > 0 ? [
+      {
+        Effect   = "Allow"
+        Action   = ["sns:Publish"]
+        Resource = local.scheduled_sns_topic_arns
+      }
+    ] : [],
+    # Presigning a PUT needs the permission the upload will use
+    var.enable_presigned_uploads ? [
+      {
+        Effect   = "Allow"
+        Action   = ["s3:GetObject", "s3:PutObject"]
+        Resource = "${aws_s3_bucket.uploads[0].arn}/uploads/*"
+      }
+    ] : [],
+    var.enable_batch_inference ? [
+      {
+        Effect = "Allow"
+        Action = [
+          "bedrock:CreateModelInvocationJob",
+          "bedrock:ListModelInvocationJobs"
+        ]
+        Resource = "*"
+      },
+      {
+        Effect   = "Allow"
+        Action   = ["iam:PassRole"]
+        Resource = aws_iam_role.batch[0].arn
+      },
+      {
+        Effect = "Allow"
+        Action = [
+          "sqs:SendMessage",
+          "sqs:ReceiveMessage",
+          "sqs:DeleteMessage",
+          "sqs:GetQueueAttributes"
+        ]
+        Resource = aws_sqs_queue.batch_submissions[0].arn
+      }
+    ] : [],
+    var.enable_profile_region_fallback && length(var.profile_fallback_regions) == 0 ? [
+      {
+        Effect   = "Allow"
+        Action   = ["bedrock:GetInferenceProfile"]
+        Resource = "arn:aws:bedrock:${data.aws_region.current.name}:${data.aws_caller_identity.current.account_id}:inference-profile/*"
+      }
+    ] : [],
+    var.enable_object_lambda ? [
+      {
+        Effect   = "Allow"
+        Action   = ["s3:PutObject"]
+        Resource = "${aws_s3_bucket.completions[0].arn}/completions/*"
+      }
+    ] : [],
+    var.enable_archival && !var.archive_use_firehose ? [
+      {
+        Effect   = "Allow"
+        Action   = ["s3:PutObject"]
+        Resource = "${aws_s3_bucket.archive[0].arn}/*"
+      }
+    ] : [],
+    local.archive_firehose_enabled ? [
+      {
+        Effect   = "Allow"
+        Action   = ["firehose:PutRecord"]
+        Resource = aws_kinesis_firehose_delivery_stream.archive[0].arn
+      }
+    ] : [],
+    local.template_ssm_path != null ? [
+      {
+        Effect = "Allow"
+        Action = ["ssm:GetParametersByPath"]
+        Resource = [
+          "arn:aws:ssm:${data.aws_region.current.name}:${data.aws_caller_identity.current.account_id}:parameter${local.template_ssm_path}",
+          "arn:aws:ssm:${data.aws_region.current.name}:${data.aws_caller_identity.current.account_id}:parameter${local.template_ssm_path}/*"
+        ]
+      }
+    ] : [],
+    local.template_s3_path != null ? [
+      {
+        Effect   = "Allow"
+        Action   = ["s3:ListBucket"]
+        Resource = "arn:aws:s3:::${split("/", local.template_s3_path)[0]}"
+      },
+      {
+        Effect   = "Allow"
+        Action   = ["s3:GetObject"]
+        Resource = "arn:aws:s3:::${local.template_s3_path}/*"
+      }
+    ] : [],
+    length(local.scheduled_s3_object_arns) > 0 ? [
+      {
+        Effect   = "Allow"
+        Action   = ["s3:PutObject"]
+        Resource = local.scheduled_s3_object_arns
+      }
+    ] : [],
+    var.enable_conversation_history && var.conversation_field_encryption ? [
+      {
+        Effect = "Allow"
+        Action = [
+          "kms:GenerateDataKey",
+          "kms:Decrypt"
+        ]
+        Resource = local.conversation_kms_key_arn
+      }
+    ] : [],
+    # X-Ray trace segments can't be scoped to a resource
+    var.enable_xray_tracing ? [
+      {
+        Effect = "Allow"
+        Action = [
+          "xray:PutTraceSegments",
+          "xray:PutTelemetryRecords"
+        ]
+        Resource = "*"
+      }
+    ] : []
+  )
+}
+
+# Application inference profile carrying cost allocation tags for the default model
+resource "aws_bedrock_inference_profile" "cost_allocation" {
+  count       = var.enable_cost_allocation_tags ? 1 : 0
+  name        = "${var.name_prefix}-bedrock-profile"
+  description = "Invocations of ${var.bedrock_model_id} through ${var.name_prefix}"
+
+  model_source {
+    copy_from = local.cost_allocation_model_source
+  }
+
+  tags = merge(var.tags, var.application_tags)
+}
+
+# Provisioned throughput the default model is invoked through (optional).
+# Without a commitment it is billed hourly until destroyed; a committed one
+# can't be deleted before its term ends.
+resource "aws_bedrock_provisioned_model_throughput" "default_model" {
+  count                  = var.enable_provisioned_throughput ? 1 : 0
+  provisioned_model_name = "${var.name_prefix}-bedrock-throughput"
+  model_arn              = local.provisioned_base_model_arn
+  model_units            = var.provisioned_model_units
+  commitment_duration    = var.provisioned_commitment_duration
+
+  tags = var.tags
+}
+
+# Guardrail applied to every completion (optional)
+resource "aws_bedrock_guardrail" "bedrock" {
+  count                     = var.enable_guardrails ? 1 : 0
+  name                      = "${var.name_prefix}-guardrail"
+  description               = "Denied topics and PII filters for ${var.name_prefix}"
+  blocked_input_messaging   = var.guardrail_blocked_input_message
+  blocked_outputs_messaging = var.guardrail_blocked_output_message
+
+  dynamic "topic_policy_config" {
+    for_each = length(var.guardrail_denied_topics) > 0 ? [1] : []
+    content {
+      dynamic "topics_config" {
+        for_each = var.guardrail_denied_topics
+        content {
+          name       = topics_config.value.name
+          definition = topics_config.value.definition
+          examples   = topics_config.value.examples
+          type       = "DENY"
+        }
+      }
+    }
+  }
+
+  dynamic "sensitive_information_policy_config" {
+    for_each = length(var.guardrail_pii_entities) > 0 ? [1] : []
+    content {
+      dynamic "pii_entities_config" {
+        for_each = var.guardrail_pii_entities
+        content {
+          type   = pii_entities_config.key
+          action = pii_entities_config.value
+        }
+      }
+    }
+  }
+
+  tags = var.tags
+}
+
+# The handler applies a fixed version, never the editable DRAFT, so a new
+# version is cut whenever the guardrail's policies change
+resource "aws_bedrock_guardrail_version" "bedrock" {
+  count         = var.enable_guardrails ? 1 : 0
+  guardrail_arn = aws_bedrock_guardrail.bedrock[0].guardrail_arn
+  description   = "Applied by ${var.name_prefix}"
+
+  lifecycle {
+    replace_triggered_by = [aws_bedrock_guardrail.bedrock[0]]
+  }
+}
+
 # Lambda execution role
 resource "aws_iam_role" "lambda_role" {
   name = "${var.name_prefix}-bedrock-lambda-role"
@@ -24,80 +817,906 @@ resource "aws_iam_role" "lambda_role" {
   tags = var.tags
 }
 
-# IAM policy for Bedrock access and CloudWatch logging
-resource "aws_iam_policy" "bedrock_policy" {
-  name        = "${var.name_prefix}-bedrock-policy"
-  description = "Bedrock model access and CloudWatch logging permissions"
+# IAM policy for Bedrock access and CloudWatch logging
+resource "aws_iam_policy" "bedrock_policy" {
+  name        = "${var.name_prefix}-bedrock-policy"
+  description = "Bedrock model access and CloudWatch logging permissions"
+
+  policy = jsonencode({
+    Version   = "2012-10-17"
+    Statement = local.bedrock_policy_statements
+  })
+}
+
+# Attach policy to role
+resource "aws_iam_role_policy_attachment" "lambda_bedrock_policy" {
+  role       = aws_iam_role.lambda_role.name
+  policy_arn = aws_iam_policy.bedrock_policy.arn
+}
+
+# Lambda logs - created first to avoid permission issues
+resource "aws_cloudwatch_log_group" "lambda_logs" {
+  count             = var.create_log_group ? 1 : 0
+  name              = local.lambda_log_group_name
+  retention_in_days = var.log_retention_days
+
+  tags = var.tags
+}
+
+# Keep existing deployments from replacing the log group now that it is optional
+moved {
+  from = aws_cloudwatch_log_group.lambda_logs
+  to   = aws_cloudwatch_log_group.lambda_logs[0]
+}
+
+# Warns when only one of the isolation settings was changed: two deployments
+# with their own log groups would still mix their metrics, or the reverse
+check "deployment_isolation" {
+  assert {
+    condition     = (var.log_group_prefix == "/aws/lambda") == (var.metric_namespace == "BedrockAPI")
+    error_message = "Only one of log_group_prefix and metric_namespace is customized. Deployments sharing an account should change both to stay isolated."
+  }
+}
+
+# Subscription filter shipping Lambda logs to a central destination (optional)
+resource "aws_cloudwatch_log_subscription_filter" "lambda_logs" {
+  count           = var.log_subscription_destination_arn != null ? 1 : 0
+  name            = "${var.name_prefix}-bedrock-lambda-subscription"
+  log_group_name  = local.lambda_log_group_name
+  filter_pattern  = var.log_subscription_filter_pattern
+  destination_arn = var.log_subscription_destination_arn
+  role_arn        = contains(["kinesis", "firehose"], local.log_subscription_service) ? aws_iam_role.log_subscription[0].arn : null
+
+  depends_on = [
+    aws_cloudwatch_log_group.lambda_logs,
+    aws_iam_role_policy.log_subscription,
+    aws_lambda_permission.log_subscription
+  ]
+}
+
+# Role CloudWatch Logs assumes to put records on a Kinesis or Firehose stream
+resource "aws_iam_role" "log_subscription" {
+  count = contains(["kinesis", "firehose"], local.log_subscription_service) ? 1 : 0
+  name  = "${var.name_prefix}-log-subscription-role"
+
+  assume_role_policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Action = "sts:AssumeRole"
+        Effect = "Allow"
+        Principal = {
+          Service = "logs.amazonaws.com"
+        }
+        Condition = {
+          StringLike = {
+            "aws:SourceArn" = "arn:aws:logs:${data.aws_region.current.name}:${data.aws_caller_identity.current.account_id}:*"
+          }
+        }
+      }
+    ]
+  })
+
+  tags = var.tags
+}
+
+locals {
+  log_subscription_policy_statements = contains(["kinesis", "firehose"], local.log_subscription_service) ? [
+    {
+      Effect   = "Allow"
+      Action   = local.log_subscription_service == "kinesis" ? ["kinesis:PutRecord", "kinesis:PutRecords"] : ["firehose:PutRecord", "firehose:PutRecordBatch"]
+      Resource = var.log_subscription_destination_arn
+    }
+  ] : []
+}
+
+resource "aws_iam_role_policy" "log_subscription" {
+  count = contains(["kinesis", "firehose"], local.log_subscription_service) ? 1 : 0
+  name  = "${var.name_prefix}-log-subscription-policy"
+  role  = aws_iam_role.log_subscription[0].id
+
+  policy = jsonencode({
+    Version   = "2012-10-17"
+    Statement = local.log_subscription_policy_statements
+  })
+}
+
+# CloudWatch Logs invokes Lambda destinations through its own service principal
+resource "aws_lambda_permission" "log_subscription" {
+  count         = local.log_subscription_service == "lambda" ? 1 : 0
+  statement_id  = "AllowLogSubscription-${var.name_prefix}"
+  action        = "lambda:InvokeFunction"
+  function_name = var.log_subscription_destination_arn
+  principal     = "logs.amazonaws.com"
+  source_arn    = "arn:aws:logs:${data.aws_region.current.name}:${data.aws_caller_identity.current.account_id}:log-group:${local.lambda_log_group_name}:*"
+}
+
+# Every distinct dimension value is a separate CloudWatch metric. Source IPs and
+# client-supplied tenant headers are unbounded, and so is the bill
+check "metric_dimension_cardinality" {
+  assert {
+    condition     = !contains(var.metric_dimensions, "source_ip") && !(contains(var.metric_dimensions, "tenant") && !var.enable_tenant_isolation && !var.enable_api_key)
+    error_message = "metric_dimensions includes source_ip, or tenant without tenant isolation or API keys to bound the tenants. Expect one metric per caller and matching CloudWatch costs."
+  }
+}
+
+# On-demand quotas for the default model (optional)
+data "aws_servicequotas_service_quota" "model_tpm" {
+  count        = var.enable_quota_check ? 1 : 0
+  service_code = "bedrock"
+  quota_name   = "On-demand model inference tokens per minute for ${local.quota_model_name}"
+
+  lifecycle {
+    precondition {
+      condition     = local.quota_model_name != null
+      error_message = "The module doesn't know the quota name of ${var.bedrock_model_id}. Set quota_model_name."
+    }
+  }
+}
+
+data "aws_servicequotas_service_quota" "model_rpm" {
+  count        = var.enable_quota_check ? 1 : 0
+  service_code = "bedrock"
+  quota_name   = "On-demand model inference requests per minute for ${local.quota_model_name}"
+
+  lifecycle {
+    precondition {
+      condition     = local.quota_model_name != null
+      error_message = "The module doesn't know the quota name of ${var.bedrock_model_id}. Set quota_model_name."
+    }
+  }
+}
+
+# AWS default quotas are sized for evaluation, not production traffic
+check "bedrock_quotas" {
+  assert {
+    condition = alltrue([
+      for quota in concat(data.aws_servicequotas_service_quota.model_tpm, data.aws_servicequotas_service_quota.model_rpm) :
+      quota.value > quota.default_value
+    ])
+    error_message = "Bedrock quotas for ${var.bedrock_model_id} are still at the AWS defaults. Request an increase in Service Quotas before sending production traffic."
+  }
+}
+
+# Python Lambda function for Bedrock API calls
+resource "aws_lambda_function" "bedrock_lambda" {
+  filename         = var.lambda_package_path != null ? var.lambda_package_path : data.archive_file.lambda_zip.output_path
+  source_code_hash = var.lambda_package_path != null ? filebase64sha256(var.lambda_package_path) : data.archive_file.lambda_zip.output_base64sha256
+  function_name    = "${var.name_prefix}-bedrock-lambda"
+  role            = aws_iam_role.lambda_role.arn
+  handler         = var.lambda_handler
+  runtime         = var.lambda_runtime
+  timeout         = var.lambda_timeout
+  memory_size     = var.lambda_memory_size
+  publish         = true
+
+  environment {
+    variables = local.lambda_environment
+  }
+
+  layers = var.lambda_layers
+
+  logging_config {
+    log_format = "Text"
+    log_group  = local.lambda_log_group_name
+  }
+
+  dynamic "tracing_config" {
+    for_each = var.enable_xray_tracing ? [1] : []
+    content {
+      mode = "Active"
+    }
+  }
+
+  # SnapStart snapshots published versions to cut cold starts
+  dynamic "snap_start" {
+    for_each = var.enable_snapstart ? [1] : []
+    content {
+      apply_on = "PublishedVersions"
+    }
+  }
+
+  # VPC configuration if subnets provided
+  dynamic "vpc_config" {
+    for_each = var.vpc_subnet_ids != null ? [1] : []
+    content {
+      subnet_ids         = var.vpc_subnet_ids
+      security_group_ids = var.vpc_security_group_ids
+    }
+  }
+
+  depends_on = [
+    aws_iam_role_policy_attachment.lambda_bedrock_policy,
+    aws_cloudwatch_log_group.lambda_logs
+  ]
+
+  tags = var.tags
+}
+
+# Conversation history store (optional)
+resource "aws_dynamodb_table" "conversations" {
+  count        = var.enable_conversation_history ? 1 : 0
+  name         = "${var.name_prefix}-conversations"
+  billing_mode = "PAY_PER_REQUEST"
+  hash_key     = "session_id"
+
+  attribute {
+    name = "session_id"
+    type = "S"
+  }
+
+  ttl {
+    attribute_name = "expires_at"
+    enabled        = true
+  }
+
+  server_side_encryption {
+    enabled = true
+  }
+
+  point_in_time_recovery {
+    enabled = true
+  }
+
+  tags = var.tags
+}
+
+# Lease table backing per-model concurrency limits (optional)
+resource "aws_dynamodb_table" "model_concurrency" {
+  count        = length(var.per_model_concurrency) > 0 ? 1 : 0
+  name         = "${var.name_prefix}-model-concurrency"
+  billing_mode = "PAY_PER_REQUEST"
+  hash_key     = "model_id"
+
+  attribute {
+    name = "model_id"
+    type = "S"
+  }
+
+  server_side_encryption {
+    enabled = true
+  }
+
+  tags = var.tags
+}
+
+# Per-tenant monthly token counters for billing (optional)
+resource "aws_dynamodb_table" "usage" {
+  count        = var.enable_usage_accounting ? 1 : 0
+  name         = "${var.name_prefix}-usage"
+  billing_mode = "PAY_PER_REQUEST"
+  hash_key     = "tenant_id"
+  range_key    = "period"
+
+  attribute {
+    name = "tenant_id"
+    type = "S"
+  }
+
+  attribute {
+    name = "period"
+    type = "S"
+  }
+
+  server_side_encryption {
+    enabled = true
+  }
+
+  point_in_time_recovery {
+    enabled = true
+  }
+
+  tags = var.tags
+}
+
+# Stored responses replayed for repeated Idempotency-Key headers (optional)
+resource "aws_dynamodb_table" "idempotency" {
+  count        = var.enable_idempotency ? 1 : 0
+  name         = "${var.name_prefix}-idempotency"
+  billing_mode = "PAY_PER_REQUEST"
+  hash_key     = "idempotency_key"
+
+  attribute {
+    name = "idempotency_key"
+    type = "S"
+  }
+
+  ttl {
+    attribute_name = "expires_at"
+    enabled        = true
+  }
+
+  server_side_encryption {
+    enabled = true
+  }
+
+  tags = var.tags
+}
+
+# Last-known-good responses keyed by tenant and cache key (optional)
+resource "aws_dynamodb_table" "stale_responses" {
+  count        = var.serve_stale_on_error ? 1 : 0
+  name         = "${var.name_prefix}-stale-responses"
+  billing_mode = "PAY_PER_REQUEST"
+  hash_key     = "cache_key"
+
+  attribute {
+    name = "cache_key"
+    type = "S"
+  }
+
+  ttl {
+    attribute_name = "expires_at"
+    enabled        = true
+  }
+
+  server_side_encryption {
+    enabled = true
+  }
+
+  tags = var.tags
+}
+
+# State of truncated completions, keyed by continuation token (optional)
+resource "aws_dynamodb_table" "continuations" {
+  count        = var.enable_continuation ? 1 : 0
+  name         = "${var.name_prefix}-continuations"
+  billing_mode = "PAY_PER_REQUEST"
+  hash_key     = "token"
+
+  attribute {
+    name = "token"
+    type = "S"
+  }
+
+  ttl {
+    attribute_name = "expires_at"
+    enabled        = true
+  }
+
+  server_side_encryption {
+    enabled = true
+  }
+
+  tags = var.tags
+}
+
+# Async job status and results, read back through GET /result/{job_id} (optional)
+resource "aws_dynamodb_table" "async_jobs" {
+  count        = var.enable_async_invocation ? 1 : 0
+  name         = "${var.name_prefix}-async-jobs"
+  billing_mode = "PAY_PER_REQUEST"
+  hash_key     = "job_id"
+
+  attribute {
+    name = "job_id"
+    type = "S"
+  }
+
+  ttl {
+    attribute_name = "expires_at"
+    enabled        = true
+  }
+
+  server_side_encryption {
+    enabled = true
+  }
+
+  tags = var.tags
+}
+
+# Async prompts waiting for the worker
+resource "aws_sqs_queue" "async_requests" {
+  count = var.enable_async_invocation ? 1 : 0
+  name  = "${var.name_prefix}-async-requests"
+  # AWS recommends six times the function timeout so retries don't overlap
+  visibility_timeout_seconds = var.lambda_timeout * 6
+  message_retention_seconds  = var.async_result_ttl_seconds
+  sqs_managed_sse_enabled    = true
+
+  # Prompts still throttled after async_max_receive_count attempts are parked
+  # rather than retried until they expire
+  redrive_policy = jsonencode({
+    deadLetterTargetArn = aws_sqs_queue.async_requests_dlq[0].arn
+    maxReceiveCount     = var.async_max_receive_count
+  })
+
+  tags = var.tags
+}
+
+resource "aws_sqs_queue" "async_requests_dlq" {
+  count                     = var.enable_async_invocation ? 1 : 0
+  name                      = "${var.name_prefix}-async-requests-dlq"
+  message_retention_seconds = 1209600
+  sqs_managed_sse_enabled   = true
+
+  tags = var.tags
+}
+
+resource "aws_lambda_event_source_mapping" "async_requests" {
+  count                   = var.enable_async_invocation ? 1 : 0
+  event_source_arn        = aws_sqs_queue.async_requests[0].arn
+  function_name           = aws_lambda_function.bedrock_lambda.arn
+  batch_size              = 1
+  function_response_types = ["ReportBatchItemFailures"]
+
+  scaling_config {
+    maximum_concurrency = var.async_max_concurrency
+  }
+}
+
+# Buffered prompts, drained at drain_rate_per_second to smooth bursts (optional)
+resource "aws_sqs_queue" "buffered_requests" {
+  count                      = var.enable_request_buffering ? 1 : 0
+  name                       = "${var.name_prefix}-buffered-requests"
+  visibility_timeout_seconds = var.lambda_timeout * 6
+  message_retention_seconds  = var.async_result_ttl_seconds
+  sqs_managed_sse_enabled    = true
+
+  tags = var.tags
+}
+
+# One message per invocation, with the fewest pollers SQS allows, so the
+# handler's wait after each message sets the drain rate
+resource "aws_lambda_event_source_mapping" "buffered_requests" {
+  count                   = var.enable_request_buffering ? 1 : 0
+  event_source_arn        = aws_sqs_queue.buffered_requests[0].arn
+  function_name           = aws_lambda_function.bedrock_lambda.arn
+  batch_size              = 1
+  function_response_types = ["ReportBatchItemFailures"]
+
+  scaling_config {
+    maximum_concurrency = local.buffer_pollers
+  }
+}
+
+# KMS key for field-level encryption of conversation content (optional)
+resource "aws_kms_key" "conversations" {
+  count                   = var.enable_conversation_history && var.conversation_field_encryption && var.conversation_kms_key_arn == null ? 1 : 0
+  description             = "${var.name_prefix} conversation content encryption"
+  deletion_window_in_days = 7
+  enable_key_rotation     = true
+
+  tags = var.tags
+}
+
+# Completion store read through the Object Lambda access point (optional)
+resource "aws_s3_bucket" "completions" {
+  count  = var.enable_object_lambda ? 1 : 0
+  bucket = "${var.name_prefix}-completions-${data.aws_caller_identity.current.account_id}"
+
+  tags = var.tags
+}
+
+resource "aws_s3_bucket_public_access_block" "completions" {
+  count                   = var.enable_object_lambda ? 1 : 0
+  bucket                  = aws_s3_bucket.completions[0].id
+  block_public_acls       = true
+  block_public_policy     = true
+  ignore_public_acls      = true
+  restrict_public_buckets = true
+}
+
+resource "aws_s3_bucket_server_side_encryption_configuration" "completions" {
+  count  = var.enable_object_lambda ? 1 : 0
+  bucket = aws_s3_bucket.completions[0].id
+
+  rule {
+    apply_server_side_encryption_by_default {
+      sse_algorithm = "AES256"
+    }
+  }
+}
+
+# Supporting access point the Object Lambda reads originals through
+resource "aws_s3_access_point" "completions" {
+  count  = var.enable_object_lambda ? 1 : 0
+  bucket = aws_s3_bucket.completions[0].id
+  name   = substr("${var.name_prefix}-completions", 0, 50)
+}
+
+resource "aws_s3control_object_lambda_access_point" "completions" {
+  count = var.enable_object_lambda ? 1 : 0
+  name  = substr("${var.name_prefix}-completions-ol", 0, 45)
+
+  configuration {
+    supporting_access_point = aws_s3_access_point.completions[0].arn
+
+    transformation_configuration {
+      actions = ["GetObject"]
+
+      content_transformation {
+        aws_lambda {
+          function_arn = coalesce(var.object_lambda_transform_arn, try(aws_lambda_function.object_transform[0].arn, null))
+        }
+      }
+    }
+  }
+}
+
+# Bundled redaction transform, deployed unless a custom transform is supplied
+data "archive_file" "object_transform_zip" {
+  count       = var.enable_object_lambda && var.object_lambda_transform_arn == null ? 1 : 0
+  type        = "zip"
+  source_file = "${path.module}/object_lambda_transform.py"
+  output_path = "${path.module}/object_lambda_transform.zip"
+}
+
+resource "aws_iam_role" "object_transform" {
+  count = var.enable_object_lambda && var.object_lambda_transform_arn == null ? 1 : 0
+  name  = "${var.name_prefix}-object-transform-role"
+
+  assume_role_policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Action = "sts:AssumeRole"
+        Effect = "Allow"
+        Principal = {
+          Service = "lambda.amazonaws.com"
+        }
+      }
+    ]
+  })
+
+  tags = var.tags
+}
+
+locals {
+  object_transform_policy_statements = var.enable_object_lambda && var.object_lambda_transform_arn == null ? [
+    {
+      Effect   = "Allow"
+      Action   = ["s3-object-lambda:WriteGetObjectResponse"]
+      Resource = "*"
+    },
+    {
+      Effect = "Allow"
+      Action = [
+        "logs:CreateLogGroup",
+        "logs:CreateLogStream",
+        "logs:PutLogEvents"
+      ]
+      Resource = "arn:aws:logs:${data.aws_region.current.name}:${data.aws_caller_identity.current.account_id}:*"
+    }
+  ] : []
+}
+
+resource "aws_iam_role_policy" "object_transform" {
+  count = var.enable_object_lambda && var.object_lambda_transform_arn == null ? 1 : 0
+  name  = "${var.name_prefix}-object-transform-policy"
+  role  = aws_iam_role.object_transform[0].id
 
   policy = jsonencode({
+    Version   = "2012-10-17"
+    Statement = local.object_transform_policy_statements
+  })
+}
+
+resource "aws_cloudwatch_log_group" "object_transform" {
+  count             = var.enable_object_lambda && var.object_lambda_transform_arn == null ? 1 : 0
+  name              = "${var.log_group_prefix}/${var.name_prefix}-object-transform"
+  retention_in_days = var.log_retention_days
+
+  tags = var.tags
+}
+
+resource "aws_lambda_function" "object_transform" {
+  count            = var.enable_object_lambda && var.object_lambda_transform_arn == null ? 1 : 0
+  filename         = data.archive_file.object_transform_zip[0].output_path
+  source_code_hash = data.archive_file.object_transform_zip[0].output_base64sha256
+  function_name    = "${var.name_prefix}-object-transform"
+  role             = aws_iam_role.object_transform[0].arn
+  handler          = "object_lambda_transform.handler"
+  runtime          = "python3.11"
+  timeout          = 30
+
+  environment {
+    variables = {
+      LOG_LEVEL = var.log_level
+    }
+  }
+
+  logging_config {
+    log_format = "Text"
+    log_group  = aws_cloudwatch_log_group.object_transform[0].name
+  }
+
+  depends_on = [aws_cloudwatch_log_group.object_transform]
+
+  tags = var.tags
+}
+
+# Batch inference inputs and outputs (optional)
+resource "aws_s3_bucket" "batch" {
+  count  = var.enable_batch_inference ? 1 : 0
+  bucket = "${var.name_prefix}-batch-${data.aws_caller_identity.current.account_id}"
+
+  tags = var.tags
+}
+
+resource "aws_s3_bucket_public_access_block" "batch" {
+  count                   = var.enable_batch_inference ? 1 : 0
+  bucket                  = aws_s3_bucket.batch[0].id
+  block_public_acls       = true
+  block_public_policy     = true
+  ignore_public_acls      = true
+  restrict_public_buckets = true
+}
+
+resource "aws_s3_bucket_server_side_encryption_configuration" "batch" {
+  count  = var.enable_batch_inference ? 1 : 0
+  bucket = aws_s3_bucket.batch[0].id
+
+  rule {
+    apply_server_side_encryption_by_default {
+      sse_algorithm = "AES256"
+    }
+  }
+}
+
+# Service role Bedrock assumes to read batch inputs and write results
+resource "aws_iam_role" "batch" {
+  count = var.enable_batch_inference ? 1 : 0
+  name  = "${var.name_prefix}-bedrock-batch-role"
+
+  assume_role_policy = jsonencode({
     Version = "2012-10-17"
     Statement = [
       {
+        Action = "sts:AssumeRole"
         Effect = "Allow"
-        Action = [
-          "bedrock:InvokeModel",
-          "bedrock:InvokeModelWithResponseStream"
-        ]
-        Resource = var.bedrock_model_arns
-      },
-      {
-        Effect = "Allow"
-        Action = [
-          "logs:CreateLogGroup",
-          "logs:CreateLogStream", 
-          "logs:PutLogEvents"
-        ]
-        Resource = "arn:aws:logs:${data.aws_region.current.name}:${data.aws_caller_identity.current.account_id}:*"
+        Principal = {
+          Service = "bedrock.amazonaws.com"
+        }
+        Condition = {
+          StringEquals = {
+            "aws:SourceAccount" = data.aws_caller_identity.current.account_id
+          }
+        }
       }
     ]
   })
+
+  tags = var.tags
 }
 
-# Attach policy to role
-resource "aws_iam_role_policy_attachment" "lambda_bedrock_policy" {
-  role       = aws_iam_role.lambda_role.name
-  policy_arn = aws_iam_policy.bedrock_policy.arn
+locals {
+  batch_policy_statements = var.enable_batch_inference ? [
+    {
+      Effect   = "Allow"
+      Action   = ["s3:GetObject", "s3:ListBucket"]
+      Resource = [aws_s3_bucket.batch[0].arn, "${aws_s3_bucket.batch[0].arn}/input/*"]
+    },
+    {
+      Effect   = "Allow"
+      Action   = ["s3:PutObject"]
+      Resource = "${aws_s3_bucket.batch[0].arn}/output/*"
+    }
+  ] : []
 }
 
-# Lambda logs - created first to avoid permission issues
-resource "aws_cloudwatch_log_group" "lambda_logs" {
-  name              = "/aws/lambda/${var.name_prefix}-bedrock-lambda"
-  retention_in_days = var.log_retention_days
+resource "aws_iam_role_policy" "batch" {
+  count = var.enable_batch_inference ? 1 : 0
+  name  = "${var.name_prefix}-bedrock-batch-policy"
+  role  = aws_iam_role.batch[0].id
+
+  policy = jsonencode({
+    Version   = "2012-10-17"
+    Statement = local.batch_policy_statements
+  })
+}
+
+# Images clients upload through presigned URLs, kept only long enough to be sent with a prompt
+resource "aws_s3_bucket" "uploads" {
+  count  = var.enable_presigned_uploads ? 1 : 0
+  bucket = "${var.name_prefix}-uploads-${data.aws_caller_identity.current.account_id}"
 
   tags = var.tags
 }
 
-# Python Lambda function for Bedrock API calls
-resource "aws_lambda_function" "bedrock_lambda" {
-  filename         = data.archive_file.lambda_zip.output_path
-  function_name    = "${var.name_prefix}-bedrock-lambda"
-  role            = aws_iam_role.lambda_role.arn
-  handler         = "index.handler"
-  runtime         = var.lambda_runtime
-  timeout         = var.lambda_timeout
-  memory_size     = var.lambda_memory_size
-  publish         = true
+resource "aws_s3_bucket_public_access_block" "uploads" {
+  count                   = var.enable_presigned_uploads ? 1 : 0
+  bucket                  = aws_s3_bucket.uploads[0].id
+  block_public_acls       = true
+  block_public_policy     = true
+  ignore_public_acls      = true
+  restrict_public_buckets = true
+}
 
-  environment {
-    variables = {
-      BEDROCK_MODEL_ID = var.bedrock_model_id
-      LOG_LEVEL        = var.log_level
+resource "aws_s3_bucket_server_side_encryption_configuration" "uploads" {
+  count  = var.enable_presigned_uploads ? 1 : 0
+  bucket = aws_s3_bucket.uploads[0].id
+
+  rule {
+    apply_server_side_encryption_by_default {
+      sse_algorithm = "AES256"
     }
   }
+}
 
-  # VPC configuration if subnets provided
-  dynamic "vpc_config" {
-    for_each = var.vpc_subnet_ids != null ? [1] : []
-    content {
-      subnet_ids         = var.vpc_subnet_ids
-      security_group_ids = var.vpc_security_group_ids
+resource "aws_s3_bucket_lifecycle_configuration" "uploads" {
+  count  = var.enable_presigned_uploads ? 1 : 0
+  bucket = aws_s3_bucket.uploads[0].id
+
+  rule {
+    id     = "expire-uploads"
+    status = "Enabled"
+
+    filter {
+      prefix = "uploads/"
+    }
+
+    expiration {
+      days = var.upload_retention_days
+    }
+
+    abort_incomplete_multipart_upload {
+      days_after_initiation = 1
     }
   }
+}
 
-  depends_on = [
-    aws_iam_role_policy_attachment.lambda_bedrock_policy,
-    aws_cloudwatch_log_group.lambda_logs
-  ]
+# Browsers PUT straight to the bucket, so it needs the API's CORS origins too
+resource "aws_s3_bucket_cors_configuration" "uploads" {
+  count  = var.enable_presigned_uploads && var.enable_cors ? 1 : 0
+  bucket = aws_s3_bucket.uploads[0].id
+
+  cors_rule {
+    allowed_methods = ["PUT"]
+    allowed_origins = var.cors_allowed_origins
+    allowed_headers = ["Content-Type"]
+    max_age_seconds = 3000
+  }
+}
+
+# Submissions waiting for a batch job slot
+resource "aws_sqs_queue" "batch_submissions" {
+  count                      = var.enable_batch_inference ? 1 : 0
+  name                       = "${var.name_prefix}-batch-submissions"
+  visibility_timeout_seconds = 300
+  message_retention_seconds  = 1209600
+  sqs_managed_sse_enabled    = true
+
+  tags = var.tags
+}
+
+# Queued submissions are retried each visibility timeout until a slot frees up
+resource "aws_lambda_event_source_mapping" "batch_submissions" {
+  count                   = var.enable_batch_inference ? 1 : 0
+  event_source_arn        = aws_sqs_queue.batch_submissions[0].arn
+  function_name           = aws_lambda_function.bedrock_lambda.arn
+  batch_size              = 1
+  function_response_types = ["ReportBatchItemFailures"]
+
+  # Bounds the submission rate against Bedrock's control plane
+  scaling_config {
+    maximum_concurrency = 2
+  }
+}
+
+# Request archive for analytics, partitioned by date and model (optional)
+resource "aws_s3_bucket" "archive" {
+  count  = var.enable_archival ? 1 : 0
+  bucket = "${var.name_prefix}-archive-${data.aws_caller_identity.current.account_id}"
+
+  tags = var.tags
+}
+
+resource "aws_s3_bucket_public_access_block" "archive" {
+  count                   = var.enable_archival ? 1 : 0
+  bucket                  = aws_s3_bucket.archive[0].id
+  block_public_acls       = true
+  block_public_policy     = true
+  ignore_public_acls      = true
+  restrict_public_buckets = true
+}
+
+resource "aws_s3_bucket_server_side_encryption_configuration" "archive" {
+  count  = var.enable_archival ? 1 : 0
+  bucket = aws_s3_bucket.archive[0].id
+
+  rule {
+    apply_server_side_encryption_by_default {
+      sse_algorithm = "AES256"
+    }
+  }
+}
+
+# Role Firehose assumes to deliver buffered archive records
+resource "aws_iam_role" "archive_firehose" {
+  count = local.archive_firehose_enabled ? 1 : 0
+  name  = "${var.name_prefix}-archive-firehose-role"
+
+  assume_role_policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Action = "sts:AssumeRole"
+        Effect = "Allow"
+        Principal = {
+          Service = "firehose.amazonaws.com"
+        }
+        Condition = {
+          StringEquals = {
+            "aws:SourceAccount" = data.aws_caller_identity.current.account_id
+          }
+        }
+      }
+    ]
+  })
+
+  tags = var.tags
+}
+
+locals {
+  archive_firehose_policy_statements = local.archive_firehose_enabled ? [
+    {
+      Effect = "Allow"
+      Action = [
+        "s3:AbortMultipartUpload",
+        "s3:GetBucketLocation",
+        "s3:ListBucket",
+        "s3:ListBucketMultipartUploads",
+        "s3:PutObject"
+      ]
+      Resource = [aws_s3_bucket.archive[0].arn, "${aws_s3_bucket.archive[0].arn}/*"]
+    }
+  ] : []
+}
+
+resource "aws_iam_role_policy" "archive_firehose" {
+  count = local.archive_firehose_enabled ? 1 : 0
+  name  = "${var.name_prefix}-archive-firehose-policy"
+  role  = aws_iam_role.archive_firehose[0].id
+
+  policy = jsonencode({
+    Version   = "2012-10-17"
+    Statement = local.archive_firehose_policy_statements
+  })
+}
+
+# Buffers archive records into larger objects; the model partition is read from each record
+resource "aws_kinesis_firehose_delivery_stream" "archive" {
+  count       = local.archive_firehose_enabled ? 1 : 0
+  name        = "${var.name_prefix}-archive"
+  destination = "extended_s3"
+
+  extended_s3_configuration {
+    role_arn            = aws_iam_role.archive_firehose[0].arn
+    bucket_arn          = aws_s3_bucket.archive[0].arn
+    prefix              = "!{timestamp:yyyy}/!{timestamp:MM}/!{timestamp:dd}/!{partitionKeyFromQuery:model_id}/"
+    error_output_prefix = "errors/!{firehose:error-output-type}/!{timestamp:yyyy}/!{timestamp:MM}/!{timestamp:dd}/"
+    buffering_interval  = var.archive_buffer_seconds
+
+    # Dynamic partitioning needs at least a 64 MB buffer
+    buffering_size = 64
+
+    dynamic_partitioning_configuration {
+      enabled = true
+    }
+
+    processing_configuration {
+      enabled = true
+
+      processors {
+        type = "MetadataExtraction"
+
+        parameters {
+          parameter_name  = "JsonParsingEngine"
+          parameter_value = "JQ-1.6"
+        }
+        parameters {
+          parameter_name  = "MetadataExtractionQuery"
+          parameter_value = "{model_id: .model_id}"
+        }
+      }
+
+      # One JSON record per line in the delivered objects
+      processors {
+        type = "AppendDelimiterToRecord"
+      }
+    }
+  }
 
   tags = var.tags
 }
@@ -117,38 +1736,84 @@ data "archive_file" "lambda_zip" {
   }
 }
 
-# API Gateway REST API
+# API Gateway REST API - skipped when attaching routes to an existing API
 resource "aws_api_gateway_rest_api" "bedrock_api" {
+  count       = var.existing_rest_api_id == null ? 1 : 0
   name        = "${var.name_prefix}-bedrock-api"
   description = "API Gateway for Amazon Bedrock Lambda integration"
 
+  minimum_compression_size = local.api_minimum_compression_size != null ? tostring(local.api_minimum_compression_size) : null
+
   endpoint_configuration {
-    types = ["REGIONAL"]
+    types = [var.api_endpoint_type]
   }
 
   tags = var.tags
 }
 
+# Resource policy restricting callers to the allowed IP ranges and accounts (optional)
+resource "aws_api_gateway_rest_api_policy" "bedrock_api" {
+  count       = local.api_allowlist_enabled ? 1 : 0
+  rest_api_id = aws_api_gateway_rest_api.bedrock_api[0].id
+  policy      = local.api_resource_policy
+}
+
+# Keep existing deployments from replacing the API now that it is optional
+moved {
+  from = aws_api_gateway_rest_api.bedrock_api
+  to   = aws_api_gateway_rest_api.bedrock_api[0]
+}
+
 # API Gateway Resource
 resource "aws_api_gateway_resource" "bedrock_resource" {
-  rest_api_id = aws_api_gateway_rest_api.bedrock_api.id
-  parent_id   = aws_api_gateway_rest_api.bedrock_api.root_resource_id
+  rest_api_id = local.rest_api_id
+  parent_id   = local.root_resource_id
   path_part   = "bedrock"
 }
 
+# Cognito authorizer (optional). Callers send an ID token from the user pool
+# in the Authorization header.
+resource "aws_api_gateway_authorizer" "cognito" {
+  count           = var.auth_type == "COGNITO" ? 1 : 0
+  name            = "${var.name_prefix}-cognito"
+  rest_api_id     = local.rest_api_id
+  type            = "COGNITO_USER_POOLS"
+  provider_arns   = [var.cognito_user_pool_arn]
+  identity_source = "method.request.header.Authorization"
+}
+
 # API Gateway Method
 resource "aws_api_gateway_method" "bedrock_method" {
-  rest_api_id   = aws_api_gateway_rest_api.bedrock_api.id
+  rest_api_id   = local.rest_api_id
   resource_id   = aws_api_gateway_resource.bedrock_resource.id
   http_method   = "POST"
-  authorization = var.enable_api_key ? "NONE" : "NONE"
+  authorization = local.method_authorization
+  authorizer_id = local.method_authorizer_id
   api_key_required = var.enable_api_key
+
+  # Clients send a SHA-256 of the body so identical prompts share a cache entry.
+  # The header is required, otherwise every request without it would share one entry.
+  # Accept is part of the key because it selects the JSON or text response format.
+  request_parameters = var.enable_api_cache ? {
+    "method.request.header.${local.cache_key_header}" = true
+    "method.request.header.Accept"                    = false
+  } : {}
+  request_validator_id = var.enable_api_cache ? aws_api_gateway_request_validator.cache_key[0].id : null
+}
+
+# Enforces the cache key header before cached responses are served
+resource "aws_api_gateway_request_validator" "cache_key" {
+  count                       = var.enable_api_cache ? 1 : 0
+  name                        = "${var.name_prefix}-cache-key"
+  rest_api_id                 = local.rest_api_id
+  validate_request_parameters = true
+  validate_request_body       = false
 }
 
 # API Gateway OPTIONS method for CORS
 resource "aws_api_gateway_method" "bedrock_options" {
   count         = var.enable_cors ? 1 : 0
-  rest_api_id   = aws_api_gateway_rest_api.bedrock_api.id
+  rest_api_id   = local.rest_api_id
   resource_id   = aws_api_gateway_resource.bedrock_resource.id
   http_method   = "OPTIONS"
   authorization = "NONE"
@@ -157,7 +1822,7 @@ resource "aws_api_gateway_method" "bedrock_options" {
 # API Gateway OPTIONS integration for CORS
 resource "aws_api_gateway_integration" "bedrock_options_integration" {
   count                   = var.enable_cors ? 1 : 0
-  rest_api_id             = aws_api_gateway_rest_api.bedrock_api.id
+  rest_api_id             = local.rest_api_id
   resource_id             = aws_api_gateway_resource.bedrock_resource.id
   http_method             = aws_api_gateway_method.bedrock_options[0].http_method
   type                    = "MOCK"
@@ -169,42 +1834,260 @@ resource "aws_api_gateway_integration" "bedrock_options_integration" {
 # API Gateway OPTIONS method response for CORS
 resource "aws_api_gateway_method_response" "bedrock_options_200" {
   count       = var.enable_cors ? 1 : 0
-  rest_api_id = aws_api_gateway_rest_api.bedrock_api.id
+  rest_api_id = local.rest_api_id
   resource_id = aws_api_gateway_resource.bedrock_resource.id
   http_method = aws_api_gateway_method.bedrock_options[0].http_method
   status_code = "200"
 
-  response_parameters = {
-    "method.response.header.Access-Control-Allow-Headers" = true
-    "method.response.header.Access-Control-Allow-Methods" = true
-    "method.response.header.Access-Control-Allow-Origin"  = true
-  }
+  response_parameters = merge(
+    {
+      "method.response.header.Access-Control-Allow-Headers" = true
+      "method.response.header.Access-Control-Allow-Methods" = true
+      "method.response.header.Access-Control-Allow-Origin"  = true
+    },
+    { for header in keys(local.cors_preflight_headers) : "method.response.header.${header}" => true }
+  )
 }
 
 # API Gateway OPTIONS integration response for CORS
 resource "aws_api_gateway_integration_response" "bedrock_options_integration_response" {
   count       = var.enable_cors ? 1 : 0
-  rest_api_id = aws_api_gateway_rest_api.bedrock_api.id
+  rest_api_id = local.rest_api_id
   resource_id = aws_api_gateway_resource.bedrock_resource.id
   http_method = aws_api_gateway_method.bedrock_options[0].http_method
   status_code = aws_api_gateway_method_response.bedrock_options_200[0].status_code
 
-  response_parameters = {
-    "method.response.header.Access-Control-Allow-Headers" = "'${join(",", var.cors_allowed_headers)}'"
-    "method.response.header.Access-Control-Allow-Methods" = "'${join(",", var.cors_allowed_methods)}'"
-    "method.response.header.Access-Control-Allow-Origin"  = "'${join(",", var.cors_allowed_origins)}'"
-  }
+  response_parameters = merge(
+    {
+      "method.response.header.Access-Control-Allow-Headers" = "'${join(",", var.cors_allowed_headers)}'"
+      "method.response.header.Access-Control-Allow-Methods" = "'${join(",", var.cors_allowed_methods)}'"
+      "method.response.header.Access-Control-Allow-Origin"  = "'${join(",", var.cors_allowed_origins)}'"
+    },
+    { for header, value in local.cors_preflight_headers : "method.response.header.${header}" => "'${value}'" }
+  )
 }
 
 # API Gateway Integration
 resource "aws_api_gateway_integration" "bedrock_integration" {
-  rest_api_id = aws_api_gateway_rest_api.bedrock_api.id
+  rest_api_id = local.rest_api_id
   resource_id = aws_api_gateway_resource.bedrock_resource.id
   http_method = aws_api_gateway_method.bedrock_method.http_method
 
   integration_http_method = "POST"
   type                   = "AWS_PROXY"
   uri                    = aws_lambda_function.bedrock_lambda.invoke_arn
+
+  cache_key_parameters = var.enable_api_cache ? ["method.request.header.${local.cache_key_header}", "method.request.header.Accept"] : []
+}
+
+# API Gateway image generation route (optional)
+resource "aws_api_gateway_resource" "images_resource" {
+  count       = var.enable_image_generation ? 1 : 0
+  rest_api_id = local.rest_api_id
+  parent_id   = local.root_resource_id
+  path_part   = "images"
+}
+
+resource "aws_api_gateway_method" "images_method" {
+  count            = var.enable_image_generation ? 1 : 0
+  rest_api_id      = local.rest_api_id
+  resource_id      = aws_api_gateway_resource.images_resource[0].id
+  http_method      = "POST"
+  authorization    = local.method_authorization
+  authorizer_id    = local.method_authorizer_id
+  api_key_required = var.enable_api_key
+}
+
+resource "aws_api_gateway_integration" "images_integration" {
+  count       = var.enable_image_generation ? 1 : 0
+  rest_api_id = local.rest_api_id
+  resource_id = aws_api_gateway_resource.images_resource[0].id
+  http_method = aws_api_gateway_method.images_method[0].http_method
+
+  integration_http_method = "POST"
+  type                    = "AWS_PROXY"
+  uri                     = aws_lambda_function.bedrock_lambda.invoke_arn
+}
+
+# API Gateway Bedrock agent route (optional)
+resource "aws_api_gateway_resource" "agent_resource" {
+  count       = var.bedrock_agent_id != null ? 1 : 0
+  rest_api_id = local.rest_api_id
+  parent_id   = local.root_resource_id
+  path_part   = "agent"
+}
+
+resource "aws_api_gateway_method" "agent_method" {
+  count            = var.bedrock_agent_id != null ? 1 : 0
+  rest_api_id      = local.rest_api_id
+  resource_id      = aws_api_gateway_resource.agent_resource[0].id
+  http_method      = "POST"
+  authorization    = local.method_authorization
+  authorizer_id    = local.method_authorizer_id
+  api_key_required = var.enable_api_key
+}
+
+resource "aws_api_gateway_integration" "agent_integration" {
+  count       = var.bedrock_agent_id != null ? 1 : 0
+  rest_api_id = local.rest_api_id
+  resource_id = aws_api_gateway_resource.agent_resource[0].id
+  http_method = aws_api_gateway_method.agent_method[0].http_method
+
+  integration_http_method = "POST"
+  type                    = "AWS_PROXY"
+  uri                     = aws_lambda_function.bedrock_lambda.invoke_arn
+}
+
+# API Gateway knowledge base route (optional)
+resource "aws_api_gateway_resource" "retrieve_resource" {
+  count       = var.bedrock_knowledge_base_id != null ? 1 : 0
+  rest_api_id = local.rest_api_id
+  parent_id   = local.root_resource_id
+  path_part   = "retrieve"
+}
+
+resource "aws_api_gateway_method" "retrieve_method" {
+  count            = var.bedrock_knowledge_base_id != null ? 1 : 0
+  rest_api_id      = local.rest_api_id
+  resource_id      = aws_api_gateway_resource.retrieve_resource[0].id
+  http_method      = "POST"
+  authorization    = local.method_authorization
+  authorizer_id    = local.method_authorizer_id
+  api_key_required = var.enable_api_key
+}
+
+resource "aws_api_gateway_integration" "retrieve_integration" {
+  count       = var.bedrock_knowledge_base_id != null ? 1 : 0
+  rest_api_id = local.rest_api_id
+  resource_id = aws_api_gateway_resource.retrieve_resource[0].id
+  http_method = aws_api_gateway_method.retrieve_method[0].http_method
+
+  integration_http_method = "POST"
+  type                    = "AWS_PROXY"
+  uri                     = aws_lambda_function.bedrock_lambda.invoke_arn
+}
+
+# API Gateway batch inference route (optional)
+resource "aws_api_gateway_resource" "batch_resource" {
+  count       = var.enable_batch_inference ? 1 : 0
+  rest_api_id = local.rest_api_id
+  parent_id   = local.root_resource_id
+  path_part   = "batch"
+}
+
+resource "aws_api_gateway_method" "batch_method" {
+  count            = var.enable_batch_inference ? 1 : 0
+  rest_api_id      = local.rest_api_id
+  resource_id      = aws_api_gateway_resource.batch_resource[0].id
+  http_method      = "POST"
+  authorization    = local.method_authorization
+  authorizer_id    = local.method_authorizer_id
+  api_key_required = var.enable_api_key
+}
+
+resource "aws_api_gateway_integration" "batch_integration" {
+  count       = var.enable_batch_inference ? 1 : 0
+  rest_api_id = local.rest_api_id
+  resource_id = aws_api_gateway_resource.batch_resource[0].id
+  http_method = aws_api_gateway_method.batch_method[0].http_method
+
+  integration_http_method = "POST"
+  type                    = "AWS_PROXY"
+  uri                     = aws_lambda_function.bedrock_lambda.invoke_arn
+}
+
+# API Gateway presigned upload route (optional)
+resource "aws_api_gateway_resource" "upload_url_resource" {
+  count       = var.enable_presigned_uploads ? 1 : 0
+  rest_api_id = local.rest_api_id
+  parent_id   = local.root_resource_id
+  path_part   = "upload-url"
+}
+
+resource "aws_api_gateway_method" "upload_url_method" {
+  count            = var.enable_presigned_uploads ? 1 : 0
+  rest_api_id      = local.rest_api_id
+  resource_id      = aws_api_gateway_resource.upload_url_resource[0].id
+  http_method      = "POST"
+  authorization    = local.method_authorization
+  authorizer_id    = local.method_authorizer_id
+  api_key_required = var.enable_api_key
+}
+
+resource "aws_api_gateway_integration" "upload_url_integration" {
+  count       = var.enable_presigned_uploads ? 1 : 0
+  rest_api_id = local.rest_api_id
+  resource_id = aws_api_gateway_resource.upload_url_resource[0].id
+  http_method = aws_api_gateway_method.upload_url_method[0].http_method
+
+  integration_http_method = "POST"
+  type                    = "AWS_PROXY"
+  uri                     = aws_lambda_function.bedrock_lambda.invoke_arn
+}
+
+# Unauthenticated health check reporting the handler version
+resource "aws_api_gateway_resource" "health_resource" {
+  rest_api_id = local.rest_api_id
+  parent_id   = local.root_resource_id
+  path_part   = "health"
+}
+
+resource "aws_api_gateway_method" "health_method" {
+  rest_api_id   = local.rest_api_id
+  resource_id   = aws_api_gateway_resource.health_resource.id
+  http_method   = "GET"
+  authorization = local.method_authorization
+  authorizer_id = local.method_authorizer_id
+}
+
+resource "aws_api_gateway_integration" "health_integration" {
+  rest_api_id = local.rest_api_id
+  resource_id = aws_api_gateway_resource.health_resource.id
+  http_method = aws_api_gateway_method.health_method.http_method
+
+  integration_http_method = "POST"
+  type                    = "AWS_PROXY"
+  uri                     = aws_lambda_function.bedrock_lambda.invoke_arn
+}
+
+# API Gateway async result route (optional)
+resource "aws_api_gateway_resource" "result_resource" {
+  count       = var.enable_async_invocation ? 1 : 0
+  rest_api_id = local.rest_api_id
+  parent_id   = local.root_resource_id
+  path_part   = "result"
+}
+
+resource "aws_api_gateway_resource" "result_job_resource" {
+  count       = var.enable_async_invocation ? 1 : 0
+  rest_api_id = local.rest_api_id
+  parent_id   = aws_api_gateway_resource.result_resource[0].id
+  path_part   = "{job_id}"
+}
+
+resource "aws_api_gateway_method" "result_method" {
+  count            = var.enable_async_invocation ? 1 : 0
+  rest_api_id      = local.rest_api_id
+  resource_id      = aws_api_gateway_resource.result_job_resource[0].id
+  http_method      = "GET"
+  authorization    = local.method_authorization
+  authorizer_id    = local.method_authorizer_id
+  api_key_required = var.enable_api_key
+
+  request_parameters = {
+    "method.request.path.job_id" = true
+  }
+}
+
+resource "aws_api_gateway_integration" "result_integration" {
+  count       = var.enable_async_invocation ? 1 : 0
+  rest_api_id = local.rest_api_id
+  resource_id = aws_api_gateway_resource.result_job_resource[0].id
+  http_method = aws_api_gateway_method.result_method[0].http_method
+
+  integration_http_method = "POST"
+  type                    = "AWS_PROXY"
+  uri                     = aws_lambda_function.bedrock_lambda.invoke_arn
 }
 
 # Lambda permission for API Gateway
@@ -213,16 +2096,80 @@ resource "aws_lambda_permission" "api_gateway" {
   action        = "lambda:InvokeFunction"
   function_name = aws_lambda_function.bedrock_lambda.function_name
   principal     = "apigateway.amazonaws.com"
-  source_arn    = "${aws_api_gateway_rest_api.bedrock_api.execution_arn}/*/*"
+  source_arn    = "${local.rest_api_execution_arn}/*/*"
+}
+
+# JSON bodies for errors API Gateway returns before reaching the Lambda
+resource "aws_api_gateway_gateway_response" "errors" {
+  for_each      = local.gateway_response_types
+  rest_api_id   = local.rest_api_id
+  response_type = each.key
+
+  response_templates = {
+    "application/json" = "{\"success\": false, \"error\": {\"code\": \"$context.error.responseType\", \"message\": ${local.gateway_response_messages[each.key]}, \"request_id\": \"$context.requestId\"}}"
+  }
+
+  response_parameters = var.enable_cors ? merge({
+    "gatewayresponse.header.Access-Control-Allow-Origin"  = "'${join(",", var.cors_allowed_origins)}'"
+    "gatewayresponse.header.Access-Control-Allow-Headers" = "'${join(",", var.cors_allowed_headers)}'"
+  }, var.cors_allow_credentials ? { "gatewayresponse.header.Access-Control-Allow-Credentials" = "'true'" } : {}) : {}
 }
 
 # API Gateway Deployment
 resource "aws_api_gateway_deployment" "bedrock_deployment" {
   depends_on = [
-    aws_api_gateway_integration.bedrock_integration
+    aws_api_gateway_integration.bedrock_integration,
+    aws_api_gateway_integration.images_integration,
+    aws_api_gateway_integration.agent_integration,
+    aws_api_gateway_integration.retrieve_integration,
+    aws_api_gateway_integration.batch_integration,
+    aws_api_gateway_integration.upload_url_integration,
+    aws_api_gateway_integration.result_integration,
+    aws_api_gateway_integration.health_integration,
+    aws_api_gateway_rest_api_policy.bedrock_api
   ]
 
-  rest_api_id = aws_api_gateway_rest_api.bedrock_api.id
+  rest_api_id = local.rest_api_id
+
+  # Redeploy the stage whenever routes are added or removed, the preflight
+  # headers or compression size change, authorization changes, or the resource
+  # policy changes, since a policy only takes effect on the next deployment
+  triggers = {
+    redeployment = sha1(jsonencode([
+      aws_api_gateway_integration.bedrock_integration.id,
+      aws_api_gateway_integration.images_integration[*].id,
+      aws_api_gateway_integration.agent_integration[*].id,
+      aws_api_gateway_integration.retrieve_integration[*].id,
+      aws_api_gateway_integration.batch_integration[*].id,
+      aws_api_gateway_integration.upload_url_integration[*].id,
+      aws_api_gateway_integration.result_integration[*].id,
+      aws_api_gateway_integration.health_integration.id,
+      [for response in aws_api_gateway_gateway_response.errors : response.response_templates],
+      local.cors_preflight_headers,
+      local.api_minimum_compression_size,
+      local.method_authorization,
+      local.method_authorizer_id,
+      local.api_resource_policy
+    ]))
+  }
+
+  lifecycle {
+    create_before_destroy = true
+  }
+}
+
+# Stable deployment for stage canaries (optional). It snapshots the API when
+# created and is only replaced when canary_stable_version changes, while
+# bedrock_deployment keeps tracking the current configuration as the canary
+resource "aws_api_gateway_deployment" "stable" {
+  count      = var.enable_stage_canary ? 1 : 0
+  depends_on = [aws_api_gateway_deployment.bedrock_deployment]
+
+  rest_api_id = local.rest_api_id
+
+  triggers = {
+    stable_version = var.canary_stable_version
+  }
 
   lifecycle {
     create_before_destroy = true
@@ -231,13 +2178,101 @@ resource "aws_api_gateway_deployment" "bedrock_deployment" {
 
 # API Gateway Stage
 resource "aws_api_gateway_stage" "bedrock_stage" {
-  deployment_id = aws_api_gateway_deployment.bedrock_deployment.id
-  rest_api_id   = aws_api_gateway_rest_api.bedrock_api.id
+  deployment_id = var.enable_stage_canary ? aws_api_gateway_deployment.stable[0].id : aws_api_gateway_deployment.bedrock_deployment.id
+  rest_api_id   = local.rest_api_id
   stage_name    = var.api_stage_name
 
+  cache_cluster_enabled = var.enable_api_cache
+  cache_cluster_size    = var.enable_api_cache ? var.cache_cluster_size : null
+  xray_tracing_enabled  = var.enable_xray_tracing
+
+  dynamic "canary_settings" {
+    for_each = var.enable_stage_canary ? [1] : []
+    content {
+      deployment_id   = aws_api_gateway_deployment.bedrock_deployment.id
+      percent_traffic = var.canary_percent_traffic
+      use_stage_cache = false
+    }
+  }
+
+  tags = var.tags
+}
+
+# Method-level caching for the Bedrock route (optional)
+resource "aws_api_gateway_method_settings" "bedrock_cache" {
+  count       = var.enable_api_cache ? 1 : 0
+  rest_api_id = local.rest_api_id
+  stage_name  = aws_api_gateway_stage.bedrock_stage.stage_name
+  method_path = "${aws_api_gateway_resource.bedrock_resource.path_part}/${aws_api_gateway_method.bedrock_method.http_method}"
+
+  settings {
+    caching_enabled      = true
+    cache_ttl_in_seconds = var.cache_ttl_seconds
+    cache_data_encrypted = true
+  }
+}
+
+# Custom domain for the stage (optional)
+resource "aws_api_gateway_domain_name" "custom" {
+  count       = var.custom_domain_name != null ? 1 : 0
+  domain_name = var.custom_domain_name
+
+  # Edge-optimized domains terminate TLS at CloudFront, which takes the certificate differently
+  certificate_arn          = var.api_endpoint_type == "EDGE" ? var.custom_domain_certificate_arn : null
+  regional_certificate_arn = var.api_endpoint_type == "REGIONAL" ? var.custom_domain_certificate_arn : null
+  security_policy          = var.minimum_tls_version
+
+  endpoint_configuration {
+    types = [var.api_endpoint_type]
+  }
+
+  tags = var.tags
+}
+
+resource "aws_api_gateway_base_path_mapping" "custom" {
+  count       = var.custom_domain_name != null ? 1 : 0
+  api_id      = local.rest_api_id
+  stage_name  = aws_api_gateway_stage.bedrock_stage.stage_name
+  domain_name = aws_api_gateway_domain_name.custom[0].domain_name
+}
+
+# EventBridge schedules for recurring prompts (optional)
+resource "aws_cloudwatch_event_rule" "scheduled_prompts" {
+  for_each = local.scheduled_prompts
+
+  name                = "${var.name_prefix}-prompt-${each.key}"
+  description         = "Scheduled Bedrock prompt: ${each.key}"
+  schedule_expression = each.value.schedule_expression
+
   tags = var.tags
 }
 
+resource "aws_cloudwatch_event_target" "scheduled_prompts" {
+  for_each = local.scheduled_prompts
+
+  rule = aws_cloudwatch_event_rule.scheduled_prompts[each.key].name
+  arn  = aws_lambda_function.bedrock_lambda.arn
+
+  input = jsonencode({
+    scheduled_prompt = {
+      name        = each.key
+      prompt      = each.value.prompt
+      model       = each.value.model
+      destination = each.value.destination
+    }
+  })
+}
+
+resource "aws_lambda_permission" "scheduled_prompts" {
+  for_each = local.scheduled_prompts
+
+  statement_id  = "AllowScheduledPrompt-${each.key}"
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.bedrock_lambda.function_name
+  principal     = "events.amazonaws.com"
+  source_arn    = aws_cloudwatch_event_rule.scheduled_prompts[each.key].arn
+}
+
 # CloudWatch Alarms for Lambda
 resource "aws_cloudwatch_metric_alarm" "lambda_errors" {
   count               = var.enable_monitoring ? 1 : 0
@@ -279,30 +2314,309 @@ resource "aws_cloudwatch_metric_alarm" "lambda_duration" {
   tags = var.tags
 }
 
+# Bedrock throttles counted by the handler, including calls the fallback
+# chain or the async queue went on to retry
+resource "aws_cloudwatch_metric_alarm" "bedrock_throttles" {
+  count               = var.enable_monitoring ? 1 : 0
+  alarm_name          = "${var.name_prefix}-bedrock-throttles"
+  comparison_operator = "GreaterThanThreshold"
+  evaluation_periods  = "1"
+  metric_name         = "BedrockThrottles"
+  namespace           = var.metric_namespace
+  period              = "60"
+  statistic           = "Sum"
+  threshold           = var.throttle_alarm_threshold
+  alarm_description   = "This metric monitors Bedrock throttling of the handler's model calls"
+  alarm_actions       = var.alarm_actions
+  treat_missing_data  = "notBreaching"
+
+  dimensions = {
+    FunctionName = aws_lambda_function.bedrock_lambda.function_name
+  }
+
+  tags = var.tags
+}
+
+# Dashboard of the function, API and handler metrics, alongside the alarms above
+resource "aws_cloudwatch_dashboard" "bedrock" {
+  count          = var.enable_monitoring ? 1 : 0
+  dashboard_name = "${var.name_prefix}-bedrock"
+
+  dashboard_body = jsonencode({
+    widgets = concat(
+      [
+        {
+          type   = "metric"
+          width  = 12
+          height = 6
+          properties = {
+            title  = "Lambda invocations"
+            region = data.aws_region.current.name
+            stat   = "Sum"
+            period = 300
+            metrics = [
+              for metric in ["Invocations", "Errors", "Throttles"] :
+              ["AWS/Lambda", metric, "FunctionName", aws_lambda_function.bedrock_lambda.function_name]
+            ]
+          }
+        },
+        {
+          type   = "metric"
+          width  = 12
+          height = 6
+          properties = {
+            title  = "Lambda duration"
+            region = data.aws_region.current.name
+            period = 300
+            metrics = [
+              for stat in ["Average", "p95"] :
+              ["AWS/Lambda", "Duration", "FunctionName", aws_lambda_function.bedrock_lambda.function_name, { stat = stat }]
+            ]
+          }
+        },
+        {
+          type   = "metric"
+          width  = 12
+          height = 6
+          properties = {
+            title   = "Bedrock throttles"
+            region  = data.aws_region.current.name
+            stat    = "Sum"
+            period  = 60
+            metrics = [[var.metric_namespace, "BedrockThrottles", "FunctionName", aws_lambda_function.bedrock_lambda.function_name]]
+          }
+        },
+        {
+          type   = "alarm"
+          width  = 12
+          height = 6
+          properties = {
+            title = "Alarms"
+            alarms = [
+              aws_cloudwatch_metric_alarm.lambda_errors[0].arn,
+              aws_cloudwatch_metric_alarm.lambda_duration[0].arn,
+              aws_cloudwatch_metric_alarm.bedrock_throttles[0].arn
+            ]
+          }
+        }
+      ],
+      # An existing API's name isn't known here, and its metrics cover its other routes
+      var.existing_rest_api_id == null ? [
+        {
+          type   = "metric"
+          width  = 24
+          height = 6
+          properties = {
+            title  = "API Gateway"
+            region = data.aws_region.current.name
+            stat   = "Sum"
+            period = 300
+            metrics = [
+              for metric in ["Count", "4XXError", "5XXError"] :
+              ["AWS/ApiGateway", metric, "ApiName", aws_api_gateway_rest_api.bedrock_api[0].name, "Stage", aws_api_gateway_stage.bedrock_stage.stage_name]
+            ]
+          }
+        }
+      ] : []
+    )
+  })
+}
+
+# Cost killswitch - pauses the API when invocations spike (optional)
+resource "aws_cloudwatch_metric_alarm" "cost_killswitch" {
+  count               = var.enable_cost_killswitch ? 1 : 0
+  alarm_name          = "${var.name_prefix}-cost-killswitch"
+  comparison_operator = "GreaterThanThreshold"
+  evaluation_periods  = "1"
+  metric_name         = "Invocations"
+  namespace           = "AWS/Lambda"
+  period              = "3600"
+  statistic           = "Sum"
+  threshold           = var.cost_killswitch_threshold
+  alarm_description   = "Pauses the Bedrock API when hourly invocations exceed the killswitch threshold"
+  alarm_actions       = concat([aws_lambda_function.cost_killswitch[0].arn], var.alarm_actions)
+
+  dimensions = {
+    FunctionName = aws_lambda_function.bedrock_lambda.function_name
+  }
+
+  tags = var.tags
+}
+
+data "archive_file" "cost_killswitch_zip" {
+  count       = var.enable_cost_killswitch ? 1 : 0
+  type        = "zip"
+  source_file = "${path.module}/cost_killswitch.py"
+  output_path = "${path.module}/cost_killswitch.zip"
+}
+
+resource "aws_iam_role" "cost_killswitch" {
+  count = var.enable_cost_killswitch ? 1 : 0
+  name  = "${var.name_prefix}-cost-killswitch-role"
+
+  assume_role_policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Action = "sts:AssumeRole"
+        Effect = "Allow"
+        Principal = {
+          Service = "lambda.amazonaws.com"
+        }
+      }
+    ]
+  })
+
+  tags = var.tags
+}
+
+locals {
+  cost_killswitch_policy_statements = var.enable_cost_killswitch ? [
+    {
+      Effect   = "Allow"
+      Action   = ["lambda:PutFunctionConcurrency"]
+      Resource = aws_lambda_function.bedrock_lambda.arn
+    },
+    {
+      Effect = "Allow"
+      Action = [
+        "logs:CreateLogGroup",
+        "logs:CreateLogStream",
+        "logs:PutLogEvents"
+      ]
+      Resource = "arn:aws:logs:${data.aws_region.current.name}:${data.aws_caller_identity.current.account_id}:*"
+    }
+  ] : []
+}
+
+resource "aws_iam_role_policy" "cost_killswitch" {
+  count = var.enable_cost_killswitch ? 1 : 0
+  name  = "${var.name_prefix}-cost-killswitch-policy"
+  role  = aws_iam_role.cost_killswitch[0].id
+
+  policy = jsonencode({
+    Version   = "2012-10-17"
+    Statement = local.cost_killswitch_policy_statements
+  })
+}
+
+resource "aws_cloudwatch_log_group" "cost_killswitch" {
+  count             = var.enable_cost_killswitch ? 1 : 0
+  name              = "${var.log_group_prefix}/${var.name_prefix}-cost-killswitch"
+  retention_in_days = var.log_retention_days
+
+  tags = var.tags
+}
+
+resource "aws_lambda_function" "cost_killswitch" {
+  count            = var.enable_cost_killswitch ? 1 : 0
+  filename         = data.archive_file.cost_killswitch_zip[0].output_path
+  source_code_hash = data.archive_file.cost_killswitch_zip[0].output_base64sha256
+  function_name    = "${var.name_prefix}-cost-killswitch"
+  role             = aws_iam_role.cost_killswitch[0].arn
+  handler          = "cost_killswitch.handler"
+  runtime          = "python3.11"
+  timeout          = 30
+
+  environment {
+    variables = {
+      LOG_LEVEL            = var.log_level
+      TARGET_FUNCTION_NAME = aws_lambda_function.bedrock_lambda.function_name
+    }
+  }
+
+  logging_config {
+    log_format = "Text"
+    log_group  = aws_cloudwatch_log_group.cost_killswitch[0].name
+  }
+
+  depends_on = [aws_cloudwatch_log_group.cost_killswitch]
+
+  tags = var.tags
+}
+
+# CloudWatch alarms invoke Lambda actions through their own service principal
+resource "aws_lambda_permission" "cost_killswitch" {
+  count         = var.enable_cost_killswitch ? 1 : 0
+  statement_id  = "AllowExecutionFromCloudWatchAlarm"
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.cost_killswitch[0].function_name
+  principal     = "lambda.alarms.cloudwatch.amazonaws.com"
+  source_arn    = aws_cloudwatch_metric_alarm.cost_killswitch[0].arn
+}
+
 # WAF Web ACL for API Gateway (optional)
 resource "aws_wafv2_web_acl" "api_gateway_waf" {
   count = var.enable_waf ? 1 : 0
 
   name        = "${var.name_prefix}-api-gateway-waf"
   description = "WAF for API Gateway"
-  scope       = "REGIONAL"
+  scope       = var.waf_scope
 
   default_action {
     allow {}
   }
 
+  # Blocked IP ranges are evaluated before any other rule
+  dynamic "rule" {
+    for_each = length(var.waf_blocked_ip_ranges) > 0 ? [1] : []
+    content {
+      name     = "BlockedIPRule"
+      priority = local.waf_rule_priorities.blocked_ips
+
+      action {
+        block {}
+      }
+
+      statement {
+        ip_set_reference_statement {
+          arn = aws_wafv2_ip_set.blocked[0].arn
+        }
+      }
+
+      visibility_config {
+        cloudwatch_metrics_enabled = true
+        metric_name                = "BlockedIPRule"
+        sampled_requests_enabled   = true
+      }
+    }
+  }
+
   rule {
     name     = "RateLimitRule"
-    priority = 1
+    priority = local.waf_rule_priorities.rate_limit
 
-    override_action {
-      none {}
+    action {
+      block {}
     }
 
     statement {
       rate_based_statement {
         limit              = var.waf_rate_limit
         aggregate_key_type = "IP"
+
+        # Excluded paths don't count toward or get blocked by the rate limit
+        dynamic "scope_down_statement" {
+          for_each = local.waf_excluded_path_regex != null ? [1] : []
+          content {
+            not_statement {
+              statement {
+                regex_match_statement {
+                  regex_string = local.waf_excluded_path_regex
+
+                  field_to_match {
+                    uri_path {}
+                  }
+
+                  text_transformation {
+                    priority = 0
+                    type     = "NONE"
+                  }
+                }
+              }
+            }
+          }
+        }
       }
     }
 
@@ -315,7 +2629,7 @@ resource "aws_wafv2_web_acl" "api_gateway_waf" {
 
   rule {
     name     = "AWSManagedRulesCommonRuleSet"
-    priority = 2
+    priority = local.waf_rule_priorities.common_rule_set
 
     override_action {
       none {}
@@ -325,6 +2639,28 @@ resource "aws_wafv2_web_acl" "api_gateway_waf" {
       managed_rule_group_statement {
         name        = "AWSManagedRulesCommonRuleSet"
         vendor_name = "AWS"
+
+        dynamic "scope_down_statement" {
+          for_each = local.waf_excluded_path_regex != null ? [1] : []
+          content {
+            not_statement {
+              statement {
+                regex_match_statement {
+                  regex_string = local.waf_excluded_path_regex
+
+                  field_to_match {
+                    uri_path {}
+                  }
+
+                  text_transformation {
+                    priority = 0
+                    type     = "NONE"
+                  }
+                }
+              }
+            }
+          }
+        }
       }
     }
 
@@ -342,11 +2678,32 @@ resource "aws_wafv2_web_acl" "api_gateway_waf" {
   }
 
   tags = var.tags
+
+  lifecycle {
+    precondition {
+      condition     = var.waf_scope == "REGIONAL" || data.aws_region.current.name == "us-east-1"
+      error_message = "CLOUDFRONT-scoped web ACLs must be created in us-east-1. Deploy the module with a us-east-1 provider or use waf_scope = \"REGIONAL\"."
+    }
+  }
+}
+
+# IP set for blocked source ranges (optional)
+resource "aws_wafv2_ip_set" "blocked" {
+  count = var.enable_waf && length(var.waf_blocked_ip_ranges) > 0 ? 1 : 0
+
+  name               = "${var.name_prefix}-blocked-ips"
+  description        = "Source IP ranges blocked by the API Gateway WAF"
+  scope              = var.waf_scope
+  ip_address_version = "IPV4"
+  addresses          = var.waf_blocked_ip_ranges
+
+  tags = var.tags
 }
 
-# WAF Web ACL Association with API Gateway
+# WAF Web ACL Association with API Gateway - CloudFront-scoped web ACLs
+# attach to the caller's distribution instead
 resource "aws_wafv2_web_acl_association" "api_gateway" {
-  count = var.enable_waf ? 1 : 0
+  count = var.enable_waf && var.waf_scope == "REGIONAL" ? 1 : 0
 
   resource_arn = aws_api_gateway_stage.bedrock_stage.arn
   web_acl_arn  = aws_wafv2_web_acl.api_gateway_waf[0].arn
@@ -365,7 +2722,7 @@ resource "aws_api_gateway_usage_plan" "bedrock_usage_plan" {
   name  = var.usage_plan_name
 
   api_stages {
-    api_id = aws_api_gateway_rest_api.bedrock_api.id
+    api_id = local.rest_api_id
     stage  = aws_api_gateway_stage.bedrock_stage.stage_name
   }
 
@@ -383,4 +2740,83 @@ resource "aws_api_gateway_usage_plan_key" "bedrock_usage_plan_key" {
   key_id        = aws_api_gateway_api_key.bedrock_api_key[0].id
   key_type      = "API_KEY"
   usage_plan_id = aws_api_gateway_usage_plan.bedrock_usage_plan[0].id
-} 
\ No newline at end of file
+}
+
+# Post-apply smoke test through the public API (optional)
+data "http" "smoke_test" {
+  count = var.run_smoke_test ? 1 : 0
+
+  url    = "${aws_api_gateway_stage.bedrock_stage.invoke_url}/bedrock"
+  method = "POST"
+
+  request_headers = merge(
+    { "Content-Type" = "application/json" },
+    var.enable_api_key ? { "X-Api-Key" = aws_api_gateway_api_key.bedrock_api_key[0].value } : {}
+  )
+
+  request_body = jsonencode({
+    prompt     = var.smoke_test_prompt
+    max_tokens = 20
+  })
+
+  retry {
+    attempts     = 3
+    min_delay_ms = 5000
+  }
+
+  depends_on = [
+    aws_api_gateway_stage.bedrock_stage,
+    aws_lambda_permission.api_gateway,
+    aws_api_gateway_usage_plan_key.bedrock_usage_plan_key
+  ]
+
+  lifecycle {
+    postcondition {
+      condition     = self.status_code == 200 && try(jsondecode(self.response_body).content, "") != ""
+      error_message = "Smoke test failed: the API did not return a completion."
+    }
+  }
+}
+
+# Feature flags reported by the deployment_info and effective_config outputs
+locals {
+  features = {
+    waf                  = var.enable_waf
+    api_key              = var.enable_api_key
+    cors                 = var.enable_cors
+    monitoring           = var.enable_monitoring
+    vpc                  = var.vpc_subnet_ids != null
+    images               = var.enable_image_generation
+    conversation_history = var.enable_conversation_history
+    session_locking      = var.enable_session_locking
+    api_cache            = var.enable_api_cache
+    streaming            = var.enable_streaming
+    idempotency          = var.enable_idempotency
+    usage_accounting     = var.enable_usage_accounting
+    tenant_isolation     = var.enable_tenant_isolation
+    scheduled_prompts    = var.enable_scheduled_prompts
+    object_lambda        = var.enable_object_lambda
+    batch_inference      = var.enable_batch_inference
+    presigned_uploads    = var.enable_presigned_uploads
+    async_invocation     = var.enable_async_invocation
+    continuation         = var.enable_continuation
+    input_moderation     = var.enable_input_moderation
+    ensemble             = var.enable_ensemble
+    adaptive_throttling  = var.enable_adaptive_throttling
+    archival             = var.enable_archival
+    prompt_cache         = var.enable_bedrock_prompt_cache
+    prompt_templates     = var.prompt_template_source != null
+    stage_canary         = var.enable_stage_canary
+    region_fallback      = var.enable_profile_region_fallback
+    cost_allocation_tags = var.enable_cost_allocation_tags
+    provisioned_model    = var.enable_provisioned_throughput
+    request_buffering    = var.enable_request_buffering
+    custom_domain        = var.custom_domain_name != null
+    serve_stale          = var.serve_stale_on_error
+    xray_tracing         = var.enable_xray_tracing
+    knowledge_base       = var.bedrock_knowledge_base_id != null
+    guardrails           = var.enable_guardrails
+    iam_auth             = local.method_authorization == "AWS_IAM"
+    cognito_auth         = var.auth_type == "COGNITO"
+  }
+}
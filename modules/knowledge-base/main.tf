@@ -0,0 +1,261 @@
+# Bedrock knowledge base backed by an OpenSearch Serverless vector collection
+# with an S3 bucket as its data source. The caller configures the opensearch
+# provider against collection_endpoint so the vector index can be created.
+
+data "aws_region" "current" {}
+data "aws_caller_identity" "current" {}
+data "aws_partition" "current" {}
+
+# Data access policies take the role behind an assumed-role session, not the session
+data "aws_iam_session_context" "current" {
+  arn = data.aws_caller_identity.current.arn
+}
+
+locals {
+  collection_name     = "${var.name_prefix}-kb"
+  vector_index_name   = "bedrock-knowledge-base-index"
+  vector_field        = "bedrock-knowledge-base-vector"
+  text_field          = "AMAZON_BEDROCK_TEXT_CHUNK"
+  metadata_field      = "AMAZON_BEDROCK_METADATA"
+  embedding_model_arn = "arn:${data.aws_partition.current.partition}:bedrock:${data.aws_region.current.name}::foundation-model/${var.embedding_model_id}"
+}
+
+# Documents bucket
+resource "aws_s3_bucket" "documents" {
+  bucket = "${var.name_prefix}-kb-docs-${data.aws_caller_identity.current.account_id}"
+  tags   = var.tags
+}
+
+resource "aws_s3_bucket_public_access_block" "documents" {
+  bucket = aws_s3_bucket.documents.id
+
+  block_public_acls       = true
+  block_public_policy     = true
+  ignore_public_acls      = true
+  restrict_public_buckets = true
+}
+
+resource "aws_s3_bucket_server_side_encryption_configuration" "documents" {
+  bucket = aws_s3_bucket.documents.id
+
+  rule {
+    apply_server_side_encryption_by_default {
+      sse_algorithm = "AES256"
+    }
+  }
+}
+
+# IAM role the knowledge base uses to read documents, embed them and write vectors
+resource "aws_iam_role" "knowledge_base" {
+  name = "${var.name_prefix}-kb-role"
+
+  assume_role_policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Effect    = "Allow"
+        Principal = { Service = "bedrock.amazonaws.com" }
+        Action    = "sts:AssumeRole"
+        Condition = {
+          StringEquals = { "aws:SourceAccount" = data.aws_caller_identity.current.account_id }
+          ArnLike      = { "aws:SourceArn" = "arn:${data.aws_partition.current.partition}:bedrock:${data.aws_region.current.name}:${data.aws_caller_identity.current.account_id}:knowledge-base/*" }
+        }
+      }
+    ]
+  })
+
+  tags = var.tags
+}
+
+resource "aws_iam_role_policy" "knowledge_base" {
+  name = "${var.name_prefix}-kb-policy"
+  role = aws_iam_role.knowledge_base.id
+
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Effect   = "Allow"
+        Action   = ["bedrock:InvokeModel"]
+        Resource = local.embedding_model_arn
+      },
+      {
+        Effect   = "Allow"
+        Action   = ["aoss:APIAccessAll"]
+        Resource = aws_opensearchserverless_collection.knowledge_base.arn
+      },
+      {
+        Effect   = "Allow"
+        Action   = ["s3:ListBucket"]
+        Resource = aws_s3_bucket.documents.arn
+      },
+      {
+        Effect   = "Allow"
+        Action   = ["s3:GetObject"]
+        Resource = "${aws_s3_bucket.documents.arn}/*"
+      }
+    ]
+  })
+}
+
+# OpenSearch Serverless vector collection. The encryption policy must exist
+# before the collection is created.
+resource "aws_opensearchserverless_security_policy" "encryption" {
+  name = "${var.name_prefix}-kb-enc"
+  type = "encryption"
+  policy = jsonencode({
+    Rules       = [{ ResourceType = "collection", Resource = ["collection/${local.collection_name}"] }]
+    AWSOwnedKey = true
+  })
+}
+
+# The endpoint is public so Terraform can create the index; the data access
+# policy below still limits who can use it
+resource "aws_opensearchserverless_security_policy" "network" {
+  name = "${var.name_prefix}-kb-net"
+  type = "network"
+  policy = jsonencode([
+    {
+      Rules = [
+        { ResourceType = "collection", Resource = ["collection/${local.collection_name}"] },
+        { ResourceType = "dashboard", Resource = ["collection/${local.collection_name}"] }
+      ]
+      AllowFromPublic = true
+    }
+  ])
+}
+
+resource "aws_opensearchserverless_access_policy" "data" {
+  name = "${var.name_prefix}-kb-data"
+  type = "data"
+  policy = jsonencode([
+    {
+      Rules = [
+        {
+          ResourceType = "collection"
+          Resource     = ["collection/${local.collection_name}"]
+          Permission   = ["aoss:DescribeCollectionItems", "aoss:CreateCollectionItems", "aoss:UpdateCollectionItems"]
+        },
+        {
+          ResourceType = "index"
+          Resource     = ["index/${local.collection_name}/*"]
+          Permission   = ["aoss:DescribeIndex", "aoss:CreateIndex", "aoss:UpdateIndex", "aoss:DeleteIndex", "aoss:ReadDocument", "aoss:WriteDocument"]
+        }
+      ]
+      Principal = distinct(concat(
+        [aws_iam_role.knowledge_base.arn, data.aws_iam_session_context.current.issuer_arn],
+        var.index_principal_arns
+      ))
+    }
+  ])
+}
+
+resource "aws_opensearchserverless_collection" "knowledge_base" {
+  name = local.collection_name
+  type = "VECTORSEARCH"
+  tags = var.tags
+
+  depends_on = [
+    aws_opensearchserverless_security_policy.encryption,
+    aws_opensearchserverless_security_policy.network
+  ]
+}
+
+# Data access policies take up to a minute to apply, and index creation fails until they do
+resource "time_sleep" "access_policy" {
+  create_duration = "60s"
+
+  depends_on = [
+    aws_opensearchserverless_access_policy.data,
+    aws_opensearchserverless_collection.knowledge_base
+  ]
+}
+
+resource "opensearch_index" "vectors" {
+  name                           = local.vector_index_name
+  number_of_shards               = "2"
+  number_of_replicas             = "0"
+  index_knn                      = true
+  index_knn_algo_param_ef_search = "512"
+  force_destroy                  = true
+
+  mappings = jsonencode({
+    properties = {
+      (local.vector_field) = {
+        type      = "knn_vector"
+        dimension = var.embedding_dimensions
+        method = {
+          name       = "hnsw"
+          engine     = "faiss"
+          space_type = "l2"
+          parameters = { m = 16, ef_construction = 512 }
+        }
+      }
+      (local.text_field)     = { type = "text", index = true }
+      (local.metadata_field) = { type = "text", index = false }
+    }
+  })
+
+  # Bedrock adds fields of its own once documents are ingested
+  lifecycle {
+    ignore_changes = [mappings]
+  }
+
+  depends_on = [time_sleep.access_policy]
+}
+
+# Knowledge base
+resource "aws_bedrockagent_knowledge_base" "this" {
+  name     = "${var.name_prefix}-kb"
+  role_arn = aws_iam_role.knowledge_base.arn
+  tags     = var.tags
+
+  knowledge_base_configuration {
+    type = "VECTOR"
+    vector_knowledge_base_configuration {
+      embedding_model_arn = local.embedding_model_arn
+    }
+  }
+
+  storage_configuration {
+    type = "OPENSEARCH_SERVERLESS"
+    opensearch_serverless_configuration {
+      collection_arn    = aws_opensearchserverless_collection.knowledge_base.arn
+      vector_index_name = opensearch_index.vectors.name
+      field_mapping {
+        vector_field   = local.vector_field
+        text_field     = local.text_field
+        metadata_field = local.metadata_field
+      }
+    }
+  }
+
+  depends_on = [aws_iam_role_policy.knowledge_base]
+}
+
+resource "aws_bedrockagent_data_source" "documents" {
+  name              = "${var.name_prefix}-kb-documents"
+  knowledge_base_id = aws_bedrockagent_knowledge_base.this.id
+
+  # Vectors are left in place on destroy; the collection is deleted right
+  # after, and deleting them first fails once the index is gone
+  data_deletion_policy = "RETAIN"
+
+  data_source_configuration {
+    type = "S3"
+    s3_configuration {
+      bucket_arn         = aws_s3_bucket.documents.arn
+      inclusion_prefixes = var.document_prefix != "" ? [var.document_prefix] : null
+    }
+  }
+
+  vector_ingestion_configuration {
+    chunking_configuration {
+      chunking_strategy = "FIXED_SIZE"
+      fixed_size_chunking_configuration {
+        max_tokens         = var.chunk_max_tokens
+        overlap_percentage = var.chunk_overlap_percentage
+      }
+    }
+  }
+}
@@ -0,0 +1,29 @@
+output "knowledge_base_id" {
+  description = "ID of the knowledge base, for the root module's bedrock_knowledge_base_id"
+  value       = aws_bedrockagent_knowledge_base.this.id
+}
+
+output "knowledge_base_arn" {
+  description = "ARN of the knowledge base"
+  value       = aws_bedrockagent_knowledge_base.this.arn
+}
+
+output "data_source_id" {
+  description = "ID of the S3 data source, for starting ingestion jobs"
+  value       = aws_bedrockagent_data_source.documents.data_source_id
+}
+
+output "documents_bucket_name" {
+  description = "S3 bucket whose documents are ingested"
+  value       = aws_s3_bucket.documents.id
+}
+
+output "collection_endpoint" {
+  description = "OpenSearch Serverless collection endpoint, for configuring the opensearch provider"
+  value       = aws_opensearchserverless_collection.knowledge_base.collection_endpoint
+}
+
+output "collection_arn" {
+  description = "ARN of the OpenSearch Serverless collection"
+  value       = aws_opensearchserverless_collection.knowledge_base.arn
+}
@@ -0,0 +1,61 @@
+variable "name_prefix" {
+  description = "Prefix for resource names. OpenSearch Serverless names must be lowercase, so this is too."
+  type        = string
+
+  validation {
+    condition     = can(regex("^[a-z][a-z0-9-]{1,24}$", var.name_prefix))
+    error_message = "Name prefix must be 2 to 25 lowercase letters, numbers or hyphens, starting with a letter."
+  }
+}
+
+variable "embedding_model_id" {
+  description = "Bedrock embedding model that vectorizes the documents"
+  type        = string
+  default     = "amazon.titan-embed-text-v2:0"
+}
+
+variable "embedding_dimensions" {
+  description = "Vector size embedding_model_id produces"
+  type        = number
+  default     = 1024
+}
+
+variable "document_prefix" {
+  description = "Key prefix in the documents bucket that is ingested. Empty ingests the whole bucket."
+  type        = string
+  default     = ""
+}
+
+variable "chunk_max_tokens" {
+  description = "Most tokens in each chunk a document is split into"
+  type        = number
+  default     = 300
+
+  validation {
+    condition     = var.chunk_max_tokens >= 20 && var.chunk_max_tokens <= 8192
+    error_message = "Chunk max tokens must be between 20 and 8192."
+  }
+}
+
+variable "chunk_overlap_percentage" {
+  description = "Share of each chunk repeated at the start of the next"
+  type        = number
+  default     = 20
+
+  validation {
+    condition     = var.chunk_overlap_percentage >= 1 && var.chunk_overlap_percentage <= 99
+    error_message = "Chunk overlap percentage must be between 1 and 99."
+  }
+}
+
+variable "index_principal_arns" {
+  description = "IAM principals besides the knowledge base role allowed into the collection. The identity running Terraform is always added so it can create the vector index."
+  type        = list(string)
+  default     = []
+}
+
+variable "tags" {
+  description = "Tags to apply to all resources"
+  type        = map(string)
+  default     = {}
+}
@@ -0,0 +1,56 @@
+import json
+import logging
+import os
+import re
+import urllib.request
+import boto3
+from typing import Dict, Any
+
+# Setup logging from environment variable
+logger = logging.getLogger()
+logger.setLevel(os.environ.get('LOG_LEVEL', 'INFO'))
+
+s3_client = boto3.client('s3')
+
+# Patterns redacted from completions on read
+REDACTION_PATTERNS = [
+    re.compile(r'[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}'),
+    re.compile(r'\+?\d[\d\s().-]{7,}\d')
+]
+REDACTION_TEXT = '[REDACTED]'
+
+def redact(text: str) -> str:
+    """Replace every match of the redaction patterns"""
+    for pattern in REDACTION_PATTERNS:
+        text = pattern.sub(REDACTION_TEXT, text)
+    return text
+
+def transform(original: bytes) -> bytes:
+    """Redact the content of a stored completion, or the whole object if it isn't one"""
+    try:
+        record = json.loads(original)
+    except ValueError:
+        return redact(original.decode('utf-8', errors='replace')).encode('utf-8')
+
+    if isinstance(record, dict) and isinstance(record.get('content'), str):
+        record['content'] = redact(record['content'])
+        record['redacted'] = True
+        return json.dumps(record, ensure_ascii=False).encode('utf-8')
+
+    return redact(json.dumps(record, ensure_ascii=False)).encode('utf-8')
+
+def handler(event: Dict[str, Any], context: Any) -> Dict[str, Any]:
+    """S3 Object Lambda entry point - transforms GetObject responses"""
+    object_context = event['getObjectContext']
+
+    # The presigned URL reads the original object through the supporting access point
+    with urllib.request.urlopen(object_context['inputS3Url']) as response:
+        original = response.read()
+
+    s3_client.write_get_object_response(
+        Body=transform(original),
+        RequestRoute=object_context['outputRoute'],
+        RequestToken=object_context['outputToken']
+    )
+
+    return {'statusCode': 200}
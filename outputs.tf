@@ -4,9 +4,222 @@ output "api_gateway_url" {
   value       = "${aws_api_gateway_stage.bedrock_stage.invoke_url}/bedrock"
 }
 
+output "model_aliases" {
+  description = "Model alias map resolved by the handler"
+  value       = var.model_aliases
+}
+
+output "allowed_model_ids" {
+  description = "Model IDs requests may use in this environment; empty allows any"
+  value       = local.allowed_model_ids
+}
+
+output "per_model_concurrency" {
+  description = "Per-model concurrency limits enforced by the handler"
+  value       = var.per_model_concurrency
+}
+
+output "usage_table_name" {
+  description = "DynamoDB table of per-tenant monthly token usage (if usage accounting enabled)"
+  value       = var.enable_usage_accounting ? aws_dynamodb_table.usage[0].name : null
+}
+
+output "idempotency_table_name" {
+  description = "DynamoDB table storing replayable responses (if idempotency enabled)"
+  value       = var.enable_idempotency ? aws_dynamodb_table.idempotency[0].name : null
+}
+
+output "stale_responses_table_name" {
+  description = "DynamoDB table storing last-known-good responses (if serve_stale_on_error enabled)"
+  value       = var.serve_stale_on_error ? aws_dynamodb_table.stale_responses[0].name : null
+}
+
+output "completions_bucket_name" {
+  description = "S3 bucket storing completions (if Object Lambda enabled)"
+  value       = var.enable_object_lambda ? aws_s3_bucket.completions[0].id : null
+}
+
+output "object_lambda_access_point_arn" {
+  description = "S3 Object Lambda access point that returns transformed completions (if Object Lambda enabled)"
+  value       = var.enable_object_lambda ? aws_s3control_object_lambda_access_point.completions[0].arn : null
+}
+
+output "handler_version" {
+  description = "Build identifier of the deployed handler, also returned by GET /health"
+  value       = local.handler_version
+}
+
+output "custom_model_arn" {
+  description = "ARN of the custom model served as the default model (if custom_model_arn set)"
+  value       = var.custom_model_arn
+}
+
+output "provisioned_throughput_arn" {
+  description = "ARN of the provisioned throughput the default model is invoked through (if enable_provisioned_throughput)"
+  value       = var.enable_provisioned_throughput ? aws_bedrock_provisioned_model_throughput.default_model[0].provisioned_model_arn : null
+}
+
+output "application_inference_profile_arn" {
+  description = "ARN of the tagged application inference profile the default model is invoked through (if enable_cost_allocation_tags)"
+  value       = var.enable_cost_allocation_tags ? aws_bedrock_inference_profile.cost_allocation[0].arn : null
+}
+
+output "context_window_tokens" {
+  description = "Context window size in tokens of the default model, or null if the module doesn't know it"
+  value       = lookup(local.model_context_windows, local.default_model_id, null)
+}
+
+output "api_style" {
+  description = "Bedrock runtime API the handler calls: invoke or converse"
+  value       = var.api_style
+}
+
+output "model_tpm_quota" {
+  description = "On-demand tokens-per-minute quota for the default model (if enable_quota_check enabled)"
+  value       = one(data.aws_servicequotas_service_quota.model_tpm[*].value)
+}
+
+output "model_rpm_quota" {
+  description = "On-demand requests-per-minute quota for the default model (if enable_quota_check enabled)"
+  value       = one(data.aws_servicequotas_service_quota.model_rpm[*].value)
+}
+
+output "health_url" {
+  description = "Unauthenticated health check endpoint URL"
+  value       = "${aws_api_gateway_stage.bedrock_stage.invoke_url}/health"
+}
+
+output "custom_domain_url" {
+  description = "API endpoint URL for Bedrock requests on the custom domain (if configured)"
+  value       = var.custom_domain_name != null ? "https://${aws_api_gateway_domain_name.custom[0].domain_name}/bedrock" : null
+}
+
+output "custom_domain_target" {
+  description = "Hostname to point the custom domain's DNS record at, with its hosted zone ID for Route 53 aliases (if configured)"
+  value = var.custom_domain_name != null ? {
+    domain_name    = coalesce(aws_api_gateway_domain_name.custom[0].regional_domain_name, aws_api_gateway_domain_name.custom[0].cloudfront_domain_name)
+    hosted_zone_id = coalesce(aws_api_gateway_domain_name.custom[0].regional_zone_id, aws_api_gateway_domain_name.custom[0].cloudfront_zone_id)
+  } : null
+}
+
+output "minimum_tls_version" {
+  description = "Minimum TLS version the custom domain accepts (if configured)"
+  value       = var.custom_domain_name != null ? aws_api_gateway_domain_name.custom[0].security_policy : null
+}
+
+output "async_result_url" {
+  description = "Async result endpoint URL; append the job_id (if async invocation enabled)"
+  value       = var.enable_async_invocation ? "${aws_api_gateway_stage.bedrock_stage.invoke_url}/result" : null
+}
+
+output "buffer_queue_url" {
+  description = "SQS queue holding buffered requests until they are drained (if request buffering enabled)"
+  value       = var.enable_request_buffering ? aws_sqs_queue.buffered_requests[0].url : null
+}
+
+output "continuation_table_name" {
+  description = "DynamoDB table holding the state of truncated completions (if continuation enabled)"
+  value       = var.enable_continuation ? aws_dynamodb_table.continuations[0].name : null
+}
+
+output "async_jobs_table_name" {
+  description = "DynamoDB table holding async job status and results (if async invocation enabled)"
+  value       = var.enable_async_invocation ? aws_dynamodb_table.async_jobs[0].name : null
+}
+
+output "async_dlq_url" {
+  description = "Dead-letter queue for async prompts that exhausted their retries (if async invocation enabled)"
+  value       = var.enable_async_invocation ? aws_sqs_queue.async_requests_dlq[0].url : null
+}
+
+output "agent_api_url" {
+  description = "Bedrock agent endpoint URL (if bedrock_agent_id set)"
+  value       = var.bedrock_agent_id != null ? "${aws_api_gateway_stage.bedrock_stage.invoke_url}/agent" : null
+}
+
+output "guardrail_id" {
+  description = "ID of the Bedrock guardrail applied to completions (if guardrails enabled)"
+  value       = var.enable_guardrails ? aws_bedrock_guardrail.bedrock[0].guardrail_id : null
+}
+
+output "guardrail_version" {
+  description = "Guardrail version the handler applies (if guardrails enabled)"
+  value       = var.enable_guardrails ? aws_bedrock_guardrail_version.bedrock[0].version : null
+}
+
+output "retrieve_api_url" {
+  description = "Knowledge base retrieve-and-generate endpoint URL (if bedrock_knowledge_base_id set)"
+  value       = var.bedrock_knowledge_base_id != null ? "${aws_api_gateway_stage.bedrock_stage.invoke_url}/retrieve" : null
+}
+
+output "upload_url_api_url" {
+  description = "Endpoint returning presigned image upload URLs (if presigned uploads enabled)"
+  value       = var.enable_presigned_uploads ? "${aws_api_gateway_stage.bedrock_stage.invoke_url}/upload-url" : null
+}
+
+output "upload_bucket_name" {
+  description = "S3 bucket receiving presigned image uploads (if presigned uploads enabled)"
+  value       = var.enable_presigned_uploads ? aws_s3_bucket.uploads[0].id : null
+}
+
+output "batch_api_url" {
+  description = "Batch inference submission endpoint URL (if batch inference enabled)"
+  value       = var.enable_batch_inference ? "${aws_api_gateway_stage.bedrock_stage.invoke_url}/batch" : null
+}
+
+output "archive_bucket_name" {
+  description = "S3 bucket holding archived requests under <yyyy>/<mm>/<dd>/<model_id>/ (if archival enabled)"
+  value       = var.enable_archival ? aws_s3_bucket.archive[0].id : null
+}
+
+output "batch_bucket_name" {
+  description = "S3 bucket for batch inputs (input/) and results (output/) (if batch inference enabled)"
+  value       = var.enable_batch_inference ? aws_s3_bucket.batch[0].id : null
+}
+
+output "batch_queue_url" {
+  description = "SQS queue holding batch submissions over max_concurrent_batch_jobs (if batch inference enabled)"
+  value       = var.enable_batch_inference ? aws_sqs_queue.batch_submissions[0].url : null
+}
+
+output "images_api_url" {
+  description = "Image generation endpoint URL (if image generation enabled)"
+  value       = var.enable_image_generation ? "${aws_api_gateway_stage.bedrock_stage.invoke_url}/images" : null
+}
+
+output "api_cache" {
+  description = "API Gateway cache cluster configuration"
+  value = {
+    enabled          = aws_api_gateway_stage.bedrock_stage.cache_cluster_enabled
+    cluster_size     = aws_api_gateway_stage.bedrock_stage.cache_cluster_size
+    ttl_seconds      = var.enable_api_cache ? var.cache_ttl_seconds : 0
+    cache_key_header = var.enable_api_cache ? local.cache_key_header : null
+  }
+}
+
+output "api_canary" {
+  description = "Stage canary deployments and traffic split (if enable_stage_canary enabled)"
+  value = var.enable_stage_canary ? {
+    stable_deployment_id = aws_api_gateway_deployment.stable[0].id
+    stable_version       = var.canary_stable_version
+    canary_deployment_id = aws_api_gateway_deployment.bedrock_deployment.id
+    percent_traffic      = var.canary_percent_traffic
+  } : null
+}
+
+output "api_route_path" {
+  description = "Path of the Bedrock route on the REST API the module created or attached to"
+  value       = aws_api_gateway_resource.bedrock_resource.path
+}
+
 output "api_gateway_rest_api_id" {
   description = "API Gateway REST API identifier"
-  value       = aws_api_gateway_rest_api.bedrock_api.id
+  value       = local.rest_api_id
+}
+
+output "api_resource_policy" {
+  description = "Effective API Gateway resource policy document, or null when no allowlist is set"
+  value       = local.api_resource_policy
 }
 
 # Lambda function outputs
@@ -20,31 +233,125 @@ output "lambda_function_arn" {
   value       = aws_lambda_function.bedrock_lambda.arn
 }
 
+output "lambda_published_version_arn" {
+  description = "Qualified ARN of the latest published Lambda version (SnapStart applies to this version)"
+  value       = aws_lambda_function.bedrock_lambda.qualified_arn
+}
+
 output "lambda_role_arn" {
   description = "Lambda execution role ARN"
   value       = aws_iam_role.lambda_role.arn
 }
 
+# Conversation store outputs
+output "conversation_table_name" {
+  description = "DynamoDB table storing conversation history (if conversation history enabled)"
+  value       = var.enable_conversation_history ? aws_dynamodb_table.conversations[0].name : null
+}
+
+output "conversation_summarization" {
+  description = "Conversation length limit and the model that summarizes older turns"
+  value = {
+    max_turns              = var.max_conversation_turns
+    summarization_model_id = var.max_conversation_turns > 0 ? var.summarization_model_id : null
+  }
+}
+
+output "conversation_kms_key_arn" {
+  description = "KMS key used for conversation field encryption (if enabled)"
+  value       = local.conversation_kms_key_arn != "" ? local.conversation_kms_key_arn : null
+}
+
+# Scheduled prompt outputs
+output "scheduled_prompt_rule_names" {
+  description = "EventBridge rule names for scheduled prompts, keyed by prompt name"
+  value       = { for name, rule in aws_cloudwatch_event_rule.scheduled_prompts : name => rule.name }
+}
+
 # Monitoring outputs
 output "cloudwatch_log_group_name" {
-  description = "CloudWatch log group for Lambda"
-  value       = aws_cloudwatch_log_group.lambda_logs.name
+  description = "CloudWatch log group the Lambda writes to, whether created by the module or existing"
+  value       = local.lambda_log_group_name
+}
+
+output "log_subscription_filter_name" {
+  description = "Name of the subscription filter on the Lambda log group (if log_subscription_destination_arn set)"
+  value       = one(aws_cloudwatch_log_subscription_filter.lambda_logs[*].name)
+}
+
+output "log_group_prefix" {
+  description = "Path prefix of the log groups created by the module"
+  value       = var.log_group_prefix
+}
+
+output "metric_namespace" {
+  description = "CloudWatch namespace of the handler's custom metrics"
+  value       = var.metric_namespace
+}
+
+output "log_sampling" {
+  description = "Content logging configuration applied by the handler"
+  value = {
+    log_content   = var.log_content
+    sampling_rate = var.log_sampling_rate
+    redact_pii    = var.log_redact_pii
+  }
+}
+
+output "cost_killswitch_function_arn" {
+  description = "ARN of the Lambda that pauses the API when the cost killswitch alarm fires (if enabled)"
+  value       = var.enable_cost_killswitch ? aws_lambda_function.cost_killswitch[0].arn : null
 }
 
 output "cloudwatch_alarm_names" {
   description = "CloudWatch alarm names (if monitoring enabled)"
   value = var.enable_monitoring ? [
     aws_cloudwatch_metric_alarm.lambda_errors[0].alarm_name,
-    aws_cloudwatch_metric_alarm.lambda_duration[0].alarm_name
+    aws_cloudwatch_metric_alarm.lambda_duration[0].alarm_name,
+    aws_cloudwatch_metric_alarm.bedrock_throttles[0].alarm_name
   ] : []
 }
 
+output "cloudwatch_dashboard_name" {
+  description = "CloudWatch dashboard of the function, API and handler metrics (if monitoring enabled)"
+  value       = var.enable_monitoring ? aws_cloudwatch_dashboard.bedrock[0].dashboard_name : null
+}
+
 # Security outputs
 output "waf_web_acl_arn" {
   description = "WAF Web ACL ARN (if WAF enabled)"
   value       = var.enable_waf ? aws_wafv2_web_acl.api_gateway_waf[0].arn : null
 }
 
+output "waf_web_acl_id" {
+  description = "WAF Web ACL ID (if WAF enabled)"
+  value       = var.enable_waf ? aws_wafv2_web_acl.api_gateway_waf[0].id : null
+}
+
+output "waf_excluded_paths" {
+  description = "Route paths exempt from the WAF rate limit and managed rules (if WAF enabled)"
+  value       = var.enable_waf ? var.waf_excluded_paths : []
+}
+
+output "waf_rules" {
+  description = "WAF rules in evaluation order with their priority and action (if WAF enabled)"
+  value       = local.waf_rules
+}
+
+output "granted_iam_actions" {
+  description = "Sorted unique list of IAM actions granted by the module's policies, on the Lambda role and each feature's own role, for audit diffing"
+  value = sort(distinct(flatten([
+    for statement in concat(
+      local.bedrock_policy_statements,
+      local.log_subscription_policy_statements,
+      local.object_transform_policy_statements,
+      local.batch_policy_statements,
+      local.archive_firehose_policy_statements,
+      local.cost_killswitch_policy_statements
+    ) : statement.Action
+  ])))
+}
+
 output "api_key_id" {
   description = "API key identifier (if API key enabled)"
   value       = var.enable_api_key ? aws_api_gateway_api_key.bedrock_api_key[0].id : null
@@ -54,4 +361,84 @@ output "api_key_value" {
   description = "API key value (if API key enabled)"
   value       = var.enable_api_key ? aws_api_gateway_api_key.bedrock_api_key[0].value : null
   sensitive   = true
-} 
\ No newline at end of file
+} 
+# Aggregated outputs for downstream stacks
+output "deployment_info" {
+  description = "Aggregated deployment details and enabled feature flags for downstream consumption"
+  value = {
+    api_url              = "${aws_api_gateway_stage.bedrock_stage.invoke_url}/bedrock"
+    api_id               = local.rest_api_id
+    api_stage_name       = aws_api_gateway_stage.bedrock_stage.stage_name
+    lambda_function_name = aws_lambda_function.bedrock_lambda.function_name
+    lambda_function_arn  = aws_lambda_function.bedrock_lambda.arn
+    lambda_role_arn      = aws_iam_role.lambda_role.arn
+    log_group_name       = local.lambda_log_group_name
+    bedrock_model_id     = local.default_model_id
+    handler_version      = local.handler_version
+    features             = local.features
+  }
+}
+
+output "effective_config" {
+  description = "Settings in force after defaults, environment overrides and feature flags are resolved, for support and debugging. Secrets and sensitive inputs are left out."
+  value = {
+    environment = var.environment
+    region      = data.aws_region.current.name
+    model = {
+      default_model_id              = local.default_model_id
+      custom_model_arn              = var.custom_model_arn
+      api_style                     = var.api_style
+      unsupported_param_mode        = var.unsupported_param_mode
+      max_tokens                    = var.max_tokens
+      temperature                   = var.temperature
+      top_p                         = var.top_p
+      model_aliases                 = var.model_aliases
+      model_fallback_chain          = var.model_fallback_chain
+      profile_fallback_regions      = var.enable_profile_region_fallback ? var.profile_fallback_regions : null
+      allowed_model_ids             = local.allowed_model_ids
+      deprecated_model_replacements = var.deprecated_model_replacements
+      response_json_schema          = var.response_json_schema
+      post_processors               = var.post_processors
+      prompt_template_source        = var.prompt_template_source
+      template_refresh_seconds      = var.prompt_template_source != null ? var.template_refresh_seconds : null
+    }
+    limits = {
+      lambda_timeout               = var.lambda_timeout
+      lambda_memory_size           = var.lambda_memory_size
+      max_request_timeout_ms       = var.max_request_timeout_ms
+      max_response_bytes           = var.max_response_bytes
+      per_model_concurrency        = var.per_model_concurrency
+      adaptive_throttling_min_rate = var.enable_adaptive_throttling ? var.adaptive_throttling_min_rate : null
+      adaptive_throttling_max_rate = var.enable_adaptive_throttling ? var.adaptive_throttling_max_rate : null
+      sync_max_tokens_threshold    = var.sync_max_tokens_threshold
+      drain_rate_per_second        = var.enable_request_buffering ? var.drain_rate_per_second : null
+      max_stale_seconds            = var.serve_stale_on_error ? var.max_stale_seconds : null
+      api_rate_limit               = var.enable_api_key ? var.rate_limit : null
+      api_burst_limit              = var.enable_api_key ? var.burst_limit : null
+      waf_rate_limit               = var.enable_waf ? var.waf_rate_limit : null
+      waf_scope                    = var.enable_waf ? var.waf_scope : null
+      minimum_compression_size     = local.api_minimum_compression_size
+    }
+    logging = {
+      log_level          = var.log_level
+      log_content        = var.log_content
+      log_sampling_rate  = var.log_sampling_rate
+      log_redact_pii     = var.log_redact_pii
+      log_retention_days = var.log_retention_days
+      error_verbosity    = var.error_verbosity
+      metric_namespace   = var.metric_namespace
+      metric_dimensions  = var.metric_dimensions
+    }
+    features = local.features
+  }
+}
+
+output "smoke_test_result" {
+  description = "Result of the post-apply smoke test (if run_smoke_test enabled)"
+  value = var.run_smoke_test ? {
+    status_code = data.http.smoke_test[0].status_code
+    success     = try(jsondecode(data.http.smoke_test[0].response_body).success, false)
+    model_id    = try(jsondecode(data.http.smoke_test[0].response_body).model_id, null)
+    content     = try(jsondecode(data.http.smoke_test[0].response_body).content, null)
+  } : null
+}
@@ -0,0 +1,92 @@
+package awsvalidate
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/accessanalyzer"
+	"github.com/aws/aws-sdk-go-v2/service/accessanalyzer/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mergePolicyDocuments combines documents into one, in name order, so the
+// role's policies can be checked together. A Statement may be a single
+// object rather than a list.
+func mergePolicyDocuments(documents map[string]string) (string, error) {
+	names := make([]string, 0, len(documents))
+	for name := range documents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	statements := []json.RawMessage{}
+	for _, name := range names {
+		var parsed struct{ Statement json.RawMessage }
+		if err := json.Unmarshal([]byte(documents[name]), &parsed); err != nil {
+			return "", err
+		}
+		var list []json.RawMessage
+		if err := json.Unmarshal(parsed.Statement, &list); err != nil {
+			list = []json.RawMessage{parsed.Statement}
+		}
+		statements = append(statements, list...)
+	}
+
+	merged, err := json.Marshal(map[string]interface{}{
+		"Version":   "2012-10-17",
+		"Statement": statements,
+	})
+	return string(merged), err
+}
+
+// AssertNoPolicyFindings runs IAM Access Analyzer policy validation on each
+// of the role's policies and fails on errors and security warnings.
+// Suggestions and general warnings are logged.
+func (v *Validator) AssertNoPolicyFindings(t *testing.T, roleARN string) {
+	for name, document := range v.RolePolicyDocuments(t, roleARN) {
+		input := &accessanalyzer.ValidatePolicyInput{
+			PolicyDocument: aws.String(document),
+			PolicyType:     types.PolicyTypeIdentityPolicy,
+		}
+		for {
+			page, err := v.accessanalyzer.ValidatePolicy(context.Background(), input)
+			require.NoError(t, err, "policy %s", name)
+			for _, finding := range page.Findings {
+				switch finding.FindingType {
+				case types.ValidatePolicyFindingTypeError, types.ValidatePolicyFindingTypeSecurityWarning:
+					assert.Failf(t, "Access Analyzer finding", "policy %s: %s %s: %s", name, finding.FindingType, aws.ToString(finding.IssueCode), aws.ToString(finding.FindingDetails))
+				default:
+					t.Logf("policy %s: %s %s: %s", name, finding.FindingType, aws.ToString(finding.IssueCode), aws.ToString(finding.FindingDetails))
+				}
+			}
+			if page.NextToken == nil {
+				break
+			}
+			input.NextToken = page.NextToken
+		}
+	}
+}
+
+// AssertNoNewAccess checks with Access Analyzer that the role's policies,
+// taken together, grant nothing the reference policy document doesn't.
+func (v *Validator) AssertNoNewAccess(t *testing.T, roleARN string, reference string) {
+	merged, err := mergePolicyDocuments(v.RolePolicyDocuments(t, roleARN))
+	require.NoError(t, err)
+
+	check, err := v.accessanalyzer.CheckNoNewAccess(context.Background(), &accessanalyzer.CheckNoNewAccessInput{
+		NewPolicyDocument:      aws.String(merged),
+		ExistingPolicyDocument: aws.String(reference),
+		PolicyType:             types.AccessCheckPolicyTypeIdentityPolicy,
+	})
+	require.NoError(t, err)
+
+	var reasons []string
+	for _, reason := range check.Reasons {
+		reasons = append(reasons, aws.ToString(reason.Description))
+	}
+	assert.Equal(t, types.CheckNoNewAccessResultPass, check.Result, "%s grants access beyond the reference policy: %s %v", roleARN, aws.ToString(check.Message), reasons)
+}
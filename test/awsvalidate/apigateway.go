@@ -0,0 +1,34 @@
+package awsvalidate
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/apigateway"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// AssertStageThrottling checks the throttle of the usage plan attached to the
+// stage. The module throttles through its usage plan, which only exists with
+// enable_api_key.
+func (v *Validator) AssertStageThrottling(t *testing.T, apiID, stageName string, rateLimit float64, burstLimit int32) {
+	out, err := v.apigateway.GetUsagePlans(context.Background(), &apigateway.GetUsagePlansInput{
+		Limit: aws.Int32(500),
+	})
+	require.NoError(t, err)
+
+	for _, plan := range out.Items {
+		for _, stage := range plan.ApiStages {
+			if aws.ToString(stage.ApiId) != apiID || aws.ToString(stage.Stage) != stageName {
+				continue
+			}
+			require.NotNil(t, plan.Throttle, "usage plan %s should have a throttle", aws.ToString(plan.Name))
+			assert.Equal(t, rateLimit, plan.Throttle.RateLimit, "rate limit of %s", aws.ToString(plan.Name))
+			assert.Equal(t, burstLimit, plan.Throttle.BurstLimit, "burst limit of %s", aws.ToString(plan.Name))
+			return
+		}
+	}
+	t.Fatalf("no usage plan is attached to stage %s of API %s", stageName, apiID)
+}
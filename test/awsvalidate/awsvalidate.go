@@ -0,0 +1,51 @@
+// Package awsvalidate checks deployed resources against the configuration the
+// root module was applied with. Outputs only prove Terraform recorded a
+// resource; these assertions read the resource back through the AWS APIs.
+package awsvalidate
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/accessanalyzer"
+	"github.com/aws/aws-sdk-go-v2/service/apigateway"
+	"github.com/aws/aws-sdk-go-v2/service/bedrock"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi"
+	"github.com/aws/aws-sdk-go-v2/service/wafv2"
+	"github.com/aws/aws-sdk-go-v2/service/xray"
+)
+
+// Validator holds one client per service a deployment touches.
+type Validator struct {
+	region         string
+	lambda         *lambda.Client
+	apigateway     *apigateway.Client
+	wafv2          *wafv2.Client
+	logs           *cloudwatchlogs.Client
+	iam            *iam.Client
+	accessanalyzer *accessanalyzer.Client
+	bedrock        *bedrock.Client
+	ec2            *ec2.Client
+	tagging        *resourcegroupstaggingapi.Client
+	xray           *xray.Client
+}
+
+// New returns a Validator whose clients share cfg, which should be in the
+// region the module was applied in.
+func New(cfg aws.Config) *Validator {
+	return &Validator{
+		region:         cfg.Region,
+		lambda:         lambda.NewFromConfig(cfg),
+		apigateway:     apigateway.NewFromConfig(cfg),
+		wafv2:          wafv2.NewFromConfig(cfg),
+		logs:           cloudwatchlogs.NewFromConfig(cfg),
+		iam:            iam.NewFromConfig(cfg),
+		accessanalyzer: accessanalyzer.NewFromConfig(cfg),
+		bedrock:        bedrock.NewFromConfig(cfg),
+		ec2:            ec2.NewFromConfig(cfg),
+		tagging:        resourcegroupstaggingapi.NewFromConfig(cfg),
+		xray:           xray.NewFromConfig(cfg),
+	}
+}
@@ -0,0 +1,166 @@
+package awsvalidate
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/wafv2/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePolicyDocument(t *testing.T) {
+	t.Parallel()
+
+	// IAM returns documents URL-encoded, with single values as plain strings
+	document := url.QueryEscape(`{
+		"Version": "2012-10-17",
+		"Statement": [
+			{"Effect": "Allow", "Action": ["bedrock:InvokeModel", "bedrock:InvokeModelWithResponseStream"], "Resource": ["arn:aws:bedrock:us-east-1::foundation-model/amazon.titan-text-express-v1"]},
+			{"Effect": "Allow", "Action": "logs:PutLogEvents", "Resource": "arn:aws:logs:us-east-1:123456789012:*"}
+		]
+	}`)
+
+	statements, err := parsePolicyDocument(document)
+	require.NoError(t, err)
+	assert.Equal(t, []Statement{
+		{"Allow", []string{"bedrock:InvokeModel", "bedrock:InvokeModelWithResponseStream"}, []string{"arn:aws:bedrock:us-east-1::foundation-model/amazon.titan-text-express-v1"}},
+		{"Allow", []string{"logs:PutLogEvents"}, []string{"arn:aws:logs:us-east-1:123456789012:*"}},
+	}, statements)
+}
+
+func TestWebACLIdentity(t *testing.T) {
+	t.Parallel()
+
+	name, id, scope, err := webACLIdentity("arn:aws:wafv2:us-east-1:123456789012:regional/webacl/bedrock-api-gateway-waf/a1b2c3d4")
+	require.NoError(t, err)
+	assert.Equal(t, "bedrock-api-gateway-waf", name)
+	assert.Equal(t, "a1b2c3d4", id)
+	assert.Equal(t, types.ScopeRegional, scope)
+
+	_, _, scope, err = webACLIdentity("arn:aws:wafv2:us-east-1:123456789012:global/webacl/edge/e5f6")
+	require.NoError(t, err)
+	assert.Equal(t, types.ScopeCloudfront, scope)
+
+	_, _, _, err = webACLIdentity("arn:aws:wafv2:us-east-1:123456789012:regional/ipset/blocked/a1b2")
+	assert.Error(t, err)
+}
+
+func TestPolicyFindings(t *testing.T) {
+	t.Parallel()
+
+	assert.Empty(t, PolicyFindings([]Statement{
+		{"Allow", []string{"bedrock:InvokeModel"}, []string{"arn:aws:bedrock:us-east-1::foundation-model/amazon.titan-text-express-v1"}},
+		{"Allow", []string{"logs:PutLogEvents"}, []string{"arn:aws:logs:us-east-1:123456789012:*"}},
+		// VPC ENI management can't be scoped and isn't flagged
+		{"Allow", []string{"ec2:CreateNetworkInterface"}, []string{"*"}},
+		{"Deny", []string{"*"}, []string{"*"}},
+	}))
+
+	assert.Equal(t, []string{
+		"bedrock:* is a wildcard action",
+		"bedrock:InvokeModel is allowed on every resource",
+		"logs:* is a wildcard action",
+		"logs:CreateLogStream is allowed on every resource",
+	}, PolicyFindings([]Statement{
+		{"Allow", []string{"bedrock:*"}, []string{"arn:aws:bedrock:us-east-1::foundation-model/amazon.titan-text-express-v1"}},
+		{"Allow", []string{"bedrock:InvokeModel"}, []string{"*"}},
+		{"Allow", []string{"logs:*"}, []string{"arn:aws:logs:us-east-1:123456789012:*"}},
+		{"Allow", []string{"logs:CreateLogStream"}, []string{"*"}},
+	}))
+}
+
+func TestMergePolicyDocuments(t *testing.T) {
+	t.Parallel()
+
+	merged, err := mergePolicyDocuments(map[string]string{
+		"b-inline":  `{"Version": "2012-10-17", "Statement": {"Effect": "Allow", "Action": "sqs:SendMessage", "Resource": "arn:aws:sqs:us-east-1:123456789012:jobs"}}`,
+		"a-managed": `{"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Action": "logs:PutLogEvents", "Resource": "*"}]}`,
+	})
+	require.NoError(t, err)
+
+	statements, err := parseStatements(merged)
+	require.NoError(t, err)
+	assert.Equal(t, []Statement{
+		{"Allow", []string{"logs:PutLogEvents"}, []string{"*"}},
+		{"Allow", []string{"sqs:SendMessage"}, []string{"arn:aws:sqs:us-east-1:123456789012:jobs"}},
+	}, statements)
+}
+
+func TestOpenEgressRules(t *testing.T) {
+	t.Parallel()
+
+	// HTTPS to another security group or the VPC stays inside the network
+	assert.Empty(t, openEgressRules([]ec2types.IpPermission{
+		{IpProtocol: aws.String("tcp"), FromPort: aws.Int32(443), ToPort: aws.Int32(443),
+			UserIdGroupPairs: []ec2types.UserIdGroupPair{{GroupId: aws.String("sg-0123456789abcdef0")}}},
+		{IpProtocol: aws.String("tcp"), FromPort: aws.Int32(443), ToPort: aws.Int32(443),
+			IpRanges: []ec2types.IpRange{{CidrIp: aws.String("10.20.0.0/16")}}},
+	}))
+
+	assert.Equal(t, []string{"all ports to 0.0.0.0/0", "all ports to ::/0", "ports 443-443 to 0.0.0.0/0"}, openEgressRules([]ec2types.IpPermission{
+		{IpProtocol: aws.String("-1"),
+			IpRanges:   []ec2types.IpRange{{CidrIp: aws.String("0.0.0.0/0")}},
+			Ipv6Ranges: []ec2types.Ipv6Range{{CidrIpv6: aws.String("::/0")}}},
+		{IpProtocol: aws.String("tcp"), FromPort: aws.Int32(443), ToPort: aws.Int32(443),
+			IpRanges: []ec2types.IpRange{{CidrIp: aws.String("0.0.0.0/0")}}},
+	}))
+}
+
+func TestRemainingResources(t *testing.T) {
+	t.Parallel()
+
+	// Keys and secrets wait out their deletion windows still tagged
+	assert.Equal(t, []string{
+		"arn:aws:logs:us-east-1:123456789012:log-group:/aws/lambda/bedrock-test-abc123-bedrock-lambda",
+		"arn:aws:wafv2:us-east-1:123456789012:regional/webacl/bedrock-test-abc123-waf/a1b2c3d4",
+	}, remainingResources([]string{
+		"arn:aws:logs:us-east-1:123456789012:log-group:/aws/lambda/bedrock-test-abc123-bedrock-lambda",
+		"arn:aws:kms:us-east-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab",
+		"arn:aws:secretsmanager:us-east-1:123456789012:secret:bedrock-test-abc123-api-key-AbCdEf",
+		"arn:aws:wafv2:us-east-1:123456789012:regional/webacl/bedrock-test-abc123-waf/a1b2c3d4",
+	}))
+	assert.Empty(t, remainingResources(nil))
+}
+
+func TestParseSegmentsFindsNestedSubsegment(t *testing.T) {
+	t.Parallel()
+
+	segments, err := parseSegments([]string{
+		`{"name": "bedrock-test-abc123/test", "origin": "AWS::ApiGateway::Stage", "aws": {"api_gateway": {"request_id": "req-1"}}}`,
+		`{"name": "bedrock-test-abc123-bedrock-lambda", "origin": "AWS::Lambda::Function", "subsegments": [
+			{"name": "Invocation", "subsegments": [
+				{"name": "Bedrock InvokeModel", "annotations": {"model_id": "anthropic.claude-3-haiku-20240307-v1:0", "input_tokens": 12},
+				 "subsegments": [{"name": "Bedrock Runtime", "namespace": "aws"}]}
+			]}
+		]}`,
+	})
+	require.NoError(t, err)
+	require.Len(t, segments, 2)
+	assert.Equal(t, "req-1", segments[0].AWS["api_gateway"].(map[string]interface{})["request_id"])
+
+	invoke, ok := FindSegment(segments, func(s TraceSegment) bool { return s.Name == "Bedrock InvokeModel" })
+	require.True(t, ok)
+	assert.Equal(t, 12.0, invoke.Annotations["input_tokens"])
+
+	call, ok := FindSegment(invoke.Subsegments, func(s TraceSegment) bool { return s.Namespace == "aws" })
+	require.True(t, ok)
+	assert.Equal(t, "Bedrock Runtime", call.Name)
+
+	_, ok = FindSegment(segments, func(s TraceSegment) bool { return s.Name == "missing" })
+	assert.False(t, ok)
+
+	_, err = parseSegments([]string{"not json"})
+	assert.Error(t, err)
+}
+
+func TestNewTraceIDFormat(t *testing.T) {
+	t.Parallel()
+
+	id := NewTraceID(time.Unix(0x5759e988, 0))
+	assert.Regexp(t, `^1-5759e988-[0-9a-f]{24}$`, id)
+	assert.NotEqual(t, id, NewTraceID(time.Unix(0x5759e988, 0)))
+}
@@ -0,0 +1,98 @@
+package awsvalidate
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrock"
+	"github.com/aws/aws-sdk-go-v2/service/bedrock/types"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// profilePrefix matches the geography prefix of a system inference profile
+// ID, as the module does when granting profiles
+var profilePrefix = regexp.MustCompile(`^(us|us-gov|eu|apac|jp|global)\.`)
+
+// AssertModelAvailable checks the validator's region can serve modelID. IDs
+// with a geography prefix, such as eu.anthropic..., must be active inference
+// profiles there; any other ID must be an active foundation model.
+func (v *Validator) AssertModelAvailable(t *testing.T, modelID string) {
+	ctx := context.Background()
+
+	if profilePrefix.MatchString(modelID) {
+		profile, err := v.bedrock.GetInferenceProfile(ctx, &bedrock.GetInferenceProfileInput{
+			InferenceProfileIdentifier: aws.String(modelID),
+		})
+		require.NoError(t, err, "inference profile %s should exist in %s", modelID, v.region)
+		assert.Equal(t, types.InferenceProfileStatusActive, profile.Status, "inference profile %s in %s", modelID, v.region)
+		assert.NotEmpty(t, profile.Models, "inference profile %s should route to a model", modelID)
+		return
+	}
+
+	models, err := v.bedrock.ListFoundationModels(ctx, &bedrock.ListFoundationModelsInput{})
+	require.NoError(t, err)
+	for _, model := range models.ModelSummaries {
+		if aws.ToString(model.ModelId) == modelID {
+			if model.ModelLifecycle != nil {
+				assert.Equal(t, types.FoundationModelLifecycleStatusActive, model.ModelLifecycle.Status, "%s is being retired in %s", modelID, v.region)
+			}
+			return
+		}
+	}
+	assert.Failf(t, "model not available", "%s is not offered in %s", modelID, v.region)
+}
+
+// AssertInvokableModelsAvailable checks every model or inference profile in
+// resources, the Resource list of a bedrock:InvokeModel grant, is available
+// in the validator's region. ARNs pinned to other regions are skipped.
+func (v *Validator) AssertInvokableModelsAvailable(t *testing.T, resources []string) {
+	for _, resource := range resources {
+		// arn:aws:bedrock:<region>:<account>:<type>/<id>
+		parts := strings.SplitN(resource, ":", 6)
+		require.Len(t, parts, 6, "%s is not a Bedrock ARN", resource)
+		region := parts[3]
+		kind, id, _ := strings.Cut(parts[5], "/")
+		if region != v.region && region != "*" {
+			continue
+		}
+
+		switch {
+		case strings.Contains(id, "*"):
+			t.Logf("not checking wildcard %s", resource)
+		case kind == "foundation-model" || kind == "inference-profile":
+			v.AssertModelAvailable(t, id)
+		default:
+			t.Logf("not checking %s", resource)
+		}
+	}
+}
+
+// AssertGuardrailReady checks the guardrail version functionName applies,
+// from its GUARDRAIL_ID and GUARDRAIL_VERSION environment variables, exists
+// and is READY. It returns the ID and version.
+func (v *Validator) AssertGuardrailReady(t *testing.T, functionName string) (string, string) {
+	ctx := context.Background()
+
+	function, err := v.lambda.GetFunctionConfiguration(ctx, &lambda.GetFunctionConfigurationInput{
+		FunctionName: aws.String(functionName),
+	})
+	require.NoError(t, err, "function %s should exist", functionName)
+	require.NotNil(t, function.Environment, "function %s has no environment", functionName)
+	id := function.Environment.Variables["GUARDRAIL_ID"]
+	version := function.Environment.Variables["GUARDRAIL_VERSION"]
+	require.NotEmpty(t, id, "function %s should be configured with a guardrail", functionName)
+	require.NotEmpty(t, version, "function %s should pin a guardrail version", functionName)
+
+	guardrail, err := v.bedrock.GetGuardrail(ctx, &bedrock.GetGuardrailInput{
+		GuardrailIdentifier: aws.String(id),
+		GuardrailVersion:    aws.String(version),
+	})
+	require.NoError(t, err, "guardrail %s version %s should exist in %s", id, version, v.region)
+	assert.Equal(t, types.GuardrailStatusReady, guardrail.Status, "guardrail %s version %s: %v", id, version, guardrail.StatusReasons)
+	return id, version
+}
@@ -0,0 +1,185 @@
+package awsvalidate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Statement is an IAM policy statement with Action and Resource normalized
+// to lists, since a single value may be written as a string.
+type Statement struct {
+	Effect   string
+	Action   []string
+	Resource []string
+}
+
+// stringOrList decodes a policy field that may be a string or a list.
+type stringOrList []string
+
+func (s *stringOrList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*s = []string{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*s = list
+	return nil
+}
+
+// parsePolicyDocument decodes a policy document as IAM returns it, URL-encoded.
+func parsePolicyDocument(document string) ([]Statement, error) {
+	decoded, err := url.QueryUnescape(document)
+	if err != nil {
+		return nil, err
+	}
+	return parseStatements(decoded)
+}
+
+// parseStatements decodes the statements of a policy document.
+func parseStatements(document string) ([]Statement, error) {
+	var parsed struct {
+		Statement []struct {
+			Effect   string
+			Action   stringOrList
+			Resource stringOrList
+		}
+	}
+	if err := json.Unmarshal([]byte(document), &parsed); err != nil {
+		return nil, err
+	}
+
+	statements := make([]Statement, len(parsed.Statement))
+	for i, statement := range parsed.Statement {
+		statements[i] = Statement{statement.Effect, statement.Action, statement.Resource}
+	}
+	return statements, nil
+}
+
+// PolicyFindings lists the grants in statements that are broader than the
+// module should ever need: wildcard actions, and Bedrock invocation or logs
+// actions allowed on every resource.
+func PolicyFindings(statements []Statement) []string {
+	var findings []string
+	for _, statement := range statements {
+		if statement.Effect != "Allow" {
+			continue
+		}
+		everyResource := false
+		for _, resource := range statement.Resource {
+			everyResource = everyResource || resource == "*"
+		}
+		for _, action := range statement.Action {
+			lower := strings.ToLower(action)
+			switch {
+			case strings.Contains(action, "*"):
+				findings = append(findings, fmt.Sprintf("%s is a wildcard action", action))
+			case everyResource && (strings.HasPrefix(lower, "bedrock:invoke") || strings.HasPrefix(lower, "logs:")):
+				findings = append(findings, fmt.Sprintf("%s is allowed on every resource", action))
+			}
+		}
+	}
+	return findings
+}
+
+// ModuleRoleARNs returns the ARNs of the roles whose names start with
+// namePrefix, which covers every role the module creates.
+func (v *Validator) ModuleRoleARNs(t *testing.T, namePrefix string) []string {
+	var arns []string
+	input := &iam.ListRolesInput{}
+	for {
+		page, err := v.iam.ListRoles(context.Background(), input)
+		require.NoError(t, err)
+		for _, role := range page.Roles {
+			if strings.HasPrefix(aws.ToString(role.RoleName), namePrefix) {
+				arns = append(arns, aws.ToString(role.Arn))
+			}
+		}
+		if !page.IsTruncated {
+			return arns
+		}
+		input.Marker = page.Marker
+	}
+}
+
+// RolePolicyDocuments returns the JSON document of every policy on the role,
+// keyed by policy name. Managed policies are read at their default version,
+// and inline policies are included.
+func (v *Validator) RolePolicyDocuments(t *testing.T, roleARN string) map[string]string {
+	ctx := context.Background()
+	roleName := roleARN[strings.LastIndex(roleARN, "/")+1:]
+	documents := map[string]string{}
+
+	attached, err := v.iam.ListAttachedRolePolicies(ctx, &iam.ListAttachedRolePoliciesInput{
+		RoleName: aws.String(roleName),
+	})
+	require.NoError(t, err, "role %s should exist", roleName)
+	for _, policy := range attached.AttachedPolicies {
+		meta, err := v.iam.GetPolicy(ctx, &iam.GetPolicyInput{PolicyArn: policy.PolicyArn})
+		require.NoError(t, err)
+		version, err := v.iam.GetPolicyVersion(ctx, &iam.GetPolicyVersionInput{
+			PolicyArn: policy.PolicyArn,
+			VersionId: meta.Policy.DefaultVersionId,
+		})
+		require.NoError(t, err)
+
+		decoded, err := url.QueryUnescape(aws.ToString(version.PolicyVersion.Document))
+		require.NoError(t, err)
+		documents[aws.ToString(policy.PolicyName)] = decoded
+	}
+
+	inline, err := v.iam.ListRolePolicies(ctx, &iam.ListRolePoliciesInput{RoleName: aws.String(roleName)})
+	require.NoError(t, err)
+	for _, name := range inline.PolicyNames {
+		policy, err := v.iam.GetRolePolicy(ctx, &iam.GetRolePolicyInput{
+			RoleName:   aws.String(roleName),
+			PolicyName: aws.String(name),
+		})
+		require.NoError(t, err)
+
+		decoded, err := url.QueryUnescape(aws.ToString(policy.PolicyDocument))
+		require.NoError(t, err)
+		documents[name] = decoded
+	}
+	return documents
+}
+
+// RolePolicyStatements returns the statements of every policy on the role.
+func (v *Validator) RolePolicyStatements(t *testing.T, roleARN string) []Statement {
+	var statements []Statement
+	for name, document := range v.RolePolicyDocuments(t, roleARN) {
+		parsed, err := parseStatements(document)
+		require.NoError(t, err, "policy %s", name)
+		statements = append(statements, parsed...)
+	}
+	return statements
+}
+
+// AssertLeastPrivilege checks the role's policies have no PolicyFindings,
+// and that Bedrock invocation is scoped to exactly invokableARNs.
+func (v *Validator) AssertLeastPrivilege(t *testing.T, roleARN string, invokableARNs []string) {
+	statements := v.RolePolicyStatements(t, roleARN)
+	assert.Empty(t, PolicyFindings(statements), "grants broader than the module needs")
+
+	var invokeResources []string
+	for _, statement := range statements {
+		for _, action := range statement.Action {
+			if action == "bedrock:InvokeModel" {
+				invokeResources = append(invokeResources, statement.Resource...)
+			}
+		}
+	}
+	assert.ElementsMatch(t, invokableARNs, invokeResources, "models the role can invoke")
+}
@@ -0,0 +1,46 @@
+package awsvalidate
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// LambdaConfig is the expected configuration of the Bedrock function. Zero
+// fields are not checked, and Environment only needs to be a subset.
+type LambdaConfig struct {
+	Runtime     string
+	Timeout     int32
+	MemorySize  int32
+	Environment map[string]string
+}
+
+// AssertLambda checks the live configuration of functionName against want.
+func (v *Validator) AssertLambda(t *testing.T, functionName string, want LambdaConfig) {
+	got, err := v.lambda.GetFunctionConfiguration(context.Background(), &lambda.GetFunctionConfigurationInput{
+		FunctionName: aws.String(functionName),
+	})
+	require.NoError(t, err, "function %s should exist", functionName)
+
+	if want.Runtime != "" {
+		assert.Equal(t, want.Runtime, string(got.Runtime), "runtime of %s", functionName)
+	}
+	if want.Timeout != 0 {
+		assert.Equal(t, want.Timeout, aws.ToInt32(got.Timeout), "timeout of %s", functionName)
+	}
+	if want.MemorySize != 0 {
+		assert.Equal(t, want.MemorySize, aws.ToInt32(got.MemorySize), "memory size of %s", functionName)
+	}
+
+	var variables map[string]string
+	if got.Environment != nil {
+		variables = got.Environment.Variables
+	}
+	for key, value := range want.Environment {
+		assert.Equal(t, value, variables[key], "environment variable %s of %s", key, functionName)
+	}
+}
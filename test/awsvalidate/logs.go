@@ -0,0 +1,29 @@
+package awsvalidate
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// AssertLogGroupRetention checks that logGroupName exists and keeps events
+// for retentionDays.
+func (v *Validator) AssertLogGroupRetention(t *testing.T, logGroupName string, retentionDays int32) {
+	out, err := v.logs.DescribeLogGroups(context.Background(), &cloudwatchlogs.DescribeLogGroupsInput{
+		LogGroupNamePrefix: aws.String(logGroupName),
+	})
+	require.NoError(t, err)
+
+	// The name is only a prefix filter, so longer names can match too
+	for _, group := range out.LogGroups {
+		if aws.ToString(group.LogGroupName) == logGroupName {
+			assert.Equal(t, retentionDays, aws.ToInt32(group.RetentionInDays), "retention of %s", logGroupName)
+			return
+		}
+	}
+	t.Fatalf("log group %s does not exist", logGroupName)
+}
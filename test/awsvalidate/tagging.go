@@ -0,0 +1,78 @@
+package awsvalidate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi"
+	"github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi/types"
+	"github.com/gruntwork-io/terratest/modules/retry"
+	"github.com/stretchr/testify/assert"
+)
+
+// scheduledDeletions are ARN fragments of resources that AWS keeps, tagged,
+// for a waiting period after Terraform deletes them
+var scheduledDeletions = []string{":kms:", ":secretsmanager:"}
+
+// remainingResources drops the ARNs of resources whose deletion AWS finishes
+// on its own schedule
+func remainingResources(arns []string) []string {
+	var remaining []string
+	for _, arn := range arns {
+		scheduled := false
+		for _, fragment := range scheduledDeletions {
+			scheduled = scheduled || strings.Contains(arn, fragment)
+		}
+		if !scheduled {
+			remaining = append(remaining, arn)
+		}
+	}
+	return remaining
+}
+
+// TaggedResources lists the ARNs of resources in the validator's region
+// tagged key=value. The tagging API doesn't cover IAM or every resource type.
+func (v *Validator) TaggedResources(ctx context.Context, key, value string) ([]string, error) {
+	var arns []string
+	paginator := resourcegroupstaggingapi.NewGetResourcesPaginator(v.tagging, &resourcegroupstaggingapi.GetResourcesInput{
+		TagFilters: []types.TagFilter{{Key: aws.String(key), Values: []string{value}}},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, mapping := range page.ResourceTagMappingList {
+			arns = append(arns, aws.ToString(mapping.ResourceARN))
+		}
+	}
+	return arns, nil
+}
+
+// AssertTornDown fails t if anything tagged key=value is left after destroy,
+// other than resources AWS deletes on a schedule. The tagging API can list a
+// resource for a few minutes after it is deleted, so it waits for the list
+// to empty before failing.
+func (v *Validator) AssertTornDown(t *testing.T, key, value string) {
+	var remaining []string
+	_, err := retry.DoWithRetryE(t, fmt.Sprintf("wait for resources tagged %s=%s to go", key, value), 10, 30*time.Second, func() (string, error) {
+		arns, err := v.TaggedResources(context.Background(), key, value)
+		if err != nil {
+			return "", err
+		}
+		remaining = remainingResources(arns)
+		if len(remaining) > 0 {
+			return "", fmt.Errorf("%d resources remain", len(remaining))
+		}
+		return "", nil
+	})
+	if len(remaining) > 0 {
+		assert.Empty(t, remaining, "resources tagged %s=%s survived destroy", key, value)
+		return
+	}
+	assert.NoError(t, err, "listing resources tagged %s=%s", key, value)
+}
@@ -0,0 +1,84 @@
+package awsvalidate
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// AssertLambdaVPC checks functionName is attached to exactly subnetIDs and
+// securityGroupIDs, and returns the ID of its VPC.
+func (v *Validator) AssertLambdaVPC(t *testing.T, functionName string, subnetIDs, securityGroupIDs []string) string {
+	got, err := v.lambda.GetFunctionConfiguration(context.Background(), &lambda.GetFunctionConfigurationInput{
+		FunctionName: aws.String(functionName),
+	})
+	require.NoError(t, err, "function %s should exist", functionName)
+	require.NotNil(t, got.VpcConfig, "function %s should be attached to a VPC", functionName)
+
+	assert.ElementsMatch(t, subnetIDs, got.VpcConfig.SubnetIds, "subnets of %s", functionName)
+	assert.ElementsMatch(t, securityGroupIDs, got.VpcConfig.SecurityGroupIds, "security groups of %s", functionName)
+	return aws.ToString(got.VpcConfig.VpcId)
+}
+
+// AssertInterfaceEndpoint checks vpcID has an available interface endpoint
+// for service, such as "bedrock-runtime", in the validator's region, with
+// private DNS so the SDK's default hostname resolves to it.
+func (v *Validator) AssertInterfaceEndpoint(t *testing.T, vpcID, service string) {
+	serviceName := fmt.Sprintf("com.amazonaws.%s.%s", v.region, service)
+	endpoints, err := v.ec2.DescribeVpcEndpoints(context.Background(), &ec2.DescribeVpcEndpointsInput{
+		Filters: []types.Filter{
+			{Name: aws.String("vpc-id"), Values: []string{vpcID}},
+			{Name: aws.String("service-name"), Values: []string{serviceName}},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, endpoints.VpcEndpoints, 1, "%s should have one %s endpoint", vpcID, serviceName)
+
+	endpoint := endpoints.VpcEndpoints[0]
+	assert.Equal(t, types.VpcEndpointTypeInterface, endpoint.VpcEndpointType, "type of %s", serviceName)
+	assert.Equal(t, types.StateAvailable, endpoint.State, "state of %s", serviceName)
+	assert.True(t, aws.ToBool(endpoint.PrivateDnsEnabled), "%s should have private DNS", serviceName)
+	assert.NotEmpty(t, endpoint.SubnetIds, "%s should be placed in subnets", serviceName)
+}
+
+// AssertNoOpenEgress checks security group groupID allows no egress to
+// 0.0.0.0/0 or ::/0.
+func (v *Validator) AssertNoOpenEgress(t *testing.T, groupID string) {
+	groups, err := v.ec2.DescribeSecurityGroups(context.Background(), &ec2.DescribeSecurityGroupsInput{
+		GroupIds: []string{groupID},
+	})
+	require.NoError(t, err)
+	require.Len(t, groups.SecurityGroups, 1, "security group %s should exist", groupID)
+
+	assert.Empty(t, openEgressRules(groups.SecurityGroups[0].IpPermissionsEgress), "egress rules of %s open to the internet", groupID)
+}
+
+// openEgressRules describes the rules in permissions whose destination is
+// every IPv4 or IPv6 address.
+func openEgressRules(permissions []types.IpPermission) []string {
+	var open []string
+	for _, permission := range permissions {
+		ports := "all ports"
+		if permission.FromPort != nil && aws.ToString(permission.IpProtocol) != "-1" {
+			ports = fmt.Sprintf("ports %d-%d", aws.ToInt32(permission.FromPort), aws.ToInt32(permission.ToPort))
+		}
+		for _, r := range permission.IpRanges {
+			if aws.ToString(r.CidrIp) == "0.0.0.0/0" {
+				open = append(open, fmt.Sprintf("%s to 0.0.0.0/0", ports))
+			}
+		}
+		for _, r := range permission.Ipv6Ranges {
+			if aws.ToString(r.CidrIpv6) == "::/0" {
+				open = append(open, fmt.Sprintf("%s to ::/0", ports))
+			}
+		}
+	}
+	return open
+}
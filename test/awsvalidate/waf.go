@@ -0,0 +1,94 @@
+package awsvalidate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/wafv2"
+	"github.com/aws/aws-sdk-go-v2/service/wafv2/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// webACLIdentity splits a web ACL ARN, which ends in
+// <scope>/webacl/<name>/<id>, into the fields GetWebACL takes.
+func webACLIdentity(aclARN string) (name, id string, scope types.Scope, err error) {
+	parts := strings.Split(aclARN, ":")
+	if len(parts) != 6 {
+		return "", "", "", fmt.Errorf("%q is not an ARN", aclARN)
+	}
+	path := strings.Split(parts[5], "/")
+	if len(path) != 4 || path[1] != "webacl" {
+		return "", "", "", fmt.Errorf("%q is not a web ACL ARN", aclARN)
+	}
+
+	scope = types.ScopeRegional
+	if path[0] == "global" {
+		scope = types.ScopeCloudfront
+	}
+	return path[2], path[3], scope, nil
+}
+
+// AssertWebACL checks that aclARN has exactly the named rules, in priority
+// order. A non-empty stageARN must also be associated with it; CloudFront
+// ACLs attach to a distribution instead.
+func (v *Validator) AssertWebACL(t *testing.T, aclARN, stageARN string, wantRules []string) {
+	name, id, scope, err := webACLIdentity(aclARN)
+	require.NoError(t, err)
+
+	out, err := v.wafv2.GetWebACL(context.Background(), &wafv2.GetWebACLInput{
+		Name:  aws.String(name),
+		Id:    aws.String(id),
+		Scope: scope,
+	})
+	require.NoError(t, err, "web ACL %s should exist", aclARN)
+
+	rules := out.WebACL.Rules
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Priority < rules[j].Priority })
+	gotRules := make([]string, len(rules))
+	for i, rule := range rules {
+		gotRules[i] = aws.ToString(rule.Name)
+	}
+	assert.Equal(t, wantRules, gotRules, "rules of %s", name)
+
+	if stageARN == "" {
+		return
+	}
+	association, err := v.wafv2.GetWebACLForResource(context.Background(), &wafv2.GetWebACLForResourceInput{
+		ResourceArn: aws.String(stageARN),
+	})
+	require.NoError(t, err)
+	require.NotNil(t, association.WebACL, "stage %s should have a web ACL", stageARN)
+	assert.Equal(t, aclARN, aws.ToString(association.WebACL.ARN), "web ACL of %s", stageARN)
+}
+
+// WAFSampledBlocks returns the requests a rule blocked since a time, from the
+// web ACL's sampled requests. WAF samples per rule by metric name, which for
+// a managed rule group is the group's; the matched rule inside it is in
+// RuleNameWithinRuleGroup. Samples can lag the request by a few minutes.
+func (v *Validator) WAFSampledBlocks(t *testing.T, aclARN, ruleMetricName string, since time.Time) []types.SampledHTTPRequest {
+	_, _, scope, err := webACLIdentity(aclARN)
+	require.NoError(t, err)
+
+	out, err := v.wafv2.GetSampledRequests(context.Background(), &wafv2.GetSampledRequestsInput{
+		WebAclArn:      aws.String(aclARN),
+		RuleMetricName: aws.String(ruleMetricName),
+		Scope:          scope,
+		TimeWindow:     &types.TimeWindow{StartTime: aws.Time(since), EndTime: aws.Time(time.Now())},
+		MaxItems:       aws.Int64(500),
+	})
+	require.NoError(t, err)
+
+	var blocked []types.SampledHTTPRequest
+	for _, sample := range out.SampledRequests {
+		if aws.ToString(sample.Action) == "BLOCK" {
+			blocked = append(blocked, sample)
+		}
+	}
+	return blocked
+}
@@ -0,0 +1,112 @@
+package awsvalidate
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/xray"
+	"github.com/gruntwork-io/terratest/modules/retry"
+	"github.com/stretchr/testify/require"
+)
+
+// TraceSegment is the part of an X-Ray segment document the tests read.
+// Subsegments nest as they do in the document.
+type TraceSegment struct {
+	Name        string                 `json:"name"`
+	Origin      string                 `json:"origin"`
+	Namespace   string                 `json:"namespace"`
+	AWS         map[string]interface{} `json:"aws"`
+	Annotations map[string]interface{} `json:"annotations"`
+	Subsegments []TraceSegment         `json:"subsegments"`
+}
+
+// NewTraceID returns an X-Ray trace ID started at now. Sending it as
+// "X-Amzn-Trace-Id: Root=<id>;Sampled=1" makes API Gateway continue that
+// trace, so a test knows which trace to read back.
+func NewTraceID(now time.Time) string {
+	random := make([]byte, 12)
+	if _, err := rand.Read(random); err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("1-%08x-%s", now.Unix(), hex.EncodeToString(random))
+}
+
+// parseSegments decodes the segment documents of a trace
+func parseSegments(documents []string) ([]TraceSegment, error) {
+	segments := make([]TraceSegment, 0, len(documents))
+	for _, document := range documents {
+		var segment TraceSegment
+		if err := json.Unmarshal([]byte(document), &segment); err != nil {
+			return nil, fmt.Errorf("parsing segment document: %w", err)
+		}
+		segments = append(segments, segment)
+	}
+	return segments, nil
+}
+
+// FindSegment returns the first segment or subsegment, depth first, that
+// match accepts.
+func FindSegment(segments []TraceSegment, match func(TraceSegment) bool) (TraceSegment, bool) {
+	for _, segment := range segments {
+		if match(segment) {
+			return segment, true
+		}
+		if found, ok := FindSegment(segment.Subsegments, match); ok {
+			return found, true
+		}
+	}
+	return TraceSegment{}, false
+}
+
+// CompleteTrace waits for X-Ray to finish assembling traceID, a trace started
+// after since, and returns its segments. Traces take up to a minute or two
+// to show up and stay partial until every service has sent its segments.
+func (v *Validator) CompleteTrace(t *testing.T, traceID string, since time.Time) []TraceSegment {
+	ctx := context.Background()
+	var segments []TraceSegment
+
+	retry.DoWithRetry(t, "wait for trace "+traceID, 20, 15*time.Second, func() (string, error) {
+		complete := false
+		paginator := xray.NewGetTraceSummariesPaginator(v.xray, &xray.GetTraceSummariesInput{
+			StartTime: aws.Time(since.Add(-time.Minute)),
+			EndTime:   aws.Time(time.Now().Add(time.Minute)),
+		})
+		for paginator.HasMorePages() && !complete {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return "", err
+			}
+			for _, summary := range page.TraceSummaries {
+				if aws.ToString(summary.Id) == traceID {
+					complete = !aws.ToBool(summary.IsPartial)
+				}
+			}
+		}
+		if !complete {
+			return "", fmt.Errorf("trace %s is missing or partial", traceID)
+		}
+
+		out, err := v.xray.BatchGetTraces(ctx, &xray.BatchGetTracesInput{TraceIds: []string{traceID}})
+		if err != nil {
+			return "", err
+		}
+		if len(out.Traces) != 1 {
+			return "", fmt.Errorf("trace %s not returned", traceID)
+		}
+		var documents []string
+		for _, segment := range out.Traces[0].Segments {
+			documents = append(documents, aws.ToString(segment.Document))
+		}
+		segments, err = parseSegments(documents)
+		return "", err
+	})
+
+	require.NotEmpty(t, segments, "trace %s has no segments", traceID)
+	return segments
+}
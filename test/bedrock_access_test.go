@@ -0,0 +1,67 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/catherinevee/tfm-aws-ai-bedrock/test/helpers"
+	"github.com/gruntwork-io/terratest/modules/retry"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runnerIP returns the public IP address the test's requests come from.
+func runnerIP(t *testing.T) string {
+	resp, err := http.Get("https://checkip.amazonaws.com")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	ip, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return strings.TrimSpace(string(ip))
+}
+
+func TestAPIResourcePolicyAllowsOnlyListedIPs(t *testing.T) {
+	t.Parallel()
+
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"api_allowed_ip_ranges": []string{runnerIP(t) + "/32"},
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	var policy struct {
+		Statement []struct {
+			Effect    string
+			Condition map[string]map[string][]string
+		}
+	}
+	require.NoError(t, json.Unmarshal([]byte(terraform.Output(t, terraformOptions, "api_resource_policy")), &policy))
+	require.Len(t, policy.Statement, 1)
+	assert.Equal(t, "Allow", policy.Statement[0].Effect)
+
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+	statusCode, body := postJSON(t, apiURL, map[string]interface{}{"prompt": "Say hello", "max_tokens": 10}, nil)
+	require.Equal(t, 200, statusCode, "allowed IP should pass: %v", body)
+
+	// Only a documentation range is allowed now, so the runner falls to the implicit deny
+	terraformOptions.Vars["api_allowed_ip_ranges"] = []string{"198.51.100.0/24"}
+	initAndApplyWithRetry(t, terraformOptions)
+
+	noRetry := helpers.RetryPolicy{RetryOnConnError: true, MaxRetries: 3, TimeBetweenRetries: 5 * time.Second}
+	headers := map[string]string{"Content-Type": "application/json"}
+	retry.DoWithRetry(t, "wait for the updated resource policy", 12, 10*time.Second, func() (string, error) {
+		statusCode, body := helpers.HTTPDoWithRetryPolicy(t, "POST", apiURL, []byte(`{"prompt": "Say hello", "max_tokens": 10}`), headers, noRetry)
+		if statusCode != http.StatusForbidden {
+			return "", fmt.Errorf("expected 403 from a disallowed IP, got %d: %s", statusCode, body)
+		}
+		return "denied", nil
+	})
+}
@@ -0,0 +1,145 @@
+package test
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockagent"
+	agenttypes "github.com/aws/aws-sdk-go-v2/service/bedrockagent/types"
+	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testAgent returns a pre-provisioned agent to run agent tests against.
+// Creating and preparing an agent per test takes minutes, so tests skip
+// unless BEDROCK_TEST_AGENT_ID and BEDROCK_TEST_AGENT_ALIAS_ID are set.
+func testAgent(t *testing.T) (string, string) {
+	agentID, aliasID := os.Getenv("BEDROCK_TEST_AGENT_ID"), os.Getenv("BEDROCK_TEST_AGENT_ALIAS_ID")
+	if agentID == "" || aliasID == "" {
+		t.Skip("BEDROCK_TEST_AGENT_ID and BEDROCK_TEST_AGENT_ALIAS_ID are required for agent tests")
+	}
+	return agentID, aliasID
+}
+
+func TestAgentSessionPoolReusesSessions(t *testing.T) {
+	t.Parallel()
+
+	agentID, aliasID := testAgent(t)
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"bedrock_agent_id":       agentID,
+		"bedrock_agent_alias_id": aliasID,
+		"session_pool_size":      2,
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	agentURL := terraform.Output(t, terraformOptions, "agent_api_url")
+	headers := map[string]string{"X-Tenant-Id": "session-pool-test"}
+
+	// Sequential requests land on a warm instance and draw from its pool
+	const requests = 4
+	sessions := map[string]bool{}
+	newSessions := 0
+	for i := 0; i < requests; i++ {
+		statusCode, body := postJSON(t, agentURL, map[string]interface{}{"prompt": "Say hello"}, headers)
+		require.Equal(t, 200, statusCode, "unexpected response: %v", body)
+
+		sessionID, ok := body["agent_session_id"].(string)
+		require.True(t, ok, "response should carry the agent session: %v", body)
+		sessions[sessionID] = true
+		if body["session_reused"] != true {
+			newSessions++
+		}
+	}
+
+	assert.Less(t, newSessions, requests, "pooled sessions should be reused")
+	assert.Equal(t, newSessions, len(sessions), "every non-reused response should start a distinct session")
+}
+
+func TestAgentExampleInvokesActionGroup(t *testing.T) {
+	t.Parallel()
+
+	namePrefix := testNamePrefix + random.UniqueId()
+	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
+		TerraformDir: "../examples/agent",
+		Vars: map[string]interface{}{
+			"name_prefix": namePrefix,
+			"region":      suiteConfig.Region,
+			"tags":        exampleTags(namePrefix),
+		},
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	agentURL := terraform.Output(t, terraformOptions, "agent_api_url")
+	agentID := terraform.Output(t, terraformOptions, "agent_id")
+	aliasID := terraform.Output(t, terraformOptions, "agent_alias_id")
+	actionGroup := terraform.Output(t, terraformOptions, "action_group_name")
+
+	// The alias must point at a prepared version that includes the action group
+	client := bedrockagent.NewFromConfig(awsConfig(t))
+	ctx := context.Background()
+	agent, err := client.GetAgent(ctx, &bedrockagent.GetAgentInput{AgentId: aws.String(agentID)})
+	require.NoError(t, err)
+	assert.Equal(t, agenttypes.AgentStatusPrepared, agent.Agent.AgentStatus)
+
+	alias, err := client.GetAgentAlias(ctx, &bedrockagent.GetAgentAliasInput{AgentId: aws.String(agentID), AgentAliasId: aws.String(aliasID)})
+	require.NoError(t, err)
+	assert.Equal(t, agenttypes.AgentAliasStatusPrepared, alias.AgentAlias.AgentAliasStatus)
+	require.NotEmpty(t, alias.AgentAlias.RoutingConfiguration)
+	aliasVersion := alias.AgentAlias.RoutingConfiguration[0].AgentVersion
+
+	groups, err := client.ListAgentActionGroups(ctx, &bedrockagent.ListAgentActionGroupsInput{AgentId: aws.String(agentID), AgentVersion: aliasVersion})
+	require.NoError(t, err)
+	var groupNames []string
+	for _, group := range groups.ActionGroupSummaries {
+		groupNames = append(groupNames, aws.ToString(group.ActionGroupName))
+	}
+	assert.Contains(t, groupNames, actionGroup, "the alias version should carry the action group")
+
+	// The tracking code only comes from the action group's Lambda
+	statusCode, body := postJSON(t, agentURL, map[string]interface{}{
+		"prompt": "What is the status of order 4821?",
+		"trace":  true,
+	}, nil)
+	require.Equal(t, 200, statusCode, "unexpected response: %v", body)
+	assert.Contains(t, body["content"], "KF-4821", "the answer should use the action group's result")
+
+	steps, ok := body["trace"].([]interface{})
+	require.True(t, ok, "a traced request should return its steps: %v", body)
+	calledActionGroup := false
+	for _, step := range steps {
+		step := step.(map[string]interface{})
+		if step["type"] == "ACTION_GROUP" && step["action_group"] == actionGroup {
+			calledActionGroup = true
+			assert.Equal(t, "get_order_status", step["function"])
+		}
+	}
+	assert.True(t, calledActionGroup, "the trace should show the %s action group was called: %v", actionGroup, steps)
+
+	// A caller-owned session comes back unchanged and keeps the agent's context
+	sessionID := "agent-session-" + random.UniqueId()
+	statusCode, body = postJSON(t, agentURL, map[string]interface{}{
+		"prompt":     "Look up order 7310 for me.",
+		"session_id": sessionID,
+	}, nil)
+	require.Equal(t, 200, statusCode, "unexpected response: %v", body)
+	assert.Equal(t, sessionID, body["agent_session_id"])
+	assert.Equal(t, false, body["session_reused"])
+	assert.NotContains(t, body, "trace", "untraced requests should not carry a trace")
+
+	statusCode, body = postJSON(t, agentURL, map[string]interface{}{
+		"prompt":     "Which carrier was that order shipped with?",
+		"session_id": sessionID,
+	}, nil)
+	require.Equal(t, 200, statusCode, "unexpected response: %v", body)
+	assert.Equal(t, sessionID, body["agent_session_id"])
+	assert.Contains(t, strings.ToLower(body["content"].(string)), "kestrel")
+}
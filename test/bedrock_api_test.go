@@ -1,32 +1,1214 @@
 package test
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/apigateway"
+	"github.com/catherinevee/tfm-aws-ai-bedrock/test/helpers"
+	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/gruntwork-io/terratest/modules/retry"
 	"github.com/gruntwork-io/terratest/modules/terraform"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestBedrockAPIBasicExample(t *testing.T) {
 	t.Parallel()
 
+	namePrefix := testNamePrefix + random.UniqueId()
 	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
 		TerraformDir: "../examples/basic",
 		Vars: map[string]interface{}{
-			"name_prefix": "test-bedrock-api",
+			"name_prefix": namePrefix,
+			"tags":        exampleTags(namePrefix),
 		},
 	})
 
-	defer terraform.Destroy(t, terraformOptions)
-	terraform.InitAndApply(t, terraformOptions)
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
 
 	// Verify outputs
 	apiURL := terraform.Output(t, terraformOptions, "api_url")
 	assert.Contains(t, apiURL, "execute-api")
 
 	functionName := terraform.Output(t, terraformOptions, "lambda_function_name")
-	assert.Contains(t, functionName, "test-bedrock-api")
+	assert.Contains(t, functionName, namePrefix)
 
 	logGroupName := terraform.Output(t, terraformOptions, "cloudwatch_log_group")
-	assert.Contains(t, logGroupName, "/aws/lambda/test-bedrock-api")
+	assert.Contains(t, logGroupName, "/aws/lambda/"+namePrefix)
+}
+
+func TestBedrockImageGeneration(t *testing.T) {
+	t.Parallel()
+
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"enable_image_generation": true,
+		"image_model_id":          "amazon.titan-image-generator-v1",
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	imagesURL := terraform.Output(t, terraformOptions, "images_api_url")
+	assert.True(t, strings.HasSuffix(imagesURL, "/images"))
+
+	statusCode, body := postJSON(t, imagesURL, map[string]interface{}{
+		"prompt":     "A lighthouse on a rocky coast at sunset",
+		"num_images": 1,
+	}, nil)
+
+	require.Equal(t, 200, statusCode, "unexpected response: %v", body)
+	images, ok := body["images"].([]interface{})
+	require.True(t, ok, "response should contain an images list")
+	assert.GreaterOrEqual(t, len(images), 1)
+	assert.NotEmpty(t, images[0])
+}
+
+func TestBedrockModelAliases(t *testing.T) {
+	t.Parallel()
+
+	concreteModelID := "anthropic.claude-3-haiku-20240307-v1:0"
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"model_aliases": map[string]string{
+			"fast": concreteModelID,
+		},
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	aliases := terraform.OutputMap(t, terraformOptions, "model_aliases")
+	assert.Equal(t, concreteModelID, aliases["fast"])
+
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+
+	statusCode, body := postJSON(t, apiURL, map[string]interface{}{
+		"prompt":     "Say hello",
+		"max_tokens": 20,
+		"model":      "fast",
+	}, nil)
+	require.Equal(t, 200, statusCode, "unexpected response: %v", body)
+	assert.Equal(t, concreteModelID, body["model_id"])
+	assert.Equal(t, "fast", body["model_alias"])
+
+	// Unknown aliases are rejected with the list of valid ones
+	statusCode, body = postJSON(t, apiURL, map[string]interface{}{
+		"prompt": "Say hello",
+		"model":  "does-not-exist",
+	}, nil)
+	assert.Equal(t, 400, statusCode)
+	assert.Contains(t, body["message"], "fast")
+}
+
+func TestProdRejectsExperimentalModel(t *testing.T) {
+	t.Parallel()
+
+	// The alias makes the model invokable, but prod only allows the default
+	experimentalModelID := "amazon.titan-text-express-v1"
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"environment": "prod",
+		"model_aliases": map[string]string{
+			"experimental": experimentalModelID,
+		},
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	allowed := terraform.OutputList(t, terraformOptions, "allowed_model_ids")
+	assert.NotContains(t, allowed, experimentalModelID)
+
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+
+	statusCode, body := postJSON(t, apiURL, map[string]interface{}{
+		"prompt":     "Say hello",
+		"max_tokens": 20,
+		"model":      "experimental",
+	}, nil)
+	require.Equal(t, 403, statusCode, "experimental model should be rejected in prod: %v", body)
+	assert.Contains(t, body["message"], experimentalModelID)
+
+	// The default model is still served
+	statusCode, body = postJSON(t, apiURL, map[string]interface{}{
+		"prompt":     "Say hello",
+		"max_tokens": 20,
+	}, nil)
+	require.Equal(t, 200, statusCode, "unexpected response: %v", body)
+}
+
+func TestBedrockRequestTimeoutOverride(t *testing.T) {
+	t.Parallel()
+
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"max_request_timeout_ms": 20000,
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+
+	// A deadline far below model latency must surface as a gateway timeout
+	statusCode, body := postJSON(t, apiURL, map[string]interface{}{
+		"prompt":     "Write a short paragraph about the ocean",
+		"max_tokens": 200,
+		"timeout_ms": 1,
+	}, nil)
+	assert.Equal(t, 504, statusCode, "unexpected response: %v", body)
+	errorBody, ok := body["error"].(map[string]interface{})
+	require.True(t, ok, "response should contain an error object")
+	assert.Equal(t, "RequestTimeout", errorBody["code"])
+
+	// A generous deadline (clamped to the server cap) succeeds
+	statusCode, body = postJSON(t, apiURL, map[string]interface{}{
+		"prompt":     "Say hello",
+		"max_tokens": 20,
+		"timeout_ms": 60000,
+	}, nil)
+	assert.Equal(t, 200, statusCode, "unexpected response: %v", body)
+	assert.NotEmpty(t, body["content"])
+}
+
+func TestBedrockAPICache(t *testing.T) {
+	t.Parallel()
+
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"enable_api_cache":  true,
+		"cache_ttl_seconds": 300,
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	cache := terraform.OutputMapOfObjects(t, terraformOptions, "api_cache")
+	assert.Equal(t, true, cache["enabled"])
+
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+	payload := []byte(`{"prompt": "Classify the sentiment of: I love this product", "max_tokens": 10}`)
+	bodyHash := sha256.Sum256(payload)
+	headers := map[string]string{
+		"Content-Type": "application/json",
+		"X-Body-Hash":  hex.EncodeToString(bodyHash[:]),
+	}
+
+	statusCode, first := helpers.HTTPDoWithRetryPolicy(t, "POST", apiURL, payload, headers, helpers.DefaultRetryPolicy())
+	require.Equal(t, 200, statusCode, "unexpected response: %s", first)
+
+	statusCode, second := helpers.HTTPDoWithRetryPolicy(t, "POST", apiURL, payload, headers, helpers.DefaultRetryPolicy())
+	require.Equal(t, 200, statusCode, "unexpected response: %s", second)
+
+	// A cache hit replays the first response, including its Lambda request ID
+	var firstBody, secondBody struct {
+		Metadata struct {
+			RequestID string `json:"request_id"`
+		} `json:"metadata"`
+	}
+	require.NoError(t, json.Unmarshal(first, &firstBody))
+	require.NoError(t, json.Unmarshal(second, &secondBody))
+	assert.NotEmpty(t, firstBody.Metadata.RequestID)
+	assert.Equal(t, firstBody.Metadata.RequestID, secondBody.Metadata.RequestID, "second request should be served from cache")
+}
+
+func TestStageCanaryDeployment(t *testing.T) {
+	t.Parallel()
+
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"enable_stage_canary":    true,
+		"canary_percent_traffic": 25,
+		"canary_stable_version":  "1",
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	canary := terraform.OutputMap(t, terraformOptions, "api_canary")
+	assert.Equal(t, "25", canary["percent_traffic"])
+
+	info := terraform.OutputMapOfObjects(t, terraformOptions, "deployment_info")
+	ctx := context.Background()
+	client := apigateway.NewFromConfig(awsConfig(t))
+	getStage := func() *apigateway.GetStageOutput {
+		stage, err := client.GetStage(ctx, &apigateway.GetStageInput{
+			RestApiId: aws.String(info["api_id"].(string)),
+			StageName: aws.String(info["api_stage_name"].(string)),
+		})
+		require.NoError(t, err)
+		return stage
+	}
+
+	// The stage serves the stable deployment and sends a quarter of requests to the canary
+	stage := getStage()
+	require.NotNil(t, stage.CanarySettings, "stage should have a canary")
+	assert.Equal(t, 25.0, stage.CanarySettings.PercentTraffic)
+	assert.Equal(t, canary["canary_deployment_id"], aws.ToString(stage.CanarySettings.DeploymentId))
+	assert.Equal(t, canary["stable_deployment_id"], aws.ToString(stage.DeploymentId))
+
+	healthURL := terraform.Output(t, terraformOptions, "health_url")
+	statusCode, body := helpers.HTTPDoWithRetryPolicy(t, "GET", healthURL, nil, nil, helpers.DefaultRetryPolicy())
+	require.Equal(t, 200, statusCode, "unexpected response: %s", body)
+
+	// Promotion replaces the stable deployment with one of the current API
+	terraformOptions.Vars["canary_stable_version"] = "2"
+	initAndApplyWithRetry(t, terraformOptions)
+
+	promoted := terraform.OutputMap(t, terraformOptions, "api_canary")
+	assert.NotEqual(t, canary["stable_deployment_id"], promoted["stable_deployment_id"])
+	assert.Equal(t, promoted["stable_deployment_id"], aws.ToString(getStage().DeploymentId))
+}
+
+func TestBedrockPerModelConcurrency(t *testing.T) {
+	t.Parallel()
+
+	// The limit is per model, so the unlimited alias needs a different one
+	limitedModelID := suiteConfig.ModelID
+	unlimitedModelID := "anthropic.claude-3-haiku-20240307-v1:0"
+	if unlimitedModelID == limitedModelID {
+		unlimitedModelID = "anthropic.claude-3-sonnet-20240229-v1:0"
+	}
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"model_aliases": map[string]string{
+			"limited":   limitedModelID,
+			"unlimited": unlimitedModelID,
+		},
+		"per_model_concurrency": map[string]int{
+			limitedModelID: 1,
+		},
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+	headers := map[string]string{"Content-Type": "application/json"}
+
+	// 429 is the behaviour under test, so it must not be retried away
+	noThrottleRetry := helpers.DefaultRetryPolicy()
+	noThrottleRetry.RetryableStatusCodes = []int{http.StatusServiceUnavailable}
+
+	// Warm up so cold starts don't serialize the burst
+	statusCode, body := helpers.HTTPDoWithRetryPolicy(t, "POST", apiURL, []byte(`{"prompt": "Say hello", "max_tokens": 10, "model": "limited"}`), headers, helpers.DefaultRetryPolicy())
+	require.Equal(t, 200, statusCode, "unexpected response: %s", body)
+
+	const burst = 6
+	statusCodes := make([]int, burst)
+	errs := make([]error, burst)
+	var wg sync.WaitGroup
+	for i := 0; i < burst; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			payload := []byte(`{"prompt": "Write a long story about a lighthouse keeper", "max_tokens": 500, "model": "limited"}`)
+			statusCodes[i], _, errs[i] = helpers.HTTPDoWithRetryPolicyE(t, "POST", apiURL, payload, headers, noThrottleRetry)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+
+	assert.Contains(t, statusCodes, 200, "at least one request should hold the slot")
+	assert.Contains(t, statusCodes, 429, "concurrent requests beyond the limit should be rejected")
+
+	// Models without a limit are unaffected
+	statusCode, body = helpers.HTTPDoWithRetryPolicy(t, "POST", apiURL, []byte(`{"prompt": "Say hello", "max_tokens": 10, "model": "unlimited"}`), headers, noThrottleRetry)
+	assert.Equal(t, 200, statusCode, "unexpected response: %s", body)
+}
+
+func TestBedrockIdempotencyDuplicateMetric(t *testing.T) {
+	t.Parallel()
+
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"enable_idempotency": true,
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+	functionName := terraform.Output(t, terraformOptions, "lambda_function_name")
+	startTime := time.Now()
+
+	payload := map[string]interface{}{"prompt": "Say hello", "max_tokens": 10}
+	headers := map[string]string{"Idempotency-Key": random.UniqueId()}
+
+	statusCode, first := postJSON(t, apiURL, payload, headers)
+	require.Equal(t, 200, statusCode, "unexpected response: %v", first)
+	assert.Equal(t, false, first["deduplicated"])
+
+	statusCode, second := postJSON(t, apiURL, payload, headers)
+	require.Equal(t, 200, statusCode, "unexpected response: %v", second)
+	assert.Equal(t, true, second["deduplicated"])
+	assert.Equal(t, first["content"], second["content"], "duplicate should replay the stored response")
+
+	// The same key with a different body is a client bug, not a duplicate
+	statusCode, _ = postJSON(t, apiURL, map[string]interface{}{"prompt": "Say goodbye"}, headers)
+	assert.Equal(t, 422, statusCode)
+
+	assert.GreaterOrEqual(t, waitForMetricSum(t, "DuplicateRequests", functionName, startTime), 1.0)
+}
+
+func TestBedrockErrorVerbosity(t *testing.T) {
+	t.Parallel()
+
+	for _, verbosity := range []string{"minimal", "detailed"} {
+		verbosity := verbosity
+		t.Run(verbosity, func(t *testing.T) {
+			t.Parallel()
+
+			// The alias points at a model ID Bedrock rejects, forcing an upstream error
+			terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+				"error_verbosity": verbosity,
+				"model_aliases": map[string]string{
+					"broken": "anthropic.claude-does-not-exist-v1:0",
+				},
+			})
+
+			defer destroy(t, terraformOptions)
+			initAndApplyWithRetry(t, terraformOptions)
+
+			apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+			statusCode, body := postJSON(t, apiURL, map[string]interface{}{
+				"prompt": "Say hello",
+				"model":  "broken",
+			}, nil)
+			require.Equal(t, 500, statusCode, "unexpected response: %v", body)
+
+			errorBody, ok := body["error"].(map[string]interface{})
+			require.True(t, ok, "error should be an object: %v", body)
+			assert.NotEmpty(t, errorBody["request_id"], "errors should always carry a request ID")
+
+			encoded, err := json.Marshal(body)
+			require.NoError(t, err)
+
+			if verbosity == "minimal" {
+				assert.NotContains(t, errorBody, "details")
+				assert.NotContains(t, string(encoded), "ValidationException", "minimal mode should hide the upstream error type")
+				return
+			}
+
+			details, ok := errorBody["details"].(map[string]interface{})
+			require.True(t, ok, "detailed mode should include error details: %v", body)
+			assert.Equal(t, "ValidationException", details["type"])
+			assert.NotEmpty(t, details["stack"])
+		})
+	}
+}
+
+func TestGatewayResponsesUseHandlerErrorShape(t *testing.T) {
+	t.Parallel()
+
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"enable_api_key": true,
+		"gateway_response_messages": map[string]string{
+			"DEFAULT_4XX": "Request rejected by the API gateway",
+		},
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+
+	// No API key, so API Gateway rejects the request before it reaches the Lambda
+	resp, err := http.Post(apiURL, "application/json", strings.NewReader(`{"prompt": "Say hello"}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Equal(t, "*", resp.Header.Get("Access-Control-Allow-Origin"))
+
+	var body struct {
+		Success *bool `json:"success"`
+		Error   struct {
+			Code      string `json:"code"`
+			Message   string `json:"message"`
+			RequestID string `json:"request_id"`
+		} `json:"error"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body), "gateway response should be JSON")
+	require.NotNil(t, body.Success)
+	assert.False(t, *body.Success)
+	assert.NotEmpty(t, body.Error.Code, "the gateway response type should be exposed as the error code")
+	assert.Equal(t, "Request rejected by the API gateway", body.Error.Message)
+	assert.NotEmpty(t, body.Error.RequestID)
+}
+
+// errorCodeAndMessage reads the code and message of an error body in either
+// shape: the handler's validation errors carry them at the top level beside
+// "error": true, gateway and model errors nest them in an "error" object.
+func errorCodeAndMessage(t *testing.T, body []byte) (string, string) {
+	var decoded struct {
+		Error   json.RawMessage `json:"error"`
+		Code    string          `json:"code"`
+		Message string          `json:"message"`
+	}
+	require.NoError(t, json.Unmarshal(body, &decoded), "error body should be JSON: %s", body)
+
+	var nested struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(decoded.Error, &nested) == nil {
+		return nested.Code, nested.Message
+	}
+	require.Equal(t, "true", string(decoded.Error), "error body should flag the error: %s", body)
+	return decoded.Code, decoded.Message
+}
+
+func TestAPIRejectsInvalidRequests(t *testing.T) {
+	t.Parallel()
+
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"enable_api_key": true,
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	outputs := helpers.GetStackOutputs(t, terraformOptions)
+	withKey := map[string]string{"Content-Type": "application/json", "x-api-key": outputs.APIKey}
+	connRetry := helpers.RetryPolicy{RetryOnConnError: true, MaxRetries: 3, TimeBetweenRetries: 5 * time.Second}
+
+	// Every case fails before Bedrock is called. Gateway codes are the gateway
+	// response type, so only the handler's codes are pinned exactly.
+	for _, tc := range []struct {
+		name    string
+		method  string
+		body    string
+		headers map[string]string
+		status  int
+		code    string
+		message string
+	}{
+		{"missing_prompt", "POST", `{"max_tokens": 10}`, withKey, 400, "InvalidRequest", "Prompt field required"},
+		{"non_json_body", "POST", `prompt=hello`, withKey, 400, "InvalidRequest", "Invalid JSON format"},
+		{"oversized_max_tokens", "POST", `{"prompt": "hi", "max_tokens": 100000}`, withKey, 400, "InvalidRequest", "max_tokens must be an integer between 1 and 4096"},
+		{"unsupported_model_override", "POST", `{"prompt": "hi", "model": "gpt-4"}`, withKey, 400, "InvalidRequest", "Unknown model alias 'gpt-4'"},
+		{"wrong_method", "GET", "", withKey, 403, "", "Missing Authentication Token"},
+		{"missing_api_key", "POST", `{"prompt": "hi"}`, map[string]string{"Content-Type": "application/json"}, 403, "", "Forbidden"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var body []byte
+			if tc.body != "" {
+				body = []byte(tc.body)
+			}
+			statusCode, respBody := helpers.HTTPDoWithRetryPolicy(t, tc.method, outputs.APIURL, body, tc.headers, connRetry)
+			require.Equal(t, tc.status, statusCode, "unexpected response: %s", respBody)
+
+			code, message := errorCodeAndMessage(t, respBody)
+			if tc.code != "" {
+				assert.Equal(t, tc.code, code)
+			} else {
+				assert.NotEmpty(t, code, "gateway errors should carry their response type")
+			}
+			assert.Contains(t, message, tc.message)
+		})
+	}
+}
+
+func TestCORSPreflightAllowsPrivateNetwork(t *testing.T) {
+	t.Parallel()
+
+	const origin = "https://app.example.com"
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"cors_allowed_origins":       []string{origin},
+		"cors_allow_credentials":     true,
+		"cors_allow_private_network": true,
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+
+	// The preflight a browser sends before a credentialed call to a private address
+	var preflight http.Header
+	retry.DoWithRetry(t, "wait for CORS preflight", 10, 5*time.Second, func() (string, error) {
+		req, err := http.NewRequest("OPTIONS", apiURL, nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		req.Header.Set("Access-Control-Request-Private-Network", "true")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("preflight returned %d", resp.StatusCode)
+		}
+		preflight = resp.Header
+		return "", nil
+	})
+
+	assert.Equal(t, "true", preflight.Get("Access-Control-Allow-Private-Network"))
+	assert.Equal(t, "true", preflight.Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, origin, preflight.Get("Access-Control-Allow-Origin"))
+
+	// Credentialed responses must name the origin rather than the wildcard
+	req, err := http.NewRequest("POST", apiURL, strings.NewReader(`{"prompt": "Say hello", "max_tokens": 10}`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Origin", origin)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, origin, resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", resp.Header.Get("Access-Control-Allow-Credentials"))
+}
+
+func TestBedrockRequestFieldMap(t *testing.T) {
+	t.Parallel()
+
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"request_field_map": map[string]string{
+			"question": "prompt",
+			"limit":    "max_tokens",
+		},
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+
+	statusCode, body := postJSON(t, apiURL, map[string]interface{}{
+		"question":      "Name three primary colours",
+		"limit":         15,
+		"client_tracer": "ignored-extra-field",
+	}, nil)
+	require.Equal(t, 200, statusCode, "mapped payload should be accepted: %v", body)
+	assert.NotEmpty(t, body["content"])
+
+	// limit was mapped to max_tokens, so the completion is capped by it
+	usage := body["usage"].(map[string]interface{})
+	assert.LessOrEqual(t, usage["output_tokens"], 15.0)
+}
+
+func TestBedrockPostProcessorsExtractJSON(t *testing.T) {
+	t.Parallel()
+
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"post_processors": []string{"json_extract"},
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+
+	// Ask for prose around the JSON so the raw completion is not parseable
+	statusCode, body := postJSON(t, apiURL, map[string]interface{}{
+		"prompt":     `Write one friendly sentence, then a JSON object {"colour": "<a primary colour>"} in a markdown code block, then one closing sentence.`,
+		"max_tokens": 150,
+	}, nil)
+	require.Equal(t, 200, statusCode, "unexpected response: %v", body)
+
+	content, ok := body["content"].(string)
+	require.True(t, ok, "content should be a string: %v", body)
+
+	var extracted map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(content), &extracted), "content should be only the JSON object: %q", content)
+	assert.Contains(t, extracted, "colour")
+}
+
+func TestBedrockAsyncInvocation(t *testing.T) {
+	t.Parallel()
+
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"enable_async_invocation": true,
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+	resultURL := terraform.Output(t, terraformOptions, "async_result_url")
+	require.NotEmpty(t, terraform.Output(t, terraformOptions, "async_jobs_table_name"))
+
+	statusCode, body := postJSON(t, apiURL, map[string]interface{}{
+		"prompt":     "Name a primary colour",
+		"max_tokens": 20,
+		"async":      true,
+	}, nil)
+	require.Equal(t, 202, statusCode, "async request should be accepted: %v", body)
+	assert.Equal(t, "pending", body["status"])
+
+	jobID, ok := body["job_id"].(string)
+	require.True(t, ok, "response should carry a job_id: %v", body)
+
+	var result map[string]interface{}
+	retry.DoWithRetry(t, "wait for async result", 20, 5*time.Second, func() (string, error) {
+		statusCode, respBody := helpers.HTTPDoWithRetryPolicy(t, "GET", resultURL+"/"+jobID, nil, nil, helpers.DefaultRetryPolicy())
+		if statusCode != 200 {
+			return "", fmt.Errorf("unexpected status %d: %s", statusCode, respBody)
+		}
+		if err := json.Unmarshal(respBody, &result); err != nil {
+			return "", err
+		}
+		if result["status"] == "pending" {
+			return "", fmt.Errorf("job %s still pending", jobID)
+		}
+		return "", nil
+	})
+
+	assert.Equal(t, "completed", result["status"], "job should complete: %v", result)
+	assert.NotEmpty(t, result["content"])
+
+	statusCode, _ = helpers.HTTPDoWithRetryPolicy(t, "GET", resultURL+"/unknown-job", nil, nil, helpers.DefaultRetryPolicy())
+	assert.Equal(t, 404, statusCode)
+}
+
+func TestBedrockLongGenerationSwitchesToAsync(t *testing.T) {
+	t.Parallel()
+
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"enable_async_invocation":   true,
+		"sync_max_tokens_threshold": 1000,
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+
+	// No "async" flag: the max_tokens alone should send this to the queue
+	statusCode, body := postJSON(t, apiURL, map[string]interface{}{
+		"prompt":     "Write a long story about a lighthouse keeper",
+		"max_tokens": 4000,
+	}, nil)
+	require.Equal(t, 202, statusCode, "long generation should be queued: %v", body)
+	assert.Equal(t, true, body["auto_async"])
+	assert.Equal(t, "pending", body["status"])
+	jobID, ok := body["job_id"].(string)
+	require.True(t, ok, "response should carry a job_id: %v", body)
+	assert.NotEmpty(t, jobID)
+
+	// Short generations are still answered inline
+	statusCode, body = postJSON(t, apiURL, map[string]interface{}{
+		"prompt":     "Say hello",
+		"max_tokens": 10,
+	}, nil)
+	require.Equal(t, 200, statusCode, "unexpected response: %v", body)
+	assert.NotContains(t, body, "job_id")
+}
+
+func TestBedrockBufferedBurstDrainsSteadily(t *testing.T) {
+	t.Parallel()
+
+	const burst = 8
+	const drainRate = 0.5
+
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"enable_async_invocation":  true,
+		"enable_request_buffering": true,
+		"drain_rate_per_second":    drainRate,
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+	resultURL := terraform.Output(t, terraformOptions, "async_result_url")
+	require.NotEmpty(t, terraform.Output(t, terraformOptions, "buffer_queue_url"))
+
+	// The whole burst arrives at once and is accepted without waiting
+	headers := map[string]string{"Content-Type": "application/json"}
+	statusCodes := make([]int, burst)
+	bodies := make([][]byte, burst)
+	errs := make([]error, burst)
+	var wg sync.WaitGroup
+	for i := 0; i < burst; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			payload := []byte(fmt.Sprintf(`{"prompt": "Reply with the number %d", "max_tokens": 10, "buffered": true}`, i))
+			statusCodes[i], bodies[i], errs[i] = helpers.HTTPDoWithRetryPolicyE(t, "POST", apiURL, payload, headers, helpers.DefaultRetryPolicy())
+		}(i)
+	}
+	wg.Wait()
+
+	var jobIDs []string
+	for i := 0; i < burst; i++ {
+		require.NoError(t, errs[i])
+		require.Equal(t, 202, statusCodes[i], "buffered request should be accepted: %s", bodies[i])
+		var accepted map[string]interface{}
+		require.NoError(t, json.Unmarshal(bodies[i], &accepted))
+		assert.Equal(t, true, accepted["buffered"])
+		jobIDs = append(jobIDs, accepted["job_id"].(string))
+	}
+
+	var completedAt []float64
+	for _, jobID := range jobIDs {
+		var result map[string]interface{}
+		retry.DoWithRetry(t, "wait for buffered result", 30, 5*time.Second, func() (string, error) {
+			statusCode, respBody := helpers.HTTPDoWithRetryPolicy(t, "GET", resultURL+"/"+jobID, nil, nil, helpers.DefaultRetryPolicy())
+			if statusCode != 200 {
+				return "", fmt.Errorf("unexpected status %d: %s", statusCode, respBody)
+			}
+			if err := json.Unmarshal(respBody, &result); err != nil {
+				return "", err
+			}
+			if result["status"] == "pending" {
+				return "", fmt.Errorf("job %s still pending", jobID)
+			}
+			return "", nil
+		})
+
+		// Throttling would surface here as a failed job
+		require.Equal(t, "completed", result["status"], "buffered job should complete: %v", result)
+		completedAt = append(completedAt, result["completed_at"].(float64))
+	}
+
+	// Two pollers finish a message together each interval, so at the drain
+	// rate the burst takes at least (burst-2)/rate seconds from first to last
+	// result; a second is allowed for completed_at's resolution
+	first, last := completedAt[0], completedAt[0]
+	for _, at := range completedAt {
+		if at < first {
+			first = at
+		}
+		if at > last {
+			last = at
+		}
+	}
+	assert.GreaterOrEqual(t, last-first, float64(burst-2)/drainRate-1, "the burst should drain at no more than %v per second", drainRate)
+}
+
+func TestBedrockContinuesTruncatedCompletion(t *testing.T) {
+	t.Parallel()
+
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"enable_continuation": true,
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+	require.NotEmpty(t, terraform.Output(t, terraformOptions, "continuation_table_name"))
+
+	statusCode, body := postJSON(t, apiURL, map[string]interface{}{
+		"prompt":     "Count from 1 to 40, separated by commas, with nothing else",
+		"max_tokens": 15,
+	}, nil)
+	require.Equal(t, 200, statusCode, "unexpected response: %v", body)
+	assert.Equal(t, true, body["truncated"], "completion should stop at max_tokens: %v", body)
+	token, ok := body["continuation_token"].(string)
+	require.True(t, ok, "truncated response should carry a continuation_token: %v", body)
+	first := body["content"].(string)
+
+	// The token alone resumes the generation where it stopped
+	statusCode, body = postJSON(t, apiURL, map[string]interface{}{
+		"continuation_token": token,
+		"max_tokens":         300,
+	}, nil)
+	require.Equal(t, 200, statusCode, "unexpected response: %v", body)
+	assert.NotContains(t, body, "truncated")
+	assert.NotContains(t, body, "continuation_token")
+	rest := body["content"].(string)
+	assert.Contains(t, rest, "40")
+	assert.NotContains(t, rest, first, "continuation should not repeat the first part")
+
+	statusCode, _ = postJSON(t, apiURL, map[string]interface{}{
+		"continuation_token": strings.Repeat("x", 32),
+	}, nil)
+	assert.Equal(t, 404, statusCode)
+}
+
+func TestBedrockAcceptTextPlain(t *testing.T) {
+	t.Parallel()
+
+	terraformOptions := moduleTerraformOptions(t, nil)
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+
+	// Give the fresh deployment a chance to warm up before asserting on headers
+	statusCode, body := postJSON(t, apiURL, map[string]interface{}{"prompt": "Say hello", "max_tokens": 10}, nil)
+	require.Equal(t, 200, statusCode, "unexpected response: %v", body)
+
+	req, err := http.NewRequest("POST", apiURL, strings.NewReader(`{"prompt": "Reply with the single word: banana", "max_tokens": 10}`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/plain")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	text, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode, "unexpected response: %s", text)
+	assert.True(t, strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain"), "unexpected content type %q", resp.Header.Get("Content-Type"))
+
+	// The bare completion, not the JSON envelope
+	assert.False(t, json.Valid(text) && strings.HasPrefix(strings.TrimSpace(string(text)), "{"), "body should not be a JSON envelope: %s", text)
+	assert.Contains(t, strings.ToLower(string(text)), "banana")
+}
+
+func TestBedrockContextUtilization(t *testing.T) {
+	t.Parallel()
+
+	// A tiny window makes a short prompt with a large max_tokens cross the warning level
+	modelID := suiteConfig.ModelID
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"bedrock_model_id":      modelID,
+		"model_context_windows": map[string]int{modelID: 1000},
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	window, err := strconv.Atoi(terraform.Output(t, terraformOptions, "context_window_tokens"))
+	require.NoError(t, err)
+	require.Equal(t, 1000, window)
+
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+
+	for _, maxTokens := range []int{10, 950} {
+		statusCode, body := postJSON(t, apiURL, map[string]interface{}{"prompt": "Say hello", "max_tokens": maxTokens}, nil)
+		require.Equal(t, 200, statusCode, "unexpected response: %v", body)
+
+		usage, ok := body["usage"].(map[string]interface{})
+		require.True(t, ok, "usage should be an object: %v", body)
+		inputTokens := usage["input_tokens"].(float64)
+		require.Positive(t, inputTokens)
+
+		expected := (inputTokens + float64(maxTokens)) / float64(window)
+		assert.InDelta(t, expected, body["context_utilization"], 0.0001, "max_tokens %d", maxTokens)
+	}
+
+	// The warning header needs the raw response, which the retry helper does not expose
+	req, err := http.NewRequest("POST", apiURL, strings.NewReader(`{"prompt": "Say hello", "max_tokens": 950}`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get("X-Context-Window-Warning"), "utilization above 90%% should set the warning header")
+}
+
+func TestBedrockEnsembleReturnsAllCompletions(t *testing.T) {
+	t.Parallel()
+
+	defaultModelID := suiteConfig.ModelID
+	fastModelID := "anthropic.claude-3-haiku-20240307-v1:0"
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"model_aliases":   map[string]string{"fast": fastModelID},
+		"enable_ensemble": true,
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+
+	statusCode, body := postJSON(t, apiURL, map[string]interface{}{
+		"prompt":     "Name one planet in the solar system",
+		"max_tokens": 20,
+		"ensemble":   []string{"fast", defaultModelID},
+	}, nil)
+	require.Equal(t, 200, statusCode, "unexpected response: %v", body)
+
+	completions, ok := body["completions"].([]interface{})
+	require.True(t, ok, "completions should be a list: %v", body)
+	require.Len(t, completions, 2)
+
+	// Completions keep the order of the ensemble list
+	for i, modelID := range []string{fastModelID, defaultModelID} {
+		completion := completions[i].(map[string]interface{})
+		assert.Equal(t, modelID, completion["model_id"])
+		assert.Equal(t, true, completion["success"], "completion from %s failed: %v", modelID, completion)
+		assert.NotEmpty(t, completion["content"])
+	}
+}
+
+func TestBedrockInvalidUTF8Prompt(t *testing.T) {
+	t.Parallel()
+
+	// 0xE9 and 0xFF are Latin-1 bytes that never form valid UTF-8
+	invalidBody := []byte("{\"prompt\": \"Say caf\xe9 \xff hello\", \"max_tokens\": 10}")
+	headers := map[string]string{"Content-Type": "application/json"}
+
+	for _, strip := range []bool{false, true} {
+		strip := strip
+		t.Run(fmt.Sprintf("strip_invalid_chars=%t", strip), func(t *testing.T) {
+			t.Parallel()
+
+			terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+				"strip_invalid_chars": strip,
+			})
+
+			defer destroy(t, terraformOptions)
+			initAndApplyWithRetry(t, terraformOptions)
+
+			apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+			statusCode, raw := helpers.HTTPDoWithRetryPolicy(t, "POST", apiURL, invalidBody, headers, helpers.DefaultRetryPolicy())
+
+			var body map[string]interface{}
+			require.NoError(t, json.Unmarshal(raw, &body), "response should be JSON: %s", raw)
+
+			if strip {
+				require.Equal(t, 200, statusCode, "sanitized prompt should succeed: %v", body)
+				assert.NotEmpty(t, body["content"])
+				return
+			}
+			require.Equal(t, 400, statusCode, "invalid UTF-8 should be a client error: %v", body)
+			assert.Contains(t, body["message"], "UTF-8")
+		})
+	}
+}
+
+func TestBedrockLenientJSON(t *testing.T) {
+	t.Parallel()
+
+	// A misspelled field that strict parsing should catch, plus a trailing comma
+	unknownField := []byte(`{"prompt": "Say hello", "max_token": 10}`)
+	trailingComma := []byte(`{"prompt": "Say hello", "max_tokens": 10,}`)
+	headers := map[string]string{"Content-Type": "application/json"}
+
+	for _, lenient := range []bool{false, true} {
+		lenient := lenient
+		t.Run(fmt.Sprintf("lenient_json=%t", lenient), func(t *testing.T) {
+			t.Parallel()
+
+			terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+				"lenient_json": lenient,
+			})
+
+			defer destroy(t, terraformOptions)
+			initAndApplyWithRetry(t, terraformOptions)
+
+			apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+			for _, payload := range [][]byte{unknownField, trailingComma} {
+				statusCode, raw := helpers.HTTPDoWithRetryPolicy(t, "POST", apiURL, payload, headers, helpers.DefaultRetryPolicy())
+
+				var body map[string]interface{}
+				require.NoError(t, json.Unmarshal(raw, &body), "response should be JSON: %s", raw)
+
+				if lenient {
+					assert.Equal(t, 200, statusCode, "lenient mode should accept %s: %v", payload, body)
+				} else {
+					assert.Equal(t, 400, statusCode, "strict mode should reject %s: %v", payload, body)
+				}
+			}
+		})
+	}
+}
+
+func TestBedrockPromptCacheReadsOnRepeat(t *testing.T) {
+	t.Parallel()
+
+	// Claude 3.7 Sonnet supports prompt caching and is invoked through its
+	// cross-region inference profile, which routes to several regions
+	const modelID = "us.anthropic.claude-3-7-sonnet-20250219-v1:0"
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"bedrock_model_id": modelID,
+		"bedrock_model_arns": []string{
+			"arn:aws:bedrock:" + suiteConfig.Region + ":*:inference-profile/" + modelID,
+			"arn:aws:bedrock:*::foundation-model/anthropic.claude-3-7-sonnet-20250219-v1:0",
+		},
+		"enable_bedrock_prompt_cache": true,
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+
+	// Well above the 1,024-token minimum Bedrock caches for this model
+	system := strings.Repeat("You are a support assistant for a hardware store. Answer briefly and politely. ", 200)
+
+	usage := func(body map[string]interface{}) map[string]interface{} {
+		usage, ok := body["usage"].(map[string]interface{})
+		require.True(t, ok, "usage should be an object: %v", body)
+		return usage
+	}
+
+	statusCode, first := postJSON(t, apiURL, map[string]interface{}{
+		"system":     system,
+		"prompt":     "Do you sell hammers?",
+		"max_tokens": 20,
+	}, nil)
+	require.Equal(t, 200, statusCode, "unexpected response: %v", first)
+	assert.Contains(t, usage(first), "cache_creation_input_tokens")
+
+	// Same system prompt, different question: the prefix is read from the cache
+	statusCode, second := postJSON(t, apiURL, map[string]interface{}{
+		"system":     system,
+		"prompt":     "Do you sell nails?",
+		"max_tokens": 20,
+	}, nil)
+	require.Equal(t, 200, statusCode, "unexpected response: %v", second)
+	assert.Greater(t, usage(second)["cache_read_input_tokens"], 0.0, "second call should read the cached system prompt: %v", second)
+}
+
+func TestPerRouteCompressionSizes(t *testing.T) {
+	t.Parallel()
+
+	// Compress everything on /bedrock, but keep /health responses uncompressed
+	// unless they grow past 10 KB
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"minimum_compression_size": 0,
+		"routes": map[string]interface{}{
+			"health": map[string]interface{}{"minimum_compression_size": 10240},
+		},
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	// Setting Accept-Encoding by hand stops net/http from decompressing and
+	// dropping the Content-Encoding header
+	contentEncoding := func(method string, url string, body string) string {
+		var encoding string
+		retry.DoWithRetry(t, "request "+url, 5, 10*time.Second, func() (string, error) {
+			req, err := http.NewRequest(method, url, strings.NewReader(body))
+			if err != nil {
+				return "", err
+			}
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Accept-Encoding", "gzip")
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return "", err
+			}
+			defer resp.Body.Close()
+			io.Copy(io.Discard, resp.Body)
+			if resp.StatusCode != http.StatusOK {
+				return "", fmt.Errorf("%s returned %d", url, resp.StatusCode)
+			}
+			encoding = resp.Header.Get("Content-Encoding")
+			return "", nil
+		})
+		return encoding
+	}
+
+	// The module has no embeddings route, so a long completion is the large response
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+	assert.Equal(t, "gzip", contentEncoding("POST", apiURL, `{"prompt": "Write a 300 word story about a lighthouse", "max_tokens": 600}`))
+
+	healthURL := terraform.Output(t, terraformOptions, "health_url")
+	assert.NotEqual(t, "gzip", contentEncoding("GET", healthURL, ""))
+}
+
+func TestBedrockConverseAPIStyle(t *testing.T) {
+	t.Parallel()
+
+	const titanModelID = "amazon.titan-text-express-v1"
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"api_style": "converse",
+		"model_aliases": map[string]string{
+			"titan": titanModelID,
+		},
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	assert.Equal(t, "converse", terraform.Output(t, terraformOptions, "api_style"))
+
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+
+	// An Anthropic model and a Titan model answer in the same shape
+	for _, model := range []string{"", "titan"} {
+		payload := map[string]interface{}{
+			"system":     "Answer with a single word.",
+			"prompt":     "What color is the sky on a clear day?",
+			"max_tokens": 20,
+		}
+		if model != "" {
+			payload["model"] = model
+		}
+
+		statusCode, body := postJSON(t, apiURL, payload, nil)
+		require.Equal(t, 200, statusCode, "unexpected response for %q: %v", model, body)
+		assert.Contains(t, strings.ToLower(body["content"].(string)), "blue")
+
+		usage, ok := body["usage"].(map[string]interface{})
+		require.True(t, ok, "usage should be an object: %v", body)
+		assert.Greater(t, usage["input_tokens"], 0.0)
+		assert.Greater(t, usage["output_tokens"], 0.0)
+	}
+}
+
+func TestBedrockPresignedImageUpload(t *testing.T) {
+	t.Parallel()
+
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"enable_presigned_uploads": true,
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	uploadURL := terraform.Output(t, terraformOptions, "upload_url_api_url")
+	statusCode, presigned := postJSON(t, uploadURL, map[string]interface{}{"content_type": "image/png"}, nil)
+	require.Equal(t, 200, statusCode, "unexpected response: %v", presigned)
+	imageKey, ok := presigned["image_key"].(string)
+	require.True(t, ok, "image_key should be a string: %v", presigned)
+
+	// A solid red square is easy for the model to describe
+	canvas := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	draw.Draw(canvas, canvas.Bounds(), &image.Uniform{C: color.RGBA{R: 255, A: 255}}, image.Point{}, draw.Src)
+	var encoded bytes.Buffer
+	require.NoError(t, png.Encode(&encoded, canvas))
+
+	// The upload goes straight to S3, so it must carry the signed Content-Type
+	req, err := http.NewRequest(http.MethodPut, presigned["upload_url"].(string), bytes.NewReader(encoded.Bytes()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "image/png")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	uploadBody, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.Equal(t, 200, resp.StatusCode, "upload failed: %s", uploadBody)
+
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+	statusCode, body := postJSON(t, apiURL, map[string]interface{}{
+		"prompt":     "What single color fills this image? Answer with one word.",
+		"image_keys": []string{imageKey},
+		"max_tokens": 20,
+	}, nil)
+	require.Equal(t, 200, statusCode, "unexpected response: %v", body)
+	assert.Contains(t, strings.ToLower(body["content"].(string)), "red")
+
+	// Keys are scoped to the uploading tenant and can't name arbitrary objects
+	statusCode, body = postJSON(t, apiURL, map[string]interface{}{
+		"prompt":     "Describe this image",
+		"image_keys": []string{"uploads/other-tenant/" + strings.Repeat("0", 32) + ".png"},
+	}, nil)
+	assert.Equal(t, 400, statusCode, "foreign image key should be rejected: %v", body)
 }
@@ -0,0 +1,67 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestArchivalPartitionsByDateAndModel(t *testing.T) {
+	t.Parallel()
+
+	modelID := suiteConfig.ModelID
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"bedrock_model_id": modelID,
+		"enable_archival":  true,
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	bucket := terraform.Output(t, terraformOptions, "archive_bucket_name")
+	client := s3.NewFromConfig(awsConfig(t))
+	ctx := context.Background()
+
+	// Archived records must be removed before the bucket can be destroyed
+	defer func() {
+		listed, err := client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{Bucket: aws.String(bucket)})
+		if err != nil {
+			return
+		}
+		for _, object := range listed.Contents {
+			client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: object.Key})
+		}
+	}()
+
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+	statusCode, body := postJSON(t, apiURL, map[string]interface{}{"prompt": "Say hello", "max_tokens": 10}, nil)
+	require.Equal(t, 200, statusCode, "unexpected response: %v", body)
+	requestID := body["metadata"].(map[string]interface{})["request_id"].(string)
+
+	// Partitions use the UTC date the handler archived the request on
+	key := fmt.Sprintf("%s/%s/%s.json", time.Now().UTC().Format("2006/01/02"), modelID, requestID)
+	out, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	require.NoError(t, err, "archive record should exist at %s", key)
+	defer out.Body.Close()
+
+	raw, err := io.ReadAll(out.Body)
+	require.NoError(t, err)
+
+	var record map[string]interface{}
+	require.NoError(t, json.Unmarshal(raw, &record))
+	assert.Equal(t, modelID, record["model_id"])
+	assert.Equal(t, "Say hello", record["prompt"])
+	assert.Equal(t, body["content"], record["response"])
+	assert.Equal(t, true, record["success"])
+	assert.NotEmpty(t, record["usage"])
+	assert.Greater(t, record["latency_ms"], 0.0)
+}
@@ -0,0 +1,182 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	cognitotypes "github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/catherinevee/tfm-aws-ai-bedrock/test/helpers"
+	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// authPrompt is the body every authenticated request sends
+var authPrompt = map[string]interface{}{"prompt": "Reply with the single word: ok", "max_tokens": 10}
+
+// signedHeaders signs a JSON POST of payload to url with SigV4 for
+// execute-api and returns the headers to send it with. postJSON marshals the
+// payload the same way, so the signed body matches the one sent.
+func signedHeaders(t *testing.T, cfg aws.Config, url string, payload interface{}) map[string]string {
+	ctx := context.Background()
+	body, err := json.Marshal(payload)
+	require.NoError(t, err)
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+
+	credentials, err := cfg.Credentials.Retrieve(ctx)
+	require.NoError(t, err)
+	hash := sha256.Sum256(body)
+	require.NoError(t, v4.NewSigner().SignHTTP(ctx, credentials, req, hex.EncodeToString(hash[:]), "execute-api", cfg.Region, time.Now()))
+
+	headers := map[string]string{}
+	for key := range req.Header {
+		headers[key] = req.Header.Get(key)
+	}
+	return headers
+}
+
+// cognitoTestUser creates a user pool with one confirmed user and an app
+// client that allows password sign-in. It returns the pool ARN and a
+// function that signs the user in and returns an ID token.
+func cognitoTestUser(t *testing.T, name string) (string, func() string) {
+	ctx := context.Background()
+	client := cognitoidentityprovider.NewFromConfig(awsConfig(t))
+
+	pool, err := client.CreateUserPool(ctx, &cognitoidentityprovider.CreateUserPoolInput{PoolName: aws.String(name)})
+	require.NoError(t, err)
+	poolID := pool.UserPool.Id
+	t.Cleanup(func() {
+		if _, err := client.DeleteUserPool(context.Background(), &cognitoidentityprovider.DeleteUserPoolInput{UserPoolId: poolID}); err != nil {
+			t.Logf("deleting user pool %s: %v", aws.ToString(poolID), err)
+		}
+	})
+
+	app, err := client.CreateUserPoolClient(ctx, &cognitoidentityprovider.CreateUserPoolClientInput{
+		UserPoolId: poolID,
+		ClientName: aws.String(name),
+		ExplicitAuthFlows: []cognitotypes.ExplicitAuthFlowsType{
+			cognitotypes.ExplicitAuthFlowsTypeAllowUserPasswordAuth,
+			cognitotypes.ExplicitAuthFlowsTypeAllowRefreshTokenAuth,
+		},
+	})
+	require.NoError(t, err)
+
+	username := "terratest"
+	password := "Tt-" + random.UniqueId() + "-" + random.UniqueId() + "a1"
+	_, err = client.AdminCreateUser(ctx, &cognitoidentityprovider.AdminCreateUserInput{
+		UserPoolId:    poolID,
+		Username:      aws.String(username),
+		MessageAction: cognitotypes.MessageActionTypeSuppress,
+	})
+	require.NoError(t, err)
+	_, err = client.AdminSetUserPassword(ctx, &cognitoidentityprovider.AdminSetUserPasswordInput{
+		UserPoolId: poolID,
+		Username:   aws.String(username),
+		Password:   aws.String(password),
+		Permanent:  true,
+	})
+	require.NoError(t, err)
+
+	signIn := func() string {
+		auth, err := client.InitiateAuth(context.Background(), &cognitoidentityprovider.InitiateAuthInput{
+			AuthFlow:       cognitotypes.AuthFlowTypeUserPasswordAuth,
+			ClientId:       app.UserPoolClient.ClientId,
+			AuthParameters: map[string]string{"USERNAME": username, "PASSWORD": password},
+		})
+		require.NoError(t, err)
+		require.NotNil(t, auth.AuthenticationResult, "sign-in should not need a challenge")
+		return aws.ToString(auth.AuthenticationResult.IdToken)
+	}
+	return aws.ToString(pool.UserPool.Arn), signIn
+}
+
+func TestAPIAuthentication(t *testing.T) {
+	t.Parallel()
+
+	// Each mode is its own deployment. Rejections come from API Gateway, so
+	// only the accepted request in each subtest reaches Bedrock.
+	t.Run("api_key", func(t *testing.T) {
+		t.Parallel()
+
+		terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+			"enable_api_key": true,
+		})
+		defer destroy(t, terraformOptions)
+		initAndApplyWithRetry(t, terraformOptions)
+		outputs := helpers.GetStackOutputs(t, terraformOptions)
+		require.NotEmpty(t, outputs.APIKey)
+
+		statusCode, body := postJSON(t, outputs.APIURL, authPrompt, nil)
+		assert.Equal(t, http.StatusForbidden, statusCode, "a request without a key should be rejected: %v", body)
+
+		statusCode, body = postJSON(t, outputs.APIURL, authPrompt, map[string]string{"x-api-key": "not-" + outputs.APIKey})
+		assert.Equal(t, http.StatusForbidden, statusCode, "a request with the wrong key should be rejected: %v", body)
+
+		statusCode, body = postJSON(t, outputs.APIURL, authPrompt, map[string]string{"x-api-key": outputs.APIKey})
+		require.Equal(t, http.StatusOK, statusCode, "unexpected response: %v", body)
+		assert.NotEmpty(t, body["content"])
+	})
+
+	t.Run("iam", func(t *testing.T) {
+		t.Parallel()
+
+		terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+			"auth_type": "AWS_IAM",
+		})
+		defer destroy(t, terraformOptions)
+		initAndApplyWithRetry(t, terraformOptions)
+		outputs := helpers.GetStackOutputs(t, terraformOptions)
+		assert.True(t, outputs.Features["iam_auth"])
+
+		// API Gateway answers unsigned requests with "Missing Authentication Token"
+		statusCode, body := postJSON(t, outputs.APIURL, authPrompt, nil)
+		assert.Equal(t, http.StatusForbidden, statusCode, "an unsigned request should be rejected: %v", body)
+
+		// A signature over a different body doesn't match
+		tampered := signedHeaders(t, awsConfig(t), outputs.APIURL, map[string]interface{}{"prompt": "something else"})
+		statusCode, body = postJSON(t, outputs.APIURL, authPrompt, tampered)
+		assert.Equal(t, http.StatusForbidden, statusCode, "a request signed over another body should be rejected: %v", body)
+
+		statusCode, body = postJSON(t, outputs.APIURL, authPrompt, signedHeaders(t, awsConfig(t), outputs.APIURL, authPrompt))
+		require.Equal(t, http.StatusOK, statusCode, "unexpected response: %v", body)
+		assert.NotEmpty(t, body["content"])
+	})
+
+	t.Run("cognito", func(t *testing.T) {
+		t.Parallel()
+
+		// The pool is created first and deleted last, after the API that
+		// refers to it is destroyed
+		poolARN, signIn := cognitoTestUser(t, testNamePrefix+random.UniqueId())
+		terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+			"auth_type":             "COGNITO",
+			"cognito_user_pool_arn": poolARN,
+		})
+		defer destroy(t, terraformOptions)
+		initAndApplyWithRetry(t, terraformOptions)
+		outputs := helpers.GetStackOutputs(t, terraformOptions)
+		assert.True(t, outputs.Features["cognito_auth"])
+
+		statusCode, body := postJSON(t, outputs.APIURL, authPrompt, nil)
+		assert.Equal(t, http.StatusUnauthorized, statusCode, "a request without a token should be rejected: %v", body)
+
+		statusCode, body = postJSON(t, outputs.APIURL, authPrompt, map[string]string{"Authorization": "eyJhbGciOiJub25lIn0.e30."})
+		assert.Equal(t, http.StatusUnauthorized, statusCode, "an unsigned token should be rejected: %v", body)
+
+		statusCode, body = postJSON(t, outputs.APIURL, authPrompt, map[string]string{"Authorization": signIn()})
+		require.Equal(t, http.StatusOK, statusCode, "unexpected response: %v", body)
+		assert.NotEmpty(t, body["content"])
+	})
+}
@@ -0,0 +1,114 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrock"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// batchInputRecords builds a JSONL batch input in the Anthropic messages
+// format. Bedrock rejects batch jobs with fewer than 100 records.
+func batchInputRecords(count int) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < count; i++ {
+		record, _ := json.Marshal(map[string]interface{}{
+			"recordId": fmt.Sprintf("record-%03d", i),
+			"modelInput": map[string]interface{}{
+				"anthropic_version": "bedrock-2023-05-31",
+				"max_tokens":        10,
+				"messages": []map[string]interface{}{
+					{"role": "user", "content": fmt.Sprintf("Reply with the number %d", i)},
+				},
+			},
+		})
+		buf.Write(record)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+func TestBatchSubmissionsQueueAtJobLimit(t *testing.T) {
+	t.Parallel()
+
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"enable_batch_inference":    true,
+		"max_concurrent_batch_jobs": 1,
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	batchURL := terraform.Output(t, terraformOptions, "batch_api_url")
+	bucket := terraform.Output(t, terraformOptions, "batch_bucket_name")
+	queueURL := terraform.Output(t, terraformOptions, "batch_queue_url")
+
+	cfg := awsConfig(t)
+	ctx := context.Background()
+	s3Client := s3.NewFromConfig(cfg)
+	bedrockClient := bedrock.NewFromConfig(cfg)
+
+	// Job results land under output/ and must be removed before the bucket can be destroyed
+	defer func() {
+		listed, err := s3Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{Bucket: aws.String(bucket)})
+		if err != nil {
+			return
+		}
+		for _, object := range listed.Contents {
+			s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: object.Key})
+		}
+	}()
+
+	inputKey := "input/batch-test.jsonl"
+	_, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(inputKey),
+		Body:        bytes.NewReader(batchInputRecords(100)),
+		ContentType: aws.String("application/jsonl"),
+	})
+	require.NoError(t, err)
+
+	// With a limit of one, at most the first submission starts a job
+	queued := 0
+	for i := 0; i < 3; i++ {
+		statusCode, body := postJSON(t, batchURL, map[string]interface{}{"input_key": inputKey}, nil)
+		require.Equal(t, 202, statusCode, "unexpected response: %v", body)
+
+		if jobARN, ok := body["job_arn"].(string); ok {
+			defer bedrockClient.StopModelInvocationJob(ctx, &bedrock.StopModelInvocationJobInput{JobIdentifier: aws.String(jobARN)})
+		}
+		if body["queued"] == true {
+			queued++
+		}
+	}
+	assert.GreaterOrEqual(t, queued, 2, "submissions over the job limit should be queued")
+
+	// Queued submissions wait in SQS, in flight or not, until a slot frees up
+	attributes, err := sqs.NewFromConfig(cfg).GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl: aws.String(queueURL),
+		AttributeNames: []sqstypes.QueueAttributeName{
+			sqstypes.QueueAttributeNameApproximateNumberOfMessages,
+			sqstypes.QueueAttributeNameApproximateNumberOfMessagesNotVisible,
+		},
+	})
+	require.NoError(t, err)
+
+	waiting := 0
+	for _, name := range []string{"ApproximateNumberOfMessages", "ApproximateNumberOfMessagesNotVisible"} {
+		count, err := strconv.Atoi(attributes.Attributes[name])
+		require.NoError(t, err)
+		waiting += count
+	}
+	assert.GreaterOrEqual(t, waiting, queued)
+}
@@ -0,0 +1,140 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/catherinevee/tfm-aws-ai-bedrock/test/helpers"
+	"github.com/gruntwork-io/terratest/modules/retry"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestBedrockThrottlingResilience fails every Bedrock call with an injected
+// ThrottlingException, since real throttling can't be induced on demand
+// without a load large enough to disturb the rest of the account
+func TestBedrockThrottlingResilience(t *testing.T) {
+	t.Parallel()
+
+	// A short timeout keeps the async queue's visibility timeout, and so each
+	// redelivery, to a minute
+	const maxReceiveCount = 2
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"enable_monitoring":        true,
+		"enable_async_invocation":  true,
+		"async_max_receive_count":  maxReceiveCount,
+		"lambda_timeout":           10,
+		"throttle_alarm_threshold": 0,
+		"handler_fault_injection": map[string]interface{}{
+			"throttle_percent": 100,
+		},
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	outputs := helpers.GetStackOutputs(t, terraformOptions)
+	resultURL := terraform.Output(t, terraformOptions, "async_result_url")
+	dlqURL := terraform.Output(t, terraformOptions, "async_dlq_url")
+	cfg := awsConfig(t)
+
+	t.Run("surfaces_429_with_retry_after", func(t *testing.T) {
+		req, err := http.NewRequest("POST", outputs.APIURL, strings.NewReader(`{"prompt": "Say hello", "max_tokens": 10}`))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+		retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+		require.NoError(t, err, "a throttled response should say when to retry")
+		assert.Positive(t, retryAfter)
+
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		assert.Equal(t, false, body["success"])
+		assert.Equal(t, "ModelThrottled", body["error"].(map[string]interface{})["code"])
+	})
+
+	t.Run("parks_async_job_in_dlq", func(t *testing.T) {
+		statusCode, body := postJSON(t, outputs.APIURL, map[string]interface{}{
+			"prompt":     "Name a primary colour",
+			"max_tokens": 20,
+			"async":      true,
+		}, nil)
+		require.Equal(t, 202, statusCode, "async request should be accepted: %v", body)
+		jobID := body["job_id"].(string)
+
+		// Each throttled attempt is reported as a batch item failure, so the
+		// message is redelivered until SQS moves it aside
+		client := sqs.NewFromConfig(cfg)
+		var message sqstypes.Message
+		retry.DoWithRetry(t, "wait for the job in the DLQ", 20, 15*time.Second, func() (string, error) {
+			out, err := client.ReceiveMessage(context.Background(), &sqs.ReceiveMessageInput{
+				QueueUrl:                    aws.String(dlqURL),
+				MaxNumberOfMessages:         1,
+				WaitTimeSeconds:             10,
+				MessageSystemAttributeNames: []sqstypes.MessageSystemAttributeName{sqstypes.MessageSystemAttributeNameApproximateReceiveCount},
+			})
+			if err != nil {
+				return "", err
+			}
+			if len(out.Messages) == 0 {
+				return "", fmt.Errorf("DLQ is still empty")
+			}
+			message = out.Messages[0]
+			return "", nil
+		})
+
+		var queued map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(aws.ToString(message.Body)), &queued))
+		assert.Equal(t, jobID, queued["job_id"])
+
+		// The DLQ receive is counted too
+		received, err := strconv.Atoi(message.Attributes[string(sqstypes.MessageSystemAttributeNameApproximateReceiveCount)])
+		require.NoError(t, err)
+		assert.Greater(t, received, maxReceiveCount, "the worker should have retried the job before it was moved")
+
+		statusCode, respBody := helpers.HTTPDoWithRetryPolicy(t, "GET", resultURL+"/"+jobID, nil, nil, helpers.DefaultRetryPolicy())
+		require.Equal(t, 200, statusCode)
+		var result map[string]interface{}
+		require.NoError(t, json.Unmarshal(respBody, &result))
+		assert.Equal(t, "pending", result["status"], "a parked job should not be reported as failed")
+	})
+
+	t.Run("fires_throttle_alarm", func(t *testing.T) {
+		client := cloudwatch.NewFromConfig(cfg)
+		alarmName := terraformOptions.Vars["name_prefix"].(string) + "-bedrock-throttles"
+
+		// EMF metrics take a few minutes to reach the alarm
+		retry.DoWithRetry(t, "wait for the throttle alarm", 20, 30*time.Second, func() (string, error) {
+			out, err := client.DescribeAlarms(context.Background(), &cloudwatch.DescribeAlarmsInput{
+				AlarmNames: []string{alarmName},
+			})
+			if err != nil {
+				return "", err
+			}
+			if len(out.MetricAlarms) != 1 {
+				return "", fmt.Errorf("alarm %s not found", alarmName)
+			}
+			if state := out.MetricAlarms[0].StateValue; state != cwtypes.StateValueAlarm {
+				return "", fmt.Errorf("alarm %s is %s", alarmName, state)
+			}
+			return "", nil
+		})
+	})
+}
@@ -0,0 +1,349 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/catherinevee/tfm-aws-ai-bedrock/test/helpers"
+	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConversationFieldEncryption(t *testing.T) {
+	t.Parallel()
+
+	// Field encryption needs the cryptography package from a Lambda layer
+	layerARN := os.Getenv("TEST_CRYPTOGRAPHY_LAYER_ARN")
+	if layerARN == "" {
+		t.Skip("TEST_CRYPTOGRAPHY_LAYER_ARN not set")
+	}
+
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"enable_conversation_history":   true,
+		"conversation_field_encryption": true,
+		"lambda_layers":                 []string{layerARN},
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+	tableName := terraform.Output(t, terraformOptions, "conversation_table_name")
+	sessionID := "session-" + random.UniqueId()
+	prompt := "My favourite colour is teal. Please remember it."
+
+	statusCode, body := postJSON(t, apiURL, map[string]interface{}{
+		"prompt":     prompt,
+		"max_tokens": 50,
+		"session_id": sessionID,
+	}, nil)
+	require.Equal(t, 200, statusCode, "unexpected response: %v", body)
+
+	// Reading back through the API must decrypt the stored history
+	statusCode, body = postJSON(t, apiURL, map[string]interface{}{
+		"prompt":     "What is my favourite colour? Answer with one word.",
+		"max_tokens": 20,
+		"session_id": sessionID,
+	}, nil)
+	require.Equal(t, 200, statusCode, "unexpected response: %v", body)
+	assert.Contains(t, strings.ToLower(body["content"].(string)), "teal")
+
+	// The raw item must only hold ciphertext
+	client := dynamodb.NewFromConfig(awsConfig(t))
+	item, err := client.GetItem(context.Background(), &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"session_id": &types.AttributeValueMemberS{Value: sessionID},
+		},
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, item.Item, "conversation should be stored")
+	assert.Contains(t, item.Item, "encrypted_data_key")
+
+	messages, ok := item.Item["messages"].(*types.AttributeValueMemberL)
+	require.True(t, ok, "messages should be a list")
+	require.Len(t, messages.Value, 4)
+	for _, message := range messages.Value {
+		fields := message.(*types.AttributeValueMemberM).Value
+		content := fields["content"].(*types.AttributeValueMemberS).Value
+		assert.NotContains(t, content, "teal")
+		assert.NotContains(t, content, "colour")
+	}
+}
+
+func TestConversationSummarization(t *testing.T) {
+	t.Parallel()
+
+	const maxTurns = 4
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"enable_conversation_history": true,
+		"max_conversation_turns":      maxTurns,
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	summarization := terraform.OutputMapOfObjects(t, terraformOptions, "conversation_summarization")
+	assert.EqualValues(t, maxTurns, summarization["max_turns"])
+	assert.NotEmpty(t, summarization["summarization_model_id"])
+
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+	tableName := terraform.Output(t, terraformOptions, "conversation_table_name")
+	sessionID := "session-" + random.UniqueId()
+
+	// One turn past the limit triggers summarization of the older turns
+	var prompts []string
+	for turn := 1; turn <= maxTurns+1; turn++ {
+		prompt := fmt.Sprintf("Turn %d: remember the code word %s-%d. Reply with OK.", turn, sessionID, turn)
+		prompts = append(prompts, prompt)
+
+		statusCode, body := postJSON(t, apiURL, map[string]interface{}{
+			"prompt":     prompt,
+			"max_tokens": 10,
+			"session_id": sessionID,
+		}, nil)
+		require.Equal(t, 200, statusCode, "unexpected response on turn %d: %v", turn, body)
+	}
+
+	client := dynamodb.NewFromConfig(awsConfig(t))
+	item, err := client.GetItem(context.Background(), &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"session_id": &types.AttributeValueMemberS{Value: sessionID},
+		},
+	})
+	require.NoError(t, err)
+
+	messages, ok := item.Item["messages"].(*types.AttributeValueMemberL)
+	require.True(t, ok, "messages should be a list")
+
+	var contents []string
+	for _, message := range messages.Value {
+		fields := message.(*types.AttributeValueMemberM).Value
+		contents = append(contents, fields["content"].(*types.AttributeValueMemberS).Value)
+	}
+
+	// A summary pair followed by the most recent half of the limit, verbatim
+	recentTurns := maxTurns / 2
+	require.Len(t, contents, 2+recentTurns*2)
+	assert.True(t, strings.HasPrefix(contents[0], "Summary of earlier conversation: "), "first message should be the summary: %q", contents[0])
+	for i, prompt := range prompts[len(prompts)-recentTurns:] {
+		assert.Equal(t, prompt, contents[2+i*2], "recent turns should be kept verbatim")
+	}
+	for _, prompt := range prompts[:len(prompts)-recentTurns] {
+		assert.NotContains(t, contents, prompt, "older turns should be replaced by the summary")
+	}
+}
+
+func TestConversationSessionLockingKeepsConcurrentTurns(t *testing.T) {
+	t.Parallel()
+
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"enable_conversation_history": true,
+		"enable_session_locking":      true,
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+	tableName := terraform.Output(t, terraformOptions, "conversation_table_name")
+	sessionID := "session-" + random.UniqueId()
+	headers := map[string]string{"Content-Type": "application/json"}
+
+	// Warm up on another session so cold starts don't serialize the burst
+	statusCode, body := postJSON(t, apiURL, map[string]interface{}{"prompt": "Say hello", "max_tokens": 10, "session_id": sessionID + "-warmup"}, nil)
+	require.Equal(t, 200, statusCode, "unexpected response: %v", body)
+
+	// Every request reads the same empty history, so without locking the
+	// last one to finish would overwrite the others
+	const concurrent = 5
+	prompts := make([]string, concurrent)
+	statusCodes := make([]int, concurrent)
+	errs := make([]error, concurrent)
+	var wg sync.WaitGroup
+	for i := range prompts {
+		prompts[i] = fmt.Sprintf("Note %d for %s. Reply with OK.", i, sessionID)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			payload := []byte(fmt.Sprintf(`{"prompt": %q, "max_tokens": 10, "session_id": %q}`, prompts[i], sessionID))
+			statusCodes[i], _, errs[i] = helpers.HTTPDoWithRetryPolicyE(t, "POST", apiURL, payload, headers, helpers.DefaultRetryPolicy())
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+
+	var saved []string
+	for i, statusCode := range statusCodes {
+		require.Contains(t, []int{200, 409}, statusCode, "request %d should be saved or report a conflict", i)
+		if statusCode == 200 {
+			saved = append(saved, prompts[i])
+		}
+	}
+	require.NotEmpty(t, saved, "at least one turn should be saved")
+
+	client := dynamodb.NewFromConfig(awsConfig(t))
+	item, err := client.GetItem(context.Background(), &dynamodb.GetItemInput{
+		TableName:      aws.String(tableName),
+		ConsistentRead: aws.Bool(true),
+		Key: map[string]types.AttributeValue{
+			"session_id": &types.AttributeValueMemberS{Value: sessionID},
+		},
+	})
+	require.NoError(t, err)
+
+	messages, ok := item.Item["messages"].(*types.AttributeValueMemberL)
+	require.True(t, ok, "messages should be a list")
+
+	var userPrompts []string
+	for _, message := range messages.Value {
+		fields := message.(*types.AttributeValueMemberM).Value
+		if fields["role"].(*types.AttributeValueMemberS).Value == "user" {
+			userPrompts = append(userPrompts, fields["content"].(*types.AttributeValueMemberS).Value)
+		}
+	}
+
+	// Every answered turn is stored exactly once, and each save bumped the version
+	assert.ElementsMatch(t, saved, userPrompts, "no answered turn should be lost")
+	assert.Len(t, messages.Value, 2*len(saved))
+	version, ok := item.Item["version"].(*types.AttributeValueMemberN)
+	require.True(t, ok, "version should be a number")
+	assert.Equal(t, fmt.Sprint(len(saved)), version.Value)
+}
+
+// conversationContents returns the stored content of each message in a
+// session's history, in order
+func conversationContents(t *testing.T, item map[string]types.AttributeValue) []string {
+	messages, ok := item["messages"].(*types.AttributeValueMemberL)
+	require.True(t, ok, "messages should be a list")
+
+	var contents []string
+	for _, message := range messages.Value {
+		fields := message.(*types.AttributeValueMemberM).Value
+		contents = append(contents, fields["content"].(*types.AttributeValueMemberS).Value)
+	}
+	return contents
+}
+
+func TestConversationMultiTurnSession(t *testing.T) {
+	t.Parallel()
+
+	const ttlDays = 2
+	const maxBytes = 4096
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"api_style":                   "converse",
+		"enable_conversation_history": true,
+		"conversation_ttl_days":       ttlDays,
+		"max_conversation_bytes":      maxBytes,
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+	tableName := terraform.Output(t, terraformOptions, "conversation_table_name")
+	client := dynamodb.NewFromConfig(awsConfig(t))
+	getSession := func(t *testing.T, sessionID string) map[string]types.AttributeValue {
+		item, err := client.GetItem(context.Background(), &dynamodb.GetItemInput{
+			TableName:      aws.String(tableName),
+			ConsistentRead: aws.Bool(true),
+			Key: map[string]types.AttributeValue{
+				"session_id": &types.AttributeValueMemberS{Value: sessionID},
+			},
+		})
+		require.NoError(t, err)
+		require.NotEmpty(t, item.Item, "session %s should be stored", sessionID)
+		return item.Item
+	}
+
+	// An unusual name the model can't guess without the earlier turn
+	const name = "Thessaly"
+	const question = "What is my name? Answer with one word."
+	sessionID := "session-" + random.UniqueId()
+
+	t.Run("remembers_earlier_turn", func(t *testing.T) {
+		savedAt := time.Now()
+		statusCode, body := postJSON(t, apiURL, map[string]interface{}{
+			"prompt":     "My name is " + name + ". Reply with OK.",
+			"max_tokens": 10,
+			"session_id": sessionID,
+		}, nil)
+		require.Equal(t, 200, statusCode, "unexpected response: %v", body)
+
+		statusCode, body = postJSON(t, apiURL, map[string]interface{}{
+			"prompt":     question,
+			"max_tokens": 20,
+			"session_id": sessionID,
+		}, nil)
+		require.Equal(t, 200, statusCode, "unexpected response: %v", body)
+		assert.Contains(t, strings.ToLower(body["content"].(string)), strings.ToLower(name))
+
+		item := getSession(t, sessionID)
+		assert.Len(t, conversationContents(t, item), 4, "both turns should be stored")
+
+		expiresAt, ok := item["expires_at"].(*types.AttributeValueMemberN)
+		require.True(t, ok, "expires_at should be a number")
+		expiry, err := strconv.ParseInt(expiresAt.Value, 10, 64)
+		require.NoError(t, err)
+		want := savedAt.Add(ttlDays * 24 * time.Hour)
+		assert.WithinDuration(t, want, time.Unix(expiry, 0), 5*time.Minute, "expires_at should be conversation_ttl_days after the last turn")
+	})
+
+	t.Run("table_expires_sessions", func(t *testing.T) {
+		ttl, err := client.DescribeTimeToLive(context.Background(), &dynamodb.DescribeTimeToLiveInput{TableName: aws.String(tableName)})
+		require.NoError(t, err)
+		assert.Equal(t, types.TimeToLiveStatusEnabled, ttl.TimeToLiveDescription.TimeToLiveStatus)
+		assert.Equal(t, "expires_at", aws.ToString(ttl.TimeToLiveDescription.AttributeName))
+	})
+
+	t.Run("new_session_starts_clean", func(t *testing.T) {
+		statusCode, body := postJSON(t, apiURL, map[string]interface{}{
+			"prompt":     question + " If you don't know, answer unknown.",
+			"max_tokens": 20,
+			"session_id": "session-" + random.UniqueId(),
+		}, nil)
+		require.Equal(t, 200, statusCode, "unexpected response: %v", body)
+		assert.NotContains(t, strings.ToLower(body["content"].(string)), strings.ToLower(name))
+	})
+
+	t.Run("trims_history_to_byte_limit", func(t *testing.T) {
+		// Each turn is over a quarter of the limit, so the oldest must go
+		trimmedID := "session-" + random.UniqueId()
+		padding := strings.Repeat("lorem ipsum ", 100)
+		var prompts []string
+		for turn := 1; turn <= 4; turn++ {
+			prompt := fmt.Sprintf("Turn %d. Ignore this filler and reply with OK: %s", turn, padding)
+			prompts = append(prompts, prompt)
+			statusCode, body := postJSON(t, apiURL, map[string]interface{}{
+				"prompt":     prompt,
+				"max_tokens": 10,
+				"session_id": trimmedID,
+			}, nil)
+			require.Equal(t, 200, statusCode, "unexpected response on turn %d: %v", turn, body)
+		}
+
+		contents := conversationContents(t, getSession(t, trimmedID))
+		size := 0
+		for _, content := range contents {
+			size += len(content)
+		}
+		assert.LessOrEqual(t, size, maxBytes, "stored history should fit max_conversation_bytes")
+		require.GreaterOrEqual(t, len(contents), 2)
+		assert.Equal(t, prompts[len(prompts)-1], contents[len(contents)-2], "the latest turn should be kept")
+		assert.NotContains(t, contents, prompts[0], "the oldest turn should be dropped")
+	})
+}
@@ -0,0 +1,54 @@
+package test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/catherinevee/tfm-aws-ai-bedrock/test/costestimate"
+	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/require"
+)
+
+// exampleBudgets is the most each example may cost per month while idle.
+// The knowledge base example pays for its OpenSearch Serverless collection
+// even with no documents; everything else bills mostly by use.
+var exampleBudgets = map[string]float64{
+	"basic":          25,
+	"advanced":       50,
+	"enterprise":     150,
+	"agent":          25,
+	"knowledge-base": 400,
+	"private":        25,
+}
+
+func TestExampleCostEstimates(t *testing.T) {
+	t.Parallel()
+
+	for example, budget := range exampleBudgets {
+		example, budget := example, budget
+		t.Run(example, func(t *testing.T) {
+			t.Parallel()
+
+			// Plan-only, so nothing is billed. Only the newer examples take
+			// name_prefix and region.
+			var vars map[string]interface{}
+			if example != "basic" && example != "advanced" && example != "enterprise" {
+				vars = map[string]interface{}{
+					"name_prefix": testNamePrefix + strings.ToLower(random.UniqueId()),
+					"region":      suiteConfig.Region,
+				}
+			}
+			options := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
+				TerraformDir: "../examples/" + example,
+				Vars:         vars,
+				PlanFilePath: filepath.Join(t.TempDir(), "tfplan"),
+			})
+
+			estimate, err := costestimate.FromPlanJSON([]byte(terraform.InitAndPlanAndShow(t, options)))
+			require.NoError(t, err)
+			estimate.AssertWithinBudget(t, costestimate.BudgetFromEnv(t, "BEDROCK_TEST_MAX_MONTHLY_COST", budget))
+		})
+	}
+}
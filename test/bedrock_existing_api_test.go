@@ -0,0 +1,64 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/apigateway"
+	"github.com/aws/aws-sdk-go-v2/service/apigateway/types"
+	"github.com/catherinevee/tfm-aws-ai-bedrock/test/helpers"
+	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttachToExistingRestAPI(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	client := apigateway.NewFromConfig(awsConfig(t))
+
+	// Stand in for an API owned by another team
+	existing, err := client.CreateRestApi(ctx, &apigateway.CreateRestApiInput{
+		Name:                  aws.String(fmt.Sprintf("bedrock-test-existing-%s", random.UniqueId())),
+		EndpointConfiguration: &types.EndpointConfiguration{Types: []types.EndpointType{types.EndpointTypeRegional}},
+	})
+	require.NoError(t, err)
+	defer client.DeleteRestApi(ctx, &apigateway.DeleteRestApiInput{RestApiId: existing.Id})
+
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"existing_rest_api_id":      aws.ToString(existing.Id),
+		"existing_root_resource_id": aws.ToString(existing.RootResourceId),
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	assert.Equal(t, aws.ToString(existing.Id), terraform.Output(t, terraformOptions, "api_gateway_rest_api_id"))
+
+	routePath := terraform.Output(t, terraformOptions, "api_route_path")
+	assert.Equal(t, "/bedrock", routePath)
+
+	resources, err := client.GetResources(ctx, &apigateway.GetResourcesInput{
+		RestApiId: existing.Id,
+		Embed:     []string{"methods"},
+	})
+	require.NoError(t, err)
+
+	var route *types.Resource
+	for i := range resources.Items {
+		if aws.ToString(resources.Items[i].Path) == routePath {
+			route = &resources.Items[i]
+		}
+	}
+	require.NotNil(t, route, "route %s should exist on the existing API", routePath)
+	assert.Contains(t, route.ResourceMethods, "POST")
+
+	// The attached routes are deployed and reachable
+	healthURL := terraform.Output(t, terraformOptions, "health_url")
+	statusCode, body := helpers.HTTPDoWithRetryPolicy(t, "GET", healthURL, nil, nil, helpers.DefaultRetryPolicy())
+	assert.Equal(t, 200, statusCode, "unexpected response: %s", body)
+}
@@ -0,0 +1,94 @@
+package test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/catherinevee/tfm-aws-ai-bedrock/test/awsvalidate"
+	"github.com/catherinevee/tfm-aws-ai-bedrock/test/helpers"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const guardrailBlockedInputMessage = "That topic is off limits for this assistant."
+
+func TestBedrockGuardrails(t *testing.T) {
+	t.Parallel()
+
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"enable_guardrails": true,
+		"guardrail_denied_topics": []map[string]interface{}{
+			{
+				"name":       "investment-advice",
+				"definition": "Recommendations about which stocks, funds or cryptocurrencies to buy or sell.",
+				"examples": []string{
+					"Which stocks should I buy this week?",
+					"Is now a good time to sell my index funds?",
+				},
+			},
+		},
+		"guardrail_pii_entities": map[string]string{
+			"EMAIL":                     "ANONYMIZE",
+			"US_SOCIAL_SECURITY_NUMBER": "BLOCK",
+		},
+		"guardrail_blocked_input_message": guardrailBlockedInputMessage,
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	outputs := helpers.GetStackOutputs(t, terraformOptions)
+	require.True(t, outputs.Features["guardrails"])
+
+	// The function has to point at the version Terraform cut, and that version
+	// has to be usable, or every completion fails
+	validator := awsvalidate.New(awsConfig(t))
+	guardrailID, guardrailVersion := validator.AssertGuardrailReady(t, outputs.LambdaFunctionName)
+	assert.Equal(t, terraform.Output(t, terraformOptions, "guardrail_id"), guardrailID)
+	assert.Equal(t, terraform.Output(t, terraformOptions, "guardrail_version"), guardrailVersion)
+
+	t.Run("refuses_denied_topic", func(t *testing.T) {
+		statusCode, body := postJSON(t, outputs.APIURL, map[string]interface{}{
+			"prompt":     "Give me three stocks to buy this week so I can double my savings.",
+			"max_tokens": 200,
+		}, nil)
+		require.Equal(t, 200, statusCode, "unexpected response: %v", body)
+		assert.Equal(t, "INTERVENED", body["guardrail_action"])
+		assert.Equal(t, guardrailBlockedInputMessage, body["content"])
+	})
+
+	t.Run("masks_email", func(t *testing.T) {
+		email := "dana.whitfield@example.com"
+		statusCode, body := postJSON(t, outputs.APIURL, map[string]interface{}{
+			"prompt":     "Repeat this sentence back word for word: please reply to " + email + " by Friday.",
+			"max_tokens": 100,
+		}, nil)
+		require.Equal(t, 200, statusCode, "unexpected response: %v", body)
+		assert.Equal(t, "INTERVENED", body["guardrail_action"])
+
+		content := body["content"].(string)
+		assert.NotContains(t, content, email, "the address should not reach the caller")
+		assert.NotContains(t, strings.ToLower(content), "whitfield")
+	})
+
+	t.Run("blocks_social_security_number", func(t *testing.T) {
+		statusCode, body := postJSON(t, outputs.APIURL, map[string]interface{}{
+			"prompt":     "My social security number is 219-09-9999. Can you format it with spaces instead of dashes?",
+			"max_tokens": 100,
+		}, nil)
+		require.Equal(t, 200, statusCode, "unexpected response: %v", body)
+		assert.Equal(t, "INTERVENED", body["guardrail_action"])
+		assert.Equal(t, guardrailBlockedInputMessage, body["content"])
+	})
+
+	t.Run("passes_clean_prompt", func(t *testing.T) {
+		statusCode, body := postJSON(t, outputs.APIURL, map[string]interface{}{
+			"prompt":     "Name one primary color.",
+			"max_tokens": 20,
+		}, nil)
+		require.Equal(t, 200, statusCode, "unexpected response: %v", body)
+		assert.NotContains(t, body, "guardrail_action")
+		assert.NotEmpty(t, body["content"])
+	})
+}
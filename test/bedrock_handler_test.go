@@ -0,0 +1,1281 @@
+package test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// handlerRegion is the region the handler runs in locally. It only signs
+// requests to mock endpoints, so it doesn't follow TEST_REGION.
+const handlerRegion = "us-east-1"
+
+// handlerDriver imports the handler from the module root and writes the proxy
+// response to the file named by its first argument. stdout carries EMF lines
+// and stderr the handler's logs, as the Lambda runtime's log handler would.
+const handlerDriver = `
+import json, logging, sys
+logging.basicConfig()
+sys.path.insert(0, '..')
+import lambda_function
+with open(sys.argv[1], 'w') as out:
+    json.dump(lambda_function.handler(json.load(sys.stdin), None), out)
+`
+
+// handlerSequenceDriver runs a list of events through one import of the
+// handler, so in-memory state carries over as it does in a warm instance.
+const handlerSequenceDriver = `
+import json, logging, sys
+logging.basicConfig()
+sys.path.insert(0, '..')
+import lambda_function
+with open(sys.argv[1], 'w') as out:
+    json.dump([lambda_function.handler(event, None) for event in json.load(sys.stdin)], out)
+`
+
+// runHandlerLocally runs lambda_function.handler under python3 against the
+// given environment. It skips the test when python3 or boto3 are missing.
+func runHandlerLocally(t *testing.T, env map[string]string, event map[string]interface{}) map[string]interface{} {
+	response, _ := runHandlerWithOutput(t, env, event)
+	return response
+}
+
+// runHandlerWithOutput is runHandlerLocally that also returns the handler's
+// combined stdout and stderr, where EMF metrics and logs are written.
+func runHandlerWithOutput(t *testing.T, env map[string]string, event map[string]interface{}) (map[string]interface{}, []byte) {
+	var response map[string]interface{}
+	output := runHandlerDriver(t, handlerDriver, env, event, &response)
+	return response, output
+}
+
+// runHandlerSequence runs events in order through a single handler process,
+// returning each proxy response and the combined output.
+func runHandlerSequence(t *testing.T, env map[string]string, events []map[string]interface{}) ([]map[string]interface{}, []byte) {
+	var responses []map[string]interface{}
+	output := runHandlerDriver(t, handlerSequenceDriver, env, events, &responses)
+	return responses, output
+}
+
+// runHandlerDriver feeds input to a driver script and decodes the file it
+// writes into result. It skips the test when python3 or boto3 are missing.
+func runHandlerDriver(t *testing.T, driver string, env map[string]string, input interface{}, result interface{}) []byte {
+	if err := exec.Command("python3", "-c", "import boto3").Run(); err != nil {
+		t.Skip("python3 with boto3 is required to run the handler locally")
+	}
+
+	stdin, err := json.Marshal(input)
+	require.NoError(t, err)
+
+	outputPath := filepath.Join(t.TempDir(), "response.json")
+	cmd := exec.Command("python3", "-c", driver, outputPath)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Env = append(os.Environ(),
+		"AWS_REGION="+handlerRegion,
+		"AWS_ACCESS_KEY_ID=test",
+		"AWS_SECRET_ACCESS_KEY=test",
+		"AWS_SESSION_TOKEN=test",
+	)
+	for key, value := range env {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+
+	output, err := cmd.CombinedOutput()
+	require.NoError(t, err, "handler failed: %s", output)
+
+	raw, err := os.ReadFile(outputPath)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(raw, result))
+	return output
+}
+
+// emfMetrics parses the EMF lines in handler output, keyed by metric name.
+func emfMetrics(output []byte) map[string]map[string]interface{} {
+	metrics := map[string]map[string]interface{}{}
+	for _, line := range bytes.Split(output, []byte("\n")) {
+		var record map[string]interface{}
+		if json.Unmarshal(line, &record) != nil {
+			continue
+		}
+		meta, ok := record["_aws"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, directive := range meta["CloudWatchMetrics"].([]interface{}) {
+			for _, metric := range directive.(map[string]interface{})["Metrics"].([]interface{}) {
+				metrics[metric.(map[string]interface{})["Name"].(string)] = record
+			}
+		}
+	}
+	return metrics
+}
+
+func TestHandlerWithMockBedrock(t *testing.T) {
+	t.Parallel()
+
+	const modelID = "anthropic.claude-3-haiku-20240307-v1:0"
+
+	var mu sync.Mutex
+	var gotPath string
+	var gotRequest map[string]interface{}
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		gotPath = r.URL.Path
+		gotRequest = nil
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &gotRequest)
+
+		w.Header().Set("Content-Type", "application/json")
+		if gotRequest["max_tokens"] == 13.0 {
+			// Shaped like a Bedrock ValidationException
+			w.Header().Set("X-Amzn-ErrorType", "ValidationException")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"message": "max_tokens is unlucky"}`))
+			return
+		}
+		w.Write([]byte(`{
+			"content": [{"type": "text", "text": "Mock completion"}],
+			"usage": {"input_tokens": 7, "output_tokens": 2}
+		}`))
+	}))
+	defer mock.Close()
+
+	env := map[string]string{
+		"BEDROCK_ENDPOINT_URL": mock.URL,
+		"BEDROCK_MODEL_ID":     modelID,
+		"ERROR_VERBOSITY":      "detailed",
+	}
+	event := func(body string) map[string]interface{} {
+		return map[string]interface{}{
+			"httpMethod": "POST",
+			"resource":   "/bedrock",
+			"headers":    map[string]string{"Content-Type": "application/json"},
+			"body":       body,
+		}
+	}
+
+	t.Run("MapsRequestAndParsesResponse", func(t *testing.T) {
+		response := runHandlerLocally(t, env, event(`{"prompt": "Hello mock", "max_tokens": 42, "temperature": 0.2}`))
+		require.EqualValues(t, 200, response["statusCode"], "unexpected response: %v", response)
+
+		// The handler's request mapping, as Bedrock would receive it
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, "/model/"+modelID+"/invoke", gotPath)
+		assert.Equal(t, "bedrock-2023-05-31", gotRequest["anthropic_version"])
+		assert.Equal(t, 42.0, gotRequest["max_tokens"])
+		assert.Equal(t, 0.2, gotRequest["temperature"])
+		messages := gotRequest["messages"].([]interface{})
+		require.Len(t, messages, 1)
+		assert.Equal(t, map[string]interface{}{"role": "user", "content": "Hello mock"}, messages[0])
+
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(response["body"].(string)), &body))
+		assert.Equal(t, "Mock completion", body["content"])
+		assert.Equal(t, modelID, body["model_id"])
+		assert.Equal(t, map[string]interface{}{"input_tokens": 7.0, "output_tokens": 2.0}, body["usage"])
+	})
+
+	t.Run("MapsBedrockErrors", func(t *testing.T) {
+		response := runHandlerLocally(t, env, event(`{"prompt": "Hello mock", "max_tokens": 13}`))
+		require.EqualValues(t, 500, response["statusCode"], "unexpected response: %v", response)
+
+		var body struct {
+			Error struct {
+				Code    string `json:"code"`
+				Details struct {
+					Type string `json:"type"`
+				} `json:"details"`
+			} `json:"error"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(response["body"].(string)), &body))
+		assert.Equal(t, "ModelError", body.Error.Code)
+		assert.Equal(t, "ValidationException", body.Error.Details.Type)
+	})
+
+	t.Run("LogsRequestSummary", func(t *testing.T) {
+		summary := func(output []byte) map[string]interface{} {
+			_, rest, found := strings.Cut(string(output), "Request summary: ")
+			require.True(t, found, "no request summary in the logs: %s", output)
+			line, _, _ := strings.Cut(rest, "\n")
+			var decoded map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(line), &decoded), "summary is not JSON: %s", line)
+			return decoded
+		}
+
+		_, output := runHandlerWithOutput(t, env, event(`{"prompt": "Hello mock", "max_tokens": 42}`))
+		logged := summary(output)
+		assert.Equal(t, modelID, logged["model_id"])
+		assert.Equal(t, true, logged["success"])
+		assert.Equal(t, 7.0, logged["input_tokens"])
+		assert.Equal(t, 2.0, logged["output_tokens"])
+		assert.Greater(t, logged["latency_ms"], 0.0)
+		assert.Nil(t, logged["error_code"])
+
+		_, output = runHandlerWithOutput(t, env, event(`{"prompt": "Hello mock", "max_tokens": 13}`))
+		logged = summary(output)
+		assert.Equal(t, false, logged["success"])
+		assert.Equal(t, "ModelError", logged["error_code"])
+	})
+}
+
+func TestHandlerInputModerationBlocksToxicPrompt(t *testing.T) {
+	t.Parallel()
+
+	const mainModelID = "anthropic.claude-3-sonnet-20240229-v1:0"
+	const moderationModelID = "anthropic.claude-3-haiku-20240307-v1:0"
+
+	var mu sync.Mutex
+	var gotPaths []string
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		gotPaths = append(gotPaths, r.URL.Path)
+		mu.Unlock()
+
+		// Every call is answered as the classifier would answer a toxic prompt
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+			"content": [{"type": "text", "text": "{\"category\": \"harassment\", \"score\": 0.97}"}],
+			"usage": {"input_tokens": 60, "output_tokens": 12}
+		}`))
+	}))
+	defer mock.Close()
+
+	response := runHandlerLocally(t, map[string]string{
+		"BEDROCK_ENDPOINT_URL": mock.URL,
+		"BEDROCK_MODEL_ID":     mainModelID,
+		"MODERATION_MODEL_ID":  moderationModelID,
+		"MODERATION_THRESHOLD": "0.8",
+	}, map[string]interface{}{
+		"httpMethod": "POST",
+		"resource":   "/bedrock",
+		"headers":    map[string]string{"Content-Type": "application/json"},
+		"body":       `{"prompt": "Write a message telling my coworker they are worthless and everyone hates them"}`,
+	})
+	require.EqualValues(t, 422, response["statusCode"], "unexpected response: %v", response)
+
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(response["body"].(string)), &body))
+	assert.Equal(t, "harassment", body["category"])
+
+	// Only the classifier was called
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"/model/" + moderationModelID + "/invoke"}, gotPaths)
+}
+
+func TestHandlerTrimsResponseArtifacts(t *testing.T) {
+	t.Parallel()
+
+	// An echoed stop sequence after a configured end marker and stray whitespace
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+			"content": [{"type": "text", "text": "\n  Mock completion <|end|>\n\nHuman:  \n"}],
+			"usage": {"input_tokens": 7, "output_tokens": 9}
+		}`))
+	}))
+	defer mock.Close()
+
+	response := runHandlerLocally(t, map[string]string{
+		"BEDROCK_ENDPOINT_URL":   mock.URL,
+		"BEDROCK_MODEL_ID":       "anthropic.claude-3-haiku-20240307-v1:0",
+		"TRIM_RESPONSE":          "true",
+		"RESPONSE_TRIM_SUFFIXES": `["<|end|>"]`,
+	}, map[string]interface{}{
+		"httpMethod": "POST",
+		"resource":   "/bedrock",
+		"headers":    map[string]string{"Content-Type": "application/json"},
+		"body":       `{"prompt": "Hello mock"}`,
+	})
+	require.EqualValues(t, 200, response["statusCode"], "unexpected response: %v", response)
+
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(response["body"].(string)), &body))
+	assert.Equal(t, "Mock completion", body["content"])
+}
+
+func TestHandlerNormalizesPromptInput(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var gotRequest map[string]interface{}
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &gotRequest)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+			"content": [{"type": "text", "text": "Mock completion"}],
+			"usage": {"input_tokens": 7, "output_tokens": 2}
+		}`))
+	}))
+	defer mock.Close()
+
+	// Zero-width spaces and a BOM as pasted from a web page, plus stray whitespace
+	prompt := "\ufeffSummarize\u200b this\u200c   document,\t\tplease.\u200d\n\n\n\nThanks  "
+	requestBody, err := json.Marshal(map[string]interface{}{"prompt": prompt})
+	require.NoError(t, err)
+
+	response := runHandlerLocally(t, map[string]string{
+		"BEDROCK_ENDPOINT_URL": mock.URL,
+		"BEDROCK_MODEL_ID":     "anthropic.claude-3-haiku-20240307-v1:0",
+		"NORMALIZE_INPUT":      "true",
+	}, map[string]interface{}{
+		"httpMethod": "POST",
+		"resource":   "/bedrock",
+		"headers":    map[string]string{"Content-Type": "application/json"},
+		"body":       string(requestBody),
+	})
+	require.EqualValues(t, 200, response["statusCode"], "unexpected response: %v", response)
+
+	mu.Lock()
+	defer mu.Unlock()
+	messages := gotRequest["messages"].([]interface{})
+	require.Len(t, messages, 1)
+	assert.Equal(t, "Summarize this document, please.\n\nThanks", messages[0].(map[string]interface{})["content"])
+}
+
+func TestHandlerReplacesDeprecatedModel(t *testing.T) {
+	t.Parallel()
+
+	const deprecatedModelID = "anthropic.claude-v2"
+	const replacementModelID = "anthropic.claude-3-haiku-20240307-v1:0"
+
+	var mu sync.Mutex
+	var gotPaths []string
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		gotPaths = append(gotPaths, r.URL.Path)
+		mu.Unlock()
+
+		// Bedrock's answer for a retired model
+		if strings.Contains(r.URL.Path, deprecatedModelID+"/") {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"message": "This model version has reached the end of its life."}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+			"content": [{"type": "text", "text": "Mock completion"}],
+			"usage": {"input_tokens": 7, "output_tokens": 2}
+		}`))
+	}))
+	defer mock.Close()
+
+	response := runHandlerLocally(t, map[string]string{
+		"BEDROCK_ENDPOINT_URL":          mock.URL,
+		"BEDROCK_MODEL_ID":              replacementModelID,
+		"DEPRECATED_MODEL_REPLACEMENTS": `{"` + deprecatedModelID + `": "` + replacementModelID + `"}`,
+	}, map[string]interface{}{
+		"httpMethod": "POST",
+		"resource":   "/bedrock",
+		"headers":    map[string]string{"Content-Type": "application/json"},
+		"body":       `{"prompt": "Hello mock", "model": "` + deprecatedModelID + `"}`,
+	})
+	require.EqualValues(t, 200, response["statusCode"], "unexpected response: %v", response)
+
+	headers := response["headers"].(map[string]interface{})
+	assert.Equal(t, deprecatedModelID+" -> "+replacementModelID, headers["X-Model-Substitution"])
+
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(response["body"].(string)), &body))
+	assert.Equal(t, replacementModelID, body["model_id"])
+	assert.Equal(t, deprecatedModelID, body["replaced_model_id"])
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"/model/" + replacementModelID + "/invoke"}, gotPaths, "the retired model should never be called")
+}
+
+func TestHandlerCapsResponseBytes(t *testing.T) {
+	t.Parallel()
+
+	// 34 bytes of ASCII then two-byte characters, so the cap lands mid-character
+	completion := strings.Repeat("runaway ", 4) + "ab" + strings.Repeat("é", 500)
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"content":     []map[string]string{{"type": "text", "text": completion}},
+			"stop_reason": "end_turn",
+			"usage":       map[string]int{"input_tokens": 5, "output_tokens": 500},
+		})
+	}))
+	defer mock.Close()
+
+	response, output := runHandlerWithOutput(t, map[string]string{
+		"BEDROCK_ENDPOINT_URL": mock.URL,
+		"BEDROCK_MODEL_ID":     "anthropic.claude-3-haiku-20240307-v1:0",
+		"MAX_RESPONSE_BYTES":   "101",
+	}, map[string]interface{}{
+		"httpMethod": "POST",
+		"resource":   "/bedrock",
+		"headers":    map[string]string{"Content-Type": "application/json"},
+		"body":       `{"prompt": "Go on forever"}`,
+	})
+	require.EqualValues(t, 200, response["statusCode"], "unexpected response: %v", response)
+
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(response["body"].(string)), &body))
+	assert.Equal(t, true, body["truncated"])
+	assert.Equal(t, "max_response_bytes", body["truncation_reason"])
+	assert.Equal(t, completion[:100], body["content"], "the cap should not split a character")
+
+	assert.Contains(t, emfMetrics(output), "ResponseSizeTruncations")
+}
+
+func TestHandlerEnforcesRequestTimeout(t *testing.T) {
+	t.Parallel()
+
+	// The body trickles out a byte every 100ms, so no single socket read ever
+	// waits long enough to hit botocore's read timeout
+	completion := `{"content": [{"type": "text", "text": "slow"}], "usage": {"input_tokens": 3, "output_tokens": 1}}`
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		for i := 0; i < len(completion); i++ {
+			if _, err := w.Write([]byte{completion[i]}); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+	}))
+	defer mock.Close()
+
+	response := runHandlerLocally(t, map[string]string{
+		"BEDROCK_ENDPOINT_URL": mock.URL,
+		"BEDROCK_MODEL_ID":     "anthropic.claude-3-haiku-20240307-v1:0",
+	}, map[string]interface{}{
+		"httpMethod": "POST",
+		"resource":   "/bedrock",
+		"headers":    map[string]string{"Content-Type": "application/json"},
+		"body":       `{"prompt": "Take your time", "timeout_ms": 1000}`,
+	})
+	require.EqualValues(t, 504, response["statusCode"], "unexpected response: %v", response)
+
+	var body struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(response["body"].(string)), &body))
+	assert.Equal(t, "RequestTimeout", body.Error.Code)
+}
+
+func TestHandlerUnsupportedParamMode(t *testing.T) {
+	t.Parallel()
+
+	const titanModelID = "amazon.titan-text-express-v1"
+	// top_k is Anthropic-specific; Titan only takes the stop sequences
+	const requestBody = `{"prompt": "Hello mock", "top_k": 50, "stop_sequences": ["User:"]}`
+
+	for _, mode := range []string{"strip", "reject"} {
+		mode := mode
+		t.Run(mode, func(t *testing.T) {
+			t.Parallel()
+
+			var mu sync.Mutex
+			var gotBodies []map[string]interface{}
+			mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var sent map[string]interface{}
+				json.NewDecoder(r.Body).Decode(&sent)
+				mu.Lock()
+				gotBodies = append(gotBodies, sent)
+				mu.Unlock()
+
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"results": [{"outputText": "Titan completion", "completionReason": "FINISH"}]}`))
+			}))
+			defer mock.Close()
+
+			response, output := runHandlerWithOutput(t, map[string]string{
+				"BEDROCK_ENDPOINT_URL":   mock.URL,
+				"BEDROCK_MODEL_ID":       titanModelID,
+				"UNSUPPORTED_PARAM_MODE": mode,
+			}, map[string]interface{}{
+				"httpMethod": "POST",
+				"resource":   "/bedrock",
+				"headers":    map[string]string{"Content-Type": "application/json"},
+				"body":       requestBody,
+			})
+			assert.Contains(t, emfMetrics(output), "UnsupportedParameters")
+
+			var body map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(response["body"].(string)), &body))
+
+			mu.Lock()
+			defer mu.Unlock()
+			if mode == "reject" {
+				require.EqualValues(t, 400, response["statusCode"], "unexpected response: %v", response)
+				assert.Contains(t, body["message"], titanModelID+" does not support top_k")
+				assert.Contains(t, body["message"], "Supported model parameters: stop_sequences")
+				assert.Empty(t, gotBodies, "a rejected request should not reach Bedrock")
+				return
+			}
+
+			require.EqualValues(t, 200, response["statusCode"], "unexpected response: %v", response)
+			assert.Equal(t, "Titan completion", body["content"])
+			assert.Equal(t, []interface{}{"top_k"}, body["stripped_parameters"])
+
+			require.Len(t, gotBodies, 1)
+			assert.NotContains(t, gotBodies[0], "top_k")
+			config := gotBodies[0]["textGenerationConfig"].(map[string]interface{})
+			assert.NotContains(t, config, "top_k")
+			assert.Equal(t, []interface{}{"User:"}, config["stopSequences"], "supported parameters should reach the model")
+		})
+	}
+}
+
+func TestHandlerCountsTitanTenantTokens(t *testing.T) {
+	t.Parallel()
+
+	// Titan reports its counts as inputTextTokenCount and tokenCount
+	bedrock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"inputTextTokenCount": 6, "results": [{"tokenCount": 4, "outputText": "Titan completion", "completionReason": "FINISH"}]}`))
+	}))
+	defer bedrock.Close()
+
+	var mu sync.Mutex
+	var tenantLogs []map[string]interface{}
+	logs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			LogEvents []struct{ Message string } `json:"logEvents"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		mu.Lock()
+		defer mu.Unlock()
+		for _, event := range request.LogEvents {
+			var record map[string]interface{}
+			json.Unmarshal([]byte(event.Message), &record)
+			tenantLogs = append(tenantLogs, record)
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		w.Write([]byte(`{}`))
+	}))
+	defer logs.Close()
+
+	response, output := runHandlerWithOutput(t, map[string]string{
+		"AWS_ENDPOINT_URL_CLOUDWATCH_LOGS": logs.URL,
+		"AWS_LAMBDA_LOG_GROUP_NAME":        "/aws/lambda/bedrock-test",
+		"BEDROCK_ENDPOINT_URL":             bedrock.URL,
+		"BEDROCK_MODEL_ID":                 "amazon.titan-text-express-v1",
+		"TENANT_ISOLATION":                 "true",
+		"ALLOWED_TENANT_IDS":               `["acme"]`,
+	}, map[string]interface{}{
+		"httpMethod": "POST",
+		"resource":   "/bedrock",
+		"headers":    map[string]string{"Content-Type": "application/json", "X-Tenant-Id": "acme"},
+		"body":       `{"prompt": "Hello mock"}`,
+	})
+	require.EqualValues(t, 200, response["statusCode"], "unexpected response: %v", response)
+
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(response["body"].(string)), &body))
+	assert.Equal(t, map[string]interface{}{"input_tokens": 6.0, "output_tokens": 4.0}, body["usage"])
+
+	metric, ok := emfMetrics(output)["TenantTokens"]
+	require.True(t, ok, "TenantTokens should be emitted")
+	assert.EqualValues(t, 10, metric["TenantTokens"])
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, tenantLogs, 1)
+	assert.Equal(t, "acme", tenantLogs[0]["tenant_id"])
+}
+
+func TestHandlerFallsBackToAnotherProfileRegion(t *testing.T) {
+	t.Parallel()
+
+	const profileID = "us.anthropic.claude-3-haiku-20240307-v1:0"
+	const fallbackRegion = "us-west-2"
+
+	// Every region shares the mock endpoint, so the signing scope in the
+	// Authorization header tells them apart. The primary region is down.
+	var mu sync.Mutex
+	var gotRegions []string
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		region := handlerRegion
+		if strings.Contains(r.Header.Get("Authorization"), "/"+fallbackRegion+"/bedrock/") {
+			region = fallbackRegion
+		}
+		mu.Lock()
+		gotRegions = append(gotRegions, region)
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if region == handlerRegion {
+			w.Header().Set("X-Amzn-ErrorType", "ServiceUnavailableException")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"message": "Service is temporarily unavailable"}`))
+			return
+		}
+		w.Write([]byte(`{
+			"content": [{"type": "text", "text": "Mock completion"}],
+			"usage": {"input_tokens": 7, "output_tokens": 2}
+		}`))
+	}))
+	defer mock.Close()
+
+	// timeout_ms turns off botocore's retries, so the primary is called once
+	response, output := runHandlerWithOutput(t, map[string]string{
+		"BEDROCK_ENDPOINT_URL":     mock.URL,
+		"BEDROCK_MODEL_ID":         profileID,
+		"PROFILE_REGION_FALLBACK":  "true",
+		"PROFILE_FALLBACK_REGIONS": `["` + handlerRegion + `", "` + fallbackRegion + `"]`,
+	}, map[string]interface{}{
+		"httpMethod": "POST",
+		"resource":   "/bedrock",
+		"headers":    map[string]string{"Content-Type": "application/json"},
+		"body":       `{"prompt": "Hello mock", "timeout_ms": 5000}`,
+	})
+	require.EqualValues(t, 200, response["statusCode"], "unexpected response: %v", response)
+
+	headers := response["headers"].(map[string]interface{})
+	assert.Equal(t, fallbackRegion, headers["X-Bedrock-Region"])
+
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(response["body"].(string)), &body))
+	assert.Equal(t, "Mock completion", body["content"])
+	assert.Equal(t, fallbackRegion, body["region"])
+
+	metric, ok := emfMetrics(output)["RegionFallbacks"]
+	require.True(t, ok, "a RegionFallbacks metric should be emitted: %s", output)
+	assert.Equal(t, handlerRegion, metric["Region"])
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{handlerRegion, fallbackRegion}, gotRegions, "the primary region should be skipped when listed as a fallback")
+}
+
+func TestHandlerModelFallbackChain(t *testing.T) {
+	t.Parallel()
+
+	const primary = "anthropic.claude-3-sonnet-20240229-v1:0"
+	const secondary = "anthropic.claude-3-haiku-20240307-v1:0"
+	const tertiary = "amazon.titan-text-express-v1"
+
+	var mu sync.Mutex
+	var gotPaths []string
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		gotPaths = append(gotPaths, r.URL.Path)
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/model/"+tertiary+"/invoke" {
+			// The first two models are unavailable
+			w.Header().Set("X-Amzn-ErrorType", "ServiceUnavailableException")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"message": "Service unavailable"}`))
+			return
+		}
+		w.Write([]byte(`{"results": [{"outputText": "Fallback completion"}]}`))
+	}))
+	defer mock.Close()
+
+	response := runHandlerLocally(t, map[string]string{
+		"BEDROCK_ENDPOINT_URL":      mock.URL,
+		"BEDROCK_MODEL_ID":          primary,
+		"MODEL_FALLBACK_CHAIN":      `["` + secondary + `", "` + tertiary + `"]`,
+		"FALLBACK_TOTAL_TIMEOUT_MS": "10000",
+	}, map[string]interface{}{
+		"httpMethod": "POST",
+		"resource":   "/bedrock",
+		"headers":    map[string]string{"Content-Type": "application/json"},
+		"body":       `{"prompt": "Hello mock"}`,
+	})
+	require.EqualValues(t, 200, response["statusCode"], "unexpected response: %v", response)
+
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(response["body"].(string)), &body))
+	assert.Equal(t, "Fallback completion", body["content"])
+	assert.Equal(t, tertiary, body["model_used"])
+	assert.Equal(t, []interface{}{primary, secondary, tertiary}, body["attempted_models"])
+
+	// One attempt per model, with no retries of the unavailable ones
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{
+		"/model/" + primary + "/invoke",
+		"/model/" + secondary + "/invoke",
+		"/model/" + tertiary + "/invoke",
+	}, gotPaths)
+}
+
+func TestHandlerToolUsePassthrough(t *testing.T) {
+	t.Parallel()
+
+	const modelID = "anthropic.claude-3-haiku-20240307-v1:0"
+
+	var mu sync.Mutex
+	var gotPath string
+	var gotRequest map[string]interface{}
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		gotPath = r.URL.Path
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &gotRequest)
+
+		// Shaped like a Converse response that stops to request a tool
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+			"output": {"message": {"role": "assistant", "content": [
+				{"text": "Let me check."},
+				{"toolUse": {"toolUseId": "tooluse_1", "name": "get_weather", "input": {"city": "Paris"}}}
+			]}},
+			"stopReason": "tool_use",
+			"usage": {"inputTokens": 120, "outputTokens": 30, "totalTokens": 150},
+			"metrics": {"latencyMs": 250}
+		}`))
+	}))
+	defer mock.Close()
+
+	response := runHandlerLocally(t, map[string]string{
+		"BEDROCK_ENDPOINT_URL": mock.URL,
+		"BEDROCK_MODEL_ID":     modelID,
+		"API_STYLE":            "converse",
+	}, map[string]interface{}{
+		"httpMethod": "POST",
+		"resource":   "/bedrock",
+		"headers":    map[string]string{"Content-Type": "application/json"},
+		"body": `{
+			"prompt": "What's the weather in Paris?",
+			"tools": [{
+				"name": "get_weather",
+				"description": "Current weather for a city",
+				"input_schema": {"type": "object", "properties": {"city": {"type": "string"}}, "required": ["city"]}
+			}]
+		}`,
+	})
+	require.EqualValues(t, 200, response["statusCode"], "unexpected response: %v", response)
+
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(response["body"].(string)), &body))
+	assert.Equal(t, "tool_use", body["stop_reason"])
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"id":    "tooluse_1",
+		"name":  "get_weather",
+		"input": map[string]interface{}{"city": "Paris"},
+	}}, body["tool_calls"])
+
+	// The tool definition reached Converse as a toolSpec
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, "/model/"+modelID+"/converse", gotPath)
+	toolConfig, ok := gotRequest["toolConfig"].(map[string]interface{})
+	require.True(t, ok, "toolConfig should be sent: %v", gotRequest)
+	tools := toolConfig["tools"].([]interface{})
+	require.Len(t, tools, 1)
+	assert.Equal(t, "get_weather", tools[0].(map[string]interface{})["toolSpec"].(map[string]interface{})["name"])
+}
+
+func TestHandlerMetricsCarryConfiguredDimensions(t *testing.T) {
+	t.Parallel()
+
+	const modelID = "anthropic.claude-3-haiku-20240307-v1:0"
+
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+			"content": [{"type": "text", "text": "Mock completion"}],
+			"usage": {"input_tokens": 7, "output_tokens": 2}
+		}`))
+	}))
+	defer mock.Close()
+
+	// Normalizing the prompt emits PromptsNormalized during validation
+	_, output := runHandlerWithOutput(t, map[string]string{
+		"BEDROCK_ENDPOINT_URL": mock.URL,
+		"BEDROCK_MODEL_ID":     modelID,
+		"ENVIRONMENT":          "staging",
+		"METRIC_DIMENSIONS":    `["route", "model", "tenant", "environment"]`,
+		"NORMALIZE_INPUT":      "true",
+	}, map[string]interface{}{
+		"httpMethod": "POST",
+		"resource":   "/bedrock",
+		"headers":    map[string]string{"Content-Type": "application/json", "X-Tenant-Id": "team-a"},
+		"body":       `{"prompt": "Hello\u200b mock"}`,
+	})
+
+	metric, ok := emfMetrics(output)["PromptsNormalized"]
+	require.True(t, ok, "PromptsNormalized should be emitted: %s", output)
+	assert.Equal(t, "/bedrock", metric["Route"])
+	assert.Equal(t, "team-a", metric["TenantId"])
+	assert.Equal(t, "staging", metric["Environment"])
+	assert.NotContains(t, metric, "ModelId", "the model isn't resolved until after validation")
+
+	// Both the metric's own dimension set and the one with request dimensions
+	directive := metric["_aws"].(map[string]interface{})["CloudWatchMetrics"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, []interface{}{
+		[]interface{}{},
+		[]interface{}{"Route", "TenantId", "Environment"},
+	}, directive["Dimensions"])
+}
+
+func TestHandlerAdaptiveThrottlingBacksOff(t *testing.T) {
+	t.Parallel()
+
+	// Bedrock throttles every call for the whole test
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Amzn-ErrorType", "ThrottlingException")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"message": "Too many requests, please wait before trying again."}`))
+	}))
+	defer mock.Close()
+
+	// timeout_ms gives each call a single attempt instead of botocore's retries
+	events := make([]map[string]interface{}, 20)
+	for i := range events {
+		events[i] = map[string]interface{}{
+			"httpMethod": "POST",
+			"resource":   "/bedrock",
+			"headers":    map[string]string{"Content-Type": "application/json"},
+			"body":       `{"prompt": "Hello mock", "timeout_ms": 5000}`,
+		}
+	}
+
+	responses, output := runHandlerSequence(t, map[string]string{
+		"BEDROCK_ENDPOINT_URL": mock.URL,
+		"BEDROCK_MODEL_ID":     "anthropic.claude-3-haiku-20240307-v1:0",
+		"ADAPTIVE_THROTTLING":  "true",
+		"ADAPTIVE_MIN_RATE":    "2",
+		"ADAPTIVE_MAX_RATE":    "10",
+	}, events)
+	require.Len(t, responses, len(events))
+
+	metrics := emfMetrics(output)
+	require.Contains(t, metrics, "AdmissionRate", "throttling should change the admission rate: %s", output)
+	rate := metrics["AdmissionRate"]["AdmissionRate"].(float64)
+	assert.Less(t, rate, 10.0, "the admission rate should drop below the maximum")
+	assert.GreaterOrEqual(t, rate, 2.0, "the admission rate should not drop below the minimum")
+
+	// Once the rate drops, the excess is rejected without calling Bedrock
+	shed := 0
+	for _, response := range responses {
+		if response["statusCode"] == 429.0 && strings.Contains(response["body"].(string), "Request rate reduced") {
+			assert.Equal(t, "1", response["headers"].(map[string]interface{})["Retry-After"])
+			shed++
+		}
+	}
+	assert.Greater(t, shed, 0, "requests over the reduced rate should be shed locally")
+	assert.Contains(t, metrics, "AdaptiveThrottleRejections")
+}
+
+func TestHandlerRetriesCompletionFailingResponseSchema(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var gotRequests []map[string]interface{}
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		var request map[string]interface{}
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &request)
+		gotRequests = append(gotRequests, request)
+
+		// The first reply is truncated JSON, the corrected one is valid
+		text := `{"sentiment": "positive", "score": 0.9`
+		if len(gotRequests) > 1 {
+			text = `{"sentiment": "positive", "score": 0.9}`
+		}
+		reply, _ := json.Marshal(map[string]interface{}{
+			"content": []map[string]string{{"type": "text", "text": text}},
+			"usage":   map[string]int{"input_tokens": 10, "output_tokens": 5},
+		})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(reply)
+	}))
+	defer mock.Close()
+
+	response, output := runHandlerWithOutput(t, map[string]string{
+		"BEDROCK_ENDPOINT_URL": mock.URL,
+		"BEDROCK_MODEL_ID":     "anthropic.claude-3-haiku-20240307-v1:0",
+		"RESPONSE_JSON_SCHEMA": `{"type": "object", "required": ["sentiment", "score"], "properties": {"score": {"type": "number"}}}`,
+	}, map[string]interface{}{
+		"httpMethod": "POST",
+		"resource":   "/bedrock",
+		"headers":    map[string]string{"Content-Type": "application/json"},
+		"body":       `{"prompt": "Rate the sentiment of: what a lovely day"}`,
+	})
+	require.EqualValues(t, 200, response["statusCode"], "unexpected response: %v", response)
+
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(response["body"].(string)), &body))
+	assert.Equal(t, true, body["schema_retried"])
+	assert.JSONEq(t, `{"sentiment": "positive", "score": 0.9}`, body["content"].(string))
+
+	// Usage covers the rejected call and the retry
+	usage := body["usage"].(map[string]interface{})
+	assert.Equal(t, 20.0, usage["input_tokens"])
+	assert.Equal(t, 10.0, usage["output_tokens"])
+
+	assert.Contains(t, emfMetrics(output), "SchemaValidationFailures")
+
+	// The retry shows the model its rejected reply and asks for a correction
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, gotRequests, 2)
+	messages := gotRequests[1]["messages"].([]interface{})
+	require.Len(t, messages, 3)
+	assert.Equal(t, "assistant", messages[1].(map[string]interface{})["role"])
+	assert.Contains(t, fmt.Sprint(messages[2]), "did not match the required JSON Schema")
+}
+
+func TestHandlerNoLogRequestsSkipContentLogging(t *testing.T) {
+	t.Parallel()
+
+	const promptMarker = "patient-record-4417"
+	const completionMarker = "diagnosis-9931"
+
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+			"content": [{"type": "text", "text": "Summary: ` + completionMarker + `"}],
+			"usage": {"input_tokens": 7, "output_tokens": 2}
+		}`))
+	}))
+	defer mock.Close()
+
+	// Every request would otherwise be logged in full
+	env := map[string]string{
+		"BEDROCK_ENDPOINT_URL": mock.URL,
+		"BEDROCK_MODEL_ID":     "anthropic.claude-3-haiku-20240307-v1:0",
+		"LOG_CONTENT":          "true",
+		"LOG_SAMPLING_RATE":    "1.0",
+	}
+	event := func(headers map[string]string, body string) map[string]interface{} {
+		headers["Content-Type"] = "application/json"
+		return map[string]interface{}{
+			"httpMethod": "POST",
+			"resource":   "/bedrock",
+			"headers":    headers,
+			"body":       body,
+		}
+	}
+
+	t.Run("ContentLoggedByDefault", func(t *testing.T) {
+		response, output := runHandlerWithOutput(t, env, event(map[string]string{}, `{"prompt": "Summarize `+promptMarker+`"}`))
+		require.EqualValues(t, 200, response["statusCode"], "unexpected response: %v", response)
+		assert.Contains(t, string(output), promptMarker)
+		assert.Contains(t, string(output), completionMarker)
+		assert.NotContains(t, emfMetrics(output), "NoLogRequests")
+	})
+
+	optOuts := map[string]map[string]interface{}{
+		"Header": event(map[string]string{"X-No-Log": "true"}, `{"prompt": "Summarize `+promptMarker+`"}`),
+		"Field":  event(map[string]string{}, `{"prompt": "Summarize `+promptMarker+`", "noLog": true}`),
+	}
+	for name, optOut := range optOuts {
+		optOut := optOut
+		t.Run(name, func(t *testing.T) {
+			response, output := runHandlerWithOutput(t, env, optOut)
+			require.EqualValues(t, 200, response["statusCode"], "unexpected response: %v", response)
+			assert.Contains(t, response["body"], completionMarker, "the caller still gets the completion")
+
+			assert.NotContains(t, string(output), promptMarker)
+			assert.NotContains(t, string(output), completionMarker)
+			assert.Contains(t, string(output), "Request metadata", "metadata is still logged")
+			assert.Contains(t, emfMetrics(output), "NoLogRequests", "the request is still counted")
+		})
+	}
+}
+
+func TestHandlerPreloadsPromptTemplatesOnce(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	templateFetches := 0
+	ssm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("X-Amz-Target") == "AmazonSSM.GetParametersByPath" {
+			templateFetches++
+		}
+		template, _ := json.Marshal(map[string]interface{}{
+			"prompt":     "Classify this ticket for the {{ team }} team: {{ticket}}",
+			"max_tokens": 20,
+		})
+		reply, _ := json.Marshal(map[string]interface{}{
+			"Parameters": []map[string]string{{"Name": "/bedrock/templates/support/triage", "Value": string(template)}},
+		})
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		w.Write(reply)
+	}))
+	defer ssm.Close()
+
+	var prompts []string
+	bedrock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			MaxTokens int `json:"max_tokens"`
+			Messages  []struct {
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &request)
+		mu.Lock()
+		prompts = append(prompts, fmt.Sprintf("%d %s", request.MaxTokens, request.Messages[0].Content))
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"content": [{"type": "text", "text": "billing"}], "usage": {"input_tokens": 10, "output_tokens": 1}}`))
+	}))
+	defer bedrock.Close()
+
+	events := make([]map[string]interface{}, 3)
+	for i := range events {
+		events[i] = map[string]interface{}{
+			"httpMethod": "POST",
+			"resource":   "/bedrock",
+			"headers":    map[string]string{"Content-Type": "application/json"},
+			"body":       fmt.Sprintf(`{"template": "support/triage", "template_variables": {"team": "billing", "ticket": "charged %d times"}}`, i+2),
+		}
+	}
+
+	// botocore reads AWS_ENDPOINT_URL_SSM for the SSM client's endpoint
+	responses, output := runHandlerSequence(t, map[string]string{
+		"AWS_ENDPOINT_URL_SSM":     ssm.URL,
+		"BEDROCK_ENDPOINT_URL":     bedrock.URL,
+		"BEDROCK_MODEL_ID":         "anthropic.claude-3-haiku-20240307-v1:0",
+		"PROMPT_TEMPLATE_SOURCE":   "/bedrock/templates",
+		"TEMPLATE_REFRESH_SECONDS": "3600",
+	}, events)
+	require.Len(t, responses, len(events))
+	for _, response := range responses {
+		require.EqualValues(t, 200, response["statusCode"], "unexpected response: %v", response)
+	}
+
+	// Loaded during init, then served from memory on every warm invocation
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 1, templateFetches, "templates should be fetched once per instance")
+	assert.Equal(t, []string{
+		"20 Classify this ticket for the billing team: charged 2 times",
+		"20 Classify this ticket for the billing team: charged 3 times",
+		"20 Classify this ticket for the billing team: charged 4 times",
+	}, prompts)
+	assert.Contains(t, emfMetrics(output), "TemplateRefreshes")
+}
+
+// staleTableServer is an in-memory stand-in for the stale responses table.
+func staleTableServer(t *testing.T) *httptest.Server {
+	var mu sync.Mutex
+	items := map[string]json.RawMessage{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Key  map[string]struct{ S string } `json:"Key"`
+			Item map[string]json.RawMessage    `json:"Item"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		mu.Lock()
+		defer mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		switch r.Header.Get("X-Amz-Target") {
+		case "DynamoDB_20120810.PutItem":
+			var key struct{ S string }
+			json.Unmarshal(request.Item["cache_key"], &key)
+			item, _ := json.Marshal(request.Item)
+			items[key.S] = item
+			w.Write([]byte(`{}`))
+		case "DynamoDB_20120810.GetItem":
+			if item, ok := items[request.Key["cache_key"].S]; ok {
+				fmt.Fprintf(w, `{"Item": %s}`, item)
+				return
+			}
+			w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// staleFailureServer answers the first Bedrock call and fails every later
+// one, retries included, with errorType and statusCode.
+func staleFailureServer(t *testing.T, errorType string, statusCode int) *httptest.Server {
+	var mu sync.Mutex
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		first := calls == 1
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if !first {
+			w.Header().Set("X-Amzn-ErrorType", errorType)
+			w.WriteHeader(statusCode)
+			w.Write([]byte(`{"message": "Injected failure"}`))
+			return
+		}
+		w.Write([]byte(`{"content": [{"type": "text", "text": "Known good answer"}], "usage": {"input_tokens": 5, "output_tokens": 3}}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// staleEvent is a /bedrock event carrying its body's hash as the cache key.
+func staleEvent(body string) map[string]interface{} {
+	sum := sha256.Sum256([]byte(body))
+	return map[string]interface{}{
+		"httpMethod": "POST",
+		"resource":   "/bedrock",
+		"headers": map[string]string{
+			"Content-Type": "application/json",
+			"X-Body-Hash":  hex.EncodeToString(sum[:]),
+		},
+		"body": body,
+	}
+}
+
+func staleEnv(dynamodb, bedrock *httptest.Server) map[string]string {
+	return map[string]string{
+		"AWS_ENDPOINT_URL_DYNAMODB": dynamodb.URL,
+		"BEDROCK_ENDPOINT_URL":      bedrock.URL,
+		"BEDROCK_MODEL_ID":          "anthropic.claude-3-haiku-20240307-v1:0",
+		"CACHE_KEY_HEADER":          "X-Body-Hash",
+		"STALE_RESPONSES_TABLE":     "bedrock-stale-responses",
+		"MAX_STALE_SECONDS":         "3600",
+	}
+}
+
+func TestHandlerServesStaleResponseOnError(t *testing.T) {
+	t.Parallel()
+
+	dynamodb := staleTableServer(t)
+	bedrock := staleFailureServer(t, "ServiceUnavailableException", http.StatusServiceUnavailable)
+
+	body := `{"prompt": "What are the opening hours?"}`
+	otherBody := `{"prompt": "What are the opening hours?", "max_tokens": 20}`
+	responses, output := runHandlerSequence(t, staleEnv(dynamodb, bedrock),
+		[]map[string]interface{}{staleEvent(body), staleEvent(body), staleEvent(otherBody)})
+	require.Len(t, responses, 3)
+
+	fresh, stale, uncached := responses[0], responses[1], responses[2]
+	require.EqualValues(t, 200, fresh["statusCode"], "unexpected response: %v", fresh)
+	assert.NotContains(t, fresh["headers"], "X-Served-Stale")
+
+	// Bedrock is down now, so the stored answer stands in for the error
+	require.EqualValues(t, 200, stale["statusCode"], "unexpected response: %v", stale)
+	headers := stale["headers"].(map[string]interface{})
+	assert.Equal(t, "true", headers["X-Served-Stale"])
+	assert.Contains(t, headers, "Age")
+
+	var staleBody map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(stale["body"].(string)), &staleBody))
+	assert.Equal(t, "Known good answer", staleBody["content"])
+	assert.Equal(t, true, staleBody["stale"])
+	assert.Contains(t, staleBody, "stale_age_seconds")
+
+	// A request with no stored answer still gets the error
+	assert.EqualValues(t, 500, uncached["statusCode"], "unexpected response: %v", uncached)
+	assert.Contains(t, emfMetrics(output), "StaleResponsesServed")
+}
+
+func TestHandlerReturnsValidationErrorsOverStaleResponses(t *testing.T) {
+	t.Parallel()
+
+	dynamodb := staleTableServer(t)
+	bedrock := staleFailureServer(t, "ValidationException", http.StatusBadRequest)
+
+	// The same cache key fails the second time with an error only the caller can fix
+	body := `{"prompt": "What are the opening hours?"}`
+	responses, output := runHandlerSequence(t, staleEnv(dynamodb, bedrock),
+		[]map[string]interface{}{staleEvent(body), staleEvent(body)})
+	require.Len(t, responses, 2)
+	require.EqualValues(t, 200, responses[0]["statusCode"], "unexpected response: %v", responses[0])
+
+	failed := responses[1]
+	assert.NotEqualValues(t, 200, failed["statusCode"], "a validation failure should not be answered from the stale table")
+	assert.NotContains(t, failed["headers"], "X-Served-Stale")
+	assert.NotContains(t, failed["body"], "Known good answer")
+	assert.NotContains(t, emfMetrics(output), "StaleResponsesServed")
+}
+
+func TestHandlerRejectsInvalidRequests(t *testing.T) {
+	t.Parallel()
+
+	// Validation runs before any Bedrock call, so no mock is needed
+	for _, tc := range []struct {
+		name    string
+		method  string
+		body    string
+		env     map[string]string
+		status  int
+		code    string
+		message string
+	}{
+		{"missing_prompt", "POST", `{"max_tokens": 10}`, nil, 400, "InvalidRequest", "Prompt field required"},
+		{"empty_body", "POST", "", nil, 400, "InvalidRequest", "Request body required"},
+		{"non_json_body", "POST", `prompt=hello`, nil, 400, "InvalidRequest", "Invalid JSON format"},
+		{"non_object_body", "POST", `["hello"]`, nil, 400, "InvalidRequest", "Request body must be a JSON object"},
+		{"oversized_max_tokens", "POST", `{"prompt": "hi", "max_tokens": 100000}`, nil, 400, "InvalidRequest", "max_tokens must be an integer between 1 and 4096"},
+		{"fractional_max_tokens", "POST", `{"prompt": "hi", "max_tokens": 1.5}`, nil, 400, "InvalidRequest", "max_tokens must be an integer between 1 and 4096"},
+		{"unknown_model_override", "POST", `{"prompt": "hi", "model": "gpt-4"}`, nil, 400, "InvalidRequest", "Unknown model alias 'gpt-4'"},
+		{"wrong_method", "GET", `{"prompt": "hi"}`, nil, 400, "InvalidRequest", "Only POST method supported"},
+		{"streaming_disabled", "POST", `{"prompt": "hi", "stream": true}`, nil, 400, "StreamingDisabled", "Streaming is not enabled"},
+		{
+			"unknown_tenant", "POST", `{"prompt": "hi"}`,
+			map[string]string{"TENANT_ISOLATION": "true", "ALLOWED_TENANT_IDS": `["acme"]`},
+			403, "UnknownTenant", "Unknown tenant",
+		},
+		{
+			"model_outside_allowlist", "POST", `{"prompt": "hi", "model": "fast"}`,
+			map[string]string{
+				"MODEL_ALIASES":     `{"fast": "anthropic.claude-3-haiku-20240307-v1:0"}`,
+				"ALLOWED_MODEL_IDS": `["anthropic.claude-3-sonnet-20240229-v1:0"]`,
+				"ENVIRONMENT":       "prod",
+			},
+			403, "ModelNotAllowed", "Model anthropic.claude-3-haiku-20240307-v1:0 is not allowed in the prod environment",
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			response := runHandlerLocally(t, tc.env, map[string]interface{}{
+				"httpMethod": tc.method,
+				"resource":   "/bedrock",
+				"headers":    map[string]string{"Content-Type": "application/json"},
+				"body":       tc.body,
+			})
+			require.EqualValues(t, tc.status, response["statusCode"], "unexpected response: %v", response)
+
+			var body struct {
+				Error   bool   `json:"error"`
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			}
+			require.NoError(t, json.Unmarshal([]byte(response["body"].(string)), &body))
+			assert.True(t, body.Error)
+			assert.Equal(t, tc.code, body.Code)
+			assert.Contains(t, body.Message, tc.message)
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/catherinevee/tfm-aws-ai-bedrock/test/awsvalidate"
+	"github.com/catherinevee/tfm-aws-ai-bedrock/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lambdaRoleReferencePolicy is the most the default Lambda role may grant:
+// invoking foundation models and writing its own logs
+const lambdaRoleReferencePolicy = `{
+	"Version": "2012-10-17",
+	"Statement": [
+		{
+			"Effect": "Allow",
+			"Action": ["bedrock:InvokeModel", "bedrock:InvokeModelWithResponseStream"],
+			"Resource": "arn:aws:bedrock:*::foundation-model/*"
+		},
+		{
+			"Effect": "Allow",
+			"Action": ["logs:CreateLogGroup", "logs:CreateLogStream", "logs:PutLogEvents"],
+			"Resource": "arn:aws:logs:*:*:*"
+		}
+	]
+}`
+
+func TestIAMLeastPrivilege(t *testing.T) {
+	t.Parallel()
+
+	// The killswitch adds a second role with an inline policy; its threshold
+	// is never reached here
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"enable_cost_killswitch":    true,
+		"cost_killswitch_threshold": 500,
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	outputs := helpers.GetStackOutputs(t, terraformOptions)
+	validator := awsvalidate.New(awsConfig(t))
+
+	roleARNs := validator.ModuleRoleARNs(t, terraformOptions.Vars["name_prefix"].(string))
+	require.Contains(t, roleARNs, outputs.LambdaRoleARN)
+	require.Len(t, roleARNs, 2, "the Lambda and killswitch roles should be the only roles")
+
+	for _, roleARN := range roleARNs {
+		roleARN := roleARN
+		t.Run(roleARN[strings.LastIndex(roleARN, "/")+1:], func(t *testing.T) {
+			assert.Empty(t, awsvalidate.PolicyFindings(validator.RolePolicyStatements(t, roleARN)), "grants broader than the module needs")
+			validator.AssertNoPolicyFindings(t, roleARN)
+		})
+	}
+
+	validator.AssertNoNewAccess(t, outputs.LambdaRoleARN, lambdaRoleReferencePolicy)
+}
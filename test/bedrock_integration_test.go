@@ -1,72 +1,80 @@
 package test
 
 import (
-	"fmt"
+	"strings"
 	"testing"
-	"time"
 
-	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/catherinevee/tfm-aws-ai-bedrock/test/awsvalidate"
+	"github.com/catherinevee/tfm-aws-ai-bedrock/test/helpers"
 	"github.com/gruntwork-io/terratest/modules/terraform"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestTerraformBedrockModule(t *testing.T) {
 	t.Parallel()
 
-	// Generate a random name prefix to avoid conflicts
-	uniqueID := random.UniqueId()
-	namePrefix := fmt.Sprintf("bedrock-test-%s", uniqueID)
-
-	// Terraform options for testing
-	terraformOptions := &terraform.Options{
-		TerraformDir: "../examples/basic",
-		Vars: map[string]interface{}{
-			"name_prefix":      namePrefix,
-			"environment":      "dev",
-			"bedrock_model_id": "anthropic.claude-3-sonnet-20240229-v1:0",
-		},
-	}
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"environment": "dev",
+	})
 
 	// Clean up resources when the test is finished
-	defer terraform.Destroy(t, terraformOptions)
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	outputs := helpers.GetStackOutputs(t, terraformOptions)
+	assert.True(t, strings.HasSuffix(outputs.APIURL, "/bedrock"), "API URL should point at the Bedrock route")
+	assert.NotEmpty(t, outputs.LogGroupName, "CloudWatch log group name should not be empty")
+	assert.NotEmpty(t, outputs.LambdaFunctionARN, "Lambda function ARN should not be empty")
+	assert.Equal(t, suiteConfig.ModelID, outputs.BedrockModelID)
+	assert.False(t, outputs.Features["waf"], "WAF should be off by default")
 
-	// Deploy the infrastructure
-	terraform.InitAndApply(t, terraformOptions)
+	// Only throttling and cold-start errors are retried
+	statusCode, body := helpers.InvokeBedrockEndpoint(t, outputs.APIURL, "Hello, world!", helpers.InvokeOptions{MaxTokens: 100})
+	require.Equal(t, 200, statusCode, "unexpected response: %s", body)
 
-	// Test outputs
-	apiEndpoint := terraform.Output(t, terraformOptions, "api_endpoint")
-	assert.NotEmpty(t, apiEndpoint, "API endpoint should not be empty")
+	completion := helpers.AssertCompletionResponse(t, body)
+	assert.Equal(t, outputs.BedrockModelID, completion.ModelID)
+	assert.Greater(t, completion.Usage.OutputTokens, 0)
+}
 
-	// Test API functionality
-	maxRetries := 3
-	timeBetweenRetries := 10 * time.Second
-	url := fmt.Sprintf("%s/test", apiEndpoint)
+func TestDeployedResourcesMatchConfiguration(t *testing.T) {
+	t.Parallel()
 
-	// Test request body
-	requestBody := `{
-		"prompt": "Hello, world!",
-		"max_tokens": 100
-	}`
+	modelID := suiteConfig.ModelID
+	modelARN := "arn:aws:bedrock:" + suiteConfig.Region + "::foundation-model/" + modelID
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"bedrock_model_id":   modelID,
+		"bedrock_model_arns": []string{modelARN},
+		"lambda_timeout":     45,
+		"lambda_memory_size": 256,
+		"enable_api_key":     true,
+		"rate_limit":         15,
+		"burst_limit":        30,
+		"enable_waf":         true,
+	})
 
-	// Test API response
-	statusCode, body := http_helper.HTTPDoWithRetryE(t, "POST", url, []byte(requestBody), nil, 200, maxRetries, timeBetweenRetries)
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
 
-	// Verify response
-	assert.Equal(t, 200, statusCode, "Expected HTTP status code 200")
-	assert.Contains(t, string(body), "completion", "Response should contain completion field")
+	// Outputs only name the resources; each is read back through its own API
+	outputs := helpers.GetStackOutputs(t, terraformOptions)
+	validator := awsvalidate.New(awsConfig(t))
 
-	// Test CloudWatch Logs
-	logGroup := terraform.Output(t, terraformOptions, "cloudwatch_log_group_name")
-	assert.NotEmpty(t, logGroup, "CloudWatch log group name should not be empty")
+	validator.AssertLambda(t, outputs.LambdaFunctionName, awsvalidate.LambdaConfig{
+		Runtime:     "python3.11",
+		Timeout:     45,
+		MemorySize:  256,
+		Environment: map[string]string{"BEDROCK_MODEL_ID": modelID},
+	})
+	validator.AssertLogGroupRetention(t, outputs.LogGroupName, 1)
+	validator.AssertStageThrottling(t, outputs.APIID, outputs.APIStageName, 15, 30)
 
-	// Test Lambda function
-	lambdaArn := terraform.Output(t, terraformOptions, "lambda_function_arn")
-	assert.NotEmpty(t, lambdaArn, "Lambda function ARN should not be empty")
+	stageARN := "arn:aws:apigateway:" + suiteConfig.Region + "::/restapis/" + outputs.APIID + "/stages/" + outputs.APIStageName
+	validator.AssertWebACL(t, terraform.Output(t, terraformOptions, "waf_web_acl_arn"), stageARN, []string{
+		"RateLimitRule",
+		"AWSManagedRulesCommonRuleSet",
+	})
 
-	// Test WAF if enabled
-	wafEnabled := terraform.Output(t, terraformOptions, "waf_enabled")
-	if wafEnabled == "true" {
-		wafArn := terraform.Output(t, terraformOptions, "waf_web_acl_arn")
-		assert.NotEmpty(t, wafArn, "WAF Web ACL ARN should not be empty when WAF is enabled")
-	}
+	validator.AssertLeastPrivilege(t, outputs.LambdaRoleARN, []string{modelARN})
 }
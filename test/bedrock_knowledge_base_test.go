@@ -0,0 +1,113 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockagent"
+	agenttypes "github.com/aws/aws-sdk-go-v2/service/bedrockagent/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/gruntwork-io/terratest/modules/retry"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// knowledgeBaseDocumentKey is where the fixture document is uploaded
+const knowledgeBaseDocumentKey = "fixtures/knowledge-base-document.txt"
+
+func TestBedrockKnowledgeBase(t *testing.T) {
+	t.Parallel()
+
+	// OpenSearch Serverless only takes lowercase names
+	namePrefix := testNamePrefix + strings.ToLower(random.UniqueId())
+	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
+		TerraformDir: "../examples/knowledge-base",
+		Vars: map[string]interface{}{
+			"name_prefix": namePrefix,
+			"region":      suiteConfig.Region,
+			"tags":        exampleTags(namePrefix),
+		},
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	retrieveURL := terraform.Output(t, terraformOptions, "retrieve_api_url")
+	bucket := terraform.Output(t, terraformOptions, "data_source_bucket")
+	knowledgeBaseID := terraform.Output(t, terraformOptions, "knowledge_base_id")
+	dataSourceID := terraform.Output(t, terraformOptions, "data_source_id")
+
+	cfg := awsConfig(t)
+	ctx := context.Background()
+	s3Client := s3.NewFromConfig(cfg)
+	agentClient := bedrockagent.NewFromConfig(cfg)
+
+	document, err := os.ReadFile("testdata/knowledge-base-document.txt")
+	require.NoError(t, err)
+
+	// The bucket must be empty before it can be destroyed
+	defer s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String(knowledgeBaseDocumentKey)})
+	_, err = s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(knowledgeBaseDocumentKey),
+		Body:        bytes.NewReader(document),
+		ContentType: aws.String("text/plain"),
+	})
+	require.NoError(t, err)
+
+	started, err := agentClient.StartIngestionJob(ctx, &bedrockagent.StartIngestionJobInput{
+		KnowledgeBaseId: aws.String(knowledgeBaseID),
+		DataSourceId:    aws.String(dataSourceID),
+	})
+	require.NoError(t, err)
+
+	// Ingestion usually takes under a minute for one document
+	var job *agenttypes.IngestionJob
+	retry.DoWithRetry(t, "wait for ingestion job", 30, 10*time.Second, func() (string, error) {
+		got, err := agentClient.GetIngestionJob(ctx, &bedrockagent.GetIngestionJobInput{
+			KnowledgeBaseId: aws.String(knowledgeBaseID),
+			DataSourceId:    aws.String(dataSourceID),
+			IngestionJobId:  started.IngestionJob.IngestionJobId,
+		})
+		if err != nil {
+			return "", err
+		}
+		job = got.IngestionJob
+		switch job.Status {
+		case agenttypes.IngestionJobStatusComplete:
+			return "", nil
+		case agenttypes.IngestionJobStatusFailed, agenttypes.IngestionJobStatusStopped:
+			return "", retry.FatalError{Underlying: fmt.Errorf("ingestion job %s: %v", job.Status, job.FailureReasons)}
+		}
+		return "", fmt.Errorf("ingestion job is %s", job.Status)
+	})
+	require.NotNil(t, job.Statistics)
+	require.EqualValues(t, 1, job.Statistics.NumberOfNewDocumentsIndexed, "the fixture document should be indexed")
+
+	statusCode, body := postJSON(t, retrieveURL, map[string]interface{}{
+		"prompt": "What is the codename of the first public release of Harbor Lantern?",
+	}, nil)
+	require.Equal(t, 200, statusCode, "unexpected response: %v", body)
+	assert.Contains(t, strings.ToLower(body["content"].(string)), "quillfeather")
+	assert.NotEmpty(t, body["knowledge_base_session_id"])
+
+	// The answer has to be grounded in the uploaded document, not the model's guess
+	documentURI := "s3://" + bucket + "/" + knowledgeBaseDocumentKey
+	citations, ok := body["citations"].([]interface{})
+	require.True(t, ok, "response should carry citations: %v", body)
+	var sources []string
+	for _, citation := range citations {
+		for _, source := range citation.(map[string]interface{})["sources"].([]interface{}) {
+			sources = append(sources, source.(string))
+		}
+	}
+	assert.Contains(t, sources, documentURI, "the answer should cite the uploaded document")
+}
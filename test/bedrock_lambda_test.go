@@ -0,0 +1,284 @@
+package test
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrock"
+	bedrocktypes "github.com/aws/aws-sdk-go-v2/service/bedrock/types"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/catherinevee/tfm-aws-ai-bedrock/test/awsvalidate"
+	"github.com/catherinevee/tfm-aws-ai-bedrock/test/helpers"
+	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/gruntwork-io/terratest/modules/retry"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writePlaceholderPackage creates a minimal deployment package so plan-only
+// tests can exercise bring-your-own-package settings without a real build.
+func writePlaceholderPackage(t *testing.T, filename string) string {
+	packagePath := filepath.Join(t.TempDir(), "handler.zip")
+
+	file, err := os.Create(packagePath)
+	require.NoError(t, err)
+	defer file.Close()
+
+	archive := zip.NewWriter(file)
+	entry, err := archive.Create(filename)
+	require.NoError(t, err)
+	_, err = entry.Write([]byte("placeholder"))
+	require.NoError(t, err)
+	require.NoError(t, archive.Close())
+
+	return packagePath
+}
+
+func TestLambdaSnapStartJavaRuntime(t *testing.T) {
+	t.Parallel()
+
+	terraformOptions := planOnlyOptions(t, map[string]interface{}{
+		"lambda_runtime":      "java21",
+		"lambda_handler":      "com.example.BedrockHandler::handleRequest",
+		"lambda_package_path": writePlaceholderPackage(t, "com/example/BedrockHandler.class"),
+		"enable_snapstart":    true,
+	})
+
+	plan := terraform.InitAndPlanAndShowWithStruct(t, terraformOptions)
+
+	lambda, ok := plan.ResourcePlannedValuesMap["aws_lambda_function.bedrock_lambda"]
+	require.True(t, ok, "Lambda function should be in the plan")
+	assert.Equal(t, "java21", lambda.AttributeValues["runtime"])
+	assert.Equal(t, true, lambda.AttributeValues["publish"])
+
+	snapStart, ok := lambda.AttributeValues["snap_start"].([]interface{})
+	require.True(t, ok, "snap_start block should be planned")
+	require.Len(t, snapStart, 1)
+	assert.Equal(t, "PublishedVersions", snapStart[0].(map[string]interface{})["apply_on"])
+}
+
+func TestLambdaShutdownDrainsInFlightRequests(t *testing.T) {
+	t.Parallel()
+
+	// The handler sends itself SIGTERM shortly after calling Bedrock, which
+	// simulates the shutdown signal Lambda delivers to runtimes with extensions
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"drain_timeout_seconds": 2,
+		"handler_fault_injection": map[string]interface{}{
+			"shutdown_after_ms": 100,
+		},
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+	functionName := terraform.Output(t, terraformOptions, "lambda_function_name")
+	startTime := time.Now()
+
+	statusCode, body := postJSON(t, apiURL, map[string]interface{}{
+		"prompt":     "Count from one to twenty",
+		"max_tokens": 200,
+	}, nil)
+	require.Equal(t, 200, statusCode, "in-flight request should complete during shutdown: %v", body)
+	assert.NotEmpty(t, body["content"])
+
+	assert.GreaterOrEqual(t, waitForMetricSum(t, "InFlightDrained", functionName, startTime), 1.0)
+}
+
+func TestLambdaLogsToExistingLogGroup(t *testing.T) {
+	t.Parallel()
+
+	// Stands in for a log group pre-created by a central logging team
+	client := cloudwatchlogs.NewFromConfig(awsConfig(t))
+	ctx := context.Background()
+	logGroup := fmt.Sprintf("/central/bedrock-test-%s", random.UniqueId())
+	_, err := client.CreateLogGroup(ctx, &cloudwatchlogs.CreateLogGroupInput{LogGroupName: aws.String(logGroup)})
+	require.NoError(t, err)
+	defer client.DeleteLogGroup(ctx, &cloudwatchlogs.DeleteLogGroupInput{LogGroupName: aws.String(logGroup)})
+
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"create_log_group": false,
+		"log_group_name":   logGroup,
+	})
+
+	// Creating the group again would fail the apply with ResourceAlreadyExistsException
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	assert.Equal(t, logGroup, terraform.Output(t, terraformOptions, "cloudwatch_log_group_name"))
+
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+	statusCode, body := postJSON(t, apiURL, map[string]interface{}{"prompt": "Say hello", "max_tokens": 10}, nil)
+	require.Equal(t, 200, statusCode, "unexpected response: %v", body)
+
+	retry.DoWithRetry(t, "wait for Lambda log stream", 10, 10*time.Second, func() (string, error) {
+		out, err := client.DescribeLogStreams(ctx, &cloudwatchlogs.DescribeLogStreamsInput{LogGroupName: aws.String(logGroup)})
+		if err != nil {
+			return "", err
+		}
+		if len(out.LogStreams) == 0 {
+			return "", fmt.Errorf("no log streams in %s yet", logGroup)
+		}
+		return "", nil
+	})
+}
+
+func TestLambdaServesCustomModel(t *testing.T) {
+	t.Parallel()
+
+	// Plan-only: provisioned throughput for a custom model is billed hourly
+	customModelARN := "arn:aws:bedrock:us-east-1:111122223333:custom-model/anthropic.claude-3-haiku-20240307-v1:0:200k/abcd1234efgh"
+	throughputARN := "arn:aws:bedrock:us-east-1:111122223333:provisioned-model/wxyz5678ijkl"
+	terraformOptions := planOnlyOptions(t, map[string]interface{}{
+		"custom_model_arn":                        customModelARN,
+		"custom_model_provisioned_throughput_arn": throughputARN,
+	})
+
+	plan := terraform.InitAndPlanAndShowWithStruct(t, terraformOptions)
+
+	lambda, ok := plan.ResourcePlannedValuesMap["aws_lambda_function.bedrock_lambda"]
+	require.True(t, ok, "Lambda function should be in the plan")
+	environment := lambda.AttributeValues["environment"].([]interface{})[0].(map[string]interface{})
+	variables := environment["variables"].(map[string]interface{})
+	assert.Equal(t, customModelARN, variables["BEDROCK_MODEL_ID"])
+	assert.JSONEq(t, `{"`+customModelARN+`": "`+throughputARN+`"}`, variables["PROVISIONED_MODEL_ARNS"].(string))
+
+	policy, ok := plan.ResourcePlannedValuesMap["aws_iam_policy.bedrock_policy"]
+	require.True(t, ok, "Bedrock policy should be in the plan")
+	assert.Contains(t, policy.AttributeValues["policy"], customModelARN)
+	assert.Contains(t, policy.AttributeValues["policy"], throughputARN)
+
+	output, ok := plan.RawPlan.PlannedValues.Outputs["custom_model_arn"]
+	require.True(t, ok, "custom_model_arn should be planned")
+	assert.Equal(t, customModelARN, output.Value)
+}
+
+func TestLambdaInvokesThroughCostAllocationProfile(t *testing.T) {
+	t.Parallel()
+
+	costCenter := fmt.Sprintf("cc-%s", random.UniqueId())
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"bedrock_model_id":            "anthropic.claude-3-haiku-20240307-v1:0",
+		"enable_cost_allocation_tags": true,
+		"application_tags": map[string]string{
+			"Team":       "bedrock-tests",
+			"CostCenter": costCenter,
+		},
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	profileARN := terraform.Output(t, terraformOptions, "application_inference_profile_arn")
+	require.NotEmpty(t, profileARN)
+
+	client := bedrock.NewFromConfig(awsConfig(t))
+	ctx := context.Background()
+
+	profile, err := client.GetInferenceProfile(ctx, &bedrock.GetInferenceProfileInput{InferenceProfileIdentifier: aws.String(profileARN)})
+	require.NoError(t, err)
+	assert.Equal(t, bedrocktypes.InferenceProfileTypeApplication, profile.Type)
+	require.Len(t, profile.Models, 1)
+	assert.Contains(t, aws.ToString(profile.Models[0].ModelArn), "anthropic.claude-3-haiku-20240307-v1:0")
+
+	listed, err := client.ListTagsForResource(ctx, &bedrock.ListTagsForResourceInput{ResourceARN: aws.String(profileARN)})
+	require.NoError(t, err)
+	tags := map[string]string{}
+	for _, tag := range listed.Tags {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	assert.Equal(t, "bedrock-tests", tags["Team"])
+	assert.Equal(t, costCenter, tags["CostCenter"])
+	assert.Equal(t, "bedrock-api", tags["Project"], "module tags should be on the profile too")
+
+	// The default model is served through the profile, which the role must be able to invoke
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+	statusCode, body := postJSON(t, apiURL, map[string]interface{}{"prompt": "Say hello", "max_tokens": 10}, nil)
+	require.Equal(t, 200, statusCode, "unexpected response: %v", body)
+	assert.Equal(t, "anthropic.claude-3-haiku-20240307-v1:0", body["model_id"])
+}
+
+func TestLambdaInvokesThroughProvisionedThroughput(t *testing.T) {
+	t.Parallel()
+
+	// Titan Text Express sells no-commitment throughput for its 8k variant
+	modelID := "amazon.titan-text-express-v1"
+	baseModelID := "amazon.titan-text-express-v1:0:8k"
+	onDemandARN := "arn:aws:bedrock:" + suiteConfig.Region + "::foundation-model/" + modelID
+	baseModelARN := "arn:aws:bedrock:" + suiteConfig.Region + "::foundation-model/" + baseModelID
+	vars := map[string]interface{}{
+		"bedrock_model_id":              modelID,
+		"bedrock_model_arns":            []string{onDemandARN},
+		"enable_provisioned_throughput": true,
+		"provisioned_base_model_id":     baseModelID,
+	}
+
+	// Throughput is billed by the hour from creation, so deploying it is
+	// opt-in. Without RUN_EXPENSIVE_TESTS only the plan is checked.
+	if !suiteConfig.RunExpensiveTests {
+		plan := terraform.InitAndPlanAndShowWithStruct(t, planOnlyOptions(t, vars))
+
+		throughput, ok := plan.ResourcePlannedValuesMap["aws_bedrock_provisioned_model_throughput.default_model[0]"]
+		require.True(t, ok, "provisioned throughput should be in the plan")
+		assert.Equal(t, baseModelARN, throughput.AttributeValues["model_arn"])
+		assert.Equal(t, 1.0, throughput.AttributeValues["model_units"])
+		assert.Nil(t, throughput.AttributeValues["commitment_duration"], "no commitment should be bought by default")
+
+		lambda, ok := plan.ResourcePlannedValuesMap["aws_lambda_function.bedrock_lambda"]
+		require.True(t, ok, "Lambda function should be in the plan")
+		environment := lambda.AttributeValues["environment"].([]interface{})[0].(map[string]interface{})
+		variables := environment["variables"].(map[string]interface{})
+		assert.Equal(t, modelID, variables["BEDROCK_MODEL_ID"], "responses should still name the on-demand model")
+
+		// The throughput's ARN is only known after apply, so the variable and
+		// the policy that name it are unknown in the plan
+		change, ok := plan.ResourceChangesMap["aws_lambda_function.bedrock_lambda"]
+		require.True(t, ok)
+		unknownEnvironment := change.Change.AfterUnknown.(map[string]interface{})["environment"].([]interface{})[0].(map[string]interface{})
+		assert.Equal(t, true, unknownEnvironment["variables"].(map[string]interface{})["PROVISIONED_MODEL_ARNS"])
+
+		policy, ok := plan.ResourceChangesMap["aws_iam_policy.bedrock_policy"]
+		require.True(t, ok, "Bedrock policy should be in the plan")
+		assert.Equal(t, true, policy.Change.AfterUnknown.(map[string]interface{})["policy"])
+		return
+	}
+
+	terraformOptions := moduleTerraformOptions(t, vars)
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	throughputARN := terraform.Output(t, terraformOptions, "provisioned_throughput_arn")
+	require.NotEmpty(t, throughputARN)
+
+	throughput, err := bedrock.NewFromConfig(awsConfig(t)).GetProvisionedModelThroughput(context.Background(), &bedrock.GetProvisionedModelThroughputInput{
+		ProvisionedModelId: aws.String(throughputARN),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, bedrocktypes.ProvisionedModelStatusInService, throughput.Status)
+	assert.Equal(t, int32(1), aws.ToInt32(throughput.ModelUnits))
+	assert.Empty(t, throughput.CommitmentDuration, "no commitment should be bought by default")
+	assert.Equal(t, baseModelARN, aws.ToString(throughput.FoundationModelArn))
+
+	outputs := helpers.GetStackOutputs(t, terraformOptions)
+	assert.True(t, outputs.Features["provisioned_model"])
+	validator := awsvalidate.New(awsConfig(t))
+	validator.AssertLambda(t, outputs.LambdaFunctionName, awsvalidate.LambdaConfig{
+		Environment: map[string]string{
+			"BEDROCK_MODEL_ID":       modelID,
+			"PROVISIONED_MODEL_ARNS": `{"` + modelID + `":"` + throughputARN + `"}`,
+		},
+	})
+	validator.AssertLeastPrivilege(t, outputs.LambdaRoleARN, []string{onDemandARN, throughputARN, baseModelARN})
+
+	statusCode, body := postJSON(t, outputs.APIURL, map[string]interface{}{"prompt": "Say hello", "max_tokens": 10}, nil)
+	require.Equal(t, 200, statusCode, "unexpected response: %v", body)
+	assert.Equal(t, modelID, body["model_id"])
+}
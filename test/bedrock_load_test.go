@@ -0,0 +1,79 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/catherinevee/tfm-aws-ai-bedrock/test/helpers"
+	"github.com/catherinevee/tfm-aws-ai-bedrock/test/loadtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadMeetsSLOs(t *testing.T) {
+	t.Parallel()
+	skipUnlessExpensive(t)
+
+	// The usage plan is set well above the load, so any 429 is a regression
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"enable_api_key": true,
+		"rate_limit":     100,
+		"burst_limit":    200,
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+	outputs := helpers.GetStackOutputs(t, terraformOptions)
+
+	// Warm an instance so the run doesn't measure the first cold start
+	statusCode, body := helpers.InvokeBedrockEndpoint(t, outputs.APIURL, "Say hi", helpers.InvokeOptions{MaxTokens: 10, APIKey: outputs.APIKey})
+	assert.Equal(t, 200, statusCode, "unexpected response: %s", body)
+
+	request := loadtest.HTTPRequest(&http.Client{Timeout: 60 * time.Second}, outputs.APIURL,
+		[]byte(`{"prompt": "Reply with one word: ready", "max_tokens": 10}`),
+		map[string]string{"Content-Type": "application/json", "x-api-key": outputs.APIKey})
+	result := loadtest.Run(context.Background(), loadtest.Config{
+		Requests:    loadtest.IntFromEnv(t, "BEDROCK_LOADTEST_REQUESTS", 40),
+		Concurrency: loadtest.IntFromEnv(t, "BEDROCK_LOADTEST_CONCURRENCY", 4),
+	}, request)
+
+	result.AssertSLO(t, loadtest.SLOFromEnv(t, loadtest.SLO{
+		P50:             5 * time.Second,
+		P95:             10 * time.Second,
+		P99:             15 * time.Second,
+		MaxErrorRate:    0.02,
+		MaxThrottleRate: 0,
+	}))
+}
+
+func TestLoadThrottlesAtUsagePlanLimit(t *testing.T) {
+	t.Parallel()
+
+	const rateLimit, burstLimit = 2, 2
+
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"enable_api_key": true,
+		"rate_limit":     rateLimit,
+		"burst_limit":    burstLimit,
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+	outputs := helpers.GetStackOutputs(t, terraformOptions)
+
+	// An empty prompt fails validation in the handler, so admitted requests
+	// cost a Lambda invocation but never reach Bedrock
+	request := loadtest.HTTPRequest(&http.Client{Timeout: 30 * time.Second}, outputs.APIURL,
+		[]byte(`{"prompt": ""}`),
+		map[string]string{"Content-Type": "application/json", "x-api-key": outputs.APIKey})
+	result := loadtest.Run(context.Background(), loadtest.Config{Requests: 60, Concurrency: 10}, request)
+	result.AssertSLO(t, loadtest.SLO{MaxErrorRate: 0.02, MaxThrottleRate: 1})
+
+	assert.Greater(t, result.ThrottleRate(), 0.0, "a burst above the usage plan should be throttled")
+	assert.Greater(t, result.StatusCodes[http.StatusBadRequest], 0, "requests within the limit should reach the handler")
+
+	// API Gateway's token bucket is approximate, so the bound allows double
+	allowed := burstLimit + rateLimit*result.Duration.Seconds()
+	assert.LessOrEqual(t, float64(result.Admitted()), 2*allowed+1, "admitted %d requests in %s", result.Admitted(), result.Duration)
+}
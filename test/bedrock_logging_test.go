@@ -0,0 +1,180 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	logstypes "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/catherinevee/tfm-aws-ai-bedrock/test/helpers"
+	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/gruntwork-io/terratest/modules/retry"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countLogEvents returns how many events in a log group since a time match a
+// CloudWatch Logs filter pattern.
+func countLogEvents(t *testing.T, client *cloudwatchlogs.Client, logGroup string, pattern string, since time.Time) int {
+	out, err := client.FilterLogEvents(context.Background(), &cloudwatchlogs.FilterLogEventsInput{
+		LogGroupName:  aws.String(logGroup),
+		FilterPattern: aws.String(pattern),
+		StartTime:     aws.Int64(since.UnixMilli()),
+	})
+	require.NoError(t, err)
+	return len(out.Events)
+}
+
+// waitForLogEvents polls a log group with exponential backoff until an event
+// matching the filter pattern and containing marker arrives, then returns
+// every matching event. Delivery to CloudWatch Logs lags the invocation by
+// seconds to a minute.
+func waitForLogEvents(t *testing.T, client *cloudwatchlogs.Client, logGroup, pattern, marker string, since time.Time) []logstypes.FilteredLogEvent {
+	deadline := time.Now().Add(3 * time.Minute)
+	backoff := 2 * time.Second
+
+	for {
+		var events []logstypes.FilteredLogEvent
+		input := &cloudwatchlogs.FilterLogEventsInput{
+			LogGroupName:  aws.String(logGroup),
+			FilterPattern: aws.String(pattern),
+			StartTime:     aws.Int64(since.UnixMilli()),
+		}
+		for {
+			out, err := client.FilterLogEvents(context.Background(), input)
+			require.NoError(t, err)
+			events = append(events, out.Events...)
+			if out.NextToken == nil {
+				break
+			}
+			input.NextToken = out.NextToken
+		}
+
+		for _, event := range events {
+			if strings.Contains(aws.ToString(event.Message), marker) {
+				return events
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no log event matching %s with %q in %s after 3 minutes", pattern, marker, logGroup)
+		}
+		time.Sleep(backoff)
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+func TestBedrockLogging(t *testing.T) {
+	t.Parallel()
+
+	modelID := suiteConfig.ModelID
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"bedrock_model_id": modelID,
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	outputs := helpers.GetStackOutputs(t, terraformOptions)
+	startTime := time.Now().Add(-time.Minute)
+
+	statusCode, body := helpers.InvokeBedrockEndpoint(t, outputs.APIURL, "Say hello in one word", helpers.InvokeOptions{MaxTokens: 20})
+	require.Equal(t, 200, statusCode, "unexpected response: %s", body)
+	requestID := helpers.AssertCompletionResponse(t, body).Metadata.RequestID
+
+	// The runtime's REPORT line is written last, so once it is in every other
+	// line of the invocation is too
+	client := cloudwatchlogs.NewFromConfig(awsConfig(t))
+	events := waitForLogEvents(t, client, outputs.LogGroupName, fmt.Sprintf("%q", requestID), "REPORT RequestId: "+requestID, startTime)
+
+	var summary map[string]interface{}
+	for _, event := range events {
+		message := aws.ToString(event.Message)
+		assert.NotContains(t, message, "[ERROR]", "a successful invocation should log no errors")
+		if _, rest, found := strings.Cut(message, "Request summary: "); found {
+			require.NoError(t, json.Unmarshal([]byte(strings.TrimSpace(rest)), &summary), "summary is not JSON: %s", message)
+		}
+	}
+
+	require.NotNil(t, summary, "the invocation should log a request summary")
+	assert.Equal(t, requestID, summary["request_id"])
+	assert.Equal(t, modelID, summary["model_id"])
+	assert.Equal(t, true, summary["success"])
+	assert.Greater(t, summary["input_tokens"], 0.0)
+	assert.Greater(t, summary["output_tokens"], 0.0)
+	assert.Greater(t, summary["latency_ms"], 0.0)
+	assert.Nil(t, summary["error_code"])
+}
+
+func TestLogSamplingZeroLogsMetadataOnly(t *testing.T) {
+	t.Parallel()
+
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"log_sampling_rate": 0,
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	sampling := terraform.OutputMap(t, terraformOptions, "log_sampling")
+	assert.Equal(t, "0", sampling["sampling_rate"])
+
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+	logGroup := terraform.Output(t, terraformOptions, "cloudwatch_log_group_name")
+	marker := "marker" + random.UniqueId()
+	startTime := time.Now().Add(-time.Minute)
+
+	statusCode, body := postJSON(t, apiURL, map[string]interface{}{
+		"prompt":     fmt.Sprintf("Repeat this word exactly: %s", marker),
+		"max_tokens": 20,
+	}, nil)
+	require.Equal(t, 200, statusCode, "unexpected response: %v", body)
+
+	// Logs arrive asynchronously, so wait for the metadata line before checking content is absent
+	client := cloudwatchlogs.NewFromConfig(awsConfig(t))
+	retry.DoWithRetry(t, "wait for request metadata log", 12, 10*time.Second, func() (string, error) {
+		if countLogEvents(t, client, logGroup, `"Request metadata"`, startTime) == 0 {
+			return "", fmt.Errorf("no request metadata logged yet")
+		}
+		return "", nil
+	})
+
+	assert.Zero(t, countLogEvents(t, client, logGroup, fmt.Sprintf("%q", marker), startTime), "prompt and response content should not be logged at rate 0")
+	assert.Zero(t, countLogEvents(t, client, logGroup, `"Processing request"`, startTime))
+}
+
+func TestLogSubscriptionFilterTargetsDestination(t *testing.T) {
+	t.Parallel()
+
+	// Plan-only: stands in for a central team's Firehose stream in another account
+	destination := "arn:aws:firehose:us-east-1:111122223333:deliverystream/central-logs"
+	terraformOptions := planOnlyOptions(t, map[string]interface{}{
+		"log_subscription_destination_arn": destination,
+		"log_subscription_filter_pattern":  `{ $.level = "ERROR" }`,
+	})
+
+	plan := terraform.InitAndPlanAndShowWithStruct(t, terraformOptions)
+
+	filter, ok := plan.ResourcePlannedValuesMap["aws_cloudwatch_log_subscription_filter.lambda_logs[0]"]
+	require.True(t, ok, "subscription filter should be in the plan")
+	assert.Equal(t, destination, filter.AttributeValues["destination_arn"])
+	assert.Equal(t, `{ $.level = "ERROR" }`, filter.AttributeValues["filter_pattern"])
+
+	logGroup, ok := plan.ResourcePlannedValuesMap["aws_cloudwatch_log_group.lambda_logs[0]"]
+	require.True(t, ok, "Lambda log group should be in the plan")
+	assert.Equal(t, logGroup.AttributeValues["name"], filter.AttributeValues["log_group_name"])
+
+	// Firehose destinations are written through a role CloudWatch Logs assumes
+	policy, ok := plan.ResourcePlannedValuesMap["aws_iam_role_policy.log_subscription[0]"]
+	require.True(t, ok, "subscription role policy should be in the plan")
+	assert.Contains(t, policy.AttributeValues["policy"], "firehose:PutRecordBatch")
+	assert.Contains(t, policy.AttributeValues["policy"], destination)
+	assert.NotContains(t, plan.ResourcePlannedValuesMap, "aws_lambda_permission.log_subscription[0]")
+}
@@ -0,0 +1,187 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// modelFamily is the InvokeModel payload shape of one Bedrock model family:
+// what the handler should send and a response the model would return.
+type modelFamily struct {
+	prefix string
+	// tokenLimit is the request field carrying max_tokens
+	tokenLimit string
+	// prompt extracts the prompt text from a request body
+	prompt   func(request map[string]interface{}) interface{}
+	response string
+	content  string
+}
+
+var modelFamilies = []modelFamily{
+	{
+		prefix:     "anthropic.",
+		tokenLimit: "max_tokens",
+		prompt: func(request map[string]interface{}) interface{} {
+			return request["messages"].([]interface{})[0].(map[string]interface{})["content"]
+		},
+		response: `{"content": [{"type": "text", "text": "Claude completion"}], "stop_reason": "end_turn", "usage": {"input_tokens": 7, "output_tokens": 3}}`,
+		content:  "Claude completion",
+	},
+	{
+		prefix:     "amazon.titan",
+		tokenLimit: "maxTokenCount",
+		prompt: func(request map[string]interface{}) interface{} {
+			return request["inputText"]
+		},
+		response: `{"results": [{"outputText": "Titan completion", "completionReason": "FINISH"}]}`,
+		content:  "Titan completion",
+	},
+	{
+		prefix:     "meta.",
+		tokenLimit: "max_gen_len",
+		prompt: func(request map[string]interface{}) interface{} {
+			return request["prompt"]
+		},
+		response: `{"generation": "Llama completion", "prompt_token_count": 7, "generation_token_count": 3, "stop_reason": "stop"}`,
+		content:  "Llama completion",
+	},
+	{
+		prefix:     "mistral.",
+		tokenLimit: "max_tokens",
+		prompt: func(request map[string]interface{}) interface{} {
+			return request["prompt"]
+		},
+		response: `{"outputs": [{"text": "Mistral completion", "stop_reason": "stop"}]}`,
+		content:  "Mistral completion",
+	},
+}
+
+// matrixModelIDs is the list of models TestBedrockModelMatrix covers, from the
+// comma-separated BEDROCK_TEST_MODEL_IDS or, by default, the suite's model and
+// one per family.
+func matrixModelIDs() []string {
+	if value := os.Getenv("BEDROCK_TEST_MODEL_IDS"); value != "" {
+		return strings.Split(value, ",")
+	}
+	modelIDs := []string{suiteConfig.ModelID}
+	for _, modelID := range []string{
+		"anthropic.claude-3-haiku-20240307-v1:0",
+		"amazon.titan-text-express-v1",
+		"meta.llama3-8b-instruct-v1:0",
+		"mistral.mistral-7b-instruct-v0:2",
+	} {
+		if modelID != suiteConfig.ModelID {
+			modelIDs = append(modelIDs, modelID)
+		}
+	}
+	return modelIDs
+}
+
+// modelFamilyOf returns the payload shape for a model ID.
+func modelFamilyOf(t *testing.T, modelID string) modelFamily {
+	for _, family := range modelFamilies {
+		if strings.HasPrefix(modelID, family.prefix) {
+			return family
+		}
+	}
+	t.Fatalf("no payload mapping for model %q", modelID)
+	return modelFamily{}
+}
+
+func TestBedrockModelMatrix(t *testing.T) {
+	t.Parallel()
+
+	for _, modelID := range matrixModelIDs() {
+		modelID := strings.TrimSpace(modelID)
+		family := modelFamilyOf(t, modelID)
+
+		t.Run(modelID, func(t *testing.T) {
+			t.Parallel()
+
+			t.Run("plan", func(t *testing.T) {
+				t.Parallel()
+
+				modelARN := "arn:aws:bedrock:" + suiteConfig.Region + "::foundation-model/" + modelID
+				plan := terraform.InitAndPlanAndShowWithStruct(t, planOnlyOptions(t, map[string]interface{}{
+					"bedrock_model_id":   modelID,
+					"bedrock_model_arns": []string{modelARN},
+				}))
+
+				lambda, ok := plan.ResourcePlannedValuesMap["aws_lambda_function.bedrock_lambda"]
+				require.True(t, ok, "Lambda function should be in the plan")
+				environment := lambda.AttributeValues["environment"].([]interface{})[0].(map[string]interface{})
+				variables := environment["variables"].(map[string]interface{})
+				assert.Equal(t, modelID, variables["BEDROCK_MODEL_ID"])
+
+				policy, ok := plan.ResourcePlannedValuesMap["aws_iam_policy.bedrock_policy"]
+				require.True(t, ok, "Bedrock policy should be in the plan")
+				var invokeResources []string
+				for _, statement := range planPolicyStatements(t, policy.AttributeValues["policy"]) {
+					for _, action := range statement.Action {
+						if action == "bedrock:InvokeModel" {
+							invokeResources = append(invokeResources, statement.Resource...)
+						}
+					}
+				}
+				assert.Equal(t, []string{modelARN}, invokeResources, "only the configured model should be invokable")
+			})
+
+			t.Run("payload", func(t *testing.T) {
+				t.Parallel()
+
+				var mu sync.Mutex
+				var gotPaths []string
+				var gotBodies []map[string]interface{}
+				mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					var sent map[string]interface{}
+					json.NewDecoder(r.Body).Decode(&sent)
+					mu.Lock()
+					gotPaths = append(gotPaths, r.URL.Path)
+					gotBodies = append(gotBodies, sent)
+					mu.Unlock()
+
+					w.Header().Set("Content-Type", "application/json")
+					w.Write([]byte(family.response))
+				}))
+				defer mock.Close()
+
+				response := runHandlerLocally(t, map[string]string{
+					"BEDROCK_ENDPOINT_URL": mock.URL,
+					"BEDROCK_MODEL_ID":     modelID,
+				}, map[string]interface{}{
+					"httpMethod": "POST",
+					"resource":   "/bedrock",
+					"headers":    map[string]string{"Content-Type": "application/json"},
+					"body":       `{"prompt": "Hello mock", "max_tokens": 64}`,
+				})
+				require.EqualValues(t, 200, response["statusCode"], "unexpected response: %v", response)
+
+				var body map[string]interface{}
+				require.NoError(t, json.Unmarshal([]byte(response["body"].(string)), &body))
+				assert.Equal(t, family.content, body["content"])
+				assert.Equal(t, modelID, body["model_id"])
+
+				mu.Lock()
+				defer mu.Unlock()
+				require.Len(t, gotBodies, 1)
+				assert.Contains(t, gotPaths[0], "/model/"+modelID+"/invoke")
+				assert.Equal(t, "Hello mock", family.prompt(gotBodies[0]))
+
+				request := gotBodies[0]
+				if config, ok := request["textGenerationConfig"].(map[string]interface{}); ok {
+					request = config
+				}
+				assert.EqualValues(t, 64, request[family.tokenLimit], "max_tokens should map to %s", family.tokenLimit)
+			})
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	awshelper "github.com/gruntwork-io/terratest/modules/aws"
+	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/gruntwork-io/terratest/modules/retry"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCostKillswitchAlarmAndAction(t *testing.T) {
+	t.Parallel()
+
+	// The threshold is never reached here; breaching it would pause the API
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"enable_cost_killswitch":    true,
+		"cost_killswitch_threshold": 500,
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	killswitchARN := terraform.Output(t, terraformOptions, "cost_killswitch_function_arn")
+	require.NotEmpty(t, killswitchARN)
+	functionName := terraform.Output(t, terraformOptions, "lambda_function_name")
+
+	client := cloudwatch.NewFromConfig(awsConfig(t))
+	out, err := client.DescribeAlarms(context.Background(), &cloudwatch.DescribeAlarmsInput{
+		AlarmNames: []string{terraformOptions.Vars["name_prefix"].(string) + "-cost-killswitch"},
+	})
+	require.NoError(t, err)
+	require.Len(t, out.MetricAlarms, 1, "killswitch alarm should exist")
+
+	alarm := out.MetricAlarms[0]
+	assert.Equal(t, "Invocations", aws.ToString(alarm.MetricName))
+	assert.Equal(t, "AWS/Lambda", aws.ToString(alarm.Namespace))
+	assert.Equal(t, 500.0, aws.ToFloat64(alarm.Threshold))
+	require.Len(t, alarm.Dimensions, 1)
+	assert.Equal(t, functionName, aws.ToString(alarm.Dimensions[0].Value))
+	assert.Contains(t, alarm.AlarmActions, killswitchARN, "the alarm should invoke the killswitch Lambda")
+}
+
+func TestCustomMetricNamespaceAndLogGroupPrefix(t *testing.T) {
+	t.Parallel()
+
+	uniqueID := random.UniqueId()
+	namespace := fmt.Sprintf("BedrockAPI/test-%s", uniqueID)
+	logGroupPrefix := fmt.Sprintf("/bedrock-test/%s", uniqueID)
+
+	// Idempotent replays emit DuplicateRequests with a FunctionName dimension
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"metric_namespace":   namespace,
+		"log_group_prefix":   logGroupPrefix,
+		"enable_idempotency": true,
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	assert.Equal(t, namespace, terraform.Output(t, terraformOptions, "metric_namespace"))
+	assert.Equal(t, logGroupPrefix, terraform.Output(t, terraformOptions, "log_group_prefix"))
+	assert.True(t, strings.HasPrefix(terraform.Output(t, terraformOptions, "cloudwatch_log_group_name"), logGroupPrefix+"/"))
+
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+	functionName := terraform.Output(t, terraformOptions, "lambda_function_name")
+	startTime := time.Now()
+
+	payload := map[string]interface{}{"prompt": "Say hello", "max_tokens": 10}
+	headers := map[string]string{"Idempotency-Key": uniqueID}
+	for i := 0; i < 2; i++ {
+		statusCode, body := postJSON(t, apiURL, payload, headers)
+		require.Equal(t, 200, statusCode, "unexpected response: %v", body)
+	}
+
+	assert.GreaterOrEqual(t, waitForNamespacedMetricSum(t, namespace, "DuplicateRequests", functionName, startTime), 1.0)
+}
+
+// dashboardStrings collects every string in a decoded dashboard body. Widgets
+// name their metrics' dimension values and their alarms' ARNs as strings.
+func dashboardStrings(value interface{}, found map[string]bool) {
+	switch v := value.(type) {
+	case string:
+		found[v] = true
+	case []interface{}:
+		for _, item := range v {
+			dashboardStrings(item, found)
+		}
+	case map[string]interface{}:
+		for _, item := range v {
+			dashboardStrings(item, found)
+		}
+	}
+}
+
+func TestMonitoringDashboardAndAlarms(t *testing.T) {
+	t.Parallel()
+
+	topicARN := awshelper.CreateSnsTopic(t, suiteConfig.Region, testNamePrefix+random.UniqueId())
+	defer awshelper.DeleteSNSTopic(t, suiteConfig.Region, topicARN)
+
+	// The handler sends itself SIGTERM while Bedrock is still generating, and
+	// with no drain handler the runtime exits, which Lambda counts as an error
+	const lambdaTimeout = 15
+	const throttleThreshold = 5
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"enable_monitoring":        true,
+		"alarm_actions":            []string{topicARN},
+		"lambda_timeout":           lambdaTimeout,
+		"throttle_alarm_threshold": throttleThreshold,
+		"handler_fault_injection": map[string]interface{}{
+			"shutdown_after_ms": 100,
+		},
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	namePrefix := terraformOptions.Vars["name_prefix"].(string)
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+	functionName := terraform.Output(t, terraformOptions, "lambda_function_name")
+	alarmNames := terraform.OutputList(t, terraformOptions, "cloudwatch_alarm_names")
+	dashboardName := terraform.Output(t, terraformOptions, "cloudwatch_dashboard_name")
+
+	ctx := context.Background()
+	client := cloudwatch.NewFromConfig(awsConfig(t))
+	out, err := client.DescribeAlarms(ctx, &cloudwatch.DescribeAlarmsInput{AlarmNames: alarmNames})
+	require.NoError(t, err)
+	alarms := map[string]cwtypes.MetricAlarm{}
+	for _, alarm := range out.MetricAlarms {
+		alarms[aws.ToString(alarm.AlarmName)] = alarm
+	}
+
+	t.Run("alarms", func(t *testing.T) {
+		expected := map[string]struct {
+			namespace string
+			metric    string
+			threshold float64
+		}{
+			namePrefix + "-lambda-errors":     {"AWS/Lambda", "Errors", 0},
+			namePrefix + "-lambda-duration":   {"AWS/Lambda", "Duration", lambdaTimeout * 1000 * 0.8},
+			namePrefix + "-bedrock-throttles": {"BedrockAPI", "BedrockThrottles", throttleThreshold},
+		}
+		assert.Len(t, alarms, len(expected), "cloudwatch_alarm_names should list every alarm the module creates")
+
+		for name, want := range expected {
+			alarm, ok := alarms[name]
+			if !assert.True(t, ok, "alarm %s should exist", name) {
+				continue
+			}
+			assert.Equal(t, want.namespace, aws.ToString(alarm.Namespace), name)
+			assert.Equal(t, want.metric, aws.ToString(alarm.MetricName), name)
+			assert.Equal(t, want.threshold, aws.ToFloat64(alarm.Threshold), name)
+			assert.Equal(t, []string{topicARN}, alarm.AlarmActions, name)
+			require.Len(t, alarm.Dimensions, 1, name)
+			assert.Equal(t, "FunctionName", aws.ToString(alarm.Dimensions[0].Name), name)
+			assert.Equal(t, functionName, aws.ToString(alarm.Dimensions[0].Value), name)
+		}
+	})
+
+	t.Run("dashboard", func(t *testing.T) {
+		require.Equal(t, namePrefix+"-bedrock", dashboardName)
+		dashboard, err := client.GetDashboard(ctx, &cloudwatch.GetDashboardInput{DashboardName: aws.String(dashboardName)})
+		require.NoError(t, err)
+
+		var body struct {
+			Widgets []map[string]interface{} `json:"widgets"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(aws.ToString(dashboard.DashboardBody)), &body))
+		require.NotEmpty(t, body.Widgets)
+
+		found := map[string]bool{}
+		for _, widget := range body.Widgets {
+			dashboardStrings(widget["properties"], found)
+		}
+		assert.True(t, found[functionName], "the dashboard should chart the deployed function")
+		assert.True(t, found[namePrefix+"-bedrock-api"], "the dashboard should chart the deployed API")
+		assert.True(t, found["BedrockThrottles"], "the dashboard should chart the handler's throttles")
+		for name, alarm := range alarms {
+			assert.True(t, found[aws.ToString(alarm.AlarmArn)], "the dashboard should show alarm %s", name)
+		}
+	})
+
+	t.Run("errors_alarm_fires", func(t *testing.T) {
+		// The alarm needs errors in two consecutive five-minute periods, so
+		// traffic keeps coming until it fires
+		alarmName := namePrefix + "-lambda-errors"
+		since := time.Now()
+		retry.DoWithRetry(t, "wait for the errors alarm", 30, 30*time.Second, func() (string, error) {
+			statusCode, body := postJSON(t, apiURL, map[string]interface{}{
+				"prompt":     "Count from one to twenty",
+				"max_tokens": 200,
+			}, nil)
+			if statusCode != http.StatusBadGateway {
+				t.Logf("expected a 502 from the crashed runtime, got %d: %v", statusCode, body)
+			}
+
+			out, err := client.DescribeAlarms(ctx, &cloudwatch.DescribeAlarmsInput{AlarmNames: []string{alarmName}})
+			if err != nil {
+				return "", err
+			}
+			if len(out.MetricAlarms) != 1 {
+				return "", fmt.Errorf("alarm %s not found", alarmName)
+			}
+			if state := out.MetricAlarms[0].StateValue; state != cwtypes.StateValueAlarm {
+				return "", fmt.Errorf("alarm %s is %s", alarmName, state)
+			}
+			return "", nil
+		})
+
+		// The transition should have notified the topic
+		history, err := client.DescribeAlarmHistory(ctx, &cloudwatch.DescribeAlarmHistoryInput{
+			AlarmName:       aws.String(alarmName),
+			HistoryItemType: cwtypes.HistoryItemTypeAction,
+			StartDate:       aws.Time(since),
+		})
+		require.NoError(t, err)
+		notified := false
+		for _, item := range history.AlarmHistoryItems {
+			notified = notified || strings.Contains(aws.ToString(item.HistorySummary), topicARN)
+		}
+		assert.True(t, notified, "the alarm should have published to %s", topicARN)
+	})
+}
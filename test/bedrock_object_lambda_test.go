@@ -0,0 +1,74 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestObjectLambdaTransformsCompletions(t *testing.T) {
+	t.Parallel()
+
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"enable_object_lambda": true,
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	bucket := terraform.Output(t, terraformOptions, "completions_bucket_name")
+	accessPointARN := terraform.Output(t, terraformOptions, "object_lambda_access_point_arn")
+	require.NotEmpty(t, accessPointARN)
+
+	client := s3.NewFromConfig(awsConfig(t))
+	ctx := context.Background()
+
+	// Completions written by the handler land under completions/
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+	statusCode, body := postJSON(t, apiURL, map[string]interface{}{"prompt": "Say hello", "max_tokens": 10}, nil)
+	require.Equal(t, 200, statusCode, "unexpected response: %v", body)
+	completionKey, ok := body["completion_key"].(string)
+	require.True(t, ok, "response should reference the stored completion: %v", body)
+	defer client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String(completionKey)})
+
+	// A stored completion with sensitive content is redacted when read through Object Lambda
+	key := "completions/object-lambda-test.json"
+	_, err := client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        strings.NewReader(`{"content": "Contact alice@example.com or +1 555 010 0199"}`),
+		ContentType: aws.String("application/json"),
+	})
+	require.NoError(t, err)
+	defer client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+
+	out, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(accessPointARN), Key: aws.String(key)})
+	require.NoError(t, err)
+	defer out.Body.Close()
+
+	transformed, err := io.ReadAll(out.Body)
+	require.NoError(t, err)
+
+	var record map[string]interface{}
+	require.NoError(t, json.Unmarshal(transformed, &record), "transformed object should stay JSON: %s", transformed)
+	assert.Equal(t, true, record["redacted"])
+	assert.NotContains(t, record["content"], "alice@example.com")
+	assert.NotContains(t, record["content"], "555 010 0199")
+	assert.Contains(t, record["content"], "[REDACTED]")
+
+	// Reading the bucket directly still returns the original
+	original, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	require.NoError(t, err)
+	defer original.Body.Close()
+	originalBody, err := io.ReadAll(original.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(originalBody), "alice@example.com")
+}
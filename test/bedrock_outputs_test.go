@@ -0,0 +1,289 @@
+package test
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+
+	"github.com/catherinevee/tfm-aws-ai-bedrock/test/helpers"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeploymentInfoOutput(t *testing.T) {
+	t.Parallel()
+
+	terraformOptions := moduleTerraformOptions(t, nil)
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	info := terraform.OutputMapOfObjects(t, terraformOptions, "deployment_info")
+
+	expectedKeys := []string{
+		"api_url",
+		"api_id",
+		"api_stage_name",
+		"lambda_function_name",
+		"lambda_function_arn",
+		"lambda_role_arn",
+		"log_group_name",
+		"bedrock_model_id",
+		"handler_version",
+		"features",
+	}
+	for _, key := range expectedKeys {
+		assert.Contains(t, info, key, "deployment_info should contain %q", key)
+	}
+
+	// The aggregated object must stay in sync with the individual outputs
+	assert.Equal(t, terraform.Output(t, terraformOptions, "api_gateway_url"), info["api_url"])
+	assert.Equal(t, terraform.Output(t, terraformOptions, "api_gateway_rest_api_id"), info["api_id"])
+	assert.Equal(t, terraform.Output(t, terraformOptions, "lambda_function_name"), info["lambda_function_name"])
+	assert.Equal(t, terraform.Output(t, terraformOptions, "lambda_function_arn"), info["lambda_function_arn"])
+	assert.Equal(t, terraform.Output(t, terraformOptions, "lambda_role_arn"), info["lambda_role_arn"])
+	assert.Equal(t, terraform.Output(t, terraformOptions, "cloudwatch_log_group_name"), info["log_group_name"])
+
+	features, ok := info["features"].(map[string]interface{})
+	require.True(t, ok, "features should be an object")
+	assert.Equal(t, false, features["waf"])
+	assert.Equal(t, false, features["monitoring"])
+	assert.Equal(t, true, features["cors"])
+}
+
+func TestSmokeTestOutput(t *testing.T) {
+	t.Parallel()
+
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"run_smoke_test": true,
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	result := terraform.OutputMapOfObjects(t, terraformOptions, "smoke_test_result")
+	require.NotEmpty(t, result, "smoke_test_result should be populated")
+	assert.EqualValues(t, 200, result["status_code"])
+	assert.Equal(t, true, result["success"])
+	assert.NotEmpty(t, result["content"])
+}
+
+func TestWAFRulesOutput(t *testing.T) {
+	t.Parallel()
+
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"enable_waf":            true,
+		"waf_blocked_ip_ranges": []string{"198.51.100.0/24"},
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	assert.NotEmpty(t, terraform.Output(t, terraformOptions, "waf_web_acl_id"))
+
+	rules := terraform.OutputListOfObjects(t, terraformOptions, "waf_rules")
+	require.Len(t, rules, 3)
+
+	expected := []struct {
+		name   string
+		action string
+	}{
+		{"BlockedIPRule", "block"},
+		{"RateLimitRule", "block"},
+		{"AWSManagedRulesCommonRuleSet", "managed"},
+	}
+	for i, rule := range rules {
+		assert.Equal(t, expected[i].name, rule["name"])
+		assert.Equal(t, expected[i].action, rule["action"])
+		if i > 0 {
+			assert.Greater(t, rule["priority"], rules[i-1]["priority"], "rules should be listed in priority order")
+		}
+	}
+}
+
+func TestGrantedIAMActionsOutput(t *testing.T) {
+	t.Parallel()
+
+	// Plan-only: the action list is known before any resource exists
+	plannedActions := func(t *testing.T, vars map[string]interface{}) []string {
+		plan := terraform.InitAndPlanAndShowWithStruct(t, planOnlyOptions(t, vars))
+
+		output, ok := plan.RawPlan.PlannedValues.Outputs["granted_iam_actions"]
+		require.True(t, ok, "granted_iam_actions should be planned")
+
+		var actions []string
+		for _, action := range output.Value.([]interface{}) {
+			actions = append(actions, action.(string))
+		}
+		assert.True(t, sort.StringsAreSorted(actions), "actions should be sorted")
+		return actions
+	}
+
+	baseVars := map[string]interface{}{
+		"vpc_subnet_ids":              []string{"subnet-00000000000000001"},
+		"vpc_security_group_ids":      []string{"sg-00000000000000001"},
+		"enable_conversation_history": true,
+	}
+
+	t.Run("base", func(t *testing.T) {
+		t.Parallel()
+
+		actions := plannedActions(t, baseVars)
+		assert.Subset(t, actions, []string{
+			"bedrock:InvokeModel",
+			"logs:PutLogEvents",
+			"ec2:CreateNetworkInterface",
+			"ec2:DeleteNetworkInterface",
+			"dynamodb:GetItem",
+			"dynamodb:PutItem",
+		})
+		assert.NotContains(t, actions, "kms:Decrypt", "KMS access should only appear with field encryption")
+		assert.NotContains(t, actions, "lambda:PutFunctionConcurrency", "the killswitch role's actions should only appear with the killswitch")
+	})
+
+	// Field encryption adds to the Lambda role; the killswitch has a role of its own
+	t.Run("features", func(t *testing.T) {
+		t.Parallel()
+
+		vars := map[string]interface{}{
+			"conversation_field_encryption": true,
+			"enable_cost_killswitch":        true,
+		}
+		for key, value := range baseVars {
+			vars[key] = value
+		}
+		assert.Subset(t, plannedActions(t, vars), []string{
+			"bedrock:InvokeModel",
+			"ec2:CreateNetworkInterface",
+			"kms:GenerateDataKey",
+			"kms:Decrypt",
+			"lambda:PutFunctionConcurrency",
+		})
+	})
+}
+
+func TestHealthReportsHandlerVersion(t *testing.T) {
+	t.Parallel()
+
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"enable_api_key": true,
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	handlerVersion := terraform.Output(t, terraformOptions, "handler_version")
+	require.NotEmpty(t, handlerVersion)
+
+	// /health needs no API key even when the Bedrock route does
+	healthURL := terraform.Output(t, terraformOptions, "health_url")
+	statusCode, body := helpers.HTTPDoWithRetryPolicy(t, "GET", healthURL, nil, nil, helpers.DefaultRetryPolicy())
+	require.Equal(t, 200, statusCode, "unexpected response: %s", body)
+
+	var health struct {
+		Status  string `json:"status"`
+		Version string `json:"version"`
+	}
+	require.NoError(t, json.Unmarshal(body, &health))
+	assert.Equal(t, "ok", health.Status)
+	assert.Equal(t, handlerVersion, health.Version)
+}
+
+func TestServiceQuotaOutputs(t *testing.T) {
+	t.Parallel()
+
+	// Plan-only: the quotas are data sources, read during the plan
+	terraformOptions := planOnlyOptions(t, map[string]interface{}{
+		"enable_quota_check": true,
+	})
+
+	plan := terraform.InitAndPlanAndShowWithStruct(t, terraformOptions)
+
+	for _, name := range []string{"model_tpm_quota", "model_rpm_quota"} {
+		output, ok := plan.RawPlan.PlannedValues.Outputs[name]
+		require.True(t, ok, "%s should be planned", name)
+		quota, ok := output.Value.(float64)
+		require.True(t, ok, "%s should be a number, got %v", name, output.Value)
+		assert.Greater(t, quota, 0.0)
+	}
+}
+
+func TestEffectiveConfigOutput(t *testing.T) {
+	t.Parallel()
+
+	// Plan-only: every value comes from variables and locals
+	terraformOptions := planOnlyOptions(t, map[string]interface{}{
+		"environment":      "prod",
+		"max_tokens":       321,
+		"enable_streaming": true,
+	})
+
+	plan := terraform.InitAndPlanAndShowWithStruct(t, terraformOptions)
+
+	output, ok := plan.RawPlan.PlannedValues.Outputs["effective_config"]
+	require.True(t, ok, "effective_config should be planned")
+	require.False(t, output.Sensitive, "effective_config should not be sensitive")
+
+	config, ok := output.Value.(map[string]interface{})
+	require.True(t, ok, "effective_config should be an object")
+	model := config["model"].(map[string]interface{})
+	limits := config["limits"].(map[string]interface{})
+	logging := config["logging"].(map[string]interface{})
+	features := config["features"].(map[string]interface{})
+
+	// Explicitly set
+	assert.Equal(t, "prod", config["environment"])
+	assert.EqualValues(t, 321, model["max_tokens"])
+	assert.Equal(t, true, features["streaming"])
+
+	// Defaulted
+	assert.EqualValues(t, 0.7, model["temperature"])
+	assert.Equal(t, "INFO", logging["log_level"])
+	assert.Equal(t, false, features["waf"])
+	assert.Nil(t, limits["waf_rate_limit"], "WAF limits should only appear when WAF is enabled")
+
+	// Production resolves the default allowlist to the configured model
+	assert.Contains(t, model["allowed_model_ids"], model["default_model_id"])
+}
+
+func TestCustomDomainMinimumTLSVersion(t *testing.T) {
+	t.Parallel()
+
+	// Plan-only: API Gateway doesn't validate the certificate until apply
+	certificateARN := "arn:aws:acm:us-east-1:111122223333:certificate/12345678-90ab-cdef-1234-567890abcdef"
+	for _, tc := range []struct {
+		name     string
+		vars     map[string]interface{}
+		expected string
+	}{
+		{"default", map[string]interface{}{}, "TLS_1_2"},
+		{"configured", map[string]interface{}{"minimum_tls_version": "TLS_1_0"}, "TLS_1_0"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			vars := map[string]interface{}{
+				"custom_domain_name":            "ai.example.com",
+				"custom_domain_certificate_arn": certificateARN,
+			}
+			for k, v := range tc.vars {
+				vars[k] = v
+			}
+			plan := terraform.InitAndPlanAndShowWithStruct(t, planOnlyOptions(t, vars))
+
+			domain, ok := plan.ResourcePlannedValuesMap["aws_api_gateway_domain_name.custom[0]"]
+			require.True(t, ok, "custom domain should be in the plan")
+			assert.Equal(t, tc.expected, domain.AttributeValues["security_policy"])
+			assert.Equal(t, certificateARN, domain.AttributeValues["regional_certificate_arn"])
+
+			_, ok = plan.ResourcePlannedValuesMap["aws_api_gateway_base_path_mapping.custom[0]"]
+			assert.True(t, ok, "the domain should be mapped to the stage")
+
+			output, ok := plan.RawPlan.PlannedValues.Outputs["minimum_tls_version"]
+			require.True(t, ok, "minimum_tls_version should be planned")
+			assert.Equal(t, tc.expected, output.Value)
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// policyStatement is an IAM policy statement with Action and Resource
+// normalized to lists, since a single value may be written as a string.
+type policyStatement struct {
+	Effect   string
+	Action   []string
+	Resource []string
+}
+
+// planPolicyStatements decodes the statements of a planned IAM policy document.
+func planPolicyStatements(t *testing.T, policy interface{}) []policyStatement {
+	document, ok := policy.(string)
+	require.True(t, ok, "policy should be known at plan time, got %v", policy)
+
+	var parsed struct {
+		Statement []struct {
+			Effect   string
+			Action   interface{}
+			Resource interface{}
+		}
+	}
+	require.NoError(t, json.Unmarshal([]byte(document), &parsed))
+
+	asList := func(value interface{}) []string {
+		switch v := value.(type) {
+		case string:
+			return []string{v}
+		case []interface{}:
+			list := make([]string, len(v))
+			for i, item := range v {
+				list[i] = item.(string)
+			}
+			return list
+		}
+		return nil
+	}
+
+	statements := make([]policyStatement, len(parsed.Statement))
+	for i, statement := range parsed.Statement {
+		statements[i] = policyStatement{statement.Effect, asList(statement.Action), asList(statement.Resource)}
+	}
+	return statements
+}
+
+// planResourceCounts counts the managed resources in a plan by type.
+func planResourceCounts(plan *terraform.PlanStruct) map[string]int {
+	counts := map[string]int{}
+	for _, change := range plan.ResourceChangesMap {
+		if change.Mode == "managed" {
+			counts[change.Type]++
+		}
+	}
+	return counts
+}
+
+func TestPlanMatrix(t *testing.T) {
+	t.Parallel()
+
+	// Plan-only: every combination of the features that change the most
+	// wiring, checked in seconds without deploying anything
+	for _, waf := range []bool{false, true} {
+		for _, vpc := range []bool{false, true} {
+			for _, streaming := range []bool{false, true} {
+				waf, vpc, streaming := waf, vpc, streaming
+				name := fmt.Sprintf("waf=%t/vpc=%t/streaming=%t", waf, vpc, streaming)
+				t.Run(name, func(t *testing.T) {
+					t.Parallel()
+
+					vars := map[string]interface{}{
+						"enable_waf":       waf,
+						"enable_streaming": streaming,
+					}
+					if vpc {
+						vars["vpc_subnet_ids"] = []string{"subnet-00000000000000001", "subnet-00000000000000002"}
+						vars["vpc_security_group_ids"] = []string{"sg-00000000000000001"}
+					}
+
+					plan := terraform.InitAndPlanAndShowWithStruct(t, planOnlyOptions(t, vars))
+					counts := planResourceCounts(plan)
+
+					assert.Equal(t, 1, counts["aws_lambda_function"])
+					assert.Equal(t, 1, counts["aws_api_gateway_rest_api"])
+					assert.Equal(t, 1, counts["aws_api_gateway_stage"])
+					assert.Equal(t, 1, counts["aws_iam_policy"])
+
+					expectedWAF := 0
+					if waf {
+						expectedWAF = 1
+					}
+					assert.Equal(t, expectedWAF, counts["aws_wafv2_web_acl"])
+					assert.Equal(t, expectedWAF, counts["aws_wafv2_web_acl_association"])
+
+					lambda, ok := plan.ResourcePlannedValuesMap["aws_lambda_function.bedrock_lambda"]
+					require.True(t, ok, "Lambda function should be in the plan")
+
+					vpcConfig, _ := lambda.AttributeValues["vpc_config"].([]interface{})
+					if vpc {
+						require.Len(t, vpcConfig, 1)
+						assert.Len(t, vpcConfig[0].(map[string]interface{})["subnet_ids"], 2)
+					} else {
+						assert.Empty(t, vpcConfig)
+					}
+
+					policy, ok := plan.ResourcePlannedValuesMap["aws_iam_policy.bedrock_policy"]
+					require.True(t, ok, "Bedrock policy should be in the plan")
+					assert.Equal(t, vpc, strings.Contains(policy.AttributeValues["policy"].(string), "ec2:CreateNetworkInterface"),
+						"ENI permissions should only be granted with a VPC")
+
+					environment := lambda.AttributeValues["environment"].([]interface{})[0].(map[string]interface{})
+					variables := environment["variables"].(map[string]interface{})
+					if streaming {
+						assert.Equal(t, "true", variables["ENABLE_STREAMING"])
+					} else {
+						assert.NotContains(t, variables, "ENABLE_STREAMING")
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestBedrockModulePlan(t *testing.T) {
+	t.Parallel()
+
+	// Plan-only: read-only credentials are enough, since the plan only reads the
+	// caller identity and region, and nothing is created
+	modelARNs := []string{
+		"arn:aws:bedrock:us-east-1::foundation-model/anthropic.claude-3-haiku-20240307-v1:0",
+		"arn:aws:bedrock:us-east-1::foundation-model/amazon.titan-text-express-v1",
+	}
+	plan := terraform.InitAndPlanAndShowWithStruct(t, planOnlyOptions(t, map[string]interface{}{
+		"bedrock_model_id":   "anthropic.claude-3-haiku-20240307-v1:0",
+		"bedrock_model_arns": modelARNs,
+		"lambda_memory_size": 1024,
+		"lambda_timeout":     60,
+		"api_stage_name":     "v1",
+		"enable_api_key":     true,
+		"rate_limit":         25,
+		"burst_limit":        50,
+		"enable_api_cache":   true,
+		"cache_ttl_seconds":  120,
+		"enable_waf":         true,
+	}))
+
+	t.Run("lambda", func(t *testing.T) {
+		lambda, ok := plan.ResourcePlannedValuesMap["aws_lambda_function.bedrock_lambda"]
+		require.True(t, ok, "Lambda function should be in the plan")
+		assert.Equal(t, "python3.11", lambda.AttributeValues["runtime"])
+		assert.Equal(t, "index.handler", lambda.AttributeValues["handler"])
+		assert.EqualValues(t, 1024, lambda.AttributeValues["memory_size"])
+		assert.EqualValues(t, 60, lambda.AttributeValues["timeout"])
+
+		environment := lambda.AttributeValues["environment"].([]interface{})[0].(map[string]interface{})
+		variables := environment["variables"].(map[string]interface{})
+		assert.Equal(t, "anthropic.claude-3-haiku-20240307-v1:0", variables["BEDROCK_MODEL_ID"])
+		assert.Equal(t, "X-Body-Hash", variables["CACHE_KEY_HEADER"])
+	})
+
+	t.Run("api_gateway_stage", func(t *testing.T) {
+		stage, ok := plan.ResourcePlannedValuesMap["aws_api_gateway_stage.bedrock_stage"]
+		require.True(t, ok, "stage should be in the plan")
+		assert.Equal(t, "v1", stage.AttributeValues["stage_name"])
+		assert.Equal(t, true, stage.AttributeValues["cache_cluster_enabled"])
+		assert.Equal(t, "0.5", stage.AttributeValues["cache_cluster_size"])
+
+		cache, ok := plan.ResourcePlannedValuesMap["aws_api_gateway_method_settings.bedrock_cache[0]"]
+		require.True(t, ok, "method cache settings should be in the plan")
+		settings := cache.AttributeValues["settings"].([]interface{})[0].(map[string]interface{})
+		assert.Equal(t, true, settings["caching_enabled"])
+		assert.EqualValues(t, 120, settings["cache_ttl_in_seconds"])
+		assert.Equal(t, true, settings["cache_data_encrypted"])
+
+		usagePlan, ok := plan.ResourcePlannedValuesMap["aws_api_gateway_usage_plan.bedrock_usage_plan[0]"]
+		require.True(t, ok, "usage plan should be in the plan")
+		throttle := usagePlan.AttributeValues["throttle_settings"].([]interface{})[0].(map[string]interface{})
+		assert.EqualValues(t, 25, throttle["rate_limit"])
+		assert.EqualValues(t, 50, throttle["burst_limit"])
+	})
+
+	t.Run("iam_policy", func(t *testing.T) {
+		policy, ok := plan.ResourcePlannedValuesMap["aws_iam_policy.bedrock_policy"]
+		require.True(t, ok, "Bedrock policy should be in the plan")
+		statements := planPolicyStatements(t, policy.AttributeValues["policy"])
+
+		var invoke *policyStatement
+		for i, statement := range statements {
+			assert.Equal(t, "Allow", statement.Effect)
+			assert.NotContains(t, statement.Action, "*", "no statement should grant every action")
+			assert.NotContains(t, statement.Action, "bedrock:*")
+			if len(statement.Action) > 0 && statement.Action[0] == "bedrock:InvokeModel" {
+				invoke = &statements[i]
+			}
+		}
+		require.NotNil(t, invoke, "the policy should grant bedrock:InvokeModel")
+		assert.ElementsMatch(t, []string{"bedrock:InvokeModel", "bedrock:InvokeModelWithResponseStream"}, invoke.Action)
+		assert.ElementsMatch(t, modelARNs, invoke.Resource, "only the listed models should be invokable")
+	})
+
+	t.Run("waf_association", func(t *testing.T) {
+		_, ok := plan.ResourcePlannedValuesMap["aws_wafv2_web_acl.api_gateway_waf[0]"]
+		require.True(t, ok, "web ACL should be in the plan")
+		association, ok := plan.ResourceChangesMap["aws_wafv2_web_acl_association.api_gateway[0]"]
+		require.True(t, ok, "web ACL association should be in the plan")
+		assert.True(t, association.Change.Actions.Create())
+	})
+}
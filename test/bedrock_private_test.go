@@ -0,0 +1,51 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/catherinevee/tfm-aws-ai-bedrock/test/awsvalidate"
+	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrivateExample(t *testing.T) {
+	t.Parallel()
+
+	namePrefix := testNamePrefix + random.UniqueId()
+	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
+		TerraformDir: "../examples/private",
+		Vars: map[string]interface{}{
+			"name_prefix":     namePrefix,
+			"region":          suiteConfig.Region,
+			"restrict_egress": true,
+			"tags":            exampleTags(namePrefix),
+		},
+	})
+
+	// Destroy waits for Lambda to release its network interfaces, which can
+	// take twenty minutes or more
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	apiURL := terraform.Output(t, terraformOptions, "api_url")
+	functionName := terraform.Output(t, terraformOptions, "lambda_function_name")
+	vpcID := terraform.Output(t, terraformOptions, "vpc_id")
+	subnetIDs := terraform.OutputList(t, terraformOptions, "subnet_ids")
+	securityGroupID := terraform.Output(t, terraformOptions, "lambda_security_group_id")
+
+	validator := awsvalidate.New(awsConfig(t))
+	assert.Equal(t, vpcID, validator.AssertLambdaVPC(t, functionName, subnetIDs, []string{securityGroupID}))
+	validator.AssertInterfaceEndpoint(t, vpcID, "bedrock-runtime")
+	validator.AssertNoOpenEgress(t, securityGroupID)
+
+	// The subnets have no route out, so a completion proves Bedrock was
+	// reached through the endpoint
+	statusCode, body := postJSON(t, apiURL, map[string]interface{}{
+		"prompt":     "Reply with the single word: ready",
+		"max_tokens": 10,
+	}, nil)
+	require.Equal(t, 200, statusCode, "unexpected response: %v", body)
+	assert.NotEmpty(t, body["content"])
+}
@@ -0,0 +1,93 @@
+package test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/catherinevee/tfm-aws-ai-bedrock/test/awsvalidate"
+	"github.com/catherinevee/tfm-aws-ai-bedrock/test/helpers"
+	awshelper "github.com/gruntwork-io/terratest/modules/aws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// deploymentRegions is the list of regions TestMultiRegionDeployment deploys
+// to, from the comma-separated BEDROCK_TEST_REGIONS.
+func deploymentRegions() []string {
+	if value := os.Getenv("BEDROCK_TEST_REGIONS"); value != "" {
+		return strings.Split(value, ",")
+	}
+	return []string{"us-east-1", "eu-west-1"}
+}
+
+// profileGeography returns the inference profile prefix serving region.
+func profileGeography(t *testing.T, region string) string {
+	switch {
+	case strings.HasPrefix(region, "us-gov-"):
+		return "us-gov"
+	case strings.HasPrefix(region, "us-"), strings.HasPrefix(region, "ca-"):
+		return "us"
+	case strings.HasPrefix(region, "eu-"):
+		return "eu"
+	case strings.HasPrefix(region, "ap-"):
+		return "apac"
+	}
+	t.Fatalf("no inference profile geography for region %s", region)
+	return ""
+}
+
+func TestMultiRegionDeployment(t *testing.T) {
+	t.Parallel()
+	skipUnlessExpensive(t)
+
+	accountID := awshelper.GetAccountId(t)
+
+	for _, region := range deploymentRegions() {
+		region := strings.TrimSpace(region)
+
+		t.Run(region, func(t *testing.T) {
+			t.Parallel()
+
+			// The profile routes to the model in any region of its geography
+			profileID := profileGeography(t, region) + "." + suiteConfig.ModelID
+			terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+				"bedrock_model_id": profileID,
+				"bedrock_model_arns": []string{
+					"arn:aws:bedrock:" + region + ":" + accountID + ":inference-profile/" + profileID,
+					"arn:aws:bedrock:*::foundation-model/" + suiteConfig.ModelID,
+				},
+			})
+			terraformOptions.EnvVars["AWS_DEFAULT_REGION"] = region
+
+			defer destroy(t, terraformOptions)
+			initAndApplyWithRetry(t, terraformOptions)
+
+			outputs := helpers.GetStackOutputs(t, terraformOptions)
+			assert.Contains(t, outputs.APIURL, "."+region+".", "the API should be deployed in %s", region)
+			validator := awsvalidate.New(awsConfigForRegion(t, region))
+
+			// The model the Lambda is configured with, and every model its
+			// role may invoke, must be offered in the region
+			validator.AssertLambda(t, outputs.LambdaFunctionName, awsvalidate.LambdaConfig{
+				Environment: map[string]string{"BEDROCK_MODEL_ID": profileID},
+			})
+			validator.AssertModelAvailable(t, profileID)
+
+			var invokeResources []string
+			for _, statement := range validator.RolePolicyStatements(t, outputs.LambdaRoleARN) {
+				for _, action := range statement.Action {
+					if action == "bedrock:InvokeModel" {
+						invokeResources = append(invokeResources, statement.Resource...)
+					}
+				}
+			}
+			require.NotEmpty(t, invokeResources)
+			validator.AssertInvokableModelsAvailable(t, invokeResources)
+
+			statusCode, body := helpers.InvokeBedrockEndpoint(t, outputs.APIURL, "Say hello", helpers.InvokeOptions{MaxTokens: 10})
+			require.Equal(t, 200, statusCode, "unexpected response from %s: %s", region, body)
+			assert.Equal(t, profileID, helpers.AssertCompletionResponse(t, body).ModelID)
+		})
+	}
+}
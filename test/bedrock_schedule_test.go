@@ -0,0 +1,62 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	awshelper "github.com/gruntwork-io/terratest/modules/aws"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduledPrompts(t *testing.T) {
+	t.Parallel()
+
+	// The topic only has to be a well-formed ARN; nothing is published during the test
+	topicARN := fmt.Sprintf("arn:aws:sns:%s:%s:bedrock-test-daily-summary", suiteConfig.Region, awshelper.GetAccountId(t))
+
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"enable_scheduled_prompts": true,
+		"scheduled_prompts": []map[string]interface{}{
+			{
+				"name":                "daily-summary",
+				"schedule_expression": "rate(1 day)",
+				"prompt":              "Summarise today's key AWS announcements",
+				"destination":         topicARN,
+			},
+		},
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	ruleNames := terraform.OutputMap(t, terraformOptions, "scheduled_prompt_rule_names")
+	ruleName, ok := ruleNames["daily-summary"]
+	require.True(t, ok, "rule for daily-summary should be created")
+
+	client := eventbridge.NewFromConfig(awsConfig(t))
+
+	rule, err := client.DescribeRule(context.Background(), &eventbridge.DescribeRuleInput{Name: aws.String(ruleName)})
+	require.NoError(t, err)
+	assert.Equal(t, "rate(1 day)", aws.ToString(rule.ScheduleExpression))
+
+	targets, err := client.ListTargetsByRule(context.Background(), &eventbridge.ListTargetsByRuleInput{Rule: aws.String(ruleName)})
+	require.NoError(t, err)
+	require.Len(t, targets.Targets, 1)
+	assert.Equal(t, terraform.Output(t, terraformOptions, "lambda_function_arn"), aws.ToString(targets.Targets[0].Arn))
+
+	var input struct {
+		ScheduledPrompt struct {
+			Name        string `json:"name"`
+			Destination string `json:"destination"`
+		} `json:"scheduled_prompt"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(aws.ToString(targets.Targets[0].Input)), &input))
+	assert.Equal(t, "daily-summary", input.ScheduledPrompt.Name)
+	assert.Equal(t, topicARN, input.ScheduledPrompt.Destination)
+}
@@ -0,0 +1,235 @@
+package test
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/catherinevee/tfm-aws-ai-bedrock/test/helpers"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type sseFrame struct {
+	Event string
+	Data  map[string]interface{}
+}
+
+// parseSSE splits a text/event-stream body into frames with decoded JSON data.
+func parseSSE(t *testing.T, body string) []sseFrame {
+	var frames []sseFrame
+	for _, block := range strings.Split(strings.TrimSpace(body), "\n\n") {
+		frame := sseFrame{Event: "message"}
+		for _, line := range strings.Split(block, "\n") {
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				frame.Event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &frame.Data))
+			}
+		}
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+// maxStreamDuration is the streaming regression budget for a whole stream,
+// from BEDROCK_TEST_MAX_STREAM_SECONDS (default 20). The REST API delivers
+// every frame at once, so total latency is the only thing worth timing.
+func maxStreamDuration(t *testing.T) time.Duration {
+	value := os.Getenv("BEDROCK_TEST_MAX_STREAM_SECONDS")
+	if value == "" {
+		return 20 * time.Second
+	}
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds <= 0 {
+		t.Fatalf("BEDROCK_TEST_MAX_STREAM_SECONDS must be a positive number, got %q", value)
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+func TestStreamingEndToEnd(t *testing.T) {
+	t.Parallel()
+
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"enable_streaming": true,
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	outputs := helpers.GetStackOutputs(t, terraformOptions)
+	require.True(t, outputs.Features["streaming"], "streaming should be enabled")
+
+	result := helpers.StreamBedrockEndpoint(t, outputs.APIURL, "Count from one to ten in words, separated by spaces.", helpers.InvokeOptions{MaxTokens: 100})
+	content := helpers.AssertStreamCompleted(t, result)
+	assert.Contains(t, strings.ToLower(content), "one")
+	assert.Contains(t, strings.ToLower(content), "ten")
+
+	t.Logf("stream of %d frames in %s", len(result.Frames), result.Duration)
+	assert.LessOrEqual(t, result.Duration, maxStreamDuration(t), "stream latency regressed")
+}
+
+func TestStreamingMidStreamFailureTrailer(t *testing.T) {
+	t.Parallel()
+
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"enable_streaming":  true,
+		"stream_error_mode": "trailer",
+		"handler_fault_injection": map[string]interface{}{
+			"stream_failure_after_chunks": 2,
+		},
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+	payload := []byte(`{"prompt": "Count from one to twenty in words", "max_tokens": 200, "stream": true}`)
+	headers := map[string]string{"Content-Type": "application/json", "Accept": "text/event-stream"}
+
+	statusCode, body := helpers.HTTPDoWithRetryPolicy(t, "POST", apiURL, payload, headers, helpers.DefaultRetryPolicy())
+	require.Equal(t, 200, statusCode, "unexpected response: %s", body)
+
+	frames := parseSSE(t, string(body))
+	require.GreaterOrEqual(t, len(frames), 3, "expected partial deltas followed by an error frame")
+
+	// Partial output is delivered, then terminated by an explicit error signal
+	for _, frame := range frames[:len(frames)-1] {
+		assert.Equal(t, "message", frame.Event)
+		assert.Contains(t, frame.Data, "delta")
+	}
+	last := frames[len(frames)-1]
+	assert.Equal(t, "error", last.Event)
+	assert.Equal(t, true, last.Data["partial"])
+	assert.Contains(t, last.Data, "error")
+}
+
+func TestStreamingCancelsOnClientDisconnect(t *testing.T) {
+	t.Parallel()
+
+	// The buffered proxy integration hides the client's socket from the handler,
+	// so the fault marks the client as gone after two chunks
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"enable_streaming":     true,
+		"cancel_on_disconnect": true,
+		"handler_fault_injection": map[string]interface{}{
+			"client_disconnect_after_chunks": 2,
+		},
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+	functionName := terraform.Output(t, terraformOptions, "lambda_function_name")
+	startTime := time.Now()
+
+	payload := []byte(`{"prompt": "Count from one to one hundred in words", "max_tokens": 1000, "stream": true}`)
+	headers := map[string]string{"Content-Type": "application/json", "Accept": "text/event-stream"}
+
+	statusCode, body := helpers.HTTPDoWithRetryPolicy(t, "POST", apiURL, payload, headers, helpers.DefaultRetryPolicy())
+	require.Equal(t, 499, statusCode, "unexpected response: %s", body)
+
+	var cancelled struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+		PartialChunks int `json:"partial_chunks"`
+	}
+	require.NoError(t, json.Unmarshal(body, &cancelled))
+	assert.Equal(t, "ClientDisconnected", cancelled.Error.Code)
+	assert.Equal(t, 2, cancelled.PartialChunks)
+
+	assert.GreaterOrEqual(t, waitForMetricSum(t, "ClientDisconnects", functionName, startTime), 1.0)
+	assert.GreaterOrEqual(t, waitForMetricSum(t, "StreamsCancelled", functionName, startTime), 1.0)
+}
+
+func TestStreamingStopsAtMaxResponseBytes(t *testing.T) {
+	t.Parallel()
+
+	const maxResponseBytes = 200
+
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"enable_streaming":   true,
+		"max_response_bytes": maxResponseBytes,
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+	functionName := terraform.Output(t, terraformOptions, "lambda_function_name")
+	startTime := time.Now()
+
+	payload := []byte(`{"prompt": "Write a 1000 word essay about the history of bridges", "max_tokens": 2000, "stream": true}`)
+	headers := map[string]string{"Content-Type": "application/json", "Accept": "text/event-stream"}
+
+	statusCode, body := helpers.HTTPDoWithRetryPolicy(t, "POST", apiURL, payload, headers, helpers.DefaultRetryPolicy())
+	require.Equal(t, 200, statusCode, "unexpected response: %s", body)
+
+	frames := parseSSE(t, string(body))
+	require.NotEmpty(t, frames)
+	var content strings.Builder
+	for _, frame := range frames {
+		if delta, ok := frame.Data["delta"].(string); ok {
+			content.WriteString(delta)
+		}
+	}
+	// A multi-byte character straddling the cap is dropped whole
+	assert.LessOrEqual(t, content.Len(), maxResponseBytes)
+	assert.Greater(t, content.Len(), maxResponseBytes-4, "the stream should be cut at the cap: %q", content.String())
+
+	done := frames[len(frames)-1]
+	require.Equal(t, "done", done.Event)
+	assert.Equal(t, true, done.Data["truncated"])
+
+	assert.GreaterOrEqual(t, waitForMetricSum(t, "ResponseSizeTruncations", functionName, startTime), 1.0)
+}
+
+func TestStreamingJSONPathFrames(t *testing.T) {
+	t.Parallel()
+
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"enable_streaming": true,
+		"stream_json_mode": "json_path",
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+
+	payload := []byte(`{
+		"prompt": "Reply with only a JSON object with the keys city (a string), population (a number) and landmarks (an array of three strings) describing Paris.",
+		"max_tokens": 300,
+		"stream": true
+	}`)
+	headers := map[string]string{"Content-Type": "application/json", "Accept": "text/event-stream"}
+
+	statusCode, body := helpers.HTTPDoWithRetryPolicy(t, "POST", apiURL, payload, headers, helpers.DefaultRetryPolicy())
+	require.Equal(t, 200, statusCode, "unexpected response: %s", body)
+
+	// parseSSE fails the test on any frame whose data doesn't parse on its own
+	frames := parseSSE(t, string(body))
+	require.Greater(t, len(frames), 1)
+	require.Equal(t, "done", frames[len(frames)-1].Event)
+
+	values := map[string]interface{}{}
+	for _, frame := range frames[:len(frames)-1] {
+		assert.NotContains(t, frame.Data, "delta", "raw token fragments should not be streamed")
+		path, ok := frame.Data["path"].(string)
+		require.True(t, ok, "every frame should carry a complete member: %v", frame.Data)
+		values[path] = frame.Data["value"]
+	}
+
+	assert.IsType(t, "", values["$.city"])
+	assert.IsType(t, 0.0, values["$.population"])
+	landmarks, ok := values["$.landmarks"].([]interface{})
+	require.True(t, ok, "landmarks should arrive as a whole array: %v", values)
+	assert.Len(t, landmarks, 3)
+}
@@ -0,0 +1,33 @@
+package test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/catherinevee/tfm-aws-ai-bedrock/test/sweeper"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSweep deletes deployments earlier runs left behind. It is opt-in with
+// BEDROCK_TEST_SWEEP=1, and BEDROCK_TEST_SWEEP_TTL sets the minimum age
+// (default 6h) so tests running alongside it are untouched.
+func TestSweep(t *testing.T) {
+	if os.Getenv("BEDROCK_TEST_SWEEP") == "" {
+		t.Skip("set BEDROCK_TEST_SWEEP=1 to delete resources left by earlier runs")
+	}
+
+	ttl := 6 * time.Hour
+	if value := os.Getenv("BEDROCK_TEST_SWEEP_TTL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		require.NoError(t, err, "BEDROCK_TEST_SWEEP_TTL should be a duration such as 6h")
+		ttl = parsed
+	}
+
+	s := sweeper.New(awsConfig(t))
+	s.Logf = t.Logf
+	swept, err := s.Sweep(context.Background(), sweeper.Config{Prefix: testNamePrefix, TTL: ttl})
+	t.Logf("swept %d resources older than %s", len(swept), ttl)
+	require.NoError(t, err)
+}
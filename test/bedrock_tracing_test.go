@@ -0,0 +1,116 @@
+package test
+
+import (
+	"archive/zip"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/catherinevee/tfm-aws-ai-bedrock/test/awsvalidate"
+	"github.com/catherinevee/tfm-aws-ai-bedrock/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// buildGoHandlerPackage builds lambda/handler for the provided.al2023 runtime,
+// as make build-go-handler does, and returns the path of the zip
+func buildGoHandlerPackage(t *testing.T) string {
+	dir := t.TempDir()
+	bootstrap := filepath.Join(dir, "bootstrap")
+
+	cmd := exec.Command("go", "build", "-tags", "lambda.norpc", "-o", bootstrap, ".")
+	cmd.Dir = "../lambda/handler"
+	cmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH=amd64", "CGO_ENABLED=0")
+	output, err := cmd.CombinedOutput()
+	require.NoError(t, err, "building the Go handler: %s", output)
+
+	binary, err := os.ReadFile(bootstrap)
+	require.NoError(t, err)
+
+	packagePath := filepath.Join(dir, "go_handler.zip")
+	file, err := os.Create(packagePath)
+	require.NoError(t, err)
+	defer file.Close()
+
+	archive := zip.NewWriter(file)
+	header := &zip.FileHeader{Name: "bootstrap", Method: zip.Deflate}
+	header.SetMode(0o755)
+	entry, err := archive.CreateHeader(header)
+	require.NoError(t, err)
+	_, err = entry.Write(binary)
+	require.NoError(t, err)
+	require.NoError(t, archive.Close())
+
+	return packagePath
+}
+
+// TestTracingPropagatesThroughBedrock sends a request with its own trace ID
+// and reads the trace back from X-Ray. Only the Go handler traces its
+// Bedrock calls, so it is the one deployed.
+func TestTracingPropagatesThroughBedrock(t *testing.T) {
+	t.Parallel()
+
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"enable_xray_tracing": true,
+		"lambda_runtime":      "provided.al2023",
+		"lambda_handler":      "bootstrap",
+		"lambda_package_path": buildGoHandlerPackage(t),
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+	outputs := helpers.GetStackOutputs(t, terraformOptions)
+
+	// API Gateway continues a sampled trace it is handed instead of starting one
+	sentAt := time.Now()
+	traceID := awsvalidate.NewTraceID(sentAt)
+	req, err := http.NewRequest("POST", outputs.APIURL, strings.NewReader(`{"prompt": "Say hello", "max_tokens": 10}`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Amzn-Trace-Id", "Root="+traceID+";Sampled=1")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	requestID := resp.Header.Get("x-amzn-RequestId")
+	require.NotEmpty(t, requestID, "API Gateway should return its request ID")
+
+	segments := awsvalidate.New(awsConfig(t)).CompleteTrace(t, traceID, sentAt)
+
+	stage, ok := awsvalidate.FindSegment(segments, func(s awsvalidate.TraceSegment) bool {
+		return s.Origin == "AWS::ApiGateway::Stage"
+	})
+	require.True(t, ok, "the trace should have an API Gateway segment")
+	apiGateway, _ := stage.AWS["api_gateway"].(map[string]interface{})
+	assert.Equal(t, requestID, apiGateway["request_id"], "the trace should be the API request's")
+
+	_, ok = awsvalidate.FindSegment(segments, func(s awsvalidate.TraceSegment) bool {
+		return s.Origin == "AWS::Lambda"
+	})
+	assert.True(t, ok, "the trace should have the Lambda service segment")
+
+	function, ok := awsvalidate.FindSegment(segments, func(s awsvalidate.TraceSegment) bool {
+		return s.Origin == "AWS::Lambda::Function" && s.Name == outputs.LambdaFunctionName
+	})
+	require.True(t, ok, "the trace should have the function's segment")
+
+	invoke, ok := awsvalidate.FindSegment(function.Subsegments, func(s awsvalidate.TraceSegment) bool {
+		return s.Name == "Bedrock InvokeModel"
+	})
+	require.True(t, ok, "the function should trace its Bedrock call")
+	assert.Equal(t, suiteConfig.ModelID, invoke.Annotations["model_id"])
+	for _, key := range []string{"input_tokens", "output_tokens"} {
+		tokens, _ := invoke.Annotations[key].(float64)
+		assert.Positive(t, tokens, "%s annotation", key)
+	}
+
+	_, ok = awsvalidate.FindSegment(invoke.Subsegments, func(s awsvalidate.TraceSegment) bool {
+		return s.Namespace == "aws"
+	})
+	assert.True(t, ok, "the Bedrock runtime request should be traced under the call")
+}
@@ -0,0 +1,118 @@
+package test
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/require"
+)
+
+// upgradeStatefulTypes are the resource types an upgrade must update in place.
+// Replacing them loses logs, changes the API's URL or drops the WAF's rules.
+var upgradeStatefulTypes = map[string]bool{
+	"aws_cloudwatch_log_group": true,
+	"aws_api_gateway_rest_api": true,
+	"aws_wafv2_web_acl":        true,
+}
+
+// upgradeConfig is a root configuration that deploys the module from source
+// with the features whose resources upgradeStatefulTypes covers
+const upgradeConfig = `provider "aws" {
+  region = %q
+}
+
+variable "name_prefix" {
+  type = string
+}
+
+variable "tags" {
+  type = map(string)
+}
+
+module "bedrock_api" {
+  source = %q
+
+  name_prefix        = var.name_prefix
+  enable_waf         = true
+  enable_monitoring  = false
+  log_retention_days = 1
+  tags               = var.tags
+}
+`
+
+// previousReleaseTag is the tag to upgrade from: BEDROCK_TEST_UPGRADE_FROM,
+// or else the latest tag before the checked-out commit
+func previousReleaseTag(t *testing.T, repoRoot string) string {
+	if tag := os.Getenv("BEDROCK_TEST_UPGRADE_FROM"); tag != "" {
+		return tag
+	}
+	out, err := exec.Command("git", "-C", repoRoot, "describe", "--tags", "--abbrev=0", "HEAD^").Output()
+	if err != nil {
+		t.Skipf("no release tag to upgrade from: %v", err)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// writeUpgradeConfig points the upgrade configuration in dir at source
+func writeUpgradeConfig(t *testing.T, dir string, source string) {
+	config := fmt.Sprintf(upgradeConfig, suiteConfig.Region, source)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.tf"), []byte(config), 0o644))
+}
+
+// TestModuleUpgrade applies the previous release, then plans the working tree
+// over the same state and fails if any stateful resource would be destroyed.
+// A renamed resource without a moved block shows up here as a delete.
+func TestModuleUpgrade(t *testing.T) {
+	t.Parallel()
+
+	repoRoot, err := filepath.Abs("..")
+	require.NoError(t, err)
+	tag := previousReleaseTag(t, repoRoot)
+
+	dir := t.TempDir()
+	namePrefix := testNamePrefix + random.UniqueId()
+	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
+		TerraformDir: dir,
+		Vars: map[string]interface{}{
+			"name_prefix": namePrefix,
+			"tags": map[string]string{
+				"Environment": "production",
+				"Project":     "bedrock-api",
+				"ManagedBy":   "terraform",
+				testTagKey:    namePrefix,
+			},
+		},
+		EnvVars: map[string]string{
+			"AWS_DEFAULT_REGION": suiteConfig.Region,
+		},
+	})
+
+	// The release is only applied, not held to assertIdempotent: a perpetual
+	// diff it shipped with can't be fixed in it
+	writeUpgradeConfig(t, dir, fmt.Sprintf("git::file://%s?ref=%s", repoRoot, tag))
+	defer destroy(t, terraformOptions)
+	assertTornDownAfterTest(t, terraformOptions)
+	terraform.InitAndApply(t, terraformOptions)
+
+	writeUpgradeConfig(t, dir, repoRoot)
+	planOptions := *terraformOptions
+	planOptions.PlanFilePath = filepath.Join(t.TempDir(), "tfplan")
+	plan := terraform.InitAndPlanAndShowWithStruct(t, &planOptions)
+
+	for address, change := range plan.ResourceChangesMap {
+		if upgradeStatefulTypes[change.Type] && (change.Change.Actions.Delete() || change.Change.Actions.Replace()) {
+			t.Errorf("upgrading from %s destroys %s (actions %v)", tag, address, change.Change.Actions)
+		}
+	}
+	if t.Failed() {
+		return
+	}
+
+	initAndApplyWithRetry(t, terraformOptions)
+}
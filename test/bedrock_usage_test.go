@@ -0,0 +1,127 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/gruntwork-io/terratest/modules/retry"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsageAccountingPerTenant(t *testing.T) {
+	t.Parallel()
+
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"enable_usage_accounting": true,
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+	tableName := terraform.Output(t, terraformOptions, "usage_table_name")
+	tenantID := "tenant-" + random.UniqueId()
+	headers := map[string]string{"X-Tenant-Id": tenantID}
+
+	const requests = 3
+	var expectedTotal int
+	for i := 0; i < requests; i++ {
+		statusCode, body := postJSON(t, apiURL, map[string]interface{}{"prompt": "Say hello", "max_tokens": 20}, headers)
+		require.Equal(t, 200, statusCode, "unexpected response: %v", body)
+
+		usage := body["usage"].(map[string]interface{})
+		expectedTotal += int(usage["input_tokens"].(float64) + usage["output_tokens"].(float64))
+	}
+
+	client := dynamodb.NewFromConfig(awsConfig(t))
+	item, err := client.GetItem(context.Background(), &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"tenant_id": &types.AttributeValueMemberS{Value: tenantID},
+			"period":    &types.AttributeValueMemberS{Value: time.Now().UTC().Format("2006-01")},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, item.Item, "usage counters should exist for the tenant")
+
+	counter := func(name string) int {
+		value, err := strconv.Atoi(item.Item[name].(*types.AttributeValueMemberN).Value)
+		require.NoError(t, err)
+		return value
+	}
+	assert.Equal(t, requests, counter("request_count"))
+	assert.Equal(t, expectedTotal, counter("total_tokens"))
+	assert.Equal(t, counter("total_tokens"), counter("input_tokens")+counter("output_tokens"))
+}
+
+func TestTenantIsolationSeparatesLogStreams(t *testing.T) {
+	t.Parallel()
+
+	tenants := []string{"tenant-a-" + random.UniqueId(), "tenant-b-" + random.UniqueId()}
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"enable_tenant_isolation": true,
+		"tenant_header":           "X-Customer-Id",
+		"allowed_tenant_ids":      tenants,
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+	logGroup := terraform.Output(t, terraformOptions, "cloudwatch_log_group_name")
+	payload := map[string]interface{}{"prompt": "Say hello", "max_tokens": 10}
+
+	for _, tenant := range tenants {
+		statusCode, body := postJSON(t, apiURL, payload, map[string]string{"X-Customer-Id": tenant})
+		require.Equal(t, 200, statusCode, "unexpected response for %s: %v", tenant, body)
+	}
+
+	statusCode, body := postJSON(t, apiURL, payload, map[string]string{"X-Customer-Id": "tenant-unlisted"})
+	assert.Equal(t, 403, statusCode, "tenants outside the allowlist should be rejected: %v", body)
+
+	// Each tenant's request summary is only in its own stream
+	client := cloudwatchlogs.NewFromConfig(awsConfig(t))
+	ctx := context.Background()
+	for _, tenant := range tenants {
+		streamName := "tenants/" + tenant
+		retry.DoWithRetry(t, fmt.Sprintf("read %s log stream", streamName), 10, 10*time.Second, func() (string, error) {
+			out, err := client.GetLogEvents(ctx, &cloudwatchlogs.GetLogEventsInput{
+				LogGroupName:  aws.String(logGroup),
+				LogStreamName: aws.String(streamName),
+				StartFromHead: aws.Bool(true),
+			})
+			if err != nil {
+				return "", err
+			}
+			if len(out.Events) == 0 {
+				return "", fmt.Errorf("no events in %s yet", streamName)
+			}
+
+			for _, event := range out.Events {
+				var record map[string]interface{}
+				require.NoError(t, json.Unmarshal([]byte(aws.ToString(event.Message)), &record))
+				assert.Equal(t, tenant, record["tenant_id"], "stream %s should only hold its tenant's requests", streamName)
+			}
+			return "", nil
+		})
+	}
+
+	streams, err := client.DescribeLogStreams(ctx, &cloudwatchlogs.DescribeLogStreamsInput{
+		LogGroupName:        aws.String(logGroup),
+		LogStreamNamePrefix: aws.String("tenants/"),
+	})
+	require.NoError(t, err)
+	assert.Len(t, streams.LogStreams, len(tenants), "only allowlisted tenants should get a stream")
+}
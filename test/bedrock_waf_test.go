@@ -0,0 +1,171 @@
+package test
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/catherinevee/tfm-aws-ai-bedrock/test/awsvalidate"
+	"github.com/catherinevee/tfm-aws-ai-bedrock/test/helpers"
+	"github.com/gruntwork-io/terratest/modules/retry"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBedrockWAF(t *testing.T) {
+	t.Parallel()
+
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"enable_waf":     true,
+		"waf_rate_limit": 100,
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	outputs := helpers.GetStackOutputs(t, terraformOptions)
+	aclARN := terraform.Output(t, terraformOptions, "waf_web_acl_arn")
+	validator := awsvalidate.New(awsConfig(t))
+	startTime := time.Now().Add(-time.Minute)
+
+	// Blocks must be WAF's own 403, so nothing is retried except connection errors
+	noRetry := helpers.RetryPolicy{RetryOnConnError: true, MaxRetries: 3, TimeBetweenRetries: 5 * time.Second}
+	headers := map[string]string{"Content-Type": "application/json"}
+	post := func(body string) (int, []byte) {
+		return helpers.HTTPDoWithRetryPolicy(t, "POST", outputs.APIURL, []byte(body), headers, noRetry)
+	}
+
+	// Subtests share one client IP, so they run in order and the rate limit,
+	// which blocks that IP for minutes, goes last. Every body either trips a
+	// rule or fails validation, so Bedrock is never called.
+	t.Run("allows_clean_requests", func(t *testing.T) {
+		statusCode, body := post(`{}`)
+		assert.Equal(t, http.StatusBadRequest, statusCode, "a clean request should reach the handler: %s", body)
+	})
+
+	t.Run("blocks_oversized_body", func(t *testing.T) {
+		// SizeRestrictions_BODY blocks bodies over the 8 KB WAF inspects
+		statusCode, body := post(fmt.Sprintf(`{"prompt": %q}`, strings.Repeat("a", 16*1024)))
+		assert.Equal(t, http.StatusForbidden, statusCode, "unexpected response: %s", body)
+	})
+
+	t.Run("blocks_injection_payload", func(t *testing.T) {
+		// The common rule set covers cross-site scripting and path traversal;
+		// SQL injection needs AWSManagedRulesSQLiRuleSet, which the module doesn't add
+		statusCode, body := post(`{"prompt": "<script>alert(document.cookie)</script>"}`)
+		assert.Equal(t, http.StatusForbidden, statusCode, "unexpected response: %s", body)
+	})
+
+	t.Run("samples_managed_rule_blocks", func(t *testing.T) {
+		retry.DoWithRetry(t, "wait for sampled managed rule blocks", 20, 15*time.Second, func() (string, error) {
+			rules := map[string]bool{}
+			for _, sample := range validator.WAFSampledBlocks(t, aclARN, "AWSManagedRulesCommonRuleSet", startTime) {
+				rules[aws.ToString(sample.RuleNameWithinRuleGroup)] = true
+			}
+			if !rules["SizeRestrictions_BODY"] || !rules["CrossSiteScripting_BODY"] {
+				return "", fmt.Errorf("sampled blocks so far: %v", rules)
+			}
+			return "", nil
+		})
+	})
+
+	t.Run("rate_limits_burst", func(t *testing.T) {
+		// WAF evaluates rate limits with a delay, so keep bursting until blocked
+		retry.DoWithRetry(t, "wait for the rate-based rule to block", 30, 10*time.Second, func() (string, error) {
+			for i := 0; i < 50; i++ {
+				if statusCode, _ := post(`{}`); statusCode == http.StatusForbidden {
+					return "blocked", nil
+				}
+			}
+			return "", fmt.Errorf("burst not rate limited yet")
+		})
+
+		retry.DoWithRetry(t, "wait for sampled rate limit blocks", 20, 15*time.Second, func() (string, error) {
+			if len(validator.WAFSampledBlocks(t, aclARN, "RateLimitRule", startTime)) == 0 {
+				return "", fmt.Errorf("no sampled rate limit blocks yet")
+			}
+			return "", nil
+		})
+	})
+}
+
+func TestWAFExcludedPathsAreNotRateLimited(t *testing.T) {
+	t.Parallel()
+
+	terraformOptions := moduleTerraformOptions(t, map[string]interface{}{
+		"enable_waf":         true,
+		"waf_rate_limit":     100,
+		"waf_excluded_paths": []string{"/health"},
+	})
+
+	defer destroy(t, terraformOptions)
+	initAndApplyWithRetry(t, terraformOptions)
+
+	excluded := terraform.OutputList(t, terraformOptions, "waf_excluded_paths")
+	assert.Equal(t, []string{"/health"}, excluded)
+
+	apiURL := terraform.Output(t, terraformOptions, "api_gateway_url")
+	healthURL := terraform.Output(t, terraformOptions, "health_url")
+	noRetry := helpers.RetryPolicy{RetryOnConnError: true, MaxRetries: 3, TimeBetweenRetries: 5 * time.Second}
+	invalidBody := []byte(`{}`)
+	headers := map[string]string{"Content-Type": "application/json"}
+
+	// WAF evaluates rate limits with a delay, so keep flooding until the
+	// Bedrock route is blocked. Invalid bodies keep Bedrock out of the loop.
+	retry.DoWithRetry(t, "wait for rate limit on the Bedrock route", 30, 10*time.Second, func() (string, error) {
+		for i := 0; i < 50; i++ {
+			statusCode, _ := helpers.HTTPDoWithRetryPolicy(t, "POST", apiURL, invalidBody, headers, noRetry)
+			if statusCode == http.StatusForbidden {
+				return "blocked", nil
+			}
+		}
+		return "", fmt.Errorf("Bedrock route not rate limited yet")
+	})
+
+	// The same client is over the limit, yet health checks still pass
+	for i := 0; i < 150; i++ {
+		statusCode, body := helpers.HTTPDoWithRetryPolicy(t, "GET", healthURL, nil, nil, noRetry)
+		require.Equal(t, http.StatusOK, statusCode, "health check %d should not be rate limited: %s", i, body)
+	}
+}
+
+func TestWAFScopeWithEdgeOptimizedAPI(t *testing.T) {
+	t.Parallel()
+
+	for _, scope := range []string{"REGIONAL", "CLOUDFRONT"} {
+		scope := scope
+		t.Run(scope, func(t *testing.T) {
+			t.Parallel()
+
+			// Plan-only in us-east-1, the only region CloudFront-scoped web ACLs exist in
+			terraformOptions := planOnlyOptions(t, map[string]interface{}{
+				"api_endpoint_type":     "EDGE",
+				"enable_waf":            true,
+				"waf_scope":             scope,
+				"waf_blocked_ip_ranges": []string{"198.51.100.0/24"},
+			})
+
+			plan := terraform.InitAndPlanAndShowWithStruct(t, terraformOptions)
+
+			api, ok := plan.ResourcePlannedValuesMap["aws_api_gateway_rest_api.bedrock_api[0]"]
+			require.True(t, ok, "REST API should be in the plan")
+			endpoint := api.AttributeValues["endpoint_configuration"].([]interface{})[0].(map[string]interface{})
+			assert.Equal(t, []interface{}{"EDGE"}, endpoint["types"])
+
+			for _, address := range []string{"aws_wafv2_web_acl.api_gateway_waf[0]", "aws_wafv2_ip_set.blocked[0]"} {
+				resource, ok := plan.ResourcePlannedValuesMap[address]
+				require.True(t, ok, "%s should be in the plan", address)
+				assert.Equal(t, scope, resource.AttributeValues["scope"], address)
+			}
+
+			// API Gateway associates regional web ACLs with the stage whatever the
+			// endpoint type; a CloudFront one is for the caller's own distribution
+			_, associated := plan.ResourcePlannedValuesMap["aws_wafv2_web_acl_association.api_gateway[0]"]
+			assert.Equal(t, scope == "REGIONAL", associated)
+		})
+	}
+}
@@ -0,0 +1,209 @@
+// Package canary probes a deployed Bedrock API the way the module's test
+// suites do: it sends a prompt, checks the response envelope, optionally
+// streams one, and holds both to a latency budget. It has no test or
+// Terraform dependencies, so cmd/bedrock-canary can run it in any pipeline or
+// as a scheduled canary, and the suite helpers share its checks.
+package canary
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Config describes the endpoint to probe and what it must do.
+type Config struct {
+	URL string
+	// APIKey is sent as x-api-key when set
+	APIKey    string
+	Prompt    string
+	MaxTokens int
+	// Stream also probes a "stream": true request, which needs enable_streaming
+	Stream bool
+	// LatencyBudget bounds each probe's full response. Zero is unlimited.
+	LatencyBudget time.Duration
+	// Retries is how many times a 429 or 503 is retried
+	Retries            int
+	TimeBetweenRetries time.Duration
+	// Client defaults to one with a 60 second timeout
+	Client *http.Client
+}
+
+// Result is the outcome of one probe. Err is nil when it passed.
+type Result struct {
+	Name     string
+	Duration time.Duration
+	Err      error
+}
+
+// Completion is the part of the completion envelope every response carries.
+type Completion struct {
+	Success  bool   `json:"success"`
+	Content  string `json:"content"`
+	ModelID  string `json:"model_id"`
+	Usage    Usage  `json:"usage"`
+	Metadata struct {
+		Timestamp int64  `json:"timestamp"`
+		RequestID string `json:"request_id"`
+	} `json:"metadata"`
+}
+
+// Usage is the token accounting in a completion.
+type Usage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// CheckCompletion decodes a successful completion and returns every field
+// that is missing or has the wrong type.
+func CheckCompletion(body []byte) (Completion, error) {
+	var completion Completion
+	if err := json.Unmarshal(body, &completion); err != nil {
+		return completion, fmt.Errorf("response is not a completion: %w: %s", err, body)
+	}
+
+	var problems []error
+	if !completion.Success {
+		problems = append(problems, fmt.Errorf("completion should succeed: %s", body))
+	}
+	if completion.Content == "" {
+		problems = append(problems, errors.New("completion should have content"))
+	}
+	if completion.ModelID == "" {
+		problems = append(problems, errors.New("completion should name the model"))
+	}
+	if completion.Usage.InputTokens < 0 || completion.Usage.OutputTokens < 0 {
+		problems = append(problems, fmt.Errorf("token counts should not be negative: %+v", completion.Usage))
+	}
+	if completion.Metadata.Timestamp <= 0 {
+		problems = append(problems, errors.New("completion should carry a timestamp"))
+	}
+	if completion.Metadata.RequestID == "" {
+		problems = append(problems, errors.New("completion should carry the Lambda request ID"))
+	}
+	return completion, errors.Join(problems...)
+}
+
+// Run sends each probe in turn and returns their results. Probes don't stop
+// at the first failure, so one run reports everything that is wrong.
+func Run(ctx context.Context, config Config) []Result {
+	if config.Client == nil {
+		config.Client = &http.Client{Timeout: 60 * time.Second}
+	}
+
+	results := []Result{timed("completion", config, func() error { return probeCompletion(ctx, config) })}
+	if config.Stream {
+		results = append(results, timed("stream", config, func() error { return probeStream(ctx, config) }))
+	}
+	return results
+}
+
+// Failed reports whether any result has an error.
+func Failed(results []Result) bool {
+	for _, result := range results {
+		if result.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// timed runs probe and adds a budget error when it passed too slowly
+func timed(name string, config Config, probe func() error) Result {
+	start := time.Now()
+	err := probe()
+	result := Result{Name: name, Duration: time.Since(start), Err: err}
+	if err == nil && config.LatencyBudget > 0 && result.Duration > config.LatencyBudget {
+		result.Err = fmt.Errorf("took %s, over the %s budget", result.Duration.Round(time.Millisecond), config.LatencyBudget)
+	}
+	return result
+}
+
+func probeCompletion(ctx context.Context, config Config) error {
+	resp, err := send(ctx, config, false)
+	if err != nil {
+		return err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d: %s", resp.StatusCode, body)
+	}
+	_, err = CheckCompletion(body)
+	return err
+}
+
+func probeStream(ctx context.Context, config Config) error {
+	start := time.Now()
+	resp, err := send(ctx, config, true)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	contentType := resp.Header.Get("Content-Type")
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(contentType, "text/event-stream") {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("status %d with content type %q, not an event stream: %s", resp.StatusCode, contentType, body)
+	}
+	frames, err := ReadStreamFrames(resp.Body, start)
+	if err != nil {
+		return fmt.Errorf("reading the stream: %w", err)
+	}
+	_, err = CheckStream(frames)
+	return err
+}
+
+// send POSTs the probe's prompt, retrying throttling and unavailability
+func send(ctx context.Context, config Config, stream bool) (*http.Response, error) {
+	payload := map[string]interface{}{"prompt": config.Prompt}
+	if config.MaxTokens > 0 {
+		payload["max_tokens"] = config.MaxTokens
+	}
+	if stream {
+		payload["stream"] = true
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, "POST", config.URL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if stream {
+			req.Header.Set("Accept", "text/event-stream")
+		}
+		if config.APIKey != "" {
+			req.Header.Set("x-api-key", config.APIKey)
+		}
+
+		resp, err := config.Client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable
+		if !retryable || attempt >= config.Retries {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(config.TimeBetweenRetries):
+		}
+	}
+}
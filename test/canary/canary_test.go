@@ -0,0 +1,178 @@
+package canary
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const completionBody = `{"success": true, "content": "ready", "model_id": "anthropic.claude-3-haiku-20240307-v1:0",
+	"usage": {"input_tokens": 12, "output_tokens": 1}, "metadata": {"timestamp": 1718000000, "request_id": "abc-123"}}`
+
+// fakeAPI answers completions with completionBody and streams with a
+// heartbeat, two deltas and a done frame, after delay
+func fakeAPI(t *testing.T, delay time.Duration, got *[]map[string]interface{}) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		payload["x-api-key"] = r.Header.Get("x-api-key")
+		*got = append(*got, payload)
+		time.Sleep(delay)
+
+		if payload["stream"] != true {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(completionBody))
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(": heartbeat\n\n" +
+			`data: {"delta": "rea"}` + "\n\n" +
+			`data: {"delta": "dy"}` + "\n\n" +
+			"event: done\n" + `data: {"done": true, "model_id": "anthropic.claude-3-haiku-20240307-v1:0", "usage": {}}` + "\n\n"))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRunPassesHealthyEndpoint(t *testing.T) {
+	t.Parallel()
+
+	var got []map[string]interface{}
+	server := fakeAPI(t, 0, &got)
+	results := Run(context.Background(), Config{
+		URL:           server.URL,
+		APIKey:        "secret",
+		Prompt:        "Reply with one word: ready",
+		MaxTokens:     10,
+		Stream:        true,
+		LatencyBudget: 5 * time.Second,
+	})
+
+	require.Len(t, results, 2)
+	for _, result := range results {
+		assert.NoError(t, result.Err, result.Name)
+	}
+	assert.False(t, Failed(results))
+
+	require.Len(t, got, 2)
+	assert.Equal(t, "Reply with one word: ready", got[0]["prompt"])
+	assert.Equal(t, 10.0, got[0]["max_tokens"])
+	assert.Equal(t, "secret", got[0]["x-api-key"])
+	assert.Equal(t, true, got[1]["stream"])
+}
+
+func TestRunFailsSlowEndpoint(t *testing.T) {
+	t.Parallel()
+
+	var got []map[string]interface{}
+	server := fakeAPI(t, 100*time.Millisecond, &got)
+	results := Run(context.Background(), Config{URL: server.URL, Prompt: "Hi", LatencyBudget: 10 * time.Millisecond})
+
+	require.Len(t, results, 1, "streaming is only probed when asked")
+	require.Error(t, results[0].Err)
+	assert.Contains(t, results[0].Err.Error(), "over the 10ms budget")
+	assert.True(t, Failed(results))
+}
+
+func TestRunRetriesThrottling(t *testing.T) {
+	t.Parallel()
+
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(completionBody))
+	}))
+	defer server.Close()
+
+	results := Run(context.Background(), Config{URL: server.URL, Prompt: "Hi", Retries: 1})
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, 2, attempts)
+}
+
+func TestCheckCompletionReportsEveryMissingField(t *testing.T) {
+	t.Parallel()
+
+	completion, err := CheckCompletion([]byte(completionBody))
+	require.NoError(t, err)
+	assert.Equal(t, "ready", completion.Content)
+
+	_, err = CheckCompletion([]byte(`{"success": true, "content": "ready"}`))
+	require.Error(t, err)
+	for _, problem := range []string{"name the model", "timestamp", "request ID"} {
+		assert.Contains(t, err.Error(), problem)
+	}
+
+	_, err = CheckCompletion([]byte(`{"success": true, "usage": {"input_tokens": "12"}}`))
+	assert.ErrorContains(t, err, "not a completion")
+}
+
+func TestCheckStream(t *testing.T) {
+	t.Parallel()
+
+	frames, err := ReadStreamFrames(strings.NewReader(": heartbeat\n\n"+
+		`data: {"delta": "Hel"}`+"\n\n"+
+		"data: {\"delta\":\ndata: \"lo\"}\n\n"+
+		"event: done\n"+`data: {"done": true, "model_id": "m", "usage": {}}`+"\n\n"), time.Now())
+	require.NoError(t, err)
+	require.Len(t, frames, 4)
+	assert.Equal(t, "heartbeat", frames[0].Comment)
+	assert.True(t, frames[2].IsContent(), "multi-line data should join into one frame")
+
+	content, err := CheckStream(frames)
+	require.NoError(t, err)
+	assert.Equal(t, "Hello", content)
+
+	_, err = CheckStream(frames[:3])
+	assert.ErrorContains(t, err, "done frame")
+
+	_, err = CheckStream(append([]StreamFrame{frames[1], frames[0]}, frames[2:]...))
+	assert.ErrorContains(t, err, "comments should only precede the first token")
+
+	_, err = ReadStreamFrames(strings.NewReader("data: {not json\n\n"), time.Now())
+	assert.Error(t, err)
+}
+
+func TestReadOutputs(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	outputJSON := filepath.Join(dir, "outputs.json")
+	require.NoError(t, os.WriteFile(outputJSON, []byte(`{
+		"api_gateway_url": {"sensitive": false, "type": "string", "value": "https://abc123.execute-api.us-east-1.amazonaws.com/prod/bedrock"},
+		"api_key_value": {"sensitive": true, "type": "string", "value": null}
+	}`), 0o644))
+	stateFile := filepath.Join(dir, "terraform.tfstate")
+	require.NoError(t, os.WriteFile(stateFile, []byte(`{"version": 4, "terraform_version": "1.13.0",
+		"outputs": {"api_url": {"type": "string", "value": "https://def456.execute-api.eu-west-1.amazonaws.com/dev/bedrock"}},
+		"resources": []}`), 0o644))
+
+	outputs, err := ReadOutputs(outputJSON)
+	require.NoError(t, err)
+	url, err := OutputString(outputs, "api_gateway_url", false)
+	require.NoError(t, err)
+	assert.Equal(t, "https://abc123.execute-api.us-east-1.amazonaws.com/prod/bedrock", url)
+	key, err := OutputString(outputs, "api_key_value", true)
+	require.NoError(t, err)
+	assert.Empty(t, key, "a null key means API keys are off")
+	_, err = OutputString(outputs, "api_key_value", false)
+	assert.Error(t, err)
+
+	outputs, err = ReadOutputs(stateFile)
+	require.NoError(t, err)
+	url, err = OutputString(outputs, "api_url", false)
+	require.NoError(t, err)
+	assert.Equal(t, "https://def456.execute-api.eu-west-1.amazonaws.com/dev/bedrock", url)
+}
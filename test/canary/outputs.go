@@ -0,0 +1,65 @@
+package canary
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// output is one entry of `terraform output -json`, and of a state file's
+// outputs object
+type output struct {
+	Value interface{} `json:"value"`
+}
+
+// ReadOutputs reads the root outputs from a file written by
+// `terraform output -json`, or from a Terraform state file.
+func ReadOutputs(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseOutputs(data)
+}
+
+func parseOutputs(data []byte) (map[string]interface{}, error) {
+	var state struct {
+		Version int               `json:"version"`
+		Outputs map[string]output `json:"outputs"`
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("outputs are not JSON: %w", err)
+	}
+
+	// State files are versioned; output -json has only output names at the top
+	entries := state.Outputs
+	if state.Version == 0 {
+		entries = nil
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return nil, fmt.Errorf("not a terraform output -json file or a state file: %w", err)
+		}
+	}
+
+	outputs := make(map[string]interface{}, len(entries))
+	for name, entry := range entries {
+		outputs[name] = entry.Value
+	}
+	return outputs, nil
+}
+
+// OutputString returns the named string output. A missing or null output is
+// an error unless optional is set, when it is returned as "".
+func OutputString(outputs map[string]interface{}, name string, optional bool) (string, error) {
+	value, ok := outputs[name]
+	if !ok || value == nil {
+		if optional {
+			return "", nil
+		}
+		return "", fmt.Errorf("output %q is not set", name)
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("output %q is a %T, not a string", name, value)
+	}
+	return s, nil
+}
@@ -0,0 +1,137 @@
+package canary
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// StreamFrame is one server-sent event, with the time it finished arriving
+// measured from when the request was sent.
+type StreamFrame struct {
+	Event   string
+	Data    map[string]interface{}
+	Comment string
+
+	Received time.Duration
+}
+
+// IsContent reports whether the frame carries model output, as opposed to a
+// heartbeat comment or the done and error frames that end a stream.
+func (f StreamFrame) IsContent() bool {
+	return f.Event == "message" && f.Data != nil
+}
+
+// ReadStreamFrames parses frames off body as each blank line completes one.
+func ReadStreamFrames(body io.Reader, start time.Time) ([]StreamFrame, error) {
+	var frames []StreamFrame
+	frame := StreamFrame{Event: "message"}
+	started := false
+	var data strings.Builder
+
+	finish := func() error {
+		if !started {
+			return nil
+		}
+		if data.Len() > 0 {
+			if err := json.Unmarshal([]byte(data.String()), &frame.Data); err != nil {
+				return fmt.Errorf("frame %d data is not JSON: %w: %s", len(frames), err, data.String())
+			}
+		}
+		frame.Received = time.Since(start)
+		frames = append(frames, frame)
+		frame, started = StreamFrame{Event: "message"}, false
+		data.Reset()
+		return nil
+	}
+
+	reader := bufio.NewReader(body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return frames, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		switch {
+		case line == "":
+			if finishErr := finish(); finishErr != nil {
+				return frames, finishErr
+			}
+		case strings.HasPrefix(line, ":"):
+			frame.Comment, started = strings.TrimSpace(strings.TrimPrefix(line, ":")), true
+		case strings.HasPrefix(line, "event:"):
+			frame.Event, started = strings.TrimSpace(strings.TrimPrefix(line, "event:")), true
+		case strings.HasPrefix(line, "data:"):
+			// Multi-line data joins with newlines, as an EventSource would
+			if data.Len() > 0 {
+				data.WriteString("\n")
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+			started = true
+		}
+
+		if err == io.EOF {
+			return frames, finish()
+		}
+	}
+}
+
+// CheckStream checks the frames of a stream that should have finished
+// normally: content frames in arrival order, heartbeats only before the first
+// of them, and a single done frame last. It returns the concatenated raw
+// deltas and every problem found.
+func CheckStream(frames []StreamFrame) (string, error) {
+	if len(frames) == 0 {
+		return "", errors.New("the stream has no frames")
+	}
+
+	var problems []error
+	var content strings.Builder
+	contentFrames := 0
+	for i, frame := range frames {
+		if i > 0 && frame.Received < frames[i-1].Received {
+			problems = append(problems, fmt.Errorf("frame %d: frames should be timed in arrival order", i))
+		}
+		switch {
+		case frame.Comment != "":
+			if contentFrames > 0 {
+				problems = append(problems, fmt.Errorf("frame %d: comments should only precede the first token", i))
+			}
+		case frame.Event == "error":
+			problems = append(problems, fmt.Errorf("frame %d: the stream failed: %v", i, frame.Data))
+		case frame.Event == "done":
+			if i != len(frames)-1 {
+				problems = append(problems, fmt.Errorf("frame %d: the done frame should end the stream", i))
+			}
+		case frame.IsContent():
+			contentFrames++
+			if delta, ok := frame.Data["delta"].(string); ok {
+				content.WriteString(delta)
+			}
+		}
+	}
+	if contentFrames == 0 {
+		problems = append(problems, errors.New("the stream should carry content"))
+	}
+
+	done := frames[len(frames)-1]
+	if done.Event != "done" {
+		problems = append(problems, errors.New("the stream should end with a done frame"))
+	} else {
+		if done.Data["done"] != true {
+			problems = append(problems, errors.New(`the done frame should have "done": true`))
+		}
+		if model, _ := done.Data["model_id"].(string); model == "" {
+			problems = append(problems, errors.New("the done frame should name the model"))
+		}
+		if _, ok := done.Data["usage"]; !ok {
+			problems = append(problems, errors.New("the done frame should carry usage"))
+		}
+	}
+	return content.String(), errors.Join(problems...)
+}
@@ -0,0 +1,78 @@
+// Command bedrock-canary smoke-tests a deployed Bedrock API without Go tests
+// or Terratest. It sends a prompt, checks the completion envelope, optionally
+// streams a second request, and exits non-zero if any check fails or runs
+// over the latency budget:
+//
+//	go run ./cmd/bedrock-canary -url https://abc123.execute-api.us-east-1.amazonaws.com/prod/bedrock
+//	terraform output -json > outputs.json
+//	go run ./cmd/bedrock-canary -outputs outputs.json -stream -budget 10s
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/catherinevee/tfm-aws-ai-bedrock/test/canary"
+)
+
+func main() {
+	url := flag.String("url", "", "Bedrock route URL to probe; overrides -outputs")
+	outputsPath := flag.String("outputs", "", "terraform output -json file or state file to read the URL and API key from")
+	urlOutput := flag.String("url-output", "api_gateway_url", "output holding the URL in -outputs")
+	apiKeyOutput := flag.String("api-key-output", "api_key_value", "output holding the API key in -outputs, if any")
+	prompt := flag.String("prompt", "Reply with one word: ready", "prompt to send")
+	maxTokens := flag.Int("max-tokens", 10, "max_tokens to request")
+	stream := flag.Bool("stream", false, "also probe a streamed request (needs enable_streaming)")
+	budget := flag.Duration("budget", 30*time.Second, "latency budget for each probe, retries included; 0 for none")
+	retries := flag.Int("retries", 2, "retries of a 429 or 503 response")
+	timeout := flag.Duration("timeout", 2*time.Minute, "time limit for the whole run")
+	flag.Parse()
+
+	config := canary.Config{
+		URL:                *url,
+		APIKey:             os.Getenv("BEDROCK_API_KEY"),
+		Prompt:             *prompt,
+		MaxTokens:          *maxTokens,
+		Stream:             *stream,
+		LatencyBudget:      *budget,
+		Retries:            *retries,
+		TimeBetweenRetries: 5 * time.Second,
+	}
+	if *outputsPath != "" {
+		outputs, err := canary.ReadOutputs(*outputsPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if config.URL == "" {
+			if config.URL, err = canary.OutputString(outputs, *urlOutput, false); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if config.APIKey == "" {
+			if config.APIKey, err = canary.OutputString(outputs, *apiKeyOutput, true); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	if config.URL == "" {
+		log.Fatal("pass -url or -outputs")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	results := canary.Run(ctx, config)
+	for _, result := range results {
+		if result.Err != nil {
+			log.Printf("FAIL %s after %s: %v", result.Name, result.Duration.Round(time.Millisecond), result.Err)
+		} else {
+			log.Printf("PASS %s in %s", result.Name, result.Duration.Round(time.Millisecond))
+		}
+	}
+	if canary.Failed(results) {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,43 @@
+// Command sweeper deletes resources left in the test account by runs whose
+// cleanup never ran. It removes the Lambda functions, REST APIs, WAF web ACLs
+// and log groups of test deployments older than -ttl:
+//
+//	go run ./cmd/sweeper -region us-east-1 -ttl 6h -dry-run
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/catherinevee/tfm-aws-ai-bedrock/test/sweeper"
+)
+
+func main() {
+	region := flag.String("region", "us-east-1", "region to sweep")
+	prefix := flag.String("prefix", "bedrock-test-", "name_prefix shared by test deployments")
+	ttl := flag.Duration("ttl", 6*time.Hour, "minimum age of a deployment to sweep")
+	dryRun := flag.Bool("dry-run", false, "list what would be deleted without deleting it")
+	flag.Parse()
+
+	ctx := context.Background()
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(*region))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s := sweeper.New(cfg)
+	s.Logf = log.Printf
+	swept, err := s.Sweep(ctx, sweeper.Config{Prefix: *prefix, TTL: *ttl, DryRun: *dryRun})
+	if *dryRun {
+		for _, resource := range swept {
+			log.Printf("would delete %s %s", resource.Kind, resource.Name)
+		}
+	}
+	log.Printf("swept %d resources older than %s in %s", len(swept), *ttl, *region)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,254 @@
+// Package costestimate estimates the fixed monthly cost of a Terraform plan:
+// what the planned resources bill by the hour or month whether or not any
+// request arrives. Usage-priced resources such as Lambda functions, on-demand
+// Bedrock calls, API Gateway requests and log ingestion are not counted, so
+// the estimate is what an idle deployment costs.
+//
+// Prices are us-east-1 on-demand list prices. They are meant to catch an
+// example that suddenly costs hundreds of dollars a month, not to forecast
+// a bill.
+package costestimate
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// HoursPerMonth is the hours AWS bills for a month of an hourly resource.
+const HoursPerMonth = 730
+
+const (
+	// provisionedModelUnitHourly is the cheapest hourly rate of one Bedrock
+	// model unit without commitment. Larger models cost several times more,
+	// so provisioned throughput is always under-estimated.
+	provisionedModelUnitHourly = 20.0
+	// ocuHourly is one OpenSearch Serverless compute unit. A collection bills
+	// at least half an OCU each for indexing and search, doubled when standby
+	// replicas are enabled, which is the default.
+	ocuHourly               = 0.24
+	natGatewayHourly        = 0.045
+	publicIPv4Hourly        = 0.005
+	interfaceEndpointHourly = 0.01
+	webACLMonthly           = 5.0
+	webACLRuleMonthly       = 1.0
+	kmsKeyMonthly           = 1.0
+	secretMonthly           = 0.40
+	dashboardMonthly        = 3.0
+	alarmMonthly            = 0.10
+	readCapacityHourly      = 0.00013
+	writeCapacityHourly     = 0.00065
+	lambdaGBSecond          = 0.0000041667
+)
+
+// cacheClusterHourly is the API Gateway cache price by cache_cluster_size.
+var cacheClusterHourly = map[string]float64{
+	"0.5":  0.02,
+	"1.6":  0.038,
+	"6.1":  0.20,
+	"13.5": 0.25,
+	"28.4": 0.50,
+	"58.2": 1.00,
+	"118":  1.90,
+	"237":  3.80,
+}
+
+// LineItem is the estimated monthly cost of one planned resource.
+type LineItem struct {
+	Address string
+	Monthly float64
+	Detail  string
+}
+
+// Estimate is the fixed monthly cost of a plan, most expensive item first.
+type Estimate struct {
+	Items   []LineItem
+	Monthly float64
+}
+
+// resource is a resource from the planned_values of `terraform show -json`.
+type resource struct {
+	Address string                 `json:"address"`
+	Mode    string                 `json:"mode"`
+	Type    string                 `json:"type"`
+	Values  map[string]interface{} `json:"values"`
+}
+
+type module struct {
+	Resources    []resource `json:"resources"`
+	ChildModules []module   `json:"child_modules"`
+}
+
+type plan struct {
+	PlannedValues struct {
+		RootModule module `json:"root_module"`
+	} `json:"planned_values"`
+}
+
+// pricer returns the monthly cost of r and how it was worked out, or zero
+// when r is configured to cost nothing while idle.
+type pricer func(r resource, all []resource) (float64, string)
+
+var pricers = map[string]pricer{
+	"aws_bedrock_provisioned_model_throughput": func(r resource, _ []resource) (float64, string) {
+		units := number(r.Values["model_units"], 1)
+		return units * provisionedModelUnitHourly * HoursPerMonth, fmt.Sprintf("%g model units", units)
+	},
+	"aws_opensearchserverless_collection": func(r resource, _ []resource) (float64, string) {
+		ocus := 2.0
+		if r.Values["standby_replicas"] == "DISABLED" {
+			ocus = 1
+		}
+		return ocus * ocuHourly * HoursPerMonth, fmt.Sprintf("at least %g OCUs", ocus)
+	},
+	"aws_api_gateway_stage": func(r resource, _ []resource) (float64, string) {
+		if r.Values["cache_cluster_enabled"] != true {
+			return 0, ""
+		}
+		size, _ := r.Values["cache_cluster_size"].(string)
+		return cacheClusterHourly[size] * HoursPerMonth, fmt.Sprintf("%s GB cache", size)
+	},
+	"aws_wafv2_web_acl": func(r resource, _ []resource) (float64, string) {
+		rules := len(list(r.Values["rule"]))
+		return webACLMonthly + float64(rules)*webACLRuleMonthly, fmt.Sprintf("%d rules", rules)
+	},
+	"aws_nat_gateway": hourly(natGatewayHourly),
+	"aws_eip":         hourly(publicIPv4Hourly),
+	"aws_vpc_endpoint": func(r resource, _ []resource) (float64, string) {
+		if r.Values["vpc_endpoint_type"] != "Interface" {
+			return 0, ""
+		}
+		// Billed per subnet, usually one subnet per availability zone
+		subnets := len(list(r.Values["subnet_ids"]))
+		if subnets == 0 {
+			subnets = 1
+		}
+		return float64(subnets) * interfaceEndpointHourly * HoursPerMonth, fmt.Sprintf("%d subnets", subnets)
+	},
+	"aws_kms_key":                 monthly(kmsKeyMonthly),
+	"aws_secretsmanager_secret":   monthly(secretMonthly),
+	"aws_cloudwatch_dashboard":    monthly(dashboardMonthly),
+	"aws_cloudwatch_metric_alarm": monthly(alarmMonthly),
+	"aws_dynamodb_table": func(r resource, _ []resource) (float64, string) {
+		if r.Values["billing_mode"] != "PROVISIONED" {
+			return 0, ""
+		}
+		reads, writes := number(r.Values["read_capacity"], 0), number(r.Values["write_capacity"], 0)
+		cost := (reads*readCapacityHourly + writes*writeCapacityHourly) * HoursPerMonth
+		return cost, fmt.Sprintf("%g RCU, %g WCU", reads, writes)
+	},
+	"aws_lambda_provisioned_concurrency_config": func(r resource, all []resource) (float64, string) {
+		executions := number(r.Values["provisioned_concurrent_executions"], 0)
+		memoryMB := 128.0
+		for _, function := range all {
+			if function.Type == "aws_lambda_function" && function.Values["function_name"] == r.Values["function_name"] {
+				memoryMB = number(function.Values["memory_size"], memoryMB)
+			}
+		}
+		cost := executions * memoryMB / 1024 * lambdaGBSecond * HoursPerMonth * 3600
+		return cost, fmt.Sprintf("%g executions of %g MB", executions, memoryMB)
+	},
+}
+
+func hourly(rate float64) pricer {
+	return func(resource, []resource) (float64, string) { return rate * HoursPerMonth, "" }
+}
+
+func monthly(rate float64) pricer {
+	return func(resource, []resource) (float64, string) { return rate, "" }
+}
+
+// FromPlanJSON estimates the plan printed by `terraform show -json`.
+// Resources of types without a fixed cost are left out.
+func FromPlanJSON(data []byte) (*Estimate, error) {
+	var parsed plan
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return nil, fmt.Errorf("parsing plan: %w", err)
+	}
+
+	var all []resource
+	var collect func(m module)
+	collect = func(m module) {
+		for _, r := range m.Resources {
+			if r.Mode == "managed" {
+				all = append(all, r)
+			}
+		}
+		for _, child := range m.ChildModules {
+			collect(child)
+		}
+	}
+	collect(parsed.PlannedValues.RootModule)
+
+	estimate := &Estimate{}
+	for _, r := range all {
+		price, ok := pricers[r.Type]
+		if !ok {
+			continue
+		}
+		cost, detail := price(r, all)
+		if cost == 0 {
+			continue
+		}
+		estimate.Items = append(estimate.Items, LineItem{Address: r.Address, Monthly: cost, Detail: detail})
+		estimate.Monthly += cost
+	}
+	sort.SliceStable(estimate.Items, func(i, j int) bool {
+		return estimate.Items[i].Monthly > estimate.Items[j].Monthly
+	})
+	return estimate, nil
+}
+
+// String lists the line items and the total, one per line.
+func (e *Estimate) String() string {
+	var b strings.Builder
+	for _, item := range e.Items {
+		fmt.Fprintf(&b, "  $%9.2f  %s", item.Monthly, item.Address)
+		if item.Detail != "" {
+			fmt.Fprintf(&b, " (%s)", item.Detail)
+		}
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "  $%9.2f  total per month", e.Monthly)
+	return b.String()
+}
+
+// BudgetFromEnv reads a monthly budget in dollars from name, or returns
+// fallback when it is unset.
+func BudgetFromEnv(t *testing.T, name string, fallback float64) float64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	budget, err := strconv.ParseFloat(value, 64)
+	if err != nil || budget < 0 {
+		t.Fatalf("%s must be a non-negative number of dollars, got %q", name, value)
+	}
+	return budget
+}
+
+// AssertWithinBudget logs the estimate and checks it is at most budget
+// dollars a month.
+func (e *Estimate) AssertWithinBudget(t *testing.T, budget float64) bool {
+	t.Logf("estimated fixed cost:\n%s", e)
+	return assert.LessOrEqualf(t, e.Monthly, budget,
+		"estimated fixed cost of $%.2f a month is over the $%.2f budget", e.Monthly, budget)
+}
+
+func number(value interface{}, fallback float64) float64 {
+	if n, ok := value.(float64); ok {
+		return n
+	}
+	return fallback
+}
+
+func list(value interface{}) []interface{} {
+	items, _ := value.([]interface{})
+	return items
+}
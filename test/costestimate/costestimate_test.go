@@ -0,0 +1,103 @@
+package costestimate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromPlanJSONPricesFixedCostResources(t *testing.T) {
+	t.Parallel()
+
+	plan := `{
+	  "planned_values": {
+	    "root_module": {
+	      "resources": [
+	        {"address": "aws_lambda_function.bedrock_lambda", "mode": "managed", "type": "aws_lambda_function",
+	         "values": {"function_name": "demo", "memory_size": 1024}},
+	        {"address": "aws_api_gateway_stage.bedrock_stage", "mode": "managed", "type": "aws_api_gateway_stage",
+	         "values": {"cache_cluster_enabled": true, "cache_cluster_size": "0.5"}},
+	        {"address": "aws_wafv2_web_acl.api_gateway_waf[0]", "mode": "managed", "type": "aws_wafv2_web_acl",
+	         "values": {"rule": [{}, {}, {}]}},
+	        {"address": "aws_dynamodb_table.usage", "mode": "managed", "type": "aws_dynamodb_table",
+	         "values": {"billing_mode": "PAY_PER_REQUEST"}},
+	        {"address": "data.aws_region.current", "mode": "data", "type": "aws_kms_key", "values": {}}
+	      ],
+	      "child_modules": [
+	        {
+	          "resources": [
+	            {"address": "module.kb.aws_opensearchserverless_collection.knowledge_base", "mode": "managed",
+	             "type": "aws_opensearchserverless_collection", "values": {"standby_replicas": "ENABLED"}},
+	            {"address": "module.kb.aws_lambda_provisioned_concurrency_config.warm", "mode": "managed",
+	             "type": "aws_lambda_provisioned_concurrency_config",
+	             "values": {"function_name": "demo", "provisioned_concurrent_executions": 2}}
+	          ]
+	        }
+	      ]
+	    }
+	  }
+	}`
+
+	estimate, err := FromPlanJSON([]byte(plan))
+	require.NoError(t, err)
+
+	addresses := make([]string, len(estimate.Items))
+	for i, item := range estimate.Items {
+		addresses[i] = item.Address
+	}
+	// Usage-priced and data resources are left out, and items are most expensive first
+	assert.Equal(t, []string{
+		"module.kb.aws_opensearchserverless_collection.knowledge_base",
+		"module.kb.aws_lambda_provisioned_concurrency_config.warm",
+		"aws_api_gateway_stage.bedrock_stage",
+		"aws_wafv2_web_acl.api_gateway_waf[0]",
+	}, addresses)
+
+	assert.InDelta(t, 2*0.24*730, estimate.Items[0].Monthly, 0.01)
+	assert.InDelta(t, 2*1.0*0.0000041667*730*3600, estimate.Items[1].Monthly, 0.01, "memory comes from the matching function")
+	assert.InDelta(t, 0.02*730, estimate.Items[2].Monthly, 0.01)
+	assert.InDelta(t, 5+3, estimate.Items[3].Monthly, 0.01)
+
+	var total float64
+	for _, item := range estimate.Items {
+		total += item.Monthly
+	}
+	assert.InDelta(t, total, estimate.Monthly, 0.001)
+	assert.Contains(t, estimate.String(), "total per month")
+}
+
+func TestFromPlanJSONFlagsProvisionedThroughput(t *testing.T) {
+	t.Parallel()
+
+	plan := `{"planned_values": {"root_module": {"resources": [
+	  {"address": "aws_bedrock_provisioned_model_throughput.haiku", "mode": "managed",
+	   "type": "aws_bedrock_provisioned_model_throughput", "values": {"model_units": 1}}
+	]}}}`
+
+	estimate, err := FromPlanJSON([]byte(plan))
+	require.NoError(t, err)
+	assert.Greater(t, estimate.Monthly, 10000.0, "a single model unit should dwarf any example budget")
+	assert.True(t, (&Estimate{Monthly: 49.99}).AssertWithinBudget(t, 50))
+}
+
+func TestFromPlanJSONSkipsIdleConfigurations(t *testing.T) {
+	t.Parallel()
+
+	plan := `{"planned_values": {"root_module": {"resources": [
+	  {"address": "aws_api_gateway_stage.bedrock_stage", "mode": "managed", "type": "aws_api_gateway_stage",
+	   "values": {"cache_cluster_enabled": false}},
+	  {"address": "aws_vpc_endpoint.s3", "mode": "managed", "type": "aws_vpc_endpoint",
+	   "values": {"vpc_endpoint_type": "Gateway"}},
+	  {"address": "aws_dynamodb_table.jobs", "mode": "managed", "type": "aws_dynamodb_table",
+	   "values": {"billing_mode": "PAY_PER_REQUEST"}}
+	]}}}`
+
+	estimate, err := FromPlanJSON([]byte(plan))
+	require.NoError(t, err)
+	assert.Empty(t, estimate.Items)
+	assert.Zero(t, estimate.Monthly)
+
+	_, err = FromPlanJSON([]byte("not json"))
+	assert.Error(t, err)
+}
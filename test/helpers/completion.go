@@ -0,0 +1,121 @@
+package helpers
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/catherinevee/tfm-aws-ai-bedrock/test/canary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// InvokeOptions are the optional request fields and transport settings for
+// InvokeBedrockEndpoint. The zero value sends only the prompt.
+type InvokeOptions struct {
+	MaxTokens int
+	Model     string
+	System    string
+	SessionID string
+	// Fields are merged into the request body last, for fields without an option
+	Fields map[string]interface{}
+
+	APIKey  string
+	Headers map[string]string
+	// RetryPolicy defaults to DefaultRetryPolicy
+	RetryPolicy *RetryPolicy
+}
+
+// Usage is the token accounting in a completion response.
+type Usage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+}
+
+// ResponseMetadata is the metadata object every handler response carries.
+type ResponseMetadata struct {
+	ExecutionTimeMs float64 `json:"execution_time_ms"`
+	Timestamp       int64   `json:"timestamp"`
+	RequestID       string  `json:"request_id"`
+}
+
+// CompletionResponse is the JSON envelope of a successful completion. Fields
+// that only some features set are left at their zero value otherwise.
+type CompletionResponse struct {
+	Success            bool             `json:"success"`
+	Content            string           `json:"content"`
+	ModelID            string           `json:"model_id"`
+	ModelAlias         string           `json:"model_alias"`
+	SessionID          string           `json:"session_id"`
+	Usage              Usage            `json:"usage"`
+	ContextUtilization float64          `json:"context_utilization"`
+	Metadata           ResponseMetadata `json:"metadata"`
+
+	Truncated          bool     `json:"truncated"`
+	TruncationReason   string   `json:"truncation_reason"`
+	ContinuationToken  string   `json:"continuation_token"`
+	ModelUsed          string   `json:"model_used"`
+	AttemptedModels    []string `json:"attempted_models"`
+	Region             string   `json:"region"`
+	StrippedParameters []string `json:"stripped_parameters"`
+	Stale              bool     `json:"stale"`
+	Deduplicated       bool     `json:"deduplicated"`
+}
+
+// InvokeBedrockEndpoint POSTs a prompt to the Bedrock route and returns the
+// status code and raw body, retrying only what the retry policy allows.
+func InvokeBedrockEndpoint(t *testing.T, url string, prompt string, opts InvokeOptions) (int, []byte) {
+	body, headers := buildInvokeRequest(t, prompt, opts)
+	return HTTPDoWithRetryPolicy(t, "POST", url, body, headers, opts.retryPolicy())
+}
+
+// buildInvokeRequest returns the JSON body and headers InvokeOptions describe.
+func buildInvokeRequest(t *testing.T, prompt string, opts InvokeOptions) ([]byte, map[string]string) {
+	payload := map[string]interface{}{"prompt": prompt}
+	if opts.MaxTokens > 0 {
+		payload["max_tokens"] = opts.MaxTokens
+	}
+	if opts.Model != "" {
+		payload["model"] = opts.Model
+	}
+	if opts.System != "" {
+		payload["system"] = opts.System
+	}
+	if opts.SessionID != "" {
+		payload["session_id"] = opts.SessionID
+	}
+	for key, value := range opts.Fields {
+		payload[key] = value
+	}
+	body, err := json.Marshal(payload)
+	require.NoError(t, err)
+
+	headers := map[string]string{"Content-Type": "application/json"}
+	if opts.APIKey != "" {
+		headers["x-api-key"] = opts.APIKey
+	}
+	for key, value := range opts.Headers {
+		headers[key] = value
+	}
+	return body, headers
+}
+
+func (opts InvokeOptions) retryPolicy() RetryPolicy {
+	if opts.RetryPolicy != nil {
+		return *opts.RetryPolicy
+	}
+	return DefaultRetryPolicy()
+}
+
+// AssertCompletionResponse decodes a successful completion and checks the
+// fields every one must have, with canary.CheckCompletion. Decoding into the
+// struct also fails the test when a field has the wrong JSON type.
+func AssertCompletionResponse(t *testing.T, body []byte) CompletionResponse {
+	var response CompletionResponse
+	require.NoError(t, json.Unmarshal(body, &response), "response is not a completion: %s", body)
+
+	_, err := canary.CheckCompletion(body)
+	assert.NoError(t, err)
+	return response
+}
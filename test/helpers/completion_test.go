@@ -0,0 +1,67 @@
+package helpers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInvokeBedrockEndpointBuildsRequest(t *testing.T) {
+	t.Parallel()
+
+	// The first attempt is throttled, so the default policy's retry is exercised too
+	var attempts int
+	var got map[string]interface{}
+	var gotAPIKey string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		gotAPIKey = r.Header.Get("x-api-key")
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+			"success": true,
+			"content": "Hello",
+			"model_id": "anthropic.claude-3-haiku-20240307-v1:0",
+			"model_alias": "fast",
+			"session_id": null,
+			"usage": {"input_tokens": 3, "output_tokens": 1},
+			"context_utilization": null,
+			"metadata": {"execution_time_ms": 12.5, "timestamp": 1700000000, "request_id": "abc-123"}
+		}`))
+	}))
+	defer server.Close()
+
+	policy := DefaultRetryPolicy()
+	policy.TimeBetweenRetries = 10 * time.Millisecond
+	statusCode, body := InvokeBedrockEndpoint(t, server.URL, "Say hello", InvokeOptions{
+		MaxTokens:   10,
+		Model:       "fast",
+		Fields:      map[string]interface{}{"temperature": 0.2},
+		APIKey:      "secret",
+		RetryPolicy: &policy,
+	})
+	require.Equal(t, 200, statusCode)
+	assert.Equal(t, 2, attempts)
+	assert.Equal(t, "secret", gotAPIKey)
+	assert.Equal(t, map[string]interface{}{
+		"prompt":      "Say hello",
+		"max_tokens":  10.0,
+		"model":       "fast",
+		"temperature": 0.2,
+	}, got)
+
+	completion := AssertCompletionResponse(t, body)
+	assert.Equal(t, "Hello", completion.Content)
+	assert.Equal(t, "fast", completion.ModelAlias)
+	assert.Equal(t, 1, completion.Usage.OutputTokens)
+	assert.Equal(t, "abc-123", completion.Metadata.RequestID)
+}
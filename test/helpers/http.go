@@ -0,0 +1,99 @@
+// Package helpers holds the HTTP, output and response helpers shared by the
+// module's Terratest suites, so a new suite can deploy, call and check the API
+// without copying retry loops or request bodies.
+package helpers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// RetryPolicy controls which responses an HTTP check retries on. Anything not
+// listed as retryable fails fast so deterministic bugs surface immediately.
+type RetryPolicy struct {
+	RetryableStatusCodes []int
+	RetryOnConnError     bool
+	MaxRetries           int
+	TimeBetweenRetries   time.Duration
+}
+
+// DefaultRetryPolicy retries throttling (429), unavailability (503) and
+// connection errors, which are expected while a fresh deployment warms up.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		RetryableStatusCodes: []int{http.StatusTooManyRequests, http.StatusServiceUnavailable},
+		RetryOnConnError:     true,
+		MaxRetries:           3,
+		TimeBetweenRetries:   10 * time.Second,
+	}
+}
+
+func (p RetryPolicy) isRetryableStatus(statusCode int) bool {
+	for _, code := range p.RetryableStatusCodes {
+		if code == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// HTTPDoWithRetryPolicyE sends a request and retries only when the policy
+// classifies the failure as transient. It returns the last status code and
+// body, or an error if the request never produced a response.
+func HTTPDoWithRetryPolicyE(t *testing.T, method string, url string, body []byte, headers map[string]string, policy RetryPolicy) (int, []byte, error) {
+	client := &http.Client{Timeout: 60 * time.Second}
+
+	var lastErr error
+	for attempt := 0; attempt <= policy.MaxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(policy.TimeBetweenRetries)
+		}
+
+		req, err := http.NewRequest(method, url, bytes.NewReader(body))
+		if err != nil {
+			return 0, nil, err
+		}
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = err
+			if !policy.RetryOnConnError {
+				return 0, nil, err
+			}
+			t.Logf("%s %s attempt %d: connection error: %v", method, url, attempt+1, err)
+			continue
+		}
+
+		respBody, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return resp.StatusCode, nil, err
+		}
+
+		if policy.isRetryableStatus(resp.StatusCode) {
+			lastErr = fmt.Errorf("retryable status %d: %s", resp.StatusCode, respBody)
+			t.Logf("%s %s attempt %d: got retryable status %d", method, url, attempt+1, resp.StatusCode)
+			continue
+		}
+
+		return resp.StatusCode, respBody, nil
+	}
+
+	return 0, nil, fmt.Errorf("%s %s failed after %d attempts: %w", method, url, policy.MaxRetries+1, lastErr)
+}
+
+// HTTPDoWithRetryPolicy is like HTTPDoWithRetryPolicyE but fails the test on error.
+func HTTPDoWithRetryPolicy(t *testing.T, method string, url string, body []byte, headers map[string]string, policy RetryPolicy) (int, []byte) {
+	statusCode, respBody, err := HTTPDoWithRetryPolicyE(t, method, url, body, headers, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return statusCode, respBody
+}
@@ -0,0 +1,41 @@
+package helpers
+
+import (
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+)
+
+// BedrockStackOutputs is the root module's deployment_info output, plus the
+// endpoint and credential outputs a test needs to call the API.
+type BedrockStackOutputs struct {
+	APIURL             string          `json:"api_url"`
+	APIID              string          `json:"api_id"`
+	APIStageName       string          `json:"api_stage_name"`
+	LambdaFunctionName string          `json:"lambda_function_name"`
+	LambdaFunctionARN  string          `json:"lambda_function_arn"`
+	LambdaRoleARN      string          `json:"lambda_role_arn"`
+	LogGroupName       string          `json:"log_group_name"`
+	BedrockModelID     string          `json:"bedrock_model_id"`
+	HandlerVersion     string          `json:"handler_version"`
+	Features           map[string]bool `json:"features"`
+
+	HealthURL string `json:"-"`
+	// APIKey is empty unless enable_api_key is set
+	APIKey string `json:"-"`
+}
+
+// GetStackOutputs reads the outputs of an applied root module. The aggregated
+// deployment_info object is decoded whole, so a renamed or retyped field fails
+// here rather than as an empty string deep inside a test.
+func GetStackOutputs(t *testing.T, options *terraform.Options) BedrockStackOutputs {
+	var outputs BedrockStackOutputs
+	terraform.OutputStruct(t, options, "deployment_info", &outputs)
+	outputs.HealthURL = terraform.Output(t, options, "health_url")
+
+	// Sensitive outputs are still readable, and null when the key is disabled
+	if outputs.Features["api_key"] {
+		outputs.APIKey = terraform.Output(t, options, "api_key_value")
+	}
+	return outputs
+}
@@ -0,0 +1,97 @@
+package helpers
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/catherinevee/tfm-aws-ai-bedrock/test/canary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// StreamFrame is one server-sent event, as the canary package parses it.
+type StreamFrame = canary.StreamFrame
+
+// StreamResult is a streamed response read frame by frame. Body is only set
+// when the response was not an event stream, such as an error envelope.
+type StreamResult struct {
+	StatusCode  int
+	ContentType string
+	Frames      []StreamFrame
+	Body        []byte
+	Duration    time.Duration
+}
+
+// StreamBedrockEndpoint sends a "stream": true request with
+// Accept: text/event-stream, reads the frames and times the whole response.
+// The module's REST API buffers streams, so per-frame arrival times say
+// nothing about time to first token. Retryable statuses are retried before any frame
+// is read; a stream that has started is never replayed.
+func StreamBedrockEndpoint(t *testing.T, url string, prompt string, opts InvokeOptions) StreamResult {
+	fields := map[string]interface{}{"stream": true}
+	for key, value := range opts.Fields {
+		fields[key] = value
+	}
+	opts.Fields = fields
+	body, headers := buildInvokeRequest(t, prompt, opts)
+	headers["Accept"] = "text/event-stream"
+
+	policy := opts.retryPolicy()
+	client := &http.Client{Timeout: 60 * time.Second}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			time.Sleep(policy.TimeBetweenRetries)
+		}
+
+		var result StreamResult
+		req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+		require.NoError(t, err)
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+
+		start := time.Now()
+		resp, err := client.Do(req)
+		if err != nil {
+			require.True(t, policy.RetryOnConnError && attempt < policy.MaxRetries, "POST %s: %v", url, err)
+			t.Logf("POST %s attempt %d: connection error: %v", url, attempt+1, err)
+			continue
+		}
+		if policy.isRetryableStatus(resp.StatusCode) && attempt < policy.MaxRetries {
+			resp.Body.Close()
+			t.Logf("POST %s attempt %d: got retryable status %d", url, attempt+1, resp.StatusCode)
+			continue
+		}
+
+		result.StatusCode = resp.StatusCode
+		result.ContentType = resp.Header.Get("Content-Type")
+		if strings.HasPrefix(result.ContentType, "text/event-stream") {
+			result.Frames, err = canary.ReadStreamFrames(resp.Body, start)
+		} else {
+			result.Body, err = io.ReadAll(resp.Body)
+		}
+		resp.Body.Close()
+		require.NoError(t, err, "reading the stream from %s", url)
+		result.Duration = time.Since(start)
+		return result
+	}
+}
+
+// AssertStreamCompleted checks a stream that should have finished normally
+// with canary.CheckStream, and that it was timed. It returns the concatenated
+// raw deltas.
+func AssertStreamCompleted(t *testing.T, result StreamResult) string {
+	require.Equal(t, http.StatusOK, result.StatusCode, "unexpected response: %s", result.Body)
+	require.True(t, strings.HasPrefix(result.ContentType, "text/event-stream"), "content type %q", result.ContentType)
+
+	content, err := canary.CheckStream(result.Frames)
+	assert.NoError(t, err)
+
+	assert.Greater(t, result.Duration, time.Duration(0))
+	return content
+}
@@ -0,0 +1,50 @@
+package helpers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamBedrockEndpointTimesStream(t *testing.T) {
+	t.Parallel()
+
+	// The content frames follow a delay, so the whole stream takes at least that
+	const tokenDelay = 200 * time.Millisecond
+	var got map[string]interface{}
+	var gotAccept string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAccept = r.Header.Get("Accept")
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Header().Set("Content-Type", "text/event-stream")
+
+		flusher := w.(http.Flusher)
+		for i, frame := range []string{
+			`data: {"delta": "Hel"}` + "\n\n",
+			`data: {"delta": "lo"}` + "\n\n",
+			"event: done\n" + `data: {"done": true, "model_id": "anthropic.claude-3-haiku-20240307-v1:0", "usage": {}}` + "\n\n",
+		} {
+			if i == 0 {
+				time.Sleep(tokenDelay)
+			}
+			w.Write([]byte(frame))
+			flusher.Flush()
+		}
+	}))
+	defer server.Close()
+
+	result := StreamBedrockEndpoint(t, server.URL, "Say hello", InvokeOptions{MaxTokens: 10})
+	assert.Equal(t, "text/event-stream", gotAccept)
+	assert.Equal(t, true, got["stream"])
+	assert.Equal(t, "Say hello", got["prompt"])
+
+	require.Len(t, result.Frames, 3)
+	assert.GreaterOrEqual(t, result.Duration, tokenDelay)
+
+	assert.Equal(t, "Hello", AssertStreamCompleted(t, result))
+}
@@ -0,0 +1,275 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/catherinevee/tfm-aws-ai-bedrock/test/awsvalidate"
+	"github.com/catherinevee/tfm-aws-ai-bedrock/test/helpers"
+	"github.com/catherinevee/tfm-aws-ai-bedrock/test/report"
+	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/gruntwork-io/terratest/modules/retry"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+)
+
+// testNamePrefix starts the name_prefix of every deployment the suite applies
+const testNamePrefix = "bedrock-test-"
+
+// testTagKey tags every resource a deployment creates with its name_prefix,
+// so assertTornDownAfterTest can find anything destroy missed
+const testTagKey = "BedrockTestID"
+
+// exampleTags returns the examples' default tags plus the test's ID, for tests
+// that apply an example instead of the root module
+func exampleTags(namePrefix string) map[string]string {
+	return map[string]string{
+		"Environment": "dev",
+		"Project":     "example",
+		"ManagedBy":   "terraform",
+		testTagKey:    namePrefix,
+	}
+}
+
+// moduleTerraformOptions returns options that apply the root module directly
+// with a unique name prefix, the suite's model and cheap defaults. Extra vars
+// override defaults.
+func moduleTerraformOptions(t *testing.T, vars map[string]interface{}) *terraform.Options {
+	namePrefix := testNamePrefix + random.UniqueId()
+	moduleVars := map[string]interface{}{
+		"name_prefix":        namePrefix,
+		"bedrock_model_id":   suiteConfig.ModelID,
+		"enable_monitoring":  false,
+		"log_retention_days": 1,
+		// The module's default tags, which tests assert on, plus the test's ID
+		"tags": map[string]string{
+			"Environment": "production",
+			"Project":     "bedrock-api",
+			"ManagedBy":   "terraform",
+			testTagKey:    namePrefix,
+		},
+	}
+	for key, value := range vars {
+		moduleVars[key] = value
+	}
+
+	return terraform.WithDefaultRetryableErrors(t, &terraform.Options{
+		TerraformDir: "../",
+		Vars:         moduleVars,
+		EnvVars: map[string]string{
+			"AWS_DEFAULT_REGION": suiteConfig.Region,
+		},
+	})
+}
+
+// planOnlyOptions is moduleTerraformOptions for tests that only plan. Showing
+// the plan as a struct needs a plan file, which apply tests must not set.
+func planOnlyOptions(t *testing.T, vars map[string]interface{}) *terraform.Options {
+	options := moduleTerraformOptions(t, vars)
+	options.PlanFilePath = filepath.Join(t.TempDir(), "tfplan")
+	return options
+}
+
+// skipUnlessExpensive skips suites that cost well above one deployment, such
+// as load runs or deployments to several regions, unless RUN_EXPENSIVE_TESTS
+// is set.
+func skipUnlessExpensive(t *testing.T) {
+	if !suiteConfig.RunExpensiveTests {
+		t.Skip("expensive suite; set RUN_EXPENSIVE_TESTS=true to run it")
+	}
+}
+
+// recordResourceCounts adds the managed resources in the applied state to
+// suiteReport. It only logs failures, since the report is informational.
+func recordResourceCounts(t *testing.T, options *terraform.Options) {
+	state, err := terraform.ShowE(t, options)
+	if err == nil {
+		var counts map[string]int
+		if counts, err = report.ResourceCounts([]byte(state)); err == nil {
+			suiteReport.For(t).SetResourceCounts(counts)
+			return
+		}
+	}
+	t.Logf("not recording resource counts: %v", err)
+}
+
+// destroy runs terraform.Destroy and records how long it took. Tests defer it
+// right before their first apply.
+func destroy(t *testing.T, options *terraform.Options) {
+	defer suiteReport.For(t).Time(report.PhaseDestroy)()
+	terraform.Destroy(t, options)
+}
+
+// tornDownChecks holds the testTagKey values assertTornDownAfterTest has
+// registered a check for, since tests may apply the same options repeatedly
+var tornDownChecks sync.Map
+
+// assertTornDownAfterTest registers a cleanup, which runs after the test's
+// deferred destroy, that fails the test if anything tagged with its testTagKey
+// is still in the account. Options without the tag aren't checked.
+func assertTornDownAfterTest(t *testing.T, options *terraform.Options) {
+	tags, ok := options.Vars["tags"].(map[string]string)
+	if !ok || tags[testTagKey] == "" {
+		return
+	}
+	if _, registered := tornDownChecks.LoadOrStore(tags[testTagKey], true); registered {
+		return
+	}
+
+	region := suiteConfig.Region
+	if value := options.EnvVars["AWS_DEFAULT_REGION"]; value != "" {
+		region = value
+	}
+	t.Cleanup(func() {
+		awsvalidate.New(awsConfigForRegion(t, region)).AssertTornDown(t, testTagKey, tags[testTagKey])
+	})
+}
+
+// assertIdempotent fails the test when the configuration just applied still
+// has changes to make: a plan with -detailed-exitcode must be empty, and a
+// second apply must change nothing. Perpetual diffs, such as tags the
+// provider normalizes or deployment triggers that never settle, otherwise
+// only show up for users. Set BEDROCK_TEST_SKIP_IDEMPOTENCY=1 to skip the
+// check while iterating locally.
+func assertIdempotent(t *testing.T, options *terraform.Options) {
+	if os.Getenv("BEDROCK_TEST_SKIP_IDEMPOTENCY") == "1" {
+		return
+	}
+
+	exitCode, err := terraform.PlanExitCodeE(t, options)
+	if err != nil && exitCode != 2 {
+		t.Fatalf("plan after apply failed: %v", err)
+	}
+	if exitCode == 2 {
+		t.Errorf("plan after apply is not empty; the changes it shows are a perpetual diff")
+	}
+
+	output := terraform.Apply(t, options)
+	if !strings.Contains(output, "0 added, 0 changed, 0 destroyed") {
+		t.Errorf("second apply was not a no-op:\n%s", output)
+	}
+}
+
+// initAndApplyWithRetry runs InitAndApply and, when it fails, destroys the
+// partial state and tries again with exponential backoff. Transient AWS
+// errors otherwise leave resources behind that make the next apply conflict.
+// A successful apply is then checked with assertIdempotent. The apply time,
+// retries included, and the deployed resource counts go to suiteReport.
+// Every deployment is also checked with assertTornDownAfterTest. The retry
+// count and the time they may take come from suiteConfig.
+func initAndApplyWithRetry(t *testing.T, options *terraform.Options) {
+	assertTornDownAfterTest(t, options)
+	retries := suiteConfig.ApplyRetries
+	deadline := time.Now().Add(suiteConfig.ApplyTimeout)
+	backoff := 30 * time.Second
+	stopTimer := suiteReport.For(t).Time(report.PhaseApply)
+
+	for attempt := 0; ; attempt++ {
+		_, err := terraform.InitAndApplyE(t, options)
+		if err == nil {
+			stopTimer()
+			recordResourceCounts(t, options)
+			assertIdempotent(t, options)
+			return
+		}
+		if attempt == retries {
+			t.Fatalf("apply failed after %d attempts: %v", attempt+1, err)
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			t.Fatalf("apply failed after %d attempts, with no time left for another within %s: %v", attempt+1, suiteConfig.ApplyTimeout, err)
+		}
+
+		t.Logf("apply attempt %d failed, destroying partial state before retrying in %s: %v", attempt+1, backoff, err)
+		if _, err := terraform.DestroyE(t, options); err != nil {
+			t.Logf("destroy after failed apply: %v", err)
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// postJSON sends a JSON payload to the API and decodes the JSON response body.
+func postJSON(t *testing.T, url string, payload interface{}, headers map[string]string) (int, map[string]interface{}) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requestHeaders := map[string]string{"Content-Type": "application/json"}
+	for key, value := range headers {
+		requestHeaders[key] = value
+	}
+
+	// Only a test's first call is kept, which is usually a cold start
+	stopTimer := suiteReport.For(t).Time(report.PhaseFirstInvocation)
+	statusCode, respBody := helpers.HTTPDoWithRetryPolicy(t, "POST", url, body, requestHeaders, helpers.DefaultRetryPolicy())
+	stopTimer()
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(respBody, &decoded); err != nil {
+		t.Fatalf("response from %s is not JSON (status %d): %s", url, statusCode, respBody)
+	}
+	return statusCode, decoded
+}
+
+// awsConfig loads SDK credentials for direct resource verification.
+func awsConfig(t *testing.T) aws.Config {
+	return awsConfigForRegion(t, suiteConfig.Region)
+}
+
+// awsConfigForRegion is awsConfig for a deployment outside suiteConfig.Region.
+func awsConfigForRegion(t *testing.T, region string) aws.Config {
+	cfg, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(region))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// waitForMetricSum polls a handler EMF metric for one function until it has a
+// non-zero sum. EMF metrics take a few minutes to become queryable.
+func waitForMetricSum(t *testing.T, metricName string, functionName string, since time.Time) float64 {
+	return waitForNamespacedMetricSum(t, "BedrockAPI", metricName, functionName, since)
+}
+
+// waitForNamespacedMetricSum is waitForMetricSum for a deployment with a
+// custom metric_namespace.
+func waitForNamespacedMetricSum(t *testing.T, namespace string, metricName string, functionName string, since time.Time) float64 {
+	client := cloudwatch.NewFromConfig(awsConfig(t))
+
+	var total float64
+	retry.DoWithRetry(t, fmt.Sprintf("wait for %s metric", metricName), 20, 30*time.Second, func() (string, error) {
+		out, err := client.GetMetricStatistics(context.Background(), &cloudwatch.GetMetricStatisticsInput{
+			Namespace:  aws.String(namespace),
+			MetricName: aws.String(metricName),
+			Dimensions: []cwtypes.Dimension{{Name: aws.String("FunctionName"), Value: aws.String(functionName)}},
+			StartTime:  aws.Time(since.Add(-time.Minute)),
+			EndTime:    aws.Time(time.Now().Add(time.Minute)),
+			Period:     aws.Int32(60),
+			Statistics: []cwtypes.Statistic{cwtypes.StatisticSum},
+		})
+		if err != nil {
+			return "", err
+		}
+
+		total = 0
+		for _, point := range out.Datapoints {
+			total += aws.ToFloat64(point.Sum)
+		}
+		if total == 0 {
+			return "", fmt.Errorf("no %s datapoints yet", metricName)
+		}
+		return fmt.Sprintf("%v", total), nil
+	})
+	return total
+}
@@ -0,0 +1,151 @@
+// Package loadtest fires concurrent requests at a deployed endpoint and
+// summarizes latency percentiles and error and throttle rates, so the module's
+// throttling and concurrency settings can be checked under load rather than
+// only read back from the plan.
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Request sends one request and returns its status code, or an error when no
+// response arrived.
+type Request func(ctx context.Context) (int, error)
+
+// Config sizes a run. RatePerSecond paces request starts across the workers;
+// zero sends as fast as the workers free up.
+type Config struct {
+	Requests      int
+	Concurrency   int
+	RatePerSecond float64
+}
+
+// Result summarizes a run. Latencies are sorted and cover every request that
+// got a response, throttled or not.
+type Result struct {
+	Total       int
+	Latencies   []time.Duration
+	StatusCodes map[int]int
+	// Errors counts requests that got no response at all
+	Errors   int
+	Duration time.Duration
+}
+
+// Run sends cfg.Requests requests from cfg.Concurrency workers and waits for
+// all of them.
+func Run(ctx context.Context, cfg Config, request Request) Result {
+	jobs := make(chan struct{})
+	var mu sync.Mutex
+	result := Result{Total: cfg.Requests, StatusCodes: map[int]int{}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				start := time.Now()
+				statusCode, err := request(ctx)
+				latency := time.Since(start)
+
+				mu.Lock()
+				if err != nil {
+					result.Errors++
+				} else {
+					result.StatusCodes[statusCode]++
+					result.Latencies = append(result.Latencies, latency)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	var ticker *time.Ticker
+	if cfg.RatePerSecond > 0 {
+		ticker = time.NewTicker(time.Duration(float64(time.Second) / cfg.RatePerSecond))
+		defer ticker.Stop()
+	}
+
+	start := time.Now()
+	for i := 0; i < cfg.Requests; i++ {
+		if ticker != nil && i > 0 {
+			<-ticker.C
+		}
+		jobs <- struct{}{}
+	}
+	close(jobs)
+	wg.Wait()
+	result.Duration = time.Since(start)
+
+	sort.Slice(result.Latencies, func(i, j int) bool { return result.Latencies[i] < result.Latencies[j] })
+	return result
+}
+
+// Percentile returns the nearest-rank latency percentile, p from 0 to 100.
+func (r Result) Percentile(p float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p/100*float64(len(r.Latencies)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return r.Latencies[rank]
+}
+
+// ThrottleRate is the share of requests API Gateway answered with 429.
+func (r Result) ThrottleRate() float64 {
+	return r.rate(r.StatusCodes[http.StatusTooManyRequests])
+}
+
+// ErrorRate is the share of requests that got no response or a 5xx.
+func (r Result) ErrorRate() float64 {
+	failed := r.Errors
+	for statusCode, count := range r.StatusCodes {
+		if statusCode >= 500 {
+			failed += count
+		}
+	}
+	return r.rate(failed)
+}
+
+// Admitted counts requests that were not throttled or lost.
+func (r Result) Admitted() int {
+	return r.Total - r.Errors - r.StatusCodes[http.StatusTooManyRequests]
+}
+
+func (r Result) rate(count int) float64 {
+	if r.Total == 0 {
+		return 0
+	}
+	return float64(count) / float64(r.Total)
+}
+
+// HTTPRequest returns a Request that POSTs body to url. The response body is
+// drained so connections are reused.
+func HTTPRequest(client *http.Client, url string, body []byte, headers map[string]string) Request {
+	return func(ctx context.Context) (int, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+		if err != nil {
+			return 0, err
+		}
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+}
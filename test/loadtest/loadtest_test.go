@@ -0,0 +1,68 @@
+package loadtest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunSummarizesLatencyAndRates(t *testing.T) {
+	t.Parallel()
+
+	// Every fifth request is throttled and every tenth fails
+	var count int64
+	var inFlight, maxInFlight int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := atomic.AddInt64(&inFlight, 1)
+		defer atomic.AddInt64(&inFlight, -1)
+		for {
+			seen := atomic.LoadInt64(&maxInFlight)
+			if current <= seen || atomic.CompareAndSwapInt64(&maxInFlight, seen, current) {
+				break
+			}
+		}
+
+		n := atomic.AddInt64(&count, 1)
+		time.Sleep(10 * time.Millisecond)
+		switch {
+		case n%10 == 0:
+			w.WriteHeader(http.StatusBadGateway)
+		case n%5 == 0:
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+
+	request := HTTPRequest(server.Client(), server.URL, []byte(`{}`), nil)
+	result := Run(context.Background(), Config{Requests: 50, Concurrency: 5}, request)
+
+	assert.Equal(t, 50, result.Total)
+	require.Len(t, result.Latencies, 50)
+	assert.LessOrEqual(t, maxInFlight, int64(5), "no more than Concurrency requests should be in flight")
+	assert.InDelta(t, 0.1, result.ErrorRate(), 1e-9)
+	assert.InDelta(t, 0.1, result.ThrottleRate(), 1e-9)
+	assert.Equal(t, 45, result.Admitted())
+
+	assert.GreaterOrEqual(t, result.Percentile(50), 10*time.Millisecond)
+	assert.LessOrEqual(t, result.Percentile(50), result.Percentile(99))
+	assert.Equal(t, result.Latencies[49], result.Percentile(100))
+}
+
+func TestPercentileNearestRank(t *testing.T) {
+	t.Parallel()
+
+	result := Result{}
+	for i := 1; i <= 10; i++ {
+		result.Latencies = append(result.Latencies, time.Duration(i)*time.Millisecond)
+	}
+	assert.Equal(t, 5*time.Millisecond, result.Percentile(50))
+	assert.Equal(t, 10*time.Millisecond, result.Percentile(95))
+	assert.Equal(t, 1*time.Millisecond, result.Percentile(0))
+	assert.Zero(t, Result{}.Percentile(99))
+}
@@ -0,0 +1,88 @@
+package loadtest
+
+import (
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// SLO holds the thresholds a run must meet. Zero latency fields are not
+// checked.
+type SLO struct {
+	P50             time.Duration
+	P95             time.Duration
+	P99             time.Duration
+	MaxErrorRate    float64
+	MaxThrottleRate float64
+}
+
+// SLOFromEnv overrides defaults with BEDROCK_LOADTEST_P50_MS, _P95_MS,
+// _P99_MS, _MAX_ERROR_RATE and _MAX_THROTTLE_RATE where they are set.
+func SLOFromEnv(t *testing.T, defaults SLO) SLO {
+	slo := defaults
+	for name, target := range map[string]*time.Duration{
+		"BEDROCK_LOADTEST_P50_MS": &slo.P50,
+		"BEDROCK_LOADTEST_P95_MS": &slo.P95,
+		"BEDROCK_LOADTEST_P99_MS": &slo.P99,
+	} {
+		if value := os.Getenv(name); value != "" {
+			ms, err := strconv.Atoi(value)
+			if err != nil || ms < 0 {
+				t.Fatalf("%s must be a non-negative number of milliseconds, got %q", name, value)
+			}
+			*target = time.Duration(ms) * time.Millisecond
+		}
+	}
+	for name, target := range map[string]*float64{
+		"BEDROCK_LOADTEST_MAX_ERROR_RATE":    &slo.MaxErrorRate,
+		"BEDROCK_LOADTEST_MAX_THROTTLE_RATE": &slo.MaxThrottleRate,
+	} {
+		if value := os.Getenv(name); value != "" {
+			rate, err := strconv.ParseFloat(value, 64)
+			if err != nil || rate < 0 || rate > 1 {
+				t.Fatalf("%s must be a rate between 0 and 1, got %q", name, value)
+			}
+			*target = rate
+		}
+	}
+	return slo
+}
+
+// IntFromEnv reads a positive integer setting such as the request count.
+func IntFromEnv(t *testing.T, name string, fallback int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		t.Fatalf("%s must be a positive integer, got %q", name, value)
+	}
+	return n
+}
+
+// AssertSLO logs the run's summary and checks it against slo.
+func (r Result) AssertSLO(t *testing.T, slo SLO) {
+	t.Logf("%d requests in %s: p50 %s, p95 %s, p99 %s, errors %.1f%%, throttled %.1f%%, status codes %v",
+		r.Total, r.Duration, r.Percentile(50), r.Percentile(95), r.Percentile(99),
+		100*r.ErrorRate(), 100*r.ThrottleRate(), r.StatusCodes)
+
+	for _, check := range []struct {
+		name      string
+		p         float64
+		threshold time.Duration
+	}{
+		{"p50", 50, slo.P50},
+		{"p95", 95, slo.P95},
+		{"p99", 99, slo.P99},
+	} {
+		if check.threshold > 0 {
+			assert.LessOrEqual(t, r.Percentile(check.p), check.threshold, "%s latency", check.name)
+		}
+	}
+	assert.LessOrEqual(t, r.ErrorRate(), slo.MaxErrorRate, "error rate")
+	assert.LessOrEqual(t, r.ThrottleRate(), slo.MaxThrottleRate, "throttle rate")
+}
@@ -0,0 +1,53 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/catherinevee/tfm-aws-ai-bedrock/test/report"
+	"github.com/catherinevee/tfm-aws-ai-bedrock/test/testconfig"
+)
+
+// suiteReport collects the timings the suite helpers record for every test
+var suiteReport = report.New()
+
+// suiteConfig is the region, model and limits the suite runs with, loaded by
+// TestMain
+var suiteConfig testconfig.Config
+
+// TestMain loads suiteConfig and runs the suite, then writes suiteReport to
+// BEDROCK_TEST_REPORT and publishes it to the BEDROCK_TEST_METRICS_NAMESPACE
+// CloudWatch namespace, when those are set. An invalid config stops the run
+// before any test starts; a failure to report doesn't fail it.
+func TestMain(m *testing.M) {
+	var err error
+	if suiteConfig, err = testconfig.Load(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	code := m.Run()
+
+	if path := os.Getenv("BEDROCK_TEST_REPORT"); path != "" {
+		if err := suiteReport.WriteFile(path); err != nil {
+			fmt.Fprintf(os.Stderr, "writing test report: %v\n", err)
+		}
+	}
+
+	if namespace := os.Getenv("BEDROCK_TEST_METRICS_NAMESPACE"); namespace != "" {
+		ctx := context.Background()
+		cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(suiteConfig.Region))
+		if err == nil {
+			err = suiteReport.Publish(ctx, cloudwatch.NewFromConfig(cfg), namespace)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "publishing test metrics: %v\n", err)
+		}
+	}
+
+	os.Exit(code)
+}
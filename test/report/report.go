@@ -0,0 +1,247 @@
+// Package report records how long each test's deployment phases took and
+// how many resources it deployed, so changes to the module's deploy time and
+// cold-start latency can be tracked across runs. A Report is written as a
+// JSON artifact and can be pushed to CloudWatch as custom metrics.
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// Phases the suite helpers time. Tests may record others.
+const (
+	PhaseApply           = "apply"
+	PhaseDestroy         = "destroy"
+	PhaseFirstInvocation = "first_invocation"
+)
+
+// Test is the record of one test or subtest.
+type Test struct {
+	Name     string    `json:"name"`
+	Status   string    `json:"status"`
+	Started  time.Time `json:"started"`
+	Duration float64   `json:"duration_seconds"`
+	// Phases holds the seconds each timed phase took
+	Phases         map[string]float64 `json:"phases_seconds,omitempty"`
+	ResourceCounts map[string]int     `json:"resource_counts,omitempty"`
+
+	mu sync.Mutex
+}
+
+// Observe records that phase took d, unless the phase was already recorded.
+// The first observation wins, so the first invocation is not overwritten by
+// later calls.
+func (rec *Test) Observe(phase string, d time.Duration) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if _, ok := rec.Phases[phase]; !ok {
+		rec.Phases[phase] = d.Seconds()
+	}
+}
+
+// Time returns a function that, when called, records the time since Time
+// was called as phase. Use it as `defer rec.Time("apply")()`.
+func (rec *Test) Time(phase string) func() {
+	start := time.Now()
+	return func() { rec.Observe(phase, time.Since(start)) }
+}
+
+// SetResourceCounts records the managed resources deployed, by type.
+func (rec *Test) SetResourceCounts(counts map[string]int) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.ResourceCounts = counts
+}
+
+// Report collects the records of a test run.
+type Report struct {
+	Started time.Time `json:"started"`
+	Tests   []*Test   `json:"tests"`
+
+	mu     sync.Mutex
+	byName map[string]*Test
+}
+
+// New returns an empty report for a run starting now.
+func New() *Report {
+	return &Report{Started: time.Now().UTC(), byName: map[string]*Test{}}
+}
+
+// For returns the record of t, creating it on first use. The record's status
+// and duration are filled in when t finishes.
+func (r *Report) For(t testing.TB) *Test {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if rec, ok := r.byName[t.Name()]; ok {
+		return rec
+	}
+
+	rec := &Test{Name: t.Name(), Started: time.Now().UTC(), Phases: map[string]float64{}}
+	r.byName[t.Name()] = rec
+	r.Tests = append(r.Tests, rec)
+	t.Cleanup(func() {
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		rec.Duration = time.Since(rec.Started).Seconds()
+		switch {
+		case t.Failed():
+			rec.Status = "fail"
+		case t.Skipped():
+			rec.Status = "skip"
+		default:
+			rec.Status = "pass"
+		}
+	})
+	return rec
+}
+
+// WriteFile writes the report to path as indented JSON, with tests in name
+// order.
+func (r *Report) WriteFile(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sort.Slice(r.Tests, func(i, j int) bool { return r.Tests[i].Name < r.Tests[j].Name })
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding report: %w", err)
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// MetricPutter is the part of the CloudWatch client Publish uses.
+type MetricPutter interface {
+	PutMetricData(ctx context.Context, in *cloudwatch.PutMetricDataInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.PutMetricDataOutput, error)
+}
+
+// maxDatumsPerCall is the most metrics one PutMetricData call accepts
+const maxDatumsPerCall = 1000
+
+// Metrics returns the report as metric data: one datum per recorded phase,
+// in seconds with a Test and Phase dimension, and the count of tests that
+// passed and failed.
+func (r *Report) Metrics() []types.MetricDatum {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var data []types.MetricDatum
+	var passed, failed float64
+	for _, rec := range r.Tests {
+		rec.mu.Lock()
+		phases := make([]string, 0, len(rec.Phases))
+		for phase := range rec.Phases {
+			phases = append(phases, phase)
+		}
+		sort.Strings(phases)
+		for _, phase := range phases {
+			data = append(data, types.MetricDatum{
+				MetricName: aws.String("PhaseDuration"),
+				Dimensions: []types.Dimension{
+					{Name: aws.String("Test"), Value: aws.String(rec.Name)},
+					{Name: aws.String("Phase"), Value: aws.String(phase)},
+				},
+				Value:     aws.Float64(rec.Phases[phase]),
+				Unit:      types.StandardUnitSeconds,
+				Timestamp: aws.Time(r.Started),
+			})
+		}
+		switch rec.Status {
+		case "pass":
+			passed++
+		case "fail":
+			failed++
+		}
+		rec.mu.Unlock()
+	}
+
+	for name, value := range map[string]float64{"TestsPassed": passed, "TestsFailed": failed} {
+		data = append(data, types.MetricDatum{
+			MetricName: aws.String(name),
+			Value:      aws.Float64(value),
+			Unit:       types.StandardUnitCount,
+			Timestamp:  aws.Time(r.Started),
+		})
+	}
+	return data
+}
+
+// Publish pushes Metrics to namespace.
+func (r *Report) Publish(ctx context.Context, client MetricPutter, namespace string) error {
+	data := r.Metrics()
+	for start := 0; start < len(data); start += maxDatumsPerCall {
+		end := start + maxDatumsPerCall
+		if end > len(data) {
+			end = len(data)
+		}
+		if _, err := client.PutMetricData(ctx, &cloudwatch.PutMetricDataInput{
+			Namespace:  aws.String(namespace),
+			MetricData: data[start:end],
+		}); err != nil {
+			return fmt.Errorf("publishing test metrics: %w", err)
+		}
+	}
+	return nil
+}
+
+// ResourceCounts counts the managed resources by type in the output of
+// `terraform show -json`, for either a saved plan or the current state.
+func ResourceCounts(showJSON []byte) (map[string]int, error) {
+	type module struct {
+		Resources []struct {
+			Mode string `json:"mode"`
+			Type string `json:"type"`
+		} `json:"resources"`
+		ChildModules []json.RawMessage `json:"child_modules"`
+	}
+	var parsed struct {
+		PlannedValues *struct {
+			RootModule json.RawMessage `json:"root_module"`
+		} `json:"planned_values"`
+		Values *struct {
+			RootModule json.RawMessage `json:"root_module"`
+		} `json:"values"`
+	}
+	if err := json.Unmarshal(showJSON, &parsed); err != nil {
+		return nil, fmt.Errorf("parsing terraform show output: %w", err)
+	}
+
+	counts := map[string]int{}
+	var count func(raw json.RawMessage) error
+	count = func(raw json.RawMessage) error {
+		var m module
+		if err := json.Unmarshal(raw, &m); err != nil {
+			return fmt.Errorf("parsing module: %w", err)
+		}
+		for _, r := range m.Resources {
+			if r.Mode == "managed" {
+				counts[r.Type]++
+			}
+		}
+		for _, child := range m.ChildModules {
+			if err := count(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	switch {
+	case parsed.PlannedValues != nil:
+		return counts, count(parsed.PlannedValues.RootModule)
+	case parsed.Values != nil:
+		return counts, count(parsed.Values.RootModule)
+	}
+	// An empty state has no values at all
+	return counts, nil
+}
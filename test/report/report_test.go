@@ -0,0 +1,124 @@
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakePutter struct {
+	inputs []*cloudwatch.PutMetricDataInput
+}
+
+func (f *fakePutter) PutMetricData(ctx context.Context, in *cloudwatch.PutMetricDataInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.PutMetricDataOutput, error) {
+	f.inputs = append(f.inputs, in)
+	return &cloudwatch.PutMetricDataOutput{}, nil
+}
+
+func TestReportRecordsPhasesAndStatus(t *testing.T) {
+	t.Parallel()
+
+	r := New()
+	t.Run("deploys", func(t *testing.T) {
+		rec := r.For(t)
+		assert.Same(t, rec, r.For(t), "a test should have one record")
+
+		rec.Observe(PhaseApply, 90*time.Second)
+		rec.Observe(PhaseFirstInvocation, 1500*time.Millisecond)
+		rec.Observe(PhaseFirstInvocation, 200*time.Millisecond)
+		rec.SetResourceCounts(map[string]int{"aws_lambda_function": 1})
+		stop := rec.Time(PhaseDestroy)
+		stop()
+	})
+
+	require.Len(t, r.Tests, 1)
+	rec := r.Tests[0]
+	assert.Equal(t, "TestReportRecordsPhasesAndStatus/deploys", rec.Name)
+	assert.Equal(t, "pass", rec.Status)
+	assert.Equal(t, 90.0, rec.Phases[PhaseApply])
+	assert.Equal(t, 1.5, rec.Phases[PhaseFirstInvocation], "the first invocation should not be overwritten")
+	assert.Contains(t, rec.Phases, PhaseDestroy)
+	assert.Equal(t, 1, rec.ResourceCounts["aws_lambda_function"])
+
+	path := filepath.Join(t.TempDir(), "report.json")
+	require.NoError(t, r.WriteFile(path))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var written struct {
+		Tests []struct {
+			Name   string             `json:"name"`
+			Status string             `json:"status"`
+			Phases map[string]float64 `json:"phases_seconds"`
+		} `json:"tests"`
+	}
+	require.NoError(t, json.Unmarshal(data, &written))
+	require.Len(t, written.Tests, 1)
+	assert.Equal(t, "pass", written.Tests[0].Status)
+	assert.Equal(t, 90.0, written.Tests[0].Phases[PhaseApply])
+}
+
+func TestReportPublishesMetrics(t *testing.T) {
+	t.Parallel()
+
+	r := New()
+	r.Tests = []*Test{
+		{Name: "TestA", Status: "pass", Phases: map[string]float64{PhaseApply: 60, PhaseDestroy: 30}},
+		{Name: "TestB", Status: "fail", Phases: map[string]float64{}},
+	}
+
+	putter := &fakePutter{}
+	require.NoError(t, r.Publish(context.Background(), putter, "BedrockModuleTests"))
+	require.Len(t, putter.inputs, 1)
+	assert.Equal(t, "BedrockModuleTests", aws.ToString(putter.inputs[0].Namespace))
+
+	values := map[string]float64{}
+	for _, datum := range putter.inputs[0].MetricData {
+		key := aws.ToString(datum.MetricName)
+		for _, dimension := range datum.Dimensions {
+			key += "/" + aws.ToString(dimension.Value)
+		}
+		values[key] = aws.ToFloat64(datum.Value)
+	}
+	assert.Equal(t, map[string]float64{
+		"PhaseDuration/TestA/apply":   60,
+		"PhaseDuration/TestA/destroy": 30,
+		"TestsPassed":                 1,
+		"TestsFailed":                 1,
+	}, values)
+}
+
+func TestResourceCounts(t *testing.T) {
+	t.Parallel()
+
+	state := `{"values": {"root_module": {
+	  "resources": [
+	    {"mode": "managed", "type": "aws_lambda_function"},
+	    {"mode": "data", "type": "aws_region"}
+	  ],
+	  "child_modules": [{"resources": [
+	    {"mode": "managed", "type": "aws_iam_role"},
+	    {"mode": "managed", "type": "aws_iam_role"}
+	  ]}]
+	}}}`
+	counts, err := ResourceCounts([]byte(state))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"aws_lambda_function": 1, "aws_iam_role": 2}, counts)
+
+	plan := `{"planned_values": {"root_module": {"resources": [{"mode": "managed", "type": "aws_sqs_queue"}]}}}`
+	counts, err = ResourceCounts([]byte(plan))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"aws_sqs_queue": 1}, counts)
+
+	counts, err = ResourceCounts([]byte(`{"format_version": "1.0"}`))
+	require.NoError(t, err)
+	assert.Empty(t, counts)
+}
@@ -0,0 +1,190 @@
+package sweeper
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/apigateway"
+	apigatewaytypes "github.com/aws/aws-sdk-go-v2/service/apigateway/types"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/wafv2"
+	waftypes "github.com/aws/aws-sdk-go-v2/service/wafv2/types"
+)
+
+// lambdaTimeLayout is the format of a function's LastModified
+const lambdaTimeLayout = "2006-01-02T15:04:05.000-0700"
+
+// listFunctions dates functions by their last modification, which is never
+// before their creation.
+func (s *Sweeper) listFunctions(ctx context.Context, prefix string) ([]Resource, error) {
+	var resources []Resource
+	input := &lambda.ListFunctionsInput{}
+	for {
+		page, err := s.lambda.ListFunctions(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, function := range page.Functions {
+			name := aws.ToString(function.FunctionName)
+			deployment, ok := deploymentOf(name, prefix)
+			if !ok {
+				continue
+			}
+			modified, _ := time.Parse(lambdaTimeLayout, aws.ToString(function.LastModified))
+			resources = append(resources, Resource{
+				Kind:       "Lambda function",
+				Name:       name,
+				Deployment: deployment,
+				Created:    modified,
+				delete: func(ctx context.Context) error {
+					_, err := s.lambda.DeleteFunction(ctx, &lambda.DeleteFunctionInput{FunctionName: aws.String(name)})
+					return err
+				},
+			})
+		}
+		if page.NextMarker == nil {
+			return resources, nil
+		}
+		input.Marker = page.NextMarker
+	}
+}
+
+func (s *Sweeper) listRestAPIs(ctx context.Context, prefix string) ([]Resource, error) {
+	var resources []Resource
+	input := &apigateway.GetRestApisInput{Limit: aws.Int32(500)}
+	for {
+		page, err := s.apigateway.GetRestApis(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, api := range page.Items {
+			deployment, ok := deploymentOf(aws.ToString(api.Name), prefix)
+			if !ok {
+				continue
+			}
+			id := aws.ToString(api.Id)
+			resources = append(resources, Resource{
+				Kind:       "REST API",
+				Name:       aws.ToString(api.Name) + " (" + id + ")",
+				Deployment: deployment,
+				Created:    aws.ToTime(api.CreatedDate),
+				delete: func(ctx context.Context) error {
+					return s.deleteRestAPI(ctx, id)
+				},
+			})
+		}
+		if page.Position == nil {
+			return resources, nil
+		}
+		input.Position = page.Position
+	}
+}
+
+// deleteRestAPI waits out DeleteRestApi's account-wide limit of one call
+// every 30 seconds.
+func (s *Sweeper) deleteRestAPI(ctx context.Context, id string) error {
+	for attempt := 0; ; attempt++ {
+		_, err := s.apigateway.DeleteRestApi(ctx, &apigateway.DeleteRestApiInput{RestApiId: aws.String(id)})
+		var throttled *apigatewaytypes.TooManyRequestsException
+		if err == nil || !errors.As(err, &throttled) || attempt == 4 {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(30 * time.Second):
+		}
+	}
+}
+
+// listWebACLs returns undated resources; a web ACL is only swept with a dated
+// resource of its deployment. Its associations are removed before deletion.
+func (s *Sweeper) listWebACLs(ctx context.Context, prefix string) ([]Resource, error) {
+	var resources []Resource
+	input := &wafv2.ListWebACLsInput{Scope: waftypes.ScopeRegional}
+	for {
+		page, err := s.wafv2.ListWebACLs(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, acl := range page.WebACLs {
+			deployment, ok := deploymentOf(aws.ToString(acl.Name), prefix)
+			if !ok {
+				continue
+			}
+			acl := acl
+			resources = append(resources, Resource{
+				Kind:       "web ACL",
+				Name:       aws.ToString(acl.Name),
+				Deployment: deployment,
+				delete: func(ctx context.Context) error {
+					return s.deleteWebACL(ctx, acl)
+				},
+			})
+		}
+		if page.NextMarker == nil {
+			return resources, nil
+		}
+		input.NextMarker = page.NextMarker
+	}
+}
+
+func (s *Sweeper) deleteWebACL(ctx context.Context, acl waftypes.WebACLSummary) error {
+	associated, err := s.wafv2.ListResourcesForWebACL(ctx, &wafv2.ListResourcesForWebACLInput{
+		WebACLArn:    acl.ARN,
+		ResourceType: waftypes.ResourceTypeApiGateway,
+	})
+	if err != nil {
+		return err
+	}
+	for _, resourceARN := range associated.ResourceArns {
+		if _, err := s.wafv2.DisassociateWebACL(ctx, &wafv2.DisassociateWebACLInput{ResourceArn: aws.String(resourceARN)}); err != nil {
+			return err
+		}
+	}
+
+	_, err = s.wafv2.DeleteWebACL(ctx, &wafv2.DeleteWebACLInput{
+		Id:        acl.Id,
+		Name:      acl.Name,
+		Scope:     waftypes.ScopeRegional,
+		LockToken: acl.LockToken,
+	})
+	return err
+}
+
+// listLogGroups matches groups on their last path segment, so groups under a
+// custom log_group_prefix are found too.
+func (s *Sweeper) listLogGroups(ctx context.Context, prefix string) ([]Resource, error) {
+	var resources []Resource
+	input := &cloudwatchlogs.DescribeLogGroupsInput{}
+	for {
+		page, err := s.logs.DescribeLogGroups(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range page.LogGroups {
+			name := aws.ToString(group.LogGroupName)
+			deployment, ok := deploymentOf(name, prefix)
+			if !ok {
+				continue
+			}
+			resources = append(resources, Resource{
+				Kind:       "log group",
+				Name:       name,
+				Deployment: deployment,
+				Created:    time.UnixMilli(aws.ToInt64(group.CreationTime)),
+				delete: func(ctx context.Context) error {
+					_, err := s.logs.DeleteLogGroup(ctx, &cloudwatchlogs.DeleteLogGroupInput{LogGroupName: aws.String(name)})
+					return err
+				},
+			})
+		}
+		if page.NextToken == nil {
+			return resources, nil
+		}
+		input.NextToken = page.NextToken
+	}
+}
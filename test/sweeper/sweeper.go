@@ -0,0 +1,138 @@
+// Package sweeper deletes resources left behind by test runs whose
+// terraform.Destroy never ran, such as after a panic or a CI timeout.
+// Resources are grouped into deployments by their name prefix, and a
+// deployment is swept as a whole once it is older than a TTL.
+package sweeper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/apigateway"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/wafv2"
+)
+
+// Resource is one resource of a test deployment. Created is zero for
+// resources whose API reports no creation time, such as WAF web ACLs.
+type Resource struct {
+	Kind       string
+	Name       string
+	Deployment string
+	Created    time.Time
+	delete     func(ctx context.Context) error
+}
+
+// Config selects what a sweep deletes.
+type Config struct {
+	// Prefix starts the name_prefix of every test deployment, for example
+	// "bedrock-test-"
+	Prefix string
+	// TTL is how old a deployment must be before it is swept, so runs still
+	// in progress are left alone
+	TTL    time.Duration
+	DryRun bool
+}
+
+// Sweeper lists and deletes test resources in one region.
+type Sweeper struct {
+	lambda     *lambda.Client
+	apigateway *apigateway.Client
+	wafv2      *wafv2.Client
+	logs       *cloudwatchlogs.Client
+	// Logf reports each deletion; it defaults to discarding them
+	Logf func(format string, args ...interface{})
+}
+
+// New returns a Sweeper for the region of cfg.
+func New(cfg aws.Config) *Sweeper {
+	return &Sweeper{
+		lambda:     lambda.NewFromConfig(cfg),
+		apigateway: apigateway.NewFromConfig(cfg),
+		wafv2:      wafv2.NewFromConfig(cfg),
+		logs:       cloudwatchlogs.NewFromConfig(cfg),
+		Logf:       func(string, ...interface{}) {},
+	}
+}
+
+// deploymentOf returns the name_prefix of the deployment a resource belongs
+// to: prefix plus the unique ID up to the next dash. Log groups are matched
+// on their last path segment. ok is false for names outside the prefix.
+func deploymentOf(name, prefix string) (string, bool) {
+	name = path.Base(name)
+	if !strings.HasPrefix(name, prefix) {
+		return "", false
+	}
+	id, _, _ := strings.Cut(strings.TrimPrefix(name, prefix), "-")
+	if id == "" {
+		return "", false
+	}
+	return prefix + id, true
+}
+
+// expired returns the deployments whose oldest dated resource was created
+// before cutoff. A deployment with no dated resource is kept, since its age
+// is unknown.
+func expired(resources []Resource, cutoff time.Time) map[string]bool {
+	oldest := map[string]time.Time{}
+	for _, resource := range resources {
+		if resource.Created.IsZero() {
+			continue
+		}
+		if current, ok := oldest[resource.Deployment]; !ok || resource.Created.Before(current) {
+			oldest[resource.Deployment] = resource.Created
+		}
+	}
+
+	deployments := map[string]bool{}
+	for deployment, created := range oldest {
+		if created.Before(cutoff) {
+			deployments[deployment] = true
+		}
+	}
+	return deployments
+}
+
+// Sweep deletes every resource of the deployments older than config.TTL and
+// returns them. With DryRun it only returns them. Functions are deleted
+// first and log groups last, so nothing writes to a group once it is gone. A
+// failed deletion doesn't stop the sweep; all failures are returned together.
+func (s *Sweeper) Sweep(ctx context.Context, config Config) ([]Resource, error) {
+	var resources []Resource
+	for _, list := range []func(context.Context, string) ([]Resource, error){
+		s.listFunctions,
+		s.listRestAPIs,
+		s.listWebACLs,
+		s.listLogGroups,
+	} {
+		listed, err := list(ctx, config.Prefix)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, listed...)
+	}
+
+	deployments := expired(resources, time.Now().Add(-config.TTL))
+	var swept []Resource
+	var errs []error
+	for _, resource := range resources {
+		if !deployments[resource.Deployment] {
+			continue
+		}
+		if !config.DryRun {
+			if err := resource.delete(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("deleting %s %s: %w", resource.Kind, resource.Name, err))
+				continue
+			}
+			s.Logf("deleted %s %s", resource.Kind, resource.Name)
+		}
+		swept = append(swept, resource)
+	}
+	return swept, errors.Join(errs...)
+}
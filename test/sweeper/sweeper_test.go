@@ -0,0 +1,45 @@
+package sweeper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeploymentOf(t *testing.T) {
+	t.Parallel()
+
+	for name, want := range map[string]string{
+		"bedrock-test-a1B2c3-bedrock-lambda":                      "bedrock-test-a1B2c3",
+		"bedrock-test-a1B2c3-api-gateway-waf":                     "bedrock-test-a1B2c3",
+		"/aws/lambda/bedrock-test-a1B2c3-cost-killswitch":         "bedrock-test-a1B2c3",
+		"/bedrock-test/x9Y8z7/bedrock-test-a1B2c3-bedrock-lambda": "bedrock-test-a1B2c3",
+	} {
+		deployment, ok := deploymentOf(name, "bedrock-test-")
+		assert.True(t, ok, name)
+		assert.Equal(t, want, deployment, name)
+	}
+
+	for _, name := range []string{"prod-bedrock-lambda", "/aws/lambda/prod-bedrock-lambda", "bedrock-test-"} {
+		_, ok := deploymentOf(name, "bedrock-test-")
+		assert.False(t, ok, name)
+	}
+}
+
+func TestExpired(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	resources := []Resource{
+		// The API is older than its function, which was updated since
+		{Deployment: "bedrock-test-old111", Created: now.Add(-2 * time.Hour)},
+		{Deployment: "bedrock-test-old111", Created: now.Add(-10 * time.Hour)},
+		{Deployment: "bedrock-test-old111"},
+		{Deployment: "bedrock-test-new222", Created: now.Add(-time.Hour)},
+		// A web ACL on its own has no age and is kept
+		{Deployment: "bedrock-test-acl333"},
+	}
+
+	assert.Equal(t, map[string]bool{"bedrock-test-old111": true}, expired(resources, now.Add(-6*time.Hour)))
+}
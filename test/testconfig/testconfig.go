@@ -0,0 +1,126 @@
+// Package testconfig loads the settings the integration suite shares across
+// tests: the region and model to deploy with, whether to run the expensive
+// suites, and how long and how often to retry an apply. They come from
+// defaults, then an optional YAML file, then environment variables, so CI and
+// local runs can target different accounts and budgets without code changes.
+package testconfig
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the suite's configuration. The YAML file uses the field tags, and
+// durations are written like 45m.
+type Config struct {
+	// Region is where single-region tests deploy (TEST_REGION)
+	Region string `yaml:"region"`
+	// ModelID is the foundation model tests invoke when the model itself
+	// isn't under test (TEST_MODEL_ID)
+	ModelID string `yaml:"model_id"`
+	// RunExpensiveTests enables suites that cost well above one deployment,
+	// such as provisioned throughput (RUN_EXPENSIVE_TESTS)
+	RunExpensiveTests bool `yaml:"run_expensive_tests"`
+	// ApplyRetries is how many times a failed apply is retried
+	// (BEDROCK_TEST_APPLY_RETRIES). 0 disables retries.
+	ApplyRetries int `yaml:"apply_retries"`
+	// ApplyTimeout bounds the time spent applying one deployment, retries
+	// included. No retry starts after it (APPLY_TIMEOUT).
+	ApplyTimeout time.Duration `yaml:"apply_timeout"`
+}
+
+// Default returns the configuration used when nothing is set.
+func Default() Config {
+	return Config{
+		Region:       "us-east-1",
+		ModelID:      "anthropic.claude-3-haiku-20240307-v1:0",
+		ApplyRetries: 2,
+		ApplyTimeout: 45 * time.Minute,
+	}
+}
+
+var (
+	regionPattern = regexp.MustCompile(`^[a-z]{2}(-gov)?-[a-z]+-\d$`)
+	// Inference profile IDs start with a geography, such as us.anthropic...
+	profilePattern = regexp.MustCompile(`^(us|eu|apac|us-gov|global)\.`)
+)
+
+// Load reads the file named by TEST_CONFIG_FILE, when set, over the defaults,
+// then applies the environment variables, and validates the result.
+func Load() (Config, error) {
+	return load(os.LookupEnv)
+}
+
+func load(lookup func(string) (string, bool)) (Config, error) {
+	config := Default()
+
+	if path, ok := lookup("TEST_CONFIG_FILE"); ok && path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("reading TEST_CONFIG_FILE: %w", err)
+		}
+		if err := yaml.Unmarshal(data, &config); err != nil {
+			return Config{}, fmt.Errorf("parsing %s: %w", path, err)
+		}
+	}
+
+	if value, ok := lookup("TEST_REGION"); ok && value != "" {
+		config.Region = value
+	}
+	if value, ok := lookup("TEST_MODEL_ID"); ok && value != "" {
+		config.ModelID = value
+	}
+	if value, ok := lookup("RUN_EXPENSIVE_TESTS"); ok && value != "" {
+		run, err := strconv.ParseBool(value)
+		if err != nil {
+			return Config{}, fmt.Errorf("RUN_EXPENSIVE_TESTS must be true or false, got %q", value)
+		}
+		config.RunExpensiveTests = run
+	}
+	if value, ok := lookup("BEDROCK_TEST_APPLY_RETRIES"); ok && value != "" {
+		retries, err := strconv.Atoi(value)
+		if err != nil {
+			return Config{}, fmt.Errorf("BEDROCK_TEST_APPLY_RETRIES must be an integer, got %q", value)
+		}
+		config.ApplyRetries = retries
+	}
+	if value, ok := lookup("APPLY_TIMEOUT"); ok && value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return Config{}, fmt.Errorf("APPLY_TIMEOUT must be a duration such as 45m, got %q", value)
+		}
+		config.ApplyTimeout = timeout
+	}
+
+	return config, config.Validate()
+}
+
+// Validate reports every setting that is out of range.
+func (c Config) Validate() error {
+	var problems []string
+	if !regionPattern.MatchString(c.Region) {
+		problems = append(problems, fmt.Sprintf("region %q is not an AWS region", c.Region))
+	}
+	if c.ModelID == "" {
+		problems = append(problems, "model ID is empty")
+	} else if profilePattern.MatchString(c.ModelID) {
+		problems = append(problems, fmt.Sprintf("model ID %q is an inference profile, not a foundation model", c.ModelID))
+	}
+	if c.ApplyRetries < 0 {
+		problems = append(problems, fmt.Sprintf("apply retries must not be negative, got %d", c.ApplyRetries))
+	}
+	if c.ApplyTimeout <= 0 {
+		problems = append(problems, fmt.Sprintf("apply timeout must be positive, got %s", c.ApplyTimeout))
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid test config: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
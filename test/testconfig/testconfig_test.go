@@ -0,0 +1,64 @@
+package testconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func lookupFrom(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	t.Parallel()
+
+	config, err := load(lookupFrom(nil))
+	require.NoError(t, err)
+	assert.Equal(t, Default(), config)
+}
+
+func TestLoadEnvironmentOverridesFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "test-config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("region: eu-west-1\nmodel_id: amazon.titan-text-express-v1\napply_retries: 0\napply_timeout: 20m\n"), 0o644))
+
+	config, err := load(lookupFrom(map[string]string{
+		"TEST_CONFIG_FILE":    path,
+		"TEST_REGION":         "us-west-2",
+		"RUN_EXPENSIVE_TESTS": "true",
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, Config{
+		Region:            "us-west-2",
+		ModelID:           "amazon.titan-text-express-v1",
+		RunExpensiveTests: true,
+		ApplyRetries:      0,
+		ApplyTimeout:      20 * time.Minute,
+	}, config)
+}
+
+func TestLoadRejectsInvalidSettings(t *testing.T) {
+	t.Parallel()
+
+	for name, env := range map[string]map[string]string{
+		"unparsable bool":     {"RUN_EXPENSIVE_TESTS": "sometimes"},
+		"unparsable duration": {"APPLY_TIMEOUT": "45"},
+		"negative retries":    {"BEDROCK_TEST_APPLY_RETRIES": "-1"},
+		"zero timeout":        {"APPLY_TIMEOUT": "0s"},
+		"not a region":        {"TEST_REGION": "virginia"},
+		"inference profile":   {"TEST_MODEL_ID": "us.anthropic.claude-3-haiku-20240307-v1:0"},
+		"missing file":        {"TEST_CONFIG_FILE": filepath.Join(t.TempDir(), "missing.yaml")},
+	} {
+		_, err := load(lookupFrom(env))
+		assert.Error(t, err, name)
+	}
+}
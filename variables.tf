@@ -27,6 +27,50 @@ variable "bedrock_model_id" {
   default     = "anthropic.claude-3-sonnet-20240229-v1:0"
 }
 
+variable "api_style" {
+  description = "Bedrock runtime API the handler calls: 'invoke' sends each model family's native body to InvokeModel, 'converse' uses the unified Converse and ConverseStream APIs"
+  type        = string
+  default     = "invoke"
+
+  validation {
+    condition     = contains(["invoke", "converse"], var.api_style)
+    error_message = "API style must be 'invoke' or 'converse'."
+  }
+}
+
+variable "max_tool_rounds" {
+  description = "Most tool_rounds a request may carry back when the client fulfills the model's tool calls. Tools need api_style converse."
+  type        = number
+  default     = 5
+
+  validation {
+    condition     = var.max_tool_rounds >= 1 && var.max_tool_rounds <= 20 && floor(var.max_tool_rounds) == var.max_tool_rounds
+    error_message = "Max tool rounds must be a whole number between 1 and 20."
+  }
+}
+
+variable "unsupported_param_mode" {
+  description = "What the handler does with top_k or stop_sequences when the target model's family doesn't take them: 'strip' removes them before invocation, 'reject' returns a 400 naming them"
+  type        = string
+  default     = "strip"
+
+  validation {
+    condition     = contains(["strip", "reject"], var.unsupported_param_mode)
+    error_message = "Unsupported param mode must be 'strip' or 'reject'."
+  }
+}
+
+variable "bedrock_endpoint_url" {
+  description = "Bedrock runtime endpoint override, e.g. a mock server for tests. Leave null in production."
+  type        = string
+  default     = null
+
+  validation {
+    condition     = var.bedrock_endpoint_url == null || can(regex("^https?://", var.bedrock_endpoint_url))
+    error_message = "Bedrock endpoint URL must start with http:// or https://."
+  }
+}
+
 variable "bedrock_model_arns" {
   description = "List of Bedrock model ARNs Lambda can access"
   type        = list(string)
@@ -37,50 +81,1154 @@ variable "bedrock_model_arns" {
   ]
 }
 
-# Lambda Configuration
-variable "lambda_runtime" {
-  description = "Python runtime version"
+variable "enable_cost_allocation_tags" {
+  description = "Create an application inference profile for bedrock_model_id tagged with application_tags, and invoke the model through it so Cost Explorer can attribute its spend"
+  type        = bool
+  default     = false
+
+  validation {
+    condition     = !var.enable_cost_allocation_tags || var.custom_model_arn == null
+    error_message = "Cost allocation tags use an application inference profile, which can't copy a custom model served through provisioned throughput."
+  }
+}
+
+variable "application_tags" {
+  description = "Cost allocation tags on the application inference profile, on top of tags. Activate the keys as cost allocation tags in the Billing console to see them in Cost Explorer."
+  type        = map(string)
+  default     = {}
+}
+
+variable "custom_model_arn" {
+  description = "ARN of a custom (fine-tuned) Bedrock model to serve as the default model instead of bedrock_model_id"
+  type        = string
+  default     = null
+
+  validation {
+    condition     = var.custom_model_arn == null || can(regex("^arn:aws[a-z-]*:bedrock:[a-z0-9-]+:[0-9]{12}:custom-model/", var.custom_model_arn))
+    error_message = "custom_model_arn must be a Bedrock custom-model ARN."
+  }
+}
+
+variable "custom_model_provisioned_throughput_arn" {
+  description = "ARN of the provisioned throughput purchased for custom_model_arn. Custom models can only be invoked through provisioned throughput."
+  type        = string
+  default     = null
+
+  validation {
+    condition     = var.custom_model_provisioned_throughput_arn == null || can(regex("^arn:aws[a-z-]*:bedrock:[a-z0-9-]+:[0-9]{12}:provisioned-model/", var.custom_model_provisioned_throughput_arn))
+    error_message = "custom_model_provisioned_throughput_arn must be a Bedrock provisioned-model ARN."
+  }
+
+  validation {
+    condition     = (var.custom_model_arn == null) == (var.custom_model_provisioned_throughput_arn == null)
+    error_message = "custom_model_arn needs custom_model_provisioned_throughput_arn, and the throughput is only used with custom_model_arn."
+  }
+}
+
+variable "enable_provisioned_throughput" {
+  description = "Purchase provisioned throughput for bedrock_model_id and invoke the default model through it. It is billed from creation until destroy whether or not it is used."
+  type        = bool
+  default     = false
+
+  validation {
+    condition     = !var.enable_provisioned_throughput || (var.custom_model_arn == null && !var.enable_cost_allocation_tags)
+    error_message = "Provisioned throughput for bedrock_model_id can't be combined with custom_model_arn, which brings its own, or with enable_cost_allocation_tags, which invokes the model through a profile."
+  }
+
+  validation {
+    condition     = !var.enable_provisioned_throughput || !can(regex("^(us|us-gov|eu|apac|jp|global)\\.", var.bedrock_model_id))
+    error_message = "Provisioned throughput is bought for a foundation model in one region, not a cross-region inference profile."
+  }
+}
+
+variable "provisioned_base_model_id" {
+  description = "Model variant to provision when it differs from bedrock_model_id, such as amazon.titan-text-express-v1:0:8k. Provisioned throughput is often only sold for a context-length variant."
+  type        = string
+  default     = null
+}
+
+variable "provisioned_model_units" {
+  description = "Model units of provisioned throughput to purchase (if enable_provisioned_throughput)"
+  type        = number
+  default     = 1
+
+  validation {
+    condition     = var.provisioned_model_units >= 1 && floor(var.provisioned_model_units) == var.provisioned_model_units
+    error_message = "Provisioned model units must be a whole number of at least 1."
+  }
+}
+
+variable "provisioned_commitment_duration" {
+  description = "Commitment term for the provisioned throughput: OneMonth or SixMonths. null buys it without a commitment, billed hourly and deletable at any time."
+  type        = string
+  default     = null
+
+  validation {
+    condition     = var.provisioned_commitment_duration == null || contains(["OneMonth", "SixMonths"], coalesce(var.provisioned_commitment_duration, "none"))
+    error_message = "Provisioned commitment duration must be OneMonth, SixMonths or null."
+  }
+}
+
+variable "model_aliases" {
+  description = "Map of stable model aliases to concrete Bedrock model IDs that clients can pass as the request 'model' field"
+  type        = map(string)
+  default     = {}
+
+  validation {
+    condition     = alltrue([for alias in keys(var.model_aliases) : can(regex("^[a-zA-Z0-9._-]+$", alias))])
+    error_message = "Model aliases must contain only letters, numbers, dots, underscores, and hyphens."
+  }
+}
+
+variable "deprecated_model_replacements" {
+  description = "Map of retired Bedrock model IDs to their replacements. Requests, aliases and bedrock_model_id resolving to a retired ID are served by the replacement instead of failing."
+  type        = map(string)
+  default     = {}
+
+  validation {
+    condition     = alltrue([for replacement in values(var.deprecated_model_replacements) : !contains(keys(var.deprecated_model_replacements), replacement)])
+    error_message = "A replacement model must not itself be listed as deprecated; map each retired ID straight to its final replacement."
+  }
+}
+
+variable "allowed_model_ids" {
+  description = "Model IDs requests may use, keyed by environment. Environments not listed get the module default: any model in dev and staging, only bedrock_model_id and the fallback chain in prod. An empty list allows any model."
+  type        = map(list(string))
+  default     = {}
+
+  validation {
+    condition     = alltrue([for env in keys(var.allowed_model_ids) : contains(["dev", "staging", "prod"], env)])
+    error_message = "Allowed model ID keys must be dev, staging or prod."
+  }
+
+  validation {
+    condition     = alltrue([for ids in values(var.allowed_model_ids) : length(ids) == 0 || contains(ids, coalesce(var.custom_model_arn, var.bedrock_model_id))])
+    error_message = "Each allowed_model_ids list must include the default model, custom_model_arn or bedrock_model_id, or every request without a model would be rejected."
+  }
+}
+
+variable "model_fallback_chain" {
+  description = "Model IDs or aliases tried in order when the requested model fails with a throttling, availability or timeout error"
+  type        = list(string)
+  default     = []
+
+  validation {
+    condition     = length(var.model_fallback_chain) == length(distinct(var.model_fallback_chain))
+    error_message = "model_fallback_chain must not list a model more than once."
+  }
+}
+
+variable "fallback_total_timeout_ms" {
+  description = "Overall deadline in milliseconds for a request and all of its fallback attempts"
+  type        = number
+  default     = 25000
+
+  validation {
+    condition     = var.fallback_total_timeout_ms >= 100 && var.fallback_total_timeout_ms <= 900000
+    error_message = "Fallback total timeout must be between 100 and 900000 milliseconds."
+  }
+}
+
+variable "enable_profile_region_fallback" {
+  description = "Retry requests for cross-region inference profiles (model IDs such as us.anthropic...) in other regions when the Lambda's region fails with a service-side or connection error"
+  type        = bool
+  default     = false
+}
+
+variable "profile_fallback_regions" {
+  description = "Regions tried in order by enable_profile_region_fallback. Empty uses the regions the inference profile routes to, looked up at runtime."
+  type        = list(string)
+  default     = []
+
+  validation {
+    condition     = alltrue([for region in var.profile_fallback_regions : can(regex("^[a-z]{2}(-gov)?-[a-z]+-[0-9]$", region))])
+    error_message = "profile_fallback_regions must contain AWS region names such as us-west-2."
+  }
+}
+
+variable "enable_quota_check" {
+  description = "Look up the default model's on-demand Bedrock quotas, report them as outputs and warn while they are still at the AWS defaults"
+  type        = bool
+  default     = false
+}
+
+variable "quota_model_name" {
+  description = "Model name as Bedrock service quota names spell it (e.g. \"Anthropic Claude 3 Sonnet\"). Defaults to the module's table entry for bedrock_model_id."
+  type        = string
+  default     = null
+}
+
+variable "enable_ensemble" {
+  description = "Allow requests with an \"ensemble\" list of models, invoked in parallel on the same prompt"
+  type        = bool
+  default     = false
+}
+
+variable "ensemble_max_models" {
+  description = "Most models one ensemble request may list"
+  type        = number
+  default     = 3
+
+  validation {
+    condition     = var.ensemble_max_models >= 2 && var.ensemble_max_models <= 10 && floor(var.ensemble_max_models) == var.ensemble_max_models
+    error_message = "Ensemble max models must be an integer between 2 and 10."
+  }
+}
+
+variable "ensemble_strategy" {
+  description = "How ensemble requests with \"ensemble_select\": \"best\" pick a completion: longest, or judge with ensemble_judge_model_id"
+  type        = string
+  default     = "longest"
+
+  validation {
+    condition     = contains(["longest", "judge"], var.ensemble_strategy)
+    error_message = "Ensemble strategy must be longest or judge."
+  }
+}
+
+variable "ensemble_judge_model_id" {
+  description = "Bedrock model that picks the best ensemble completion when ensemble_strategy is judge"
+  type        = string
+  default     = "anthropic.claude-3-haiku-20240307-v1:0"
+}
+
+variable "enable_streaming" {
+  description = "Allow requests with \"stream\": true, answered as batched server-sent event frames from InvokeModelWithResponseStream. The REST API buffers the whole response, so frames arrive together when generation ends."
+  type        = bool
+  default     = false
+}
+
+variable "stream_error_mode" {
+  description = "How mid-stream Bedrock failures are surfaced: 'trailer' appends an error frame to the partial output, 'abort' discards it and returns 502"
+  type        = string
+  default     = "trailer"
+
+  validation {
+    condition     = contains(["trailer", "abort"], var.stream_error_mode)
+    error_message = "Stream error mode must be 'trailer' or 'abort'."
+  }
+}
+
+variable "stream_json_mode" {
+  description = "How streamed completions are framed: 'raw' sends token deltas, 'ndjson' one frame per complete JSON line, 'json_path' one frame per complete member of the top-level JSON object or array"
+  type        = string
+  default     = "raw"
+
+  validation {
+    condition     = contains(["raw", "ndjson", "json_path"], var.stream_json_mode)
+    error_message = "Stream JSON mode must be 'raw', 'ndjson' or 'json_path'."
+  }
+}
+
+variable "cancel_on_disconnect" {
+  description = "Close the Bedrock stream once the client is gone, which API Gateway causes at its 29-second integration timeout, instead of generating the rest of the completion"
+  type        = bool
+  default     = true
+}
+
+variable "handler_fault_injection" {
+  description = "Testing only: inject handler faults. stream_failure_after_chunks fails streams after N chunks; shutdown_after_ms sends the handler SIGTERM mid-request; client_disconnect_after_chunks treats the client as gone after N chunks; throttle_percent fails that percentage of Bedrock calls with ThrottlingException."
+  type = object({
+    stream_failure_after_chunks    = optional(number, 0)
+    shutdown_after_ms              = optional(number, 0)
+    client_disconnect_after_chunks = optional(number, 0)
+    throttle_percent               = optional(number, 0)
+  })
+  default = {}
+
+  validation {
+    condition     = var.handler_fault_injection.throttle_percent >= 0 && var.handler_fault_injection.throttle_percent <= 100
+    error_message = "Fault injection throttle_percent must be between 0 and 100."
+  }
+}
+
+variable "drain_timeout_seconds" {
+  description = "Seconds the handler waits for in-flight Bedrock calls on SIGTERM. 0 disables draining. Lambda only sends SIGTERM when an extension is registered, and allows at most 2 seconds."
+  type        = number
+  default     = 0
+
+  validation {
+    condition     = var.drain_timeout_seconds >= 0 && var.drain_timeout_seconds <= 2
+    error_message = "Drain timeout must be between 0 and 2 seconds."
+  }
+}
+
+variable "enable_conversation_history" {
+  description = "Store multi-turn conversation history in DynamoDB, keyed by the request session_id"
+  type        = bool
+  default     = false
+}
+
+variable "enable_session_locking" {
+  description = "Make conversation history writes conditional on a version attribute, merging concurrent turns on the same session_id instead of losing them"
+  type        = bool
+  default     = false
+
+  validation {
+    condition     = !var.enable_session_locking || var.enable_conversation_history
+    error_message = "enable_session_locking requires enable_conversation_history."
+  }
+}
+
+variable "conversation_ttl_days" {
+  description = "Days of inactivity before a stored conversation expires"
+  type        = number
+  default     = 7
+
+  validation {
+    condition     = var.conversation_ttl_days >= 1 && var.conversation_ttl_days <= 365
+    error_message = "Conversation TTL must be between 1 and 365 days."
+  }
+}
+
+variable "max_conversation_turns" {
+  description = "Stored turns after which older turns are summarized with summarization_model_id. 0 keeps every turn."
+  type        = number
+  default     = 0
+
+  validation {
+    condition     = var.max_conversation_turns == 0 || (var.max_conversation_turns >= 2 && floor(var.max_conversation_turns) == var.max_conversation_turns)
+    error_message = "Max conversation turns must be 0 or an integer of at least 2."
+  }
+}
+
+variable "max_conversation_bytes" {
+  description = "Most bytes of message content a stored conversation keeps. The oldest turns are dropped beyond it."
+  type        = number
+  default     = 262144
+
+  validation {
+    condition     = var.max_conversation_bytes >= 1024 && var.max_conversation_bytes <= 300000
+    error_message = "Max conversation bytes must be between 1024 and 300000, so an encrypted history stays under DynamoDB's 400 KB item limit."
+  }
+}
+
+variable "summarization_model_id" {
+  description = "Cheaper Bedrock model used to summarize older conversation turns"
+  type        = string
+  default     = "anthropic.claude-3-haiku-20240307-v1:0"
+}
+
+variable "conversation_field_encryption" {
+  description = "Envelope-encrypt message content with a KMS data key before writing conversations to DynamoDB. Requires a layer providing the cryptography package."
+  type        = bool
+  default     = false
+}
+
+variable "conversation_kms_key_arn" {
+  description = "KMS key ARN for conversation field encryption. A dedicated key is created if not specified."
+  type        = string
+  default     = null
+}
+
+variable "enable_scheduled_prompts" {
+  description = "Create EventBridge schedules that invoke the Lambda with the prompts in scheduled_prompts"
+  type        = bool
+  default     = false
+}
+
+variable "scheduled_prompts" {
+  description = "Recurring prompts. destination is an SNS topic ARN or an s3://bucket/prefix URI. model is an alias or model ID (defaults to bedrock_model_id)."
+  type = list(object({
+    name                = string
+    schedule_expression = string
+    prompt              = string
+    model               = optional(string)
+    destination         = string
+  }))
+  default = []
+
+  validation {
+    condition     = alltrue([for p in var.scheduled_prompts : can(regex("^[a-zA-Z0-9_-]{1,40}$", p.name))])
+    error_message = "Scheduled prompt names must be 1-40 letters, numbers, underscores, or hyphens."
+  }
+
+  validation {
+    condition     = alltrue([for p in var.scheduled_prompts : can(regex("^(rate|cron)\\(.+\\)$", p.schedule_expression))])
+    error_message = "Schedule expressions must be rate(...) or cron(...) expressions."
+  }
+
+  validation {
+    condition     = alltrue([for p in var.scheduled_prompts : can(regex("^(arn:aws[a-z-]*:sns:|s3://)", p.destination))])
+    error_message = "Scheduled prompt destinations must be SNS topic ARNs or s3:// URIs."
+  }
+}
+
+variable "enable_idempotency" {
+  description = "Replay stored responses for requests that repeat an Idempotency-Key header"
+  type        = bool
+  default     = false
+}
+
+variable "idempotency_ttl_seconds" {
+  description = "How long a response is kept for replay to requests with the same Idempotency-Key"
+  type        = number
+  default     = 3600
+
+  validation {
+    condition     = var.idempotency_ttl_seconds >= 60 && var.idempotency_ttl_seconds <= 86400
+    error_message = "Idempotency TTL must be between 60 and 86400 seconds."
+  }
+}
+
+variable "enable_usage_accounting" {
+  description = "Atomically accumulate per-tenant monthly token usage in DynamoDB. The tenant is the API key ID, else the tenant_header header."
+  type        = bool
+  default     = false
+}
+
+variable "tenant_header" {
+  description = "Request header identifying the tenant when API keys are not in use"
+  type        = string
+  default     = "X-Tenant-Id"
+
+  validation {
+    condition     = can(regex("^[A-Za-z0-9-]+$", var.tenant_header))
+    error_message = "Tenant header must be a valid HTTP header name."
+  }
+}
+
+variable "enable_tenant_isolation" {
+  description = "Reject tenants not in allowed_tenant_ids, write each tenant's request log to its own log stream and emit per-tenant metrics"
+  type        = bool
+  default     = false
+
+  validation {
+    condition     = !var.enable_tenant_isolation || length(var.allowed_tenant_ids) > 0
+    error_message = "Tenant isolation requires at least one entry in allowed_tenant_ids."
+  }
+}
+
+variable "allowed_tenant_ids" {
+  description = "Tenant IDs accepted when tenant isolation is enabled"
+  type        = list(string)
+  default     = []
+
+  validation {
+    condition     = alltrue([for tenant in var.allowed_tenant_ids : can(regex("^[A-Za-z0-9_.-]{1,64}$", tenant))])
+    error_message = "Tenant IDs must be 1-64 characters of letters, digits, '_', '.' or '-'."
+  }
+}
+
+variable "request_field_map" {
+  description = "Map of client payload field names to handler field names (e.g. { question = \"prompt\" }), applied before validation"
+  type        = map(string)
+  default     = {}
+
+  validation {
+    condition = alltrue([
+      for field in values(var.request_field_map) :
+      contains(["prompt", "system", "max_tokens", "temperature", "top_p", "model", "timeout_ms", "session_id", "stream", "num_images"], field)
+    ])
+    error_message = "Request field map targets must be one of: prompt, system, max_tokens, temperature, top_p, model, timeout_ms, session_id, stream, num_images."
+  }
+}
+
+variable "lenient_json" {
+  description = "Ignore unknown request fields and accept trailing commas. When false, requests with unknown fields are rejected with a 400."
+  type        = bool
+  default     = false
+}
+
+variable "prompt_template_source" {
+  description = "SSM parameter path (e.g. /bedrock/templates) or s3://bucket/prefix URI holding named prompt templates. Templates are loaded at init and requests select one with the template field."
+  type        = string
+  default     = null
+
+  validation {
+    condition     = var.prompt_template_source == null || can(regex("^(/[A-Za-z0-9_.-]+(/[A-Za-z0-9_.-]+)*/?|s3://[a-z0-9][a-z0-9.-]{1,61}[a-z0-9](/.*)?)$", var.prompt_template_source))
+    error_message = "prompt_template_source must be an SSM parameter path starting with / or an s3://bucket/prefix URI."
+  }
+}
+
+variable "template_refresh_seconds" {
+  description = "How often warm instances reload prompt templates in the background. 0 keeps the templates loaded at init for the life of the instance."
+  type        = number
+  default     = 300
+
+  validation {
+    condition     = var.template_refresh_seconds >= 0 && var.template_refresh_seconds <= 86400 && floor(var.template_refresh_seconds) == var.template_refresh_seconds
+    error_message = "Template refresh interval must be a whole number of seconds between 0 and 86400."
+  }
+}
+
+variable "model_context_windows" {
+  description = "Context window sizes in tokens by concrete model ID, added to or overriding the module's built-in table"
+  type        = map(number)
+  default     = {}
+
+  validation {
+    condition     = alltrue([for tokens in values(var.model_context_windows) : tokens > 0 && floor(tokens) == tokens])
+    error_message = "Context window sizes must be positive integers."
+  }
+}
+
+variable "enable_bedrock_prompt_cache" {
+  description = "Mark the system prompt and prior conversation turns as Bedrock prompt cache checkpoints on Anthropic models that support caching"
+  type        = bool
+  default     = false
+}
+
+variable "strip_invalid_chars" {
+  description = "Remove invalid UTF-8 and control characters from prompts instead of rejecting the request with a 400"
+  type        = bool
+  default     = false
+}
+
+variable "normalize_input" {
+  description = "Remove control and zero-width characters from prompts and collapse repeated whitespace before invoking the model. The archive keeps the original."
+  type        = bool
+  default     = false
+}
+
+variable "trim_response" {
+  description = "Trim surrounding whitespace, echoed stop sequences and response_trim_suffixes from non-streamed completions"
+  type        = bool
+  default     = false
+}
+
+variable "max_response_bytes" {
+  description = "Cap on a completion's size in UTF-8 bytes. Longer completions are cut and flagged truncated, and streams stop generating at the cap. 0 disables the cap."
+  type        = number
+  default     = 0
+
+  validation {
+    condition     = var.max_response_bytes >= 0 && var.max_response_bytes <= 6291456 && floor(var.max_response_bytes) == var.max_response_bytes
+    error_message = "Max response bytes must be a whole number between 0 and 6291456, Lambda's response payload limit."
+  }
+}
+
+variable "response_trim_suffixes" {
+  description = "Trailing artifacts removed from completions when trim_response is enabled, on top of the per-model-family defaults"
+  type        = list(string)
+  default     = []
+
+  validation {
+    condition     = alltrue([for suffix in var.response_trim_suffixes : length(suffix) > 0])
+    error_message = "Response trim suffixes must not be empty strings."
+  }
+}
+
+variable "post_processors" {
+  description = "Transforms applied in order to non-streamed completion content: json_extract, trim, markdown_to_text"
+  type        = list(string)
+  default     = []
+
+  validation {
+    condition     = alltrue([for processor in var.post_processors : contains(["json_extract", "trim", "markdown_to_text"], processor)])
+    error_message = "Post processors must be one of: json_extract, trim, markdown_to_text."
+  }
+}
+
+variable "response_json_schema" {
+  description = "JSON Schema document, as a JSON string, that post-processed completions must match. A mismatch is retried once with a correction prompt before the request fails with a 502."
+  type        = string
+  default     = null
+
+  validation {
+    condition     = var.response_json_schema == null || can(keys(jsondecode(var.response_json_schema)))
+    error_message = "response_json_schema must be a JSON object document."
+  }
+}
+
+variable "enable_input_moderation" {
+  description = "Classify each prompt with moderation_model_id before the main model call and reject flagged prompts with a 422"
+  type        = bool
+  default     = false
+}
+
+variable "moderation_model_id" {
+  description = "Cheap Bedrock model used to classify prompts for input moderation"
+  type        = string
+  default     = "anthropic.claude-3-haiku-20240307-v1:0"
+}
+
+variable "moderation_threshold" {
+  description = "Classifier confidence (0-1) at or above which a non-benign prompt is blocked"
+  type        = number
+  default     = 0.8
+
+  validation {
+    condition     = var.moderation_threshold >= 0 && var.moderation_threshold <= 1
+    error_message = "Moderation threshold must be between 0 and 1."
+  }
+}
+
+variable "per_model_concurrency" {
+  description = "Maximum concurrent in-flight requests per concrete model ID, enforced across Lambda instances via DynamoDB leases"
+  type        = map(number)
+  default     = {}
+
+  validation {
+    condition     = alltrue([for limit in values(var.per_model_concurrency) : limit >= 1 && floor(limit) == limit])
+    error_message = "Per-model concurrency limits must be positive integers."
+  }
+}
+
+variable "enable_adaptive_throttling" {
+  description = "Have each Lambda instance lower its admission rate while Bedrock returns ThrottlingException, and raise it again as throttling subsides"
+  type        = bool
+  default     = false
+}
+
+variable "adaptive_throttling_min_rate" {
+  description = "Lowest admission rate, in requests per second per Lambda instance, that adaptive throttling backs off to"
+  type        = number
+  default     = 1
+
+  validation {
+    condition     = var.adaptive_throttling_min_rate > 0
+    error_message = "adaptive_throttling_min_rate must be greater than 0."
+  }
+}
+
+variable "adaptive_throttling_max_rate" {
+  description = "Admission rate, in requests per second per Lambda instance, that adaptive throttling starts at and recovers to"
+  type        = number
+  default     = 50
+
+  validation {
+    condition     = var.adaptive_throttling_max_rate >= var.adaptive_throttling_min_rate
+    error_message = "adaptive_throttling_max_rate must be at least adaptive_throttling_min_rate."
+  }
+}
+
+variable "enable_archival" {
+  description = "Archive every Bedrock request (prompt, response, usage, latency, model) as JSON in an S3 bucket partitioned by date and model"
+  type        = bool
+  default     = false
+}
+
+variable "archive_use_firehose" {
+  description = "Buffer archive records through Kinesis Data Firehose into larger objects instead of writing one object per request"
+  type        = bool
+  default     = false
+}
+
+variable "archive_buffer_seconds" {
+  description = "How long Firehose buffers archive records before writing them to S3"
+  type        = number
+  default     = 300
+
+  validation {
+    condition     = var.archive_buffer_seconds >= 60 && var.archive_buffer_seconds <= 900
+    error_message = "Archive buffer seconds must be between 60 and 900."
+  }
+}
+
+variable "enable_object_lambda" {
+  description = "Store completions in S3 and expose them through an S3 Object Lambda access point that transforms them on read"
+  type        = bool
+  default     = false
+}
+
+variable "object_lambda_transform_arn" {
+  description = "ARN of a custom Object Lambda transform function. The bundled redaction transform is deployed if not specified."
+  type        = string
+  default     = null
+}
+
+variable "enable_continuation" {
+  description = "Return a continuation_token with completions truncated at max_tokens, which a follow-up request uses to generate the rest"
+  type        = bool
+  default     = false
+}
+
+variable "continuation_ttl_seconds" {
+  description = "How long a continuation token can be used after the truncated response"
+  type        = number
+  default     = 3600
+
+  validation {
+    condition     = var.continuation_ttl_seconds >= 60 && var.continuation_ttl_seconds <= 604800
+    error_message = "Continuation TTL must be between 60 and 604800 seconds."
+  }
+}
+
+variable "enable_async_invocation" {
+  description = "Accept \"async\": true requests, process them from an SQS queue and serve results from GET /result/{job_id}"
+  type        = bool
+  default     = false
+}
+
+variable "async_result_ttl_seconds" {
+  description = "How long async job results are kept, and how long queued prompts wait before being dropped"
+  type        = number
+  default     = 86400
+
+  validation {
+    condition     = var.async_result_ttl_seconds >= 300 && var.async_result_ttl_seconds <= 1209600
+    error_message = "Async result TTL must be between 300 and 1209600 seconds (SQS retention limits)."
+  }
+}
+
+variable "async_max_receive_count" {
+  description = "Attempts at an async prompt, each after the queue's visibility timeout, before it moves to the dead-letter queue"
+  type        = number
+  default     = 3
+
+  validation {
+    condition     = var.async_max_receive_count >= 1 && var.async_max_receive_count <= 1000
+    error_message = "Async max receive count must be between 1 and 1000."
+  }
+}
+
+variable "async_max_concurrency" {
+  description = "Maximum concurrent Lambda invocations processing the async queue"
+  type        = number
+  default     = 5
+
+  validation {
+    condition     = var.async_max_concurrency >= 2 && var.async_max_concurrency <= 1000
+    error_message = "Async max concurrency must be between 2 and 1000."
+  }
+}
+
+variable "enable_request_buffering" {
+  description = "Accept \"buffered\": true requests into a queue drained at drain_rate_per_second, with results served from GET /result/{job_id}. Requires enable_async_invocation."
+  type        = bool
+  default     = false
+
+  validation {
+    condition     = !var.enable_request_buffering || var.enable_async_invocation
+    error_message = "Request buffering delivers results through the async result endpoint, which needs enable_async_invocation."
+  }
+}
+
+variable "drain_rate_per_second" {
+  description = "Most buffered requests sent to Bedrock per second across all workers"
+  type        = number
+  default     = 1
+
+  validation {
+    condition     = var.drain_rate_per_second > 0 && var.drain_rate_per_second <= 100
+    error_message = "Drain rate must be greater than 0 and at most 100 requests per second."
+  }
+
+  # Each of the two pollers waits out its interval inside one invocation
+  validation {
+    condition     = 2 / var.drain_rate_per_second < var.lambda_timeout
+    error_message = "At this drain rate each worker invocation would outlast lambda_timeout; raise the rate or the timeout."
+  }
+}
+
+variable "sync_max_tokens_threshold" {
+  description = "Queue requests whose max_tokens exceeds this as async jobs rather than risk API Gateway's 29 second timeout. Requires enable_async_invocation."
+  type        = number
+  default     = null
+
+  validation {
+    condition     = var.sync_max_tokens_threshold == null || (var.sync_max_tokens_threshold >= 1 && floor(var.sync_max_tokens_threshold) == var.sync_max_tokens_threshold)
+    error_message = "Sync max tokens threshold must be a positive whole number."
+  }
+
+  validation {
+    condition     = var.sync_max_tokens_threshold == null || var.enable_async_invocation
+    error_message = "sync_max_tokens_threshold requires enable_async_invocation."
+  }
+}
+
+variable "enable_presigned_uploads" {
+  description = "Expose a /upload-url route returning presigned S3 PUT URLs, so images too large for API Gateway can be uploaded and sent with prompts by key"
+  type        = bool
+  default     = false
+}
+
+variable "upload_url_expiry_seconds" {
+  description = "How long a presigned upload URL stays valid"
+  type        = number
+  default     = 300
+
+  validation {
+    condition     = var.upload_url_expiry_seconds >= 60 && var.upload_url_expiry_seconds <= 3600
+    error_message = "Upload URL expiry must be between 60 and 3600 seconds."
+  }
+}
+
+variable "upload_retention_days" {
+  description = "Days uploaded images are kept before the bucket lifecycle deletes them"
+  type        = number
+  default     = 1
+
+  validation {
+    condition     = var.upload_retention_days >= 1 && floor(var.upload_retention_days) == var.upload_retention_days
+    error_message = "Upload retention must be a whole number of days, at least 1."
+  }
+}
+
+variable "enable_batch_inference" {
+  description = "Expose a /batch route that submits Bedrock batch inference jobs from JSONL inputs in a module-managed bucket"
+  type        = bool
+  default     = false
+}
+
+variable "max_concurrent_batch_jobs" {
+  description = "Active batch jobs allowed before new submissions are queued in SQS. Counted across the account, as Bedrock's quota is."
+  type        = number
+  default     = 5
+
+  validation {
+    condition     = var.max_concurrent_batch_jobs >= 1 && floor(var.max_concurrent_batch_jobs) == var.max_concurrent_batch_jobs
+    error_message = "Max concurrent batch jobs must be a positive integer."
+  }
+}
+
+variable "bedrock_agent_id" {
+  description = "ID of a Bedrock agent to expose on a /agent route. The route is skipped when null."
+  type        = string
+  default     = null
+}
+
+variable "bedrock_agent_alias_id" {
+  description = "Alias of bedrock_agent_id to invoke"
+  type        = string
+  default     = null
+
+  validation {
+    condition     = (var.bedrock_agent_id == null) == (var.bedrock_agent_alias_id == null)
+    error_message = "bedrock_agent_id and bedrock_agent_alias_id must be set together."
+  }
+}
+
+variable "session_pool_size" {
+  description = "Idle agent sessions each Lambda instance keeps per tenant for reuse by requests without a session_id"
+  type        = number
+  default     = 10
+
+  validation {
+    condition     = var.session_pool_size >= 0 && floor(var.session_pool_size) == var.session_pool_size
+    error_message = "Session pool size must be a non-negative integer."
+  }
+}
+
+variable "session_idle_seconds" {
+  description = "Pooled agent sessions idle longer than this are evicted instead of reused"
+  type        = number
+  default     = 300
+
+  validation {
+    condition     = var.session_idle_seconds >= 1 && var.session_idle_seconds <= 3600
+    error_message = "Session idle seconds must be between 1 and 3600 (the agent's idle session limit)."
+  }
+}
+
+variable "enable_guardrails" {
+  description = "Create a Bedrock guardrail from guardrail_denied_topics and guardrail_pii_entities and apply it to every /bedrock completion"
+  type        = bool
+  default     = false
+}
+
+variable "guardrail_denied_topics" {
+  description = "Topics the guardrail refuses, each with a definition and up to five example prompts"
+  type = list(object({
+    name       = string
+    definition = string
+    examples   = optional(list(string), [])
+  }))
+  default = []
+
+  validation {
+    condition     = alltrue([for topic in var.guardrail_denied_topics : length(topic.definition) <= 200 && length(topic.examples) <= 5])
+    error_message = "Each denied topic needs a definition of at most 200 characters and at most 5 examples."
+  }
+}
+
+variable "guardrail_pii_entities" {
+  description = "PII entity types the guardrail filters in prompts and completions, mapped to BLOCK or ANONYMIZE (masked as {TYPE})"
+  type        = map(string)
+  default = {
+    EMAIL                     = "ANONYMIZE"
+    PHONE                     = "ANONYMIZE"
+    US_SOCIAL_SECURITY_NUMBER = "BLOCK"
+    CREDIT_DEBIT_CARD_NUMBER  = "BLOCK"
+  }
+
+  validation {
+    condition     = alltrue([for action in values(var.guardrail_pii_entities) : contains(["BLOCK", "ANONYMIZE"], action)])
+    error_message = "PII entity actions must be BLOCK or ANONYMIZE."
+  }
+
+  validation {
+    condition     = !var.enable_guardrails || length(var.guardrail_pii_entities) + length(var.guardrail_denied_topics) > 0
+    error_message = "Guardrails need at least one denied topic or PII entity."
+  }
+}
+
+variable "guardrail_blocked_input_message" {
+  description = "Completion returned instead of calling the model when the guardrail blocks a prompt"
+  type        = string
+  default     = "Sorry, I can't help with that request."
+}
+
+variable "guardrail_blocked_output_message" {
+  description = "Completion returned in place of a model response the guardrail blocks"
+  type        = string
+  default     = "Sorry, I can't share that response."
+}
+
+variable "enable_agent_trace" {
+  description = "Let /agent requests set \"trace\": true to get the action groups and knowledge bases the agent called"
+  type        = bool
+  default     = false
+}
+
+variable "bedrock_knowledge_base_id" {
+  description = "ID of a Bedrock knowledge base to answer from on a /retrieve route through RetrieveAndGenerate with bedrock_model_id. The route is skipped when null."
+  type        = string
+  default     = null
+}
+
+variable "knowledge_base_number_of_results" {
+  description = "Most knowledge base chunks retrieved to ground each /retrieve answer"
+  type        = number
+  default     = 5
+
+  validation {
+    condition     = var.knowledge_base_number_of_results >= 1 && var.knowledge_base_number_of_results <= 100 && floor(var.knowledge_base_number_of_results) == var.knowledge_base_number_of_results
+    error_message = "Knowledge base number of results must be a whole number between 1 and 100."
+  }
+}
+
+variable "enable_image_generation" {
+  description = "Expose a /images route backed by a Bedrock image generation model"
+  type        = bool
+  default     = false
+}
+
+variable "image_model_id" {
+  description = "Bedrock image generation model ID (Titan Image Generator or Stability)"
+  type        = string
+  default     = "amazon.titan-image-generator-v1"
+
+  validation {
+    condition     = can(regex("^(amazon\\.titan-image-generator|stability\\.)", var.image_model_id))
+    error_message = "Image model ID must be an Amazon Titan Image Generator or Stability AI model."
+  }
+}
+
+# Lambda Configuration
+variable "lambda_runtime" {
+  description = "Lambda runtime. Java runtimes require lambda_package_path and lambda_handler, and provided.al2023 runs the Go handler built from lambda/handler."
+  type        = string
+  default     = "python3.11"
+
+  validation {
+    condition = contains([
+      "python3.8", "python3.9", "python3.10", "python3.11", "python3.12", "python3.13",
+      "java11", "java17", "java21", "provided.al2023"
+    ], var.lambda_runtime)
+    error_message = "Must be a supported Python or Java runtime version, or provided.al2023."
+  }
+
+  # The Go handler serves only POST /bedrock and GET /health with the default
+  # model and request parameters; everything else is implemented in Python
+  validation {
+    condition = var.lambda_runtime != "provided.al2023" || !anytrue([
+      var.enable_streaming, var.enable_conversation_history, var.enable_tenant_isolation, var.enable_idempotency,
+      var.enable_guardrails, var.enable_input_moderation, var.enable_usage_accounting, var.enable_adaptive_throttling,
+      length(var.per_model_concurrency) > 0, length(var.model_fallback_chain) > 0, var.enable_profile_region_fallback,
+      length(var.deprecated_model_replacements) > 0, var.custom_model_arn != null, var.enable_cost_allocation_tags,
+      var.enable_provisioned_throughput, var.enable_ensemble, var.enable_bedrock_prompt_cache,
+      var.prompt_template_source != null, length(var.request_field_map) > 0, var.unsupported_param_mode != "strip",
+      var.lenient_json, var.strip_invalid_chars, var.normalize_input, var.trim_response, length(var.post_processors) > 0,
+      var.response_json_schema != null, var.max_response_bytes > 0, var.enable_api_cache, var.serve_stale_on_error,
+      var.enable_continuation, var.enable_async_invocation, var.enable_request_buffering, var.sync_max_tokens_threshold != null,
+      var.enable_image_generation, var.enable_presigned_uploads, var.bedrock_agent_id != null,
+      var.bedrock_knowledge_base_id != null, var.enable_batch_inference, var.enable_object_lambda, var.enable_archival,
+      var.drain_timeout_seconds > 0, anytrue([for value in values(var.handler_fault_injection) : value > 0]),
+    ])
+    error_message = "The Go handler (provided.al2023) only serves POST /bedrock and GET /health. Streaming, conversations, tenants, idempotency, guardrails, fallbacks, caching, async, batch and the other handler features need a Python runtime; turn them off or keep the default runtime."
+  }
+}
+
+variable "lambda_handler" {
+  description = "Lambda handler entry point. Override when bringing your own package."
+  type        = string
+  default     = "index.handler"
+}
+
+variable "lambda_package_path" {
+  description = "Path to a pre-built Lambda deployment package. Defaults to the bundled Python handler."
+  type        = string
+  default     = null
+}
+
+variable "lambda_layers" {
+  description = "Lambda layer ARNs attached to the function (e.g., to provide the cryptography package)"
+  type        = list(string)
+  default     = []
+}
+
+variable "handler_version" {
+  description = "Build identifier for the handler, e.g. a git SHA from CI. Defaults to a hash of the deployment package."
+  type        = string
+  default     = null
+}
+
+variable "enable_snapstart" {
+  description = "Enable Lambda SnapStart on published versions (Java and Python 3.12+ runtimes only)"
+  type        = bool
+  default     = false
+
+  validation {
+    condition     = !var.enable_snapstart || contains(["java11", "java17", "java21", "python3.12", "python3.13"], var.lambda_runtime)
+    error_message = "SnapStart is only supported for java11, java17, java21, python3.12, and python3.13 runtimes."
+  }
+}
+
+variable "lambda_timeout" {
+  description = "Function timeout in seconds (1-900)"
+  type        = number
+  default     = 30
+
+  validation {
+    condition     = var.lambda_timeout >= 1 && var.lambda_timeout <= 900
+    error_message = "Timeout must be between 1 and 900 seconds."
+  }
+}
+
+variable "max_request_timeout_ms" {
+  description = "Upper bound in milliseconds for the per-request timeout_ms override applied to Bedrock calls"
+  type        = number
+  default     = 25000
+
+  validation {
+    condition     = var.max_request_timeout_ms >= 100 && var.max_request_timeout_ms <= 900000
+    error_message = "Max request timeout must be between 100 and 900000 milliseconds."
+  }
+}
+
+variable "lambda_memory_size" {
+  description = "Memory allocation in MB (128-10240)"
+  type        = number
+  default     = 512
+
+  validation {
+    condition     = var.lambda_memory_size >= 128 && var.lambda_memory_size <= 10240
+    error_message = "Memory must be between 128 and 10240 MB."
+  }
+}
+
+variable "log_level" {
+  description = "Lambda logging level"
+  type        = string
+  default     = "INFO"
+
+  validation {
+    condition = contains(["DEBUG", "INFO", "WARNING", "ERROR", "CRITICAL"], var.log_level)
+    error_message = "Must be valid log level."
+  }
+}
+
+variable "default_response_format" {
+  description = "Completion body when the Accept header doesn't choose one: json for the full envelope, text for the bare completion"
+  type        = string
+  default     = "json"
+
+  validation {
+    condition     = contains(["json", "text"], var.default_response_format)
+    error_message = "Default response format must be json or text."
+  }
+}
+
+variable "error_verbosity" {
+  description = "Client error detail: minimal returns a generic message and request ID, detailed adds the underlying Bedrock error and stack context"
   type        = string
-  default     = "python3.11"
+  default     = "minimal"
 
   validation {
-    condition = contains([
-      "python3.8", "python3.9", "python3.10", "python3.11", "python3.12"
-    ], var.lambda_runtime)
-    error_message = "Must be a supported Python runtime version."
+    condition     = contains(["minimal", "detailed"], var.error_verbosity)
+    error_message = "Error verbosity must be minimal or detailed."
   }
 }
 
-variable "lambda_timeout" {
-  description = "Function timeout in seconds (1-900)"
+variable "log_content" {
+  description = "Log full prompts and responses for sampled requests. When false, only request metadata is logged."
+  type        = bool
+  default     = true
+}
+
+variable "log_sampling_rate" {
+  description = "Fraction of requests (0.0-1.0) whose full prompt and response are logged when log_content is true"
   type        = number
-  default     = 30
+  default     = 1.0
 
   validation {
-    condition     = var.lambda_timeout >= 1 && var.lambda_timeout <= 900
-    error_message = "Timeout must be between 1 and 900 seconds."
+    condition     = var.log_sampling_rate >= 0 && var.log_sampling_rate <= 1
+    error_message = "Log sampling rate must be between 0.0 and 1.0."
   }
 }
 
-variable "lambda_memory_size" {
-  description = "Memory allocation in MB (128-10240)"
-  type        = number
-  default     = 512
+variable "log_redact_pii" {
+  description = "Redact email addresses and phone numbers from logged prompts and responses"
+  type        = bool
+  default     = true
+}
+
+variable "create_log_group" {
+  description = "Create the Lambda log group. Set to false to log to an existing log_group_name, e.g. one pre-created with central policies."
+  type        = bool
+  default     = true
 
   validation {
-    condition     = var.lambda_memory_size >= 128 && var.lambda_memory_size <= 10240
-    error_message = "Memory must be between 128 and 10240 MB."
+    condition     = var.create_log_group || var.log_group_name != null
+    error_message = "log_group_name is required when create_log_group is false."
   }
 }
 
-variable "log_level" {
-  description = "Lambda logging level"
+variable "log_group_name" {
+  description = "Lambda log group name. Defaults to <log_group_prefix>/<name_prefix>-bedrock-lambda."
   type        = string
-  default     = "INFO"
+  default     = null
+}
+
+variable "log_subscription_destination_arn" {
+  description = "Kinesis stream, Firehose delivery stream, Lambda function or CloudWatch Logs destination ARN to subscribe the Lambda log group to"
+  type        = string
+  default     = null
 
   validation {
-    condition = contains(["DEBUG", "INFO", "WARNING", "ERROR", "CRITICAL"], var.log_level)
-    error_message = "Must be valid log level."
+    condition     = var.log_subscription_destination_arn == null || can(regex("^arn:aws[a-z-]*:(kinesis|firehose|lambda|logs):", var.log_subscription_destination_arn))
+    error_message = "Log subscription destination must be a Kinesis, Firehose, Lambda or CloudWatch Logs destination ARN."
+  }
+}
+
+variable "log_subscription_filter_pattern" {
+  description = "CloudWatch Logs filter pattern selecting the events sent to log_subscription_destination_arn. Empty sends everything."
+  type        = string
+  default     = ""
+}
+
+variable "log_group_prefix" {
+  description = "Path prefix for the log groups the module creates. Give each deployment in an account its own prefix to keep their logs apart."
+  type        = string
+  default     = "/aws/lambda"
+
+  validation {
+    condition     = can(regex("^/[A-Za-z0-9_./#-]*[A-Za-z0-9_.#-]$", var.log_group_prefix))
+    error_message = "Log group prefix must start with / and must not end with /."
+  }
+}
+
+variable "metric_namespace" {
+  description = "CloudWatch namespace for the handler's custom metrics. Give each deployment in an account its own namespace to keep their metrics apart."
+  type        = string
+  default     = "BedrockAPI"
+
+  validation {
+    condition     = can(regex("^[A-Za-z0-9_./#:-]{1,255}$", var.metric_namespace)) && !startswith(var.metric_namespace, "AWS/")
+    error_message = "Metric namespace must be 1-255 letters, numbers or . _ / # : - characters and must not start with AWS/, which is reserved."
+  }
+}
+
+variable "metric_dimensions" {
+  description = "Request fields attached as dimensions to the handler's metrics, on top of each metric's own: route, model, tenant, environment or source_ip"
+  type        = list(string)
+  default     = []
+
+  validation {
+    condition     = alltrue([for d in var.metric_dimensions : contains(["route", "model", "tenant", "environment", "source_ip"], d)]) && length(var.metric_dimensions) == length(distinct(var.metric_dimensions))
+    error_message = "Metric dimensions must be distinct values from: route, model, tenant, environment, source_ip."
   }
 }
 
@@ -150,7 +1298,7 @@ variable "environment" {
 }
 
 variable "enable_xray_tracing" {
-  description = "Enable X-Ray tracing for Lambda and API Gateway"
+  description = "Enable X-Ray tracing for Lambda and API Gateway. The default Python handler is traced only at the function level; Bedrock call subsegments with model and token annotations need the Go handler (lambda_runtime provided.al2023)."
   type        = bool
   default     = false
 }
@@ -170,6 +1318,16 @@ variable "auth_type" {
     condition     = contains(["NONE", "AWS_IAM", "COGNITO"], var.auth_type)
     error_message = "Auth type must be one of: NONE, AWS_IAM, COGNITO"
   }
+
+  validation {
+    condition     = var.auth_type != "COGNITO" || var.cognito_user_pool_arn != null
+    error_message = "COGNITO authorization needs cognito_user_pool_arn."
+  }
+
+  validation {
+    condition     = var.auth_type != "COGNITO" || length(var.api_allowed_account_ids) == 0
+    error_message = "api_allowed_account_ids needs signed requests, so it can't be combined with COGNITO authorization."
+  }
 }
 
 variable "cognito_user_pool_arn" {
@@ -190,6 +1348,146 @@ variable "log_retention_days" {
   }
 }
 
+variable "existing_rest_api_id" {
+  description = "ID of an existing REST API to attach the Bedrock routes to instead of creating one"
+  type        = string
+  default     = null
+}
+
+variable "existing_root_resource_id" {
+  description = "Resource ID under which routes are attached on existing_rest_api_id, usually its root resource"
+  type        = string
+  default     = null
+
+  validation {
+    condition     = (var.existing_rest_api_id == null) == (var.existing_root_resource_id == null)
+    error_message = "Set both existing_rest_api_id and existing_root_resource_id to attach to an existing API, or neither to create one."
+  }
+}
+
+variable "api_endpoint_type" {
+  description = "API Gateway endpoint type: REGIONAL, or EDGE to serve the API through an API Gateway-managed CloudFront distribution"
+  type        = string
+  default     = "REGIONAL"
+
+  validation {
+    condition     = contains(["REGIONAL", "EDGE"], var.api_endpoint_type)
+    error_message = "API endpoint type must be REGIONAL or EDGE."
+  }
+
+  validation {
+    condition     = var.api_endpoint_type == "REGIONAL" || var.existing_rest_api_id == null
+    error_message = "The endpoint type is set on the API itself; configure it on the existing API where it is defined."
+  }
+}
+
+variable "custom_domain_name" {
+  description = "Custom domain name to serve the API stage at, for example api.example.com. DNS for the name is left to the caller."
+  type        = string
+  default     = null
+}
+
+variable "custom_domain_certificate_arn" {
+  description = "ACM certificate ARN for custom_domain_name. EDGE endpoints need a certificate in us-east-1."
+  type        = string
+  default     = null
+
+  validation {
+    condition     = (var.custom_domain_name == null) == (var.custom_domain_certificate_arn == null)
+    error_message = "Set both custom_domain_name and custom_domain_certificate_arn to use a custom domain, or neither."
+  }
+
+  validation {
+    condition     = var.custom_domain_certificate_arn == null || can(regex("^arn:aws[a-z-]*:acm:[a-z0-9-]+:[0-9]{12}:certificate/", var.custom_domain_certificate_arn))
+    error_message = "Custom domain certificate ARN must be an ACM certificate ARN."
+  }
+}
+
+variable "minimum_tls_version" {
+  description = "Minimum TLS version the custom domain accepts: TLS_1_2, or TLS_1_0 for clients that can't negotiate TLS 1.2"
+  type        = string
+  default     = "TLS_1_2"
+
+  validation {
+    condition     = contains(["TLS_1_0", "TLS_1_2"], var.minimum_tls_version)
+    error_message = "Minimum TLS version must be TLS_1_0 or TLS_1_2."
+  }
+}
+
+variable "api_allowed_ip_ranges" {
+  description = "Source CIDR ranges allowed to call the API. With this or api_allowed_account_ids set, a resource policy denies every other caller."
+  type        = list(string)
+  default     = []
+
+  validation {
+    condition     = alltrue([for cidr in var.api_allowed_ip_ranges : can(cidrhost(cidr, 0))])
+    error_message = "API allowed IP ranges must be valid CIDR blocks."
+  }
+
+  validation {
+    condition     = length(var.api_allowed_ip_ranges) == 0 || var.existing_rest_api_id == null
+    error_message = "API allowlists need the module's own API; manage the resource policy of an existing API where it is defined."
+  }
+}
+
+variable "api_allowed_account_ids" {
+  description = "AWS account IDs allowed to call the API. Setting any switches the routes to AWS_IAM authorization so callers must sign requests."
+  type        = list(string)
+  default     = []
+
+  validation {
+    condition     = alltrue([for id in var.api_allowed_account_ids : can(regex("^[0-9]{12}$", id))])
+    error_message = "API allowed account IDs must be 12-digit AWS account IDs."
+  }
+
+  validation {
+    condition     = length(var.api_allowed_account_ids) == 0 || var.existing_rest_api_id == null
+    error_message = "API allowlists need the module's own API; manage the resource policy of an existing API where it is defined."
+  }
+}
+
+variable "minimum_compression_size" {
+  description = "Smallest response in bytes API Gateway gzip-compresses for clients that accept it. null disables compression except on routes given a size in routes."
+  type        = number
+  default     = null
+
+  validation {
+    condition     = var.minimum_compression_size == null || (var.minimum_compression_size >= 0 && var.minimum_compression_size <= 10485760)
+    error_message = "Minimum compression size must be between 0 and 10485760 bytes."
+  }
+
+  validation {
+    condition     = var.minimum_compression_size == null || var.existing_rest_api_id == null
+    error_message = "Compression is an API-wide setting; configure it on the existing API where it is defined."
+  }
+}
+
+variable "routes" {
+  description = "Per-route settings keyed by route (bedrock, health, images, agent, retrieve, batch, upload-url, result). minimum_compression_size overrides the API-wide size for that route."
+  type = map(object({
+    minimum_compression_size = optional(number)
+  }))
+  default = {}
+
+  validation {
+    condition     = alltrue([for route in keys(var.routes) : contains(["bedrock", "health", "images", "agent", "retrieve", "batch", "upload-url", "result"], route)])
+    error_message = "Route keys must be bedrock, health, images, agent, retrieve, batch, upload-url or result."
+  }
+
+  validation {
+    condition = alltrue([
+      for route in values(var.routes) :
+      route.minimum_compression_size == null || (route.minimum_compression_size >= 0 && route.minimum_compression_size <= 10485760)
+    ])
+    error_message = "Route minimum compression sizes must be between 0 and 10485760 bytes."
+  }
+
+  validation {
+    condition     = alltrue([for route in values(var.routes) : route.minimum_compression_size == null]) || var.existing_rest_api_id == null
+    error_message = "Per-route compression needs the module's own API."
+  }
+}
+
 variable "api_stage_name" {
   description = "API Gateway stage name"
   type        = string
@@ -201,6 +1499,79 @@ variable "api_stage_name" {
   }
 }
 
+variable "enable_stage_canary" {
+  description = "Split the stage between a stable deployment and a canary deployment of the current API configuration, for testing stage-level changes such as request models on part of the traffic"
+  type        = bool
+  default     = false
+}
+
+variable "canary_percent_traffic" {
+  description = "Percentage of stage traffic sent to the canary deployment when enable_stage_canary is set"
+  type        = number
+  default     = 10
+
+  validation {
+    condition     = var.canary_percent_traffic >= 0 && var.canary_percent_traffic <= 100
+    error_message = "Canary traffic must be between 0 and 100 percent."
+  }
+}
+
+variable "canary_stable_version" {
+  description = "Version label of the stable deployment. Changing it promotes the canary: the stable deployment is recreated from the current API configuration."
+  type        = string
+  default     = "1"
+}
+
+variable "enable_api_cache" {
+  description = "Enable API Gateway response caching for the Bedrock route, keyed on the X-Body-Hash request header"
+  type        = bool
+  default     = false
+}
+
+variable "cache_cluster_size" {
+  description = "API Gateway cache cluster size in GB"
+  type        = string
+  default     = "0.5"
+
+  validation {
+    condition     = contains(["0.5", "1.6", "6.1", "13.5", "28.4", "58.2", "118", "237"], var.cache_cluster_size)
+    error_message = "Cache cluster size must be one of: 0.5, 1.6, 6.1, 13.5, 28.4, 58.2, 118, 237."
+  }
+}
+
+variable "cache_ttl_seconds" {
+  description = "TTL in seconds for cached Bedrock responses (0-3600)"
+  type        = number
+  default     = 300
+
+  validation {
+    condition     = var.cache_ttl_seconds >= 0 && var.cache_ttl_seconds <= 3600
+    error_message = "Cache TTL must be between 0 and 3600 seconds."
+  }
+}
+
+variable "serve_stale_on_error" {
+  description = "When every model fails, answer with the last successful response for the request's X-Body-Hash cache key instead of an error"
+  type        = bool
+  default     = false
+
+  validation {
+    condition     = !var.serve_stale_on_error || var.enable_api_cache
+    error_message = "Serving stale responses on error requires enable_api_cache, whose X-Body-Hash header is the cache key."
+  }
+}
+
+variable "max_stale_seconds" {
+  description = "Oldest last-known-good response, in seconds, served when serve_stale_on_error is enabled"
+  type        = number
+  default     = 3600
+
+  validation {
+    condition     = var.max_stale_seconds >= 60 && var.max_stale_seconds <= 604800 && floor(var.max_stale_seconds) == var.max_stale_seconds
+    error_message = "Max stale seconds must be a whole number between 60 and 604800 (7 days)."
+  }
+}
+
 variable "max_tokens" {
   description = "Maximum number of tokens to generate"
   type        = number
@@ -234,6 +1605,23 @@ variable "top_p" {
   }
 }
 
+variable "run_smoke_test" {
+  description = "Send a fixed prompt through the deployed API after apply and fail if no completion is returned. The check is a data source, so it calls Bedrock again on every plan, refresh and destroy while enabled; turn it off once the deployment is verified. The request is unsigned, so it needs auth_type NONE and no api_allowed_account_ids."
+  type        = bool
+  default     = false
+
+  validation {
+    condition     = !var.run_smoke_test || (var.auth_type == "NONE" && length(var.api_allowed_account_ids) == 0)
+    error_message = "The smoke test sends an unsigned request, so it can't run with auth_type AWS_IAM or COGNITO or with api_allowed_account_ids."
+  }
+}
+
+variable "smoke_test_prompt" {
+  description = "Prompt used by the post-apply smoke test"
+  type        = string
+  default     = "Reply with the single word: ok"
+}
+
 variable "enable_monitoring" {
   description = "Enable CloudWatch monitoring and alarms"
   type        = bool
@@ -246,6 +1634,34 @@ variable "alarm_actions" {
   default     = []
 }
 
+variable "throttle_alarm_threshold" {
+  description = "Bedrock throttles in a minute above which the throttle alarm fires"
+  type        = number
+  default     = 10
+
+  validation {
+    condition     = var.throttle_alarm_threshold >= 0
+    error_message = "Throttle alarm threshold must not be negative."
+  }
+}
+
+variable "enable_cost_killswitch" {
+  description = "Pause the API by setting the Lambda's reserved concurrency to 0 when hourly invocations exceed cost_killswitch_threshold"
+  type        = bool
+  default     = false
+}
+
+variable "cost_killswitch_threshold" {
+  description = "Lambda invocations per hour above which the cost killswitch pauses the API"
+  type        = number
+  default     = 10000
+
+  validation {
+    condition     = var.cost_killswitch_threshold >= 1
+    error_message = "Cost killswitch threshold must be at least 1 invocation per hour."
+  }
+}
+
 variable "enable_waf" {
   description = "Enable WAF for API Gateway"
   type        = bool
@@ -263,12 +1679,62 @@ variable "waf_rate_limit" {
   }
 }
 
+variable "waf_blocked_ip_ranges" {
+  description = "IPv4 CIDR ranges blocked by the WAF before other rules are evaluated"
+  type        = list(string)
+  default     = []
+
+  validation {
+    condition     = alltrue([for cidr in var.waf_blocked_ip_ranges : can(cidrhost(cidr, 0))])
+    error_message = "WAF blocked IP ranges must be valid CIDR blocks."
+  }
+}
+
+variable "waf_excluded_paths" {
+  description = "Route paths (relative to the stage) exempt from the WAF rate limit and managed rules, e.g. health checks. Blocked IP ranges still apply."
+  type        = list(string)
+  default     = ["/health"]
+
+  validation {
+    condition     = alltrue([for path in var.waf_excluded_paths : can(regex("^/[A-Za-z0-9/_-]+$", path))])
+    error_message = "WAF excluded paths must start with / and contain only letters, numbers, /, _ and -."
+  }
+}
+
+variable "waf_scope" {
+  description = "WAF web ACL scope. REGIONAL (the default) is associated with the API stage, for edge-optimized APIs too. CLOUDFRONT creates the web ACL for a CloudFront distribution you put in front of the API instead, and requires us-east-1."
+  type        = string
+  default     = "REGIONAL"
+
+  validation {
+    condition     = contains(["REGIONAL", "CLOUDFRONT"], var.waf_scope)
+    error_message = "WAF scope must be REGIONAL or CLOUDFRONT."
+  }
+}
+
 variable "enable_cors" {
   description = "Enable CORS for API Gateway"
   type        = bool
   default     = true
 }
 
+variable "enable_gateway_responses" {
+  description = "Return API Gateway-generated errors (auth, throttling, defaults) as JSON in the handler's error shape"
+  type        = bool
+  default     = true
+}
+
+variable "gateway_response_messages" {
+  description = "Message overrides for gateway responses, keyed by DEFAULT_4XX, DEFAULT_5XX, THROTTLED or UNAUTHORIZED. API Gateway's own message is used otherwise."
+  type        = map(string)
+  default     = {}
+
+  validation {
+    condition     = alltrue([for type in keys(var.gateway_response_messages) : contains(["DEFAULT_4XX", "DEFAULT_5XX", "THROTTLED", "UNAUTHORIZED"], type)])
+    error_message = "Gateway response message keys must be DEFAULT_4XX, DEFAULT_5XX, THROTTLED or UNAUTHORIZED."
+  }
+}
+
 variable "cors_allowed_origins" {
   description = "List of allowed origins for CORS"
   type        = list(string)
@@ -296,6 +1762,23 @@ variable "cors_allowed_headers" {
   default     = ["Content-Type", "Authorization", "X-Requested-With"]
 }
 
+variable "cors_allow_credentials" {
+  description = "Send Access-Control-Allow-Credentials so browsers include cookies and auth headers. Requires a single, non-wildcard cors_allowed_origins entry."
+  type        = bool
+  default     = false
+
+  validation {
+    condition     = !var.cors_allow_credentials || (length(var.cors_allowed_origins) == 1 && !contains(var.cors_allowed_origins, "*"))
+    error_message = "cors_allow_credentials requires cors_allowed_origins to contain exactly one origin other than \"*\"."
+  }
+}
+
+variable "cors_allow_private_network" {
+  description = "Answer preflights with Access-Control-Allow-Private-Network so public pages may call an API on a private network"
+  type        = bool
+  default     = false
+}
+
 variable "enable_api_key" {
   description = "Enable API key authentication"
   type        = bool